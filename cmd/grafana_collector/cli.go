@@ -0,0 +1,276 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/config"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/report"
+	"github.com/pkg/errors"
+)
+
+// tokenEnv is the environment variable of the Grafana token of report command, which is not visible in process list
+// and shell history like flags
+const tokenEnv = "GRAFANA_TOKEN"
+
+// exit codes of report command
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitUsage  = 2
+)
+
+// variableFlags are the repeated -var name=value flags of report command
+type variableFlags url.Values
+
+func (v variableFlags) String() string {
+	return url.Values(v).Encode()
+}
+
+func (v variableFlags) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return errors.Errorf("variable %s should be name=value", s)
+	}
+	url.Values(v).Add("var-"+s[:i], s[i+1:])
+	return nil
+}
+
+// reportCommand runs `grafana_collector report`, which generates the report of a dashboard to a pdf or html file
+// with the same pipeline as report requests. Progress of panels is printed to out, it returns the exit code of process
+func reportCommand(args []string, out io.Writer) int {
+	vars := variableFlags{}
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	fs.SetOutput(out)
+	grafanaURL := fs.String("url", "http://localhost:3000", "Grafana URL")
+	dashboard := fs.String("dashboard", "", "uid of Grafana v5 dashboard, or name of Grafana v4 dashboard with -v4")
+	v4 := fs.Bool("v4", false, "use the API of Grafana v4")
+	from := fs.String("from", "now-1h", "start of time range, e.g. now-1h, unix milliseconds or ISO-8601 time")
+	to := fs.String("to", "now", "end of time range")
+	tz := fs.String("tz", "", "time zone of report, e.g. Asia/Shanghai, the time zone of dashboard by default")
+	fs.Var(vars, "var", "template variable of the form name=value, it can be repeated")
+	format := fs.String("format", "pdf", "format of report: pdf, or html with embedded panel images")
+	output := fs.String("o", "", "path of the report file, <dashboard>.<format> by default")
+	token := fs.String("token", "", "service account token or API key of Grafana if "+tokenEnv+" is not set, it is visible to other users in process list")
+	strict := fs.Bool("strict", false, "fail if any panel fails to render, strict in config by default")
+	renderer := fs.String("renderer", "", "how panel images are rendered: grafana or native, the renderer in config by default")
+	cfgFile := fs.String("config", "", "path to configuration file")
+	fontDir := fs.String("font-dir", "", "ttf fonts directory")
+	logLevel := fs.String("log-level", "warn", "log level: debug, info, warn, error, fatal")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	strictSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "strict" {
+			strictSet = true
+		}
+	})
+	if *dashboard == "" || *fontDir == "" {
+		fmt.Fprintln(out, "missing parameter: -dashboard and -font-dir are required")
+		fs.Usage()
+		return exitUsage
+	}
+	outputFormat, err := report.ParseFormat(*format)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return exitUsage
+	}
+	if *output == "" {
+		*output = *dashboard + "." + string(outputFormat)
+	}
+	log.SetLevelByString(*logLevel)
+
+	cfg = config.GetGlobalConfig()
+	if *cfgFile != "" {
+		if err := cfg.SetConfig(*cfgFile); err != nil {
+			fmt.Fprintf(out, "parsing configure file error: %v\n", err)
+			return exitUsage
+		}
+	}
+	report.SetFontDir(*fontDir)
+	if *renderer == "" {
+		*renderer = cfg.Grafana.Renderer
+	}
+	if !strictSet {
+		*strict = cfg.Report.Strict
+	}
+	if t := os.Getenv(tokenEnv); t != "" {
+		*token = t
+	}
+
+	start := time.Now()
+	err = generateToFile(reportOptions{
+		grafanaURL: strings.TrimSuffix(*grafanaURL, "/"),
+		dashboard:  *dashboard,
+		v4:         *v4,
+		from:       *from,
+		to:         *to,
+		tz:         *tz,
+		variables:  url.Values(vars),
+		token:      *token,
+		renderer:   *renderer,
+		strict:     *strict,
+		format:     outputFormat,
+		output:     *output,
+	}, out)
+	if err != nil {
+		fmt.Fprintf(out, "generating report of dashboard %s error: %v\n", *dashboard, err)
+		return exitFailed
+	}
+	fmt.Fprintf(out, "report of dashboard %s is written to %s in %v\n", *dashboard, *output, time.Since(start).Round(time.Second))
+	return exitOK
+}
+
+// reportOptions are the options of report command
+type reportOptions struct {
+	grafanaURL string
+	dashboard  string
+	v4         bool
+	from, to   string
+	tz         string
+	variables  url.Values
+	token      string
+	renderer   string
+	strict     bool
+	format     report.Format
+	output     string
+}
+
+// generateToFile generates the report of opts and writes the report file, the report is cancelled by SIGINT and SIGTERM
+func generateToFile(opts reportOptions, out io.Writer) error {
+	u, err := url.Parse(opts.grafanaURL)
+	if err != nil || u.Host == "" {
+		return errors.Errorf("invalid Grafana URL %s", opts.grafanaURL)
+	}
+	t, err := grafana.NewTimeRange(opts.from, opts.to, opts.tz)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	creds := configCredentials(u.Host)
+	if opts.token != "" {
+		creds = grafana.Credentials{Token: opts.token, OrgID: creds.OrgID}
+	}
+
+	// reports of the command are kept in a work directory of their own, the sweep of the work directory in config
+	// would remove the reports of a running server
+	dir, err := ioutil.TempDir("", "grafana_collector")
+	if err != nil {
+		return errors.Errorf("creating work directory error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	workdir, err := report.NewWorkdir(dir, cfg.Workdir.MaxSize*1024*1024)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	report.SetWorkdir(workdir)
+
+	newClient := grafana.NewV5Client
+	if opts.v4 {
+		newClient = grafana.NewV4Client
+	}
+	g, err := withRenderer(newClient(opts.grafanaURL, creds, opts.variables, t), opts.renderer)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	g = grafana.NewLimitingClient(g, grafana.NewRenderLimiter(cfg.Render.MaxConcurrent, 0))
+	progress := &progressClient{Client: g, out: out}
+	reporter := report.New(progress, opts.dashboard, t)
+	progress.reporter = reporter
+	reporter.SetFormat(opts.format)
+	defer reporter.Clean()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sc)
+	go func() {
+		select {
+		case sig := <-sc:
+			fmt.Fprintf(out, "got signal [%d], cancelling report\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	file, err := generate(ctx, reportModeCLI, reporter, opts.strict)
+	printFailures(out, reporter)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+
+	output, err := os.Create(opts.output)
+	if err != nil {
+		return errors.Errorf("creating report file %s error: %v", opts.output, err)
+	}
+	_, err = io.Copy(output, file)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Errorf("writing report file %s error: %v", opts.output, err)
+	}
+	return nil
+}
+
+// printFailures prints the progress of reporter and the panels which failed to render
+func printFailures(out io.Writer, reporter report.Report) {
+	p := reporter.Progress()
+	fmt.Fprintf(out, "%d panels: %d rendered, %d failed, %d not rendered\n", p.Total, p.Done, p.Failed, p.Pending)
+	for _, f := range reporter.Failures() {
+		fmt.Fprintf(out, "  failed panel %d %q of dashboard %s: %s\n", f.PanelID, f.Title, f.Dashboard, f.Error)
+	}
+}
+
+// progressClient prints a line for every panel rendered by Client
+type progressClient struct {
+	grafana.Client
+	out      io.Writer
+	reporter report.Report
+
+	mu   sync.Mutex
+	done int
+}
+
+func (g *progressClient) GetPanelPng(ctx context.Context, p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+	start := time.Now()
+	img, err := g.Client.GetPanelPng(ctx, p, dashName, t)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.done++
+	status := "rendered"
+	if err != nil {
+		status = "failed"
+	}
+	fmt.Fprintf(g.out, "[%d/%d] panel %d %q %s in %v\n", g.done, g.reporter.Progress().Total, p.ID, p.Title, status,
+		time.Since(start).Round(time.Millisecond))
+	return img, err
+}
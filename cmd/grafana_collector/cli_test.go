@@ -0,0 +1,119 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const cliDashJSON = `{"Dashboard": {"Title": "CLI", "Panels": [
+	{"Type": "graph", "ID": 1, "Title": "QPS", "GridPos": {"X": 0, "Y": 0, "W": 12, "H": 8}},
+	{"Type": "graph", "ID": 2, "Title": "Latency", "GridPos": {"X": 12, "Y": 0, "W": 12, "H": 8}}]}}`
+
+func TestReportCommand(t *testing.T) {
+	Convey("When a report is generated by report command", t, func() {
+		var img bytes.Buffer
+		So(png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 10, 10))), ShouldBeNil)
+		var query string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/api/dashboards/uid/abc":
+				fmt.Fprint(w, cliDashJSON)
+			case strings.HasPrefix(r.URL.Path, "/render/d-solo/abc/"):
+				query = r.URL.RawQuery
+				if r.URL.Query().Get("panelId") == "2" && r.Header.Get("Authorization") != "Bearer glsa_token" {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				w.Write(img.Bytes())
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer ts.Close()
+		conf := config.GetGlobalConfig()
+		retryInterval := conf.Grafana.RetryInterval
+		conf.Grafana.RetryInterval = 0
+		defer func() { conf.Grafana.RetryInterval = retryInterval }()
+
+		dir, err := ioutil.TempDir("", "cli")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		output := filepath.Join(dir, "report.pdf")
+		args := []string{"-url", ts.URL, "-dashboard", "abc", "-font-dir", "../../grafana_collector/ttf/", "-o", output,
+			"-from", "now-6h", "-var", "instance=tikv-1"}
+		var out bytes.Buffer
+
+		Convey("The pdf should be written with the progress of every panel", func() {
+			So(os.Setenv(tokenEnv, "glsa_token"), ShouldBeNil)
+			defer os.Unsetenv(tokenEnv)
+			So(reportCommand(args, &out), ShouldEqual, exitOK)
+			pdf, err := ioutil.ReadFile(output)
+			So(err, ShouldBeNil)
+			So(string(pdf), ShouldStartWith, "%PDF-")
+			So(out.String(), ShouldContainSubstring, `panel 1 "QPS" rendered`)
+			So(out.String(), ShouldContainSubstring, "[2/2]")
+			So(out.String(), ShouldContainSubstring, "2 panels: 2 rendered, 0 failed")
+			So(query, ShouldContainSubstring, "var-instance=tikv-1")
+			So(query, ShouldContainSubstring, "from=now-6h")
+		})
+
+		Convey("The report should be written as html with -format html", func() {
+			So(reportCommand(append(args, "-token", "glsa_token", "-format", "html"), &out), ShouldEqual, exitOK)
+			html, err := ioutil.ReadFile(output)
+			So(err, ShouldBeNil)
+			So(string(html), ShouldStartWith, "<!DOCTYPE html>")
+			So(string(html), ShouldContainSubstring, "QPS")
+		})
+
+		Convey("Failed panels should be listed and fail the command in strict mode", func() {
+			So(reportCommand(append(args, "-strict"), &out), ShouldEqual, exitFailed)
+			So(out.String(), ShouldContainSubstring, `failed panel 2 "Latency" of dashboard CLI`)
+			_, err := os.Stat(output)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("An explicit -strict=false should override strict in config", func() {
+			strict := conf.Report.Strict
+			conf.Report.Strict = true
+			defer func() { conf.Report.Strict = strict }()
+			So(reportCommand(args, &out), ShouldEqual, exitFailed)
+			So(reportCommand(append(args, "-strict=false"), &out), ShouldEqual, exitOK)
+			So(out.String(), ShouldContainSubstring, "2 panels: 1 rendered, 1 failed")
+		})
+
+		Convey("Unknown dashboards should fail the command", func() {
+			So(reportCommand([]string{"-url", ts.URL, "-dashboard", "unknown", "-font-dir", "../../grafana_collector/ttf/", "-o", output}, &out), ShouldEqual, exitFailed)
+		})
+
+		Convey("Missing parameters should be usage errors", func() {
+			So(reportCommand([]string{"-url", ts.URL}, &out), ShouldEqual, exitUsage)
+			So(reportCommand([]string{"-dashboard", "abc", "-font-dir", "../../grafana_collector/ttf/", "-var", "instance"}, &out), ShouldEqual, exitUsage)
+			So(reportCommand([]string{"-dashboard", "abc", "-font-dir", "../../grafana_collector/ttf/", "-format", "docx"}, &out), ShouldEqual, exitUsage)
+		})
+	})
+}
@@ -0,0 +1,124 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/config"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/mail"
+	"github.com/pkg/errors"
+)
+
+type mailStatus string
+
+// mail delivery status
+const (
+	mailPending mailStatus = "pending"
+	mailSending mailStatus = "sending"
+	mailSent    mailStatus = "sent"
+	mailFailed  mailStatus = "failed"
+)
+
+// mailInfo is the JSON representation of the mail of a report
+type mailInfo struct {
+	To       []string   `json:"to,omitempty"`
+	Status   mailStatus `json:"status"`
+	Attempts int        `json:"attempts"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// delivery mails a report by the templates of its mail, and keeps the status of the mail
+type delivery struct {
+	template *mail.Template
+
+	mu   sync.Mutex
+	info mailInfo
+}
+
+// newMailTemplate parses the templates of mails to the recipients to, subject and body are the defaults in config if
+// they are empty. It fails if no SMTP server is configured
+func newMailTemplate(to []string, subject, body string) (*mail.Template, error) {
+	conf := config.GetGlobalConfig().SMTP
+	if conf.Addr == "" {
+		return nil, errors.New("reports can't be mailed, addr of smtp is not configured")
+	}
+	if subject == "" {
+		subject = conf.Subject
+	}
+	if body == "" {
+		body = conf.Body
+	}
+	tmpl, err := mail.NewTemplate(to, subject, body)
+	return tmpl, errors.WithStack(err)
+}
+
+func newDelivery(tmpl *mail.Template) *delivery {
+	return &delivery{template: tmpl, info: mailInfo{Status: mailPending}}
+}
+
+// send mails the report of data with its file as attachment. Failed sends are retried by smtp in config, and every
+// failure is logged with label, e.g. "job xxx"
+func (d *delivery) send(label string, data mail.Data, attachment mail.Attachment) error {
+	conf := config.GetGlobalConfig().SMTP
+	msg, err := d.template.Message(data)
+	if err != nil {
+		log.Errorf("creating mail of %s error: %v", label, err)
+		d.update(mailFailed, nil, 0, err)
+		return errors.WithStack(err)
+	}
+	msg.Attachments = []mail.Attachment{attachment}
+	sender := mail.Sender{
+		Addr:               conf.Addr,
+		From:               conf.From,
+		Username:           conf.Username,
+		Password:           conf.Password,
+		StartTLS:           conf.StartTLS,
+		InsecureSkipVerify: conf.InsecureSkipVerify,
+		Timeout:            time.Duration(conf.Timeout) * time.Second,
+	}
+
+	for attempt := 1; ; attempt++ {
+		d.update(mailSending, msg.To, attempt, nil)
+		if err = sender.Send(msg); err == nil {
+			log.Infof("mail of %s is sent to %v", label, msg.To)
+			d.update(mailSent, msg.To, attempt, nil)
+			return nil
+		}
+		if attempt > conf.Retries {
+			log.Errorf("sending mail of %s to %v error, giving up after %d attempts: %v", label, msg.To, attempt, err)
+			d.update(mailFailed, msg.To, attempt, err)
+			return errors.WithStack(err)
+		}
+		log.Warnf("sending mail of %s to %v error, attempt %d of %d: %v", label, msg.To, attempt, conf.Retries+1, err)
+		time.Sleep(time.Duration(conf.RetryInterval) * time.Second)
+	}
+}
+
+func (d *delivery) update(status mailStatus, to []string, attempts int, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.info = mailInfo{To: to, Status: status, Attempts: attempts}
+	if err != nil {
+		d.info.Error = err.Error()
+	}
+}
+
+func (d *delivery) status() mailInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.info
+}
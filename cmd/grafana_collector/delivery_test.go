@@ -0,0 +1,132 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	netmail "net/mail"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/config"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/mail"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/report"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// useSink sends mails to sink without retry interval, and returns the function restoring config
+func useSink(sink *mail.Sink) func() {
+	conf := config.GetGlobalConfig()
+	saved := conf.SMTP
+	conf.SMTP.Addr = sink.Addr()
+	conf.SMTP.StartTLS = false
+	conf.SMTP.RetryInterval = 0
+	return func() { conf.SMTP = saved }
+}
+
+func TestDelivery(t *testing.T) {
+	Convey("When reports are mailed", t, func() {
+		_, err := newMailTemplate([]string{"ops@example.com"}, "", "")
+		So(err, ShouldNotBeNil)
+
+		sink, err := mail.NewSink(nil, "", "")
+		So(err, ShouldBeNil)
+		defer sink.Close()
+		defer useSink(sink)()
+		tmpl, err := newMailTemplate([]string{"{{.Dashboard}}@example.com"}, "", "")
+		So(err, ShouldBeNil)
+		data := mail.Data{Dashboard: "tikv", Title: "TiKV", From: "2018-12-03 00:00:00", To: "2018-12-04 00:00:00"}
+		attachment := mail.Attachment{Name: "tikv.pdf", ContentType: "application/pdf", Data: []byte("%PDF")}
+
+		Convey("Failed sends should be retried", func() {
+			sink.Reject(2)
+			d := newDelivery(tmpl)
+			So(d.send("job 1", data, attachment), ShouldBeNil)
+			So(d.status(), ShouldResemble, mailInfo{To: []string{"tikv@example.com"}, Status: mailSent, Attempts: 3})
+
+			messages := sink.Messages()
+			So(messages, ShouldHaveLength, 1)
+			m, err := netmail.ReadMessage(bytes.NewReader(messages[0].Data))
+			So(err, ShouldBeNil)
+			So(m.Header.Get("Subject"), ShouldEqual, "Grafana report: TiKV")
+		})
+
+		Convey("The mail should fail when retries are used up", func() {
+			sink.Reject(10)
+			d := newDelivery(tmpl)
+			So(d.send("job 1", data, attachment), ShouldNotBeNil)
+			status := d.status()
+			So(status.Status, ShouldEqual, mailFailed)
+			So(status.Attempts, ShouldEqual, config.GetGlobalConfig().SMTP.Retries+1)
+			So(status.Error, ShouldContainSubstring, "451")
+		})
+	})
+}
+
+func TestMailJobHandlers(t *testing.T) {
+	Convey("When a report job is mailed", t, func() {
+		newReport := func(grafana.Client, string, grafana.TimeRange) report.Report {
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil, nil}, newJobRegistry(time.Minute))
+		rec := httptest.NewRecorder()
+		submit := "/api/v5/report/testDash/jobs?mail-to=ops@example.com&mail-subject=" +
+			"{{.Title}}+from+{{.From}}&mail-body={{.Dashboard}}"
+
+		Convey("Mails should be rejected if no SMTP server is configured", func() {
+			req, _ := http.NewRequest("POST", submit, nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Body.String(), ShouldContainSubstring, "smtp")
+		})
+
+		Convey("The pdf should be mailed when the job is done, and the mail status should be returned", func() {
+			sink, err := mail.NewSink(nil, "", "")
+			So(err, ShouldBeNil)
+			defer sink.Close()
+			defer useSink(sink)()
+
+			req, _ := http.NewRequest("POST", submit, nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusAccepted)
+			So(rec.Body.String(), ShouldContainSubstring, `"status": "pending"`)
+
+			location := rec.Header().Get("Location")
+			var info jobInfo
+			for i := 0; i < 100; i++ {
+				rec = httptest.NewRecorder()
+				req, _ = http.NewRequest("GET", location, nil)
+				router.ServeHTTP(rec, req)
+				So(json.Unmarshal(rec.Body.Bytes(), &info), ShouldBeNil)
+				if info.Mail.Status == mailSent {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(info.Mail, ShouldResemble, &mailInfo{To: []string{"ops@example.com"}, Status: mailSent, Attempts: 1})
+			messages := sink.Messages()
+			So(messages, ShouldHaveLength, 1)
+			body := string(messages[0].Data)
+			So(body, ShouldContainSubstring, "Subject: Dash from Tue Dec 4 00:00:00 UTC 2018")
+			So(body, ShouldContainSubstring, "testDash")
+			So(body, ShouldContainSubstring, `filename=testDash.pdf`)
+		})
+	})
+}
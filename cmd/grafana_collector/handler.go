@@ -30,52 +30,554 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/ngaut/log"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/alert"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/config"
 	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
 	"github.com/pingcap/tidb-inspect-tools/grafana_collector/report"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/schedule"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/unrolled/render"
 )
 
+var rdr = render.New(render.Options{
+	IndentJSON: true,
+})
+
+// failedPanelsHeader is the response header of the number of panels which failed to render
+const failedPanelsHeader = "X-Report-Failed-Panels"
+
 // ServeReportHandler generates grafana dashboard pdf file and returns to client
 type ServeReportHandler struct {
-	newGrafanaClient func(url string, apiToken string, timeRange grafana.TimeRange) grafana.Client
+	newGrafanaClient func(url string, credentials grafana.Credentials, variables url.Values, timeRange grafana.TimeRange) grafana.Client
 	newReport        func(g grafana.Client, dashName string, timeRange grafana.TimeRange) report.Report
+	newBundle        func(title string, dashboards []report.BundleDashboard, timeRange grafana.TimeRange) report.Report
+	newComparison    func(g grafana.Client, dashName string, timeRange, compareRange grafana.TimeRange, stacked bool) report.Report
+}
+
+// ServeBundleHandler generates a single pdf file of several grafana dashboards, which are a bundle named in config
+// or posted in request body
+type ServeBundleHandler struct {
+	reportServer ServeReportHandler
+}
+
+// bundleRequest is an ordered list of dashboards with their own variables
+type bundleRequest struct {
+	Title      string            `json:"title"`
+	Dashboards []bundleDashboard `json:"dashboards"`
+}
+
+type bundleDashboard struct {
+	Dashboard string              `json:"dashboard"`
+	Variables map[string][]string `json:"variables"`
+	Selection string              `json:"selection"`
+}
+
+// SubmitReportJobHandler starts generating grafana dashboard pdf file in background
+// and returns the job ID to client
+type SubmitReportJobHandler struct {
+	reportServer ServeReportHandler
+	jobs         *jobRegistry
+}
+
+// ReportJobStatusHandler returns the status and panel progress of a report job
+type ReportJobStatusHandler struct {
+	jobs *jobRegistry
+}
+
+// ReportJobDownloadHandler returns the pdf or html file of a finished report job
+type ReportJobDownloadHandler struct {
+	jobs *jobRegistry
 }
 
+// CacheStatsHandler returns the counters of panel image cache
+type CacheStatsHandler struct{}
+
+// ArchiveListHandler lists archived reports, newest first
+type ArchiveListHandler struct{}
+
+// ArchiveDownloadHandler downloads an archived report
+type ArchiveDownloadHandler struct{}
+
+// ArchiveDeleteHandler deletes an archived report
+type ArchiveDeleteHandler struct{}
+
+// AlertWebhookHandler generates incident reports for the alerts posted by Alertmanager webhooks
+type AlertWebhookHandler struct{}
+
+// HealthHandler responds ok while the process is serving
+type HealthHandler struct{}
+
+// ReadyHandler responds ok if Grafana is reachable with the credentials in config
+type ReadyHandler struct{}
+
+// readyTimeout is how long readiness check waits for Grafana
+const readyTimeout = 5 * time.Second
+
 // RegisterHandlers registers all http.Handler with their associated routes to
 // the router. Two different serve report handlers are used to provide support
 // for both Grafana v4 (and older) and v5 APIs
-func RegisterHandlers(router *mux.Router, reportServerV4, reportServerV5 ServeReportHandler) {
+func RegisterHandlers(router *mux.Router, reportServerV4, reportServerV5 ServeReportHandler, jobs *jobRegistry) {
 	router.Handle("/api/report/{dashId}", reportServerV4)
 	router.Handle("/api/v5/report/{dashId}", reportServerV5)
+	router.Handle("/api/bundle/{bundle}", ServeBundleHandler{reportServerV4}).Methods("GET")
+	router.Handle("/api/v5/bundle/{bundle}", ServeBundleHandler{reportServerV5}).Methods("GET")
+	router.Handle("/api/bundle", ServeBundleHandler{reportServerV4}).Methods("POST")
+	router.Handle("/api/v5/bundle", ServeBundleHandler{reportServerV5}).Methods("POST")
+	router.Handle("/api/report/{dashId}/jobs", SubmitReportJobHandler{reportServerV4, jobs}).Methods("POST")
+	router.Handle("/api/v5/report/{dashId}/jobs", SubmitReportJobHandler{reportServerV5, jobs}).Methods("POST")
+	router.Handle("/api/jobs/{jobId}", ReportJobStatusHandler{jobs}).Methods("GET")
+	router.Handle("/api/jobs/{jobId}/{format:pdf|html}", ReportJobDownloadHandler{jobs}).Methods("GET")
+	router.Handle("/api/cache", CacheStatsHandler{}).Methods("GET")
+	router.Handle("/api/archive", ArchiveListHandler{}).Methods("GET")
+	router.Handle("/api/archive/{archiveId}", ArchiveDownloadHandler{}).Methods("GET")
+	router.Handle("/api/archive/{archiveId}", ArchiveDeleteHandler{}).Methods("DELETE")
+	router.Handle("/api/alerts", AlertWebhookHandler{}).Methods("POST")
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	router.Handle("/healthz", HealthHandler{}).Methods("GET")
+	router.Handle("/readyz", ReadyHandler{}).Methods("GET")
+}
+
+func (h ServeReportHandler) reporter(req *http.Request) (report.Report, error) {
+	t, err := timeRange(req)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := refreshCache(req)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := panelFilter(req, "")
+	if err != nil {
+		return nil, err
+	}
+	compareRange, stacked, err := comparison(req, t)
+	if err != nil {
+		return nil, err
+	}
+	format, err := report.ParseFormat(req.URL.Query().Get("format"))
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := h.grafanaClient(req, variables(req), t, refresh, filter)
+	if err != nil {
+		return nil, err
+	}
+	var reporter report.Report
+	if compareRange != nil {
+		reporter = h.newComparison(g, dashID(req), t, *compareRange, stacked)
+	} else {
+		reporter = h.newReport(g, dashID(req), t)
+	}
+	reporter.SetFormat(format)
+	return reporter, nil
+}
+
+// bundleReporter creates the report of bundle, the variables of a dashboard in bundle override the request ones,
+// and the panel selection of a dashboard in bundle is added to the request filter
+func (h ServeReportHandler) bundleReporter(req *http.Request, b bundleRequest) (report.Report, error) {
+	if len(b.Dashboards) == 0 {
+		return nil, errors.New("no dashboard in bundle")
+	}
+	t, err := timeRange(req)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := refreshCache(req)
+	if err != nil {
+		return nil, err
+	}
+	compareRange, _, err := comparison(req, t)
+	if err != nil {
+		return nil, err
+	}
+	if compareRange != nil {
+		return nil, errors.New("comparison of time ranges is not supported by bundle reports")
+	}
+	format, err := report.ParseFormat(req.URL.Query().Get("format"))
+	if err != nil {
+		return nil, err
+	}
+
+	dashboards := make([]report.BundleDashboard, 0, len(b.Dashboards))
+	for _, d := range b.Dashboards {
+		if d.Dashboard == "" {
+			return nil, errors.New("dashboard of bundle is empty")
+		}
+		vars := variables(req)
+		for name, values := range d.Variables {
+			if !strings.HasPrefix(name, "var-") {
+				name = "var-" + name
+			}
+			vars[name] = values
+		}
+		filter, err := panelFilter(req, d.Selection)
+		if err != nil {
+			return nil, err
+		}
+		g, err := h.grafanaClient(req, vars, t, refresh, filter)
+		if err != nil {
+			return nil, err
+		}
+		dashboards = append(dashboards, report.BundleDashboard{Client: g, DashName: d.Dashboard})
+	}
+
+	title := b.Title
+	if title == "" {
+		title = "Bundle report"
+	}
+	reporter := h.newBundle(title, dashboards, t)
+	reporter.SetFormat(format)
+	return reporter, nil
+}
+
+// grafanaClient creates a Grafana client with the selected variables and panels, which caches panel images if the
+// cache is enabled, and renders panels within the render limit of process
+func (h ServeReportHandler) grafanaClient(req *http.Request, vars url.Values, t grafana.TimeRange, refresh bool, filter grafana.PanelFilter) (grafana.Client, error) {
+	creds, err := credentials(req)
+	if err != nil {
+		return nil, err
+	}
+	grafanaClient := h.newGrafanaClient(*proto+*ip, creds, vars, t)
+	grafanaClient, err = withRenderer(grafanaClient, renderer(req))
+	if err != nil {
+		return nil, err
+	}
+	// panel images found in cache don't take render slots
+	if renderLimiter != nil {
+		grafanaClient = grafana.NewLimitingClient(grafanaClient, renderLimiter)
+	}
+	if panelCache != nil {
+		grafanaClient = grafana.NewCachingClient(grafanaClient, panelCache, refresh)
+	}
+	if !filter.IsEmpty() {
+		grafanaClient = grafana.NewFilteringClient(grafanaClient, filter)
+	}
+	return grafanaClient, nil
 }
 
 func (h ServeReportHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	log.Info("reporter called")
-	grafanaClient := h.newGrafanaClient(*proto+*ip, apiToken(req), time(req))
-	reporter := h.newReport(grafanaClient, dashID(req), time(req))
+	if rejectBusy(w) {
+		return
+	}
+	reporter, err := h.reporter(req)
+	if err != nil {
+		log.Errorf("parsing report request error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer reporter.Clean()
+	strict, err := strictMode(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	serveReport(w, req, reporter, strict)
+}
+
+func (h ServeBundleHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if rejectBusy(w) {
+		return
+	}
+	var b bundleRequest
+	if name, ok := mux.Vars(req)["bundle"]; ok {
+		log.Infof("bundle reporter called with bundle: %s", name)
+		conf, ok := config.GetGlobalConfig().Bundle[name]
+		if !ok {
+			http.Error(w, "bundle "+name+" is not found in config", http.StatusNotFound)
+			return
+		}
+		b.Title = conf.Title
+		if b.Title == "" {
+			b.Title = name
+		}
+		for _, d := range conf.Dashboards {
+			b.Dashboards = append(b.Dashboards, bundleDashboard{Dashboard: d.Dashboard, Variables: d.Variables, Selection: d.Selection})
+		}
+	} else {
+		log.Info("bundle reporter called with request body")
+		if err := json.NewDecoder(req.Body).Decode(&b); err != nil {
+			http.Error(w, "decoding bundle error: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-	file, err := reporter.Generate()
+	reporter, err := h.reportServer.bundleReporter(req, b)
 	if err != nil {
-		log.Errorf("generating report error: %v", err)
-		http.Error(w, err.Error(), 500)
+		log.Errorf("parsing bundle request error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	defer reporter.Clean()
+	strict, err := strictMode(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	serveReport(w, req, reporter, strict)
+}
+
+// serveReport generates the report and writes the pdf or html file to response, with the number of failed panels in header.
+// Rendering stops when the client of req disconnects. The caller cleans the report, whether it is generated or not
+func serveReport(w http.ResponseWriter, req *http.Request, reporter report.Report, strict bool) {
+	file, err := generate(req.Context(), reportModeSync, reporter, strict)
+	w.Header().Set(failedPanelsHeader, strconv.Itoa(len(reporter.Failures())))
+	if err != nil {
+		log.Errorf("generating report error: %v", err)
+		status := http.StatusInternalServerError
+		if errors.Cause(err) == report.ErrWorkdirFull {
+			status = http.StatusInsufficientStorage
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
 	defer file.Close()
 
+	w.Header().Set("Content-Type", reporter.Format().ContentType())
 	_, err = io.Copy(w, file)
 	if err != nil {
-		log.Errorf("copying pdf data to response error: %v", err)
+		log.Errorf("copying report file to response error: %v", err)
 		http.Error(w, err.Error(), 500)
 		return
 	}
 	log.Info("report generated correctly")
 }
 
+// generate generates the report. Failed panels are drawn as placeholders, unless the report fails in strict mode.
+// mode is how the report is requested, e.g. sync, job, cli or schedule
+func generate(ctx context.Context, mode string, reporter report.Report, strict bool) (file io.ReadCloser, err error) {
+	start := time.Now()
+	defer func() { observeReport(ctx, mode, reporter, start, err) }()
+	file, err = reporter.Generate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if failures := reporter.Failures(); strict && len(failures) > 0 {
+		file.Close()
+		f := failures[0]
+		return nil, errors.Errorf("%d panels failed to render in strict mode, panel %d of dashboard %s: %s",
+			len(failures), f.PanelID, f.Dashboard, f.Error)
+	}
+	return file, nil
+}
+
+func (h SubmitReportJobHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	log.Info("report job submitted")
+	if rejectBusy(w) {
+		return
+	}
+	reporter, err := h.reportServer.reporter(req)
+	if err != nil {
+		log.Errorf("parsing report job request error: %v", err)
+		rdr.Text(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	strict, err := strictMode(req)
+	if err != nil {
+		rdr.Text(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	mailer, err := jobDelivery(req)
+	if err != nil {
+		rdr.Text(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	j := h.jobs.submit(dashID(req), reporter, strict, mailer)
+
+	w.Header().Set("Location", "/api/jobs/"+j.id)
+	rdr.JSON(w, http.StatusAccepted, j.info())
+}
+
+func (h ReportJobStatusHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	j, ok := h.jobs.get(jobID(req))
+	if !ok {
+		rdr.Text(w, http.StatusNotFound, "report job not found")
+		return
+	}
+	rdr.JSON(w, http.StatusOK, j.info())
+}
+
+func (h ReportJobDownloadHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	j, ok := h.jobs.get(jobID(req))
+	if !ok {
+		rdr.Text(w, http.StatusNotFound, "report job not found")
+		return
+	}
+
+	if format := mux.Vars(req)["format"]; format != string(j.reporter.Format()) {
+		rdr.Text(w, http.StatusNotFound, "report job has no "+format+" file, its format is "+string(j.reporter.Format()))
+		return
+	}
+	file, status := j.result()
+	switch status {
+	case jobDone:
+	case jobFailed:
+		rdr.Text(w, http.StatusInternalServerError, j.info().Error)
+		return
+	default:
+		rdr.Text(w, http.StatusConflict, "report job is "+string(status))
+		return
+	}
+
+	w.Header().Set("Content-Type", j.reporter.Format().ContentType())
+	w.Header().Set(failedPanelsHeader, strconv.Itoa(len(j.reporter.Failures())))
+	_, err := io.Copy(w, bytes.NewReader(file))
+	if err != nil {
+		log.Errorf("copying report file of job %s to response error: %v", j.id, err)
+	}
+}
+
+func (h CacheStatsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if panelCache == nil {
+		rdr.Text(w, http.StatusNotFound, "panel cache is disabled")
+		return
+	}
+	rdr.JSON(w, http.StatusOK, panelCache.Stats())
+}
+
+func (h ArchiveListHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if reportArchive == nil {
+		rdr.Text(w, http.StatusNotFound, "report archive is disabled")
+		return
+	}
+	entries, err := reportArchive.List(req.URL.Query().Get("schedule"))
+	if err != nil {
+		log.Errorf("listing archived reports error: %v", err)
+		rdr.Text(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if entries == nil {
+		entries = []schedule.Entry{}
+	}
+	rdr.JSON(w, http.StatusOK, entries)
+}
+
+func (h ArchiveDownloadHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if reportArchive == nil {
+		rdr.Text(w, http.StatusNotFound, "report archive is disabled")
+		return
+	}
+	e, file, err := reportArchive.Open(archiveID(req))
+	if err != nil {
+		archiveError(w, err)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", report.Format(e.Format).ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", e.FileName()))
+	w.Header().Set("Content-Length", strconv.FormatInt(e.Size, 10))
+	w.Header().Set(failedPanelsHeader, strconv.Itoa(e.FailedPanels))
+	if _, err = io.Copy(w, file); err != nil {
+		log.Errorf("copying archived report %s to response error: %v", e.ID, err)
+	}
+}
+
+func (h ArchiveDeleteHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if reportArchive == nil {
+		rdr.Text(w, http.StatusNotFound, "report archive is disabled")
+		return
+	}
+	if err := reportArchive.Delete(archiveID(req)); err != nil {
+		archiveError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// archiveError responds 404 if the archived report is not found, and 500 for other errors
+func archiveError(w http.ResponseWriter, err error) {
+	if errors.Cause(err) == schedule.ErrNotFound {
+		rdr.Text(w, http.StatusNotFound, err.Error())
+		return
+	}
+	log.Errorf("archived report error: %v", err)
+	rdr.Text(w, http.StatusInternalServerError, err.Error())
+}
+
+func (h AlertWebhookHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if incidentReports == nil {
+		rdr.Text(w, http.StatusNotFound, "incident reports are disabled")
+		return
+	}
+	var hook alert.Webhook
+	if err := json.NewDecoder(req.Body).Decode(&hook); err != nil {
+		http.Error(w, "decoding alertmanager webhook error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	infos := []incidentInfo{}
+	for _, a := range hook.Alerts {
+		infos = append(infos, incidentReports.handle(a)...)
+	}
+	rdr.JSON(w, http.StatusOK, infos)
+}
+
+func (h HealthHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rdr.Text(w, http.StatusOK, "ok")
+}
+
+func (h ReadyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), readyTimeout)
+	defer cancel()
+	if err := grafana.Ping(ctx, *proto+*ip, configCredentials(*ip)); err != nil {
+		log.Warnf("grafana is not ready: %v", err)
+		rdr.Text(w, http.StatusServiceUnavailable, "grafana is not ready: "+err.Error())
+		return
+	}
+	rdr.Text(w, http.StatusOK, "ok")
+}
+
+// jobDelivery returns the delivery of the mail-to, mail-subject and mail-body parameters, which are Go templates of
+// the mail of job. It is nil without mail-to
+func jobDelivery(r *http.Request) (*delivery, error) {
+	query := r.URL.Query()
+	to := query["mail-to"]
+	if len(to) == 0 {
+		return nil, nil
+	}
+	tmpl, err := newMailTemplate(to, query.Get("mail-subject"), query.Get("mail-body"))
+	if err != nil {
+		return nil, err
+	}
+	return newDelivery(tmpl), nil
+}
+
+// rejectBusy responds 429 Too Many Requests with Retry-After if too many panel renders are queued, new reports
+// would wait too long for the renderer
+func rejectBusy(w http.ResponseWriter) bool {
+	if renderLimiter == nil || !renderLimiter.Full() {
+		return false
+	}
+	stats := renderLimiter.Stats()
+	log.Warnf("rejecting report request, %d panel renders are running and %d are queued", stats.Running, stats.Queued)
+	w.Header().Set("Retry-After", strconv.Itoa(config.GetGlobalConfig().Render.RetryAfter))
+	http.Error(w, "too many panel renders are queued, please retry later", http.StatusTooManyRequests)
+	return true
+}
+
+func archiveID(r *http.Request) string {
+	return mux.Vars(r)["archiveId"]
+}
+
+func jobID(r *http.Request) string {
+	return mux.Vars(r)["jobId"]
+}
+
 func dashID(r *http.Request) string {
 	vars := mux.Vars(r)
 	d := vars["dashId"]
@@ -83,15 +585,166 @@ func dashID(r *http.Request) string {
 	return d
 }
 
-func time(r *http.Request) grafana.TimeRange {
+// timeRange returns the time range of from, to and tz parameters, tz is the time zone of absolute times in report
+func timeRange(r *http.Request) (grafana.TimeRange, error) {
 	params := r.URL.Query()
-	t := grafana.NewTimeRange(params.Get("from"), params.Get("to"))
+	t, err := grafana.NewTimeRange(params.Get("from"), params.Get("to"), params.Get("tz"))
+	if err != nil {
+		return t, err
+	}
 	log.Infof("called with time range: %v", t)
-	return t
+	return t, nil
 }
 
-func apiToken(r *http.Request) string {
-	apiToken := r.URL.Query().Get("apitoken")
-	log.Infof("called with API Token: %s", apiToken)
-	return apiToken
+// variables returns Grafana template variables of the form var-{name}={value}, a variable can have multiple values
+func variables(r *http.Request) url.Values {
+	vars := url.Values{}
+	for k, v := range r.URL.Query() {
+		if strings.HasPrefix(k, "var-") {
+			vars[k] = v
+		}
+	}
+	log.Infof("called with variables: %v", vars)
+	return vars
+}
+
+// comparison returns the second time range of comparison report and whether panels of both ranges are stacked.
+// The range is from2 and to2, or the time range shifted back by compare, e.g. compare=7d. It is nil if neither is
+// requested
+func comparison(r *http.Request, t grafana.TimeRange) (*grafana.TimeRange, bool, error) {
+	params := r.URL.Query()
+	from2, to2, shift := params.Get("from2"), params.Get("to2"), params.Get("compare")
+
+	var stacked bool
+	switch l := params.Get("compare-layout"); l {
+	case "", "side-by-side":
+	case "stacked":
+		stacked = true
+	default:
+		return nil, false, errors.Errorf("compare-layout=%s is not supported, it should be side-by-side or stacked", l)
+	}
+
+	var (
+		compareRange grafana.TimeRange
+		err          error
+	)
+	switch {
+	case shift != "" && (from2 != "" || to2 != ""):
+		return nil, false, errors.New("compare and from2/to2 can't be requested together")
+	case shift != "":
+		compareRange, err = t.Shift(shift)
+	case from2 != "" && to2 != "":
+		compareRange, err = grafana.NewTimeRange(from2, to2, params.Get("tz"))
+	case from2 != "" || to2 != "":
+		return nil, false, errors.New("both from2 and to2 should be requested")
+	default:
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, errors.Wrap(err, "invalid compared time range")
+	}
+	log.Infof("called with compared time range: %v", compareRange)
+	return &compareRange, stacked, nil
+}
+
+// refreshCache returns whether cached panel images are rendered again, which is requested by cache=refresh
+func refreshCache(r *http.Request) (bool, error) {
+	switch c := r.URL.Query().Get("cache"); c {
+	case "":
+		return false, nil
+	case "refresh":
+		return true, nil
+	default:
+		return false, errors.Errorf("cache=%s is not supported, it should be cache=refresh", c)
+	}
+}
+
+// panelFilter returns the panels selected by rows, panels, exclude-rows and exclude-panels parameters, and by the
+// named selections of selection parameter and of selection argument. Every value is a panel ID or a title regex
+func panelFilter(r *http.Request, selection string) (grafana.PanelFilter, error) {
+	params := r.URL.Query()
+	filter := grafana.PanelFilter{
+		Rows:          params["rows"],
+		Panels:        params["panels"],
+		ExcludeRows:   params["exclude-rows"],
+		ExcludePanels: params["exclude-panels"],
+	}
+	for _, name := range []string{params.Get("selection"), selection} {
+		if name == "" {
+			continue
+		}
+		s, ok := config.GetGlobalConfig().Selection[name]
+		if !ok {
+			return filter, errors.Errorf("panel selection %s is not found in config", name)
+		}
+		filter = filter.Merge(grafana.PanelFilter{Rows: s.Rows, Panels: s.Panels, ExcludeRows: s.ExcludeRows, ExcludePanels: s.ExcludePanels})
+	}
+	if err := filter.Validate(); err != nil {
+		return filter, err
+	}
+	if !filter.IsEmpty() {
+		log.Infof("called with panel filter: %+v", filter)
+	}
+	return filter, nil
+}
+
+// renderer returns the renderer of panel images by the renderer parameter, or the renderer in config
+func renderer(r *http.Request) string {
+	if s := r.URL.Query().Get("renderer"); s != "" {
+		return s
+	}
+	return config.GetGlobalConfig().Grafana.Renderer
+}
+
+// withRenderer returns the client of g which renders panel images by renderer
+func withRenderer(g grafana.Client, renderer string) (grafana.Client, error) {
+	switch renderer {
+	case grafana.NativeRenderer:
+		return grafana.NewNativeClient(g, config.GetGlobalConfig().Grafana.Prometheus)
+	case "", grafana.GrafanaRenderer:
+		return g, nil
+	}
+	return nil, errors.Errorf("renderer=%s should be grafana or native", renderer)
+}
+
+// strictMode returns whether a report fails if any panel fails to render, which is requested by strict parameter or
+// set in config
+func strictMode(r *http.Request) (bool, error) {
+	s := r.URL.Query().Get("strict")
+	if s == "" {
+		return config.GetGlobalConfig().Report.Strict, nil
+	}
+	strict, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, errors.Errorf("strict=%s should be true or false", s)
+	}
+	return strict, nil
+}
+
+// configCredentials returns the credentials in config of Grafana at host
+func configCredentials(host string) grafana.Credentials {
+	auth := config.GetGlobalConfig().Auth[host]
+	return grafana.Credentials{Token: auth.Token, Username: auth.Username, Password: auth.Password, OrgID: auth.OrgID}
+}
+
+// credentials returns the credentials of Grafana. The Authorization header of request is forwarded, otherwise the
+// apitoken parameter or the credentials of Grafana in config are used. X-Grafana-Org-Id header selects the
+// organization
+func credentials(r *http.Request) (grafana.Credentials, error) {
+	creds := configCredentials(*ip)
+	if apiToken := r.URL.Query().Get("apitoken"); apiToken != "" {
+		creds = grafana.Credentials{Token: apiToken, OrgID: creds.OrgID}
+	}
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		creds = grafana.Credentials{Authorization: authorization, OrgID: creds.OrgID}
+	}
+	if org := r.Header.Get("X-Grafana-Org-Id"); org != "" {
+		orgID, err := strconv.Atoi(org)
+		if err != nil || orgID <= 0 {
+			return creds, errors.Errorf("X-Grafana-Org-Id %s should be a positive organization ID", org)
+		}
+		creds.OrgID = orgID
+	}
+	log.Infof("called with credentials: %s", creds)
+	return creds, nil
 }
@@ -31,34 +31,85 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/config"
 	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
 	"github.com/pingcap/tidb-inspect-tools/grafana_collector/report"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/schedule"
+	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
 type mockReport struct {
+	failures []report.Failure
+	err      error
+	format   report.Format
+	details  *report.Details
 }
 
-func (m mockReport) Generate() (pdf io.ReadCloser, err error) {
+func (m mockReport) Generate(ctx context.Context) (pdf io.ReadCloser, err error) {
+	if m.err != nil {
+		return nil, m.err
+	}
 	return ioutil.NopCloser(bytes.NewReader(nil)), nil
 }
 
 func (m mockReport) Clean() {}
 
+func (m mockReport) Progress() report.Progress {
+	return report.Progress{Total: 2, Done: 2}
+}
+
+func (m mockReport) Failures() []report.Failure {
+	return m.failures
+}
+
+func (m *mockReport) SetFormat(f report.Format) {
+	m.format = f
+}
+
+func (m mockReport) Format() report.Format {
+	if m.format == "" {
+		return report.PDF
+	}
+	return m.format
+}
+
+func (m *mockReport) SetDetails(d report.Details) {
+	m.details = &d
+}
+
+func (m mockReport) Title() string {
+	return "Dash"
+}
+
+func (m mockReport) TimeRange() grafana.TimeRange {
+	t, _ := grafana.NewTimeRange("2018-12-04T00:00:00Z", "2018-12-05T00:00:00Z", "utc")
+	return t
+}
+
 func TestV4ServeReportHandler(t *testing.T) {
 	Convey("When the v4 report server handler is called", t, func() {
 		//mock new grafana client function to capture and validate its input parameters
 		var clAPIToken string
-		newGrafanaClient := func(url string, apiToken string, timeRange grafana.TimeRange) grafana.Client {
-			clAPIToken = apiToken
-			return grafana.NewV4Client(url, apiToken, timeRange)
+		newGrafanaClient := func(url string, credentials grafana.Credentials, variables url.Values, timeRange grafana.TimeRange) grafana.Client {
+			clAPIToken = credentials.Token
+			return grafana.NewV4Client(url, credentials, variables, timeRange)
 		}
 		//mock new report function to capture and validate its input parameters
 		var repDashName string
@@ -68,7 +119,7 @@ func TestV4ServeReportHandler(t *testing.T) {
 		}
 
 		router := mux.NewRouter()
-		RegisterHandlers(router, ServeReportHandler{newGrafanaClient, newReport}, ServeReportHandler{nil, nil})
+		RegisterHandlers(router, ServeReportHandler{newGrafanaClient, newReport, nil, nil}, ServeReportHandler{nil, nil, nil, nil}, newJobRegistry(0))
 		rec := httptest.NewRecorder()
 
 		Convey("It should extract dashboard ID from the URL and forward it to the new reporter ", func() {
@@ -82,6 +133,18 @@ func TestV4ServeReportHandler(t *testing.T) {
 			router.ServeHTTP(rec, req)
 			So(clAPIToken, ShouldEqual, "1234")
 		})
+
+		Convey("It should return bad request for invalid time range", func() {
+			req, _ := http.NewRequest("GET", "/api/report/testDash?from=now-1x", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(repDashName, ShouldBeEmpty)
+
+			rec = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", "/api/report/testDash?tz=Mars/Olympus", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
 	})
 }
 
@@ -89,9 +152,13 @@ func TestV5ServeReportHandler(t *testing.T) {
 	Convey("When the v5 report server handler is called", t, func() {
 		//mock new grafana client function to capture and validate its input parameters
 		var clAPIToken string
-		newGrafanaClient := func(url string, apiToken string, timeRange grafana.TimeRange) grafana.Client {
-			clAPIToken = apiToken
-			return grafana.NewV5Client(url, apiToken, timeRange)
+		var clVariables url.Values
+		var clCredentials grafana.Credentials
+		newGrafanaClient := func(url string, credentials grafana.Credentials, variables url.Values, timeRange grafana.TimeRange) grafana.Client {
+			clAPIToken = credentials.Token
+			clCredentials = credentials
+			clVariables = variables
+			return grafana.NewV5Client(url, credentials, variables, timeRange)
 		}
 		//mock new report function to capture and validate its input parameters
 		var repDashName string
@@ -101,7 +168,7 @@ func TestV5ServeReportHandler(t *testing.T) {
 		}
 
 		router := mux.NewRouter()
-		RegisterHandlers(router, ServeReportHandler{nil, nil}, ServeReportHandler{newGrafanaClient, newReport})
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{newGrafanaClient, newReport, nil, nil}, newJobRegistry(0))
 		rec := httptest.NewRecorder()
 
 		Convey("It should extract dashboard ID from the URL and forward it to the new reporter ", func() {
@@ -115,5 +182,672 @@ func TestV5ServeReportHandler(t *testing.T) {
 			router.ServeHTTP(rec, req)
 			So(clAPIToken, ShouldEqual, "1234")
 		})
+
+		Convey("It should forward the Authorization and X-Grafana-Org-Id headers to the new Grafana Client ", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?apitoken=1234", nil)
+			req.Header.Set("Authorization", "Bearer glsa_caller")
+			req.Header.Set("X-Grafana-Org-Id", "2")
+			router.ServeHTTP(rec, req)
+			So(clCredentials, ShouldResemble, grafana.Credentials{Authorization: "Bearer glsa_caller", OrgID: 2})
+
+			rec = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", "/api/v5/report/testDash", nil)
+			req.Header.Set("X-Grafana-Org-Id", "main")
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("It should use the credentials of Grafana in config without credentials in request ", func() {
+			conf := config.GetGlobalConfig()
+			saved := *conf
+			defer func() { *conf = saved }()
+			file, err := ioutil.TempFile("", "config")
+			So(err, ShouldBeNil)
+			defer os.Remove(file.Name())
+			fmt.Fprintf(file, "[auth.\"%s\"]\nusername = \"viewer\"\npassword = \"secret\"\norg-id = 3\n", *ip)
+			file.Close()
+			So(conf.SetConfig(file.Name()), ShouldBeNil)
+
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+			router.ServeHTTP(rec, req)
+			So(clCredentials, ShouldResemble, grafana.Credentials{Username: "viewer", Password: "secret", OrgID: 3})
+			So(fmt.Sprintf("%+v", conf), ShouldNotContainSubstring, "secret")
+		})
+
+		Convey("It should extract the template variables from the URL and forward them to the new Grafana Client ", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?var-instance=a&var-instance=b&var-db=kv&from=now-1h", nil)
+			router.ServeHTTP(rec, req)
+			So(clVariables, ShouldResemble, url.Values{"var-instance": {"a", "b"}, "var-db": {"kv"}})
+		})
+	})
+}
+
+func TestReportJobHandlers(t *testing.T) {
+	Convey("When a report job is submitted", t, func() {
+		var repDashName string
+		newReport := func(g grafana.Client, dashName string, _ grafana.TimeRange) report.Report {
+			repDashName = dashName
+			return &mockReport{}
+		}
+
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil, nil}, newJobRegistry(time.Hour))
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v5/report/testDash/jobs", nil)
+		router.ServeHTTP(rec, req)
+
+		var info jobInfo
+		err := json.Unmarshal(rec.Body.Bytes(), &info)
+
+		Convey("It should return the job ID right away", func() {
+			So(rec.Code, ShouldEqual, http.StatusAccepted)
+			So(err, ShouldBeNil)
+			So(info.ID, ShouldNotBeEmpty)
+			So(info.Dashboard, ShouldEqual, "testDash")
+			So(rec.Header().Get("Location"), ShouldEqual, "/api/jobs/"+info.ID)
+			So(repDashName, ShouldEqual, "testDash")
+		})
+
+		Convey("Its status should become done with the report progress", func() {
+			var status jobInfo
+			for i := 0; i < 100 && status.Status != jobDone; i++ {
+				rec = httptest.NewRecorder()
+				req, _ = http.NewRequest("GET", "/api/jobs/"+info.ID, nil)
+				router.ServeHTTP(rec, req)
+				json.Unmarshal(rec.Body.Bytes(), &status)
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(status.Status, ShouldEqual, jobDone)
+			So(status.Progress.Total, ShouldEqual, 2)
+			So(status.Progress.Done, ShouldEqual, 2)
+			So(status.Finished, ShouldNotBeNil)
+
+			Convey("Its pdf file should be downloadable", func() {
+				rec = httptest.NewRecorder()
+				req, _ = http.NewRequest("GET", "/api/jobs/"+info.ID+"/pdf", nil)
+				router.ServeHTTP(rec, req)
+				So(rec.Code, ShouldEqual, http.StatusOK)
+				So(rec.Header().Get("Content-Type"), ShouldEqual, "application/pdf")
+			})
+		})
+
+		Convey("Unknown jobs should not be found", func() {
+			rec = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", "/api/jobs/unknown", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
+
+func TestPanelCacheHandlers(t *testing.T) {
+	Convey("When the panel cache is enabled", t, func() {
+		dir, err := ioutil.TempDir("", "cache")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		panelCache, err = grafana.NewPanelCache(dir, 0, time.Hour)
+		So(err, ShouldBeNil)
+		defer func() { panelCache = nil }()
+
+		var repClient grafana.Client
+		newReport := func(g grafana.Client, dashName string, _ grafana.TimeRange) report.Report {
+			repClient = g
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil, nil}, newJobRegistry(0))
+		rec := httptest.NewRecorder()
+
+		Convey("Reports should use a caching client, and unknown cache options should be bad requests", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?cache=refresh", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(fmt.Sprintf("%T", repClient), ShouldEqual, fmt.Sprintf("%T", grafana.NewCachingClient(nil, nil, false)))
+
+			rec = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", "/api/v5/report/testDash?cache=off", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("The counters of cache should be returned", func() {
+			req, _ := http.NewRequest("GET", "/api/cache", nil)
+			router.ServeHTTP(rec, req)
+			var stats grafana.CacheStats
+			So(json.Unmarshal(rec.Body.Bytes(), &stats), ShouldBeNil)
+			So(stats, ShouldResemble, grafana.CacheStats{})
+		})
+	})
+}
+
+func TestBundleHandlers(t *testing.T) {
+	Convey("When a bundle report is requested", t, func() {
+		conf := config.GetGlobalConfig()
+		saved := *conf
+		defer func() { *conf = saved }()
+		file, err := ioutil.TempFile("", "config")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name())
+		fmt.Fprint(file, `
+[bundle.weekly]
+title = "Weekly"
+[[bundle.weekly.dashboard]]
+dashboard = "overview"
+[[bundle.weekly.dashboard]]
+dashboard = "tikv"
+variables = { instance = ["tikv-1"] }
+`)
+		file.Close()
+		So(conf.SetConfig(file.Name()), ShouldBeNil)
+
+		var clVariables []url.Values
+		newGrafanaClient := func(url string, credentials grafana.Credentials, variables url.Values, timeRange grafana.TimeRange) grafana.Client {
+			clVariables = append(clVariables, variables)
+			return grafana.NewV5Client(url, credentials, variables, timeRange)
+		}
+		var (
+			bundleTitle string
+			dashNames   []string
+		)
+		newBundle := func(title string, dashboards []report.BundleDashboard, _ grafana.TimeRange) report.Report {
+			bundleTitle = title
+			for _, d := range dashboards {
+				dashNames = append(dashNames, d.DashName)
+			}
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{newGrafanaClient, nil, newBundle, nil}, newJobRegistry(0))
+		rec := httptest.NewRecorder()
+
+		Convey("A bundle of config should be rendered with the variables of its dashboards", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/bundle/weekly?var-db=kv", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(bundleTitle, ShouldEqual, "Weekly")
+			So(dashNames, ShouldResemble, []string{"overview", "tikv"})
+			So(clVariables, ShouldResemble, []url.Values{{"var-db": {"kv"}}, {"var-db": {"kv"}, "var-instance": {"tikv-1"}}})
+		})
+
+		Convey("Unknown bundles should not be found", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/bundle/daily", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("A bundle should be accepted in request body", func() {
+			body := `{"title":"Review", "dashboards":[{"dashboard":"pd", "variables":{"var-instance":["pd-1"]}}]}`
+			req, _ := http.NewRequest("POST", "/api/v5/bundle", strings.NewReader(body))
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(bundleTitle, ShouldEqual, "Review")
+			So(dashNames, ShouldResemble, []string{"pd"})
+			So(clVariables, ShouldResemble, []url.Values{{"var-instance": {"pd-1"}}})
+
+			rec = httptest.NewRecorder()
+			req, _ = http.NewRequest("POST", "/api/v5/bundle", strings.NewReader(`{"dashboards":[]}`))
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
+
+// dashboardClient returns a dashboard of two rows without requesting Grafana
+type dashboardClient struct {
+	grafana.Client
+}
+
+func (c dashboardClient) GetDashboard(ctx context.Context, dashName string) (grafana.Dashboard, error) {
+	rows := []grafana.Row{
+		{ID: 1, Title: "Cluster", Panels: []grafana.Panel{{ID: 2, Title: "QPS"}, {ID: 3, Title: "Latency"}}},
+		{ID: 4, Title: "RocksDB", Panels: []grafana.Panel{{ID: 5, Title: "Write Stall"}}},
+	}
+	var panels []grafana.Panel
+	for _, row := range rows {
+		panels = append(panels, row.Panels...)
+	}
+	return grafana.Dashboard{Title: dashName, Rows: rows, Panels: panels}, nil
+}
+
+func TestPanelFilterHandlers(t *testing.T) {
+	Convey("When a report of selected panels is requested", t, func() {
+		conf := config.GetGlobalConfig()
+		saved := *conf
+		defer func() { *conf = saved }()
+		file, err := ioutil.TempFile("", "config")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name())
+		fmt.Fprint(file, `
+[selection.write-stall]
+rows = ["RocksDB"]
+`)
+		file.Close()
+		So(conf.SetConfig(file.Name()), ShouldBeNil)
+
+		newGrafanaClient := func(string, grafana.Credentials, url.Values, grafana.TimeRange) grafana.Client {
+			return dashboardClient{}
+		}
+		var dash grafana.Dashboard
+		newReport := func(g grafana.Client, dashName string, _ grafana.TimeRange) report.Report {
+			dash, err = g.GetDashboard(context.Background(), dashName)
+			So(err, ShouldBeNil)
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{newGrafanaClient, newReport, nil, nil}, newJobRegistry(0))
+		rec := httptest.NewRecorder()
+		titles := func() []string {
+			var titles []string
+			for _, p := range dash.Panels {
+				titles = append(titles, p.Title)
+			}
+			return titles
+		}
+
+		Convey("Panels should be selected by the filter parameters", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?panels=^Q&panels=3&exclude-panels=Latency", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(titles(), ShouldResemble, []string{"QPS"})
+			So(dash.Filter.ExcludePanels, ShouldResemble, []string{"Latency"})
+		})
+
+		Convey("Panels should be selected by a named selection of config", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?selection=write-stall&panels=QPS", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(titles(), ShouldResemble, []string{"QPS", "Write Stall"})
+		})
+
+		Convey("Unknown selections and invalid regexes should be bad requests", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?selection=unknown", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+
+			rec = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", "/api/v5/report/testDash?rows=(", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
+
+func TestComparisonHandlers(t *testing.T) {
+	Convey("When a comparison report is requested", t, func() {
+		var (
+			repTime, repCompareRange grafana.TimeRange
+			repStacked, compared     bool
+		)
+		newReport := func(grafana.Client, string, grafana.TimeRange) report.Report {
+			return &mockReport{}
+		}
+		newComparison := func(_ grafana.Client, _ string, timeRange, compareRange grafana.TimeRange, stacked bool) report.Report {
+			repTime, repCompareRange, repStacked, compared = timeRange, compareRange, stacked, true
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil, newComparison}, newJobRegistry(0))
+		rec := httptest.NewRecorder()
+
+		Convey("from2 and to2 should be the compared time range", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?from=now-1h&to=now&from2=now-25h&to2=now-24h&compare-layout=stacked", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(compared, ShouldBeTrue)
+			So(repTime.From, ShouldEqual, "now-1h")
+			So(repCompareRange.From, ShouldEqual, "now-25h")
+			So(repCompareRange.To, ShouldEqual, "now-24h")
+			So(repStacked, ShouldBeTrue)
+		})
+
+		Convey("compare should shift the time range back", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?from=1543910400000&to=1543914000000&compare=7d", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(repCompareRange.From, ShouldEqual, "1543305600000")
+			So(repCompareRange.To, ShouldEqual, "1543309200000")
+			So(repStacked, ShouldBeFalse)
+		})
+
+		Convey("Reports without compared time range should not be comparisons", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(compared, ShouldBeFalse)
+		})
+
+		Convey("Invalid comparisons should be bad requests", func() {
+			for _, query := range []string{"compare=7", "from2=now-2h", "compare=1d&from2=now-2h&to2=now-1h", "compare=1d&compare-layout=grid"} {
+				rec = httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/api/v5/report/testDash?"+query, nil)
+				router.ServeHTTP(rec, req)
+				So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			}
+			So(compared, ShouldBeFalse)
+		})
+	})
+}
+
+func TestPartialFailureHandlers(t *testing.T) {
+	Convey("When some panels of a report failed to render", t, func() {
+		newReport := func(grafana.Client, string, grafana.TimeRange) report.Report {
+			return &mockReport{failures: []report.Failure{{Dashboard: "Dash", PanelID: 2, Title: "QPS", Error: "timeout"}}}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil, nil}, newJobRegistry(0))
+		rec := httptest.NewRecorder()
+
+		Convey("The report should be served with the number of failed panels by default", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get(failedPanelsHeader), ShouldEqual, "1")
+		})
+
+		Convey("The report should fail in strict mode", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?strict=true", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+			So(rec.Body.String(), ShouldContainSubstring, "panel 2 of dashboard Dash: timeout")
+		})
+
+		Convey("Invalid strict parameters should be bad requests", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?strict=yes", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
+
+func TestRenderLimitHandlers(t *testing.T) {
+	Convey("When too many panel renders are queued", t, func() {
+		renderLimiter = grafana.NewRenderLimiter(1, 1)
+		defer func() { renderLimiter = nil }()
+		So(renderLimiter.Acquire(context.Background()), ShouldBeNil)
+		defer renderLimiter.Release()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go renderLimiter.Acquire(ctx)
+		for i := 0; i < 100 && !renderLimiter.Full(); i++ {
+			time.Sleep(time.Millisecond)
+		}
+
+		newReport := func(grafana.Client, string, grafana.TimeRange) report.Report {
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil, nil}, newJobRegistry(0))
+
+		Convey("New reports and jobs should be rejected with Retry-After", func() {
+			for _, method := range []string{"GET", "POST"} {
+				path := "/api/v5/report/testDash"
+				if method == "POST" {
+					path += "/jobs"
+				}
+				rec := httptest.NewRecorder()
+				req, _ := http.NewRequest(method, path, nil)
+				router.ServeHTTP(rec, req)
+				So(rec.Code, ShouldEqual, http.StatusTooManyRequests)
+				So(rec.Header().Get("Retry-After"), ShouldEqual, "30")
+			}
+		})
+	})
+}
+
+func TestRendererHandlers(t *testing.T) {
+	Convey("When a renderer is requested", t, func() {
+		var renderer string
+		newReport := func(g grafana.Client, dashName string, _ grafana.TimeRange) report.Report {
+			dash, err := g.GetDashboard(context.Background(), dashName)
+			So(err, ShouldNotBeNil)
+			renderer = dash.Renderer
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil, nil}, newJobRegistry(0))
+
+		Convey("Panels should be drawn natively with renderer=native", func() {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?renderer=native", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(renderer, ShouldEqual, grafana.NativeRenderer)
+		})
+
+		Convey("Unknown renderers should be bad requests", func() {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?renderer=chrome", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
+
+func TestHealthHandlers(t *testing.T) {
+	Convey("When grafana_collector is monitored", t, func() {
+		grafanaUp := true
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !grafanaUp || r.URL.Path != "/api/org" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"id":1, "name":"Main Org."}`)
+		}))
+		defer ts.Close()
+		oldIP := *ip
+		*ip = strings.TrimPrefix(ts.URL, "http://")
+		defer func() { *ip = oldIP }()
+
+		newReport := func(grafana.Client, string, grafana.TimeRange) report.Report {
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil, nil}, newJobRegistry(0))
+		get := func(path string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", path, nil)
+			router.ServeHTTP(rec, req)
+			return rec
+		}
+
+		Convey("healthz should be ok while serving", func() {
+			So(get("/healthz").Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("readyz should be ok only if Grafana accepts the credentials", func() {
+			So(get("/readyz").Code, ShouldEqual, http.StatusOK)
+			grafanaUp = false
+			rec := get("/readyz")
+			So(rec.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(rec.Body.String(), ShouldContainSubstring, "grafana is not ready")
+		})
+
+		Convey("Report durations should be exported as metrics", func() {
+			So(get("/api/v5/report/testDash").Code, ShouldEqual, http.StatusOK)
+			rec := get("/metrics")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldContainSubstring, `grafana_collector_report_duration_seconds_count{mode="sync",result="succeeded"}`)
+			So(rec.Body.String(), ShouldContainSubstring, "grafana_collector_render_queued")
+			So(rec.Body.String(), ShouldContainSubstring, "grafana_collector_cache_hits_total")
+		})
+	})
+}
+
+func TestWorkdirFullHandlers(t *testing.T) {
+	Convey("When the work directory is full", t, func() {
+		newReport := func(grafana.Client, string, grafana.TimeRange) report.Report {
+			return &mockReport{err: errors.Wrap(report.ErrWorkdirFull, "report Dash")}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil, nil}, newJobRegistry(0))
+
+		Convey("Reports should fail with Insufficient Storage", func() {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusInsufficientStorage)
+			So(rec.Body.String(), ShouldContainSubstring, "work directory is full")
+		})
+	})
+}
+
+func TestWorkdirCleanHandlers(t *testing.T) {
+	Convey("When a report fails after its panels are written to work directory", t, func() {
+		var img bytes.Buffer
+		So(png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 10, 10))), ShouldBeNil)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/api/dashboards/uid/abc":
+				fmt.Fprint(w, cliDashJSON)
+			case strings.HasPrefix(r.URL.Path, "/render/d-solo/abc/") && r.URL.Query().Get("panelId") == "1":
+				w.Write(img.Bytes())
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}))
+		defer ts.Close()
+		oldIP := *ip
+		*ip = strings.TrimPrefix(ts.URL, "http://")
+		defer func() { *ip = oldIP }()
+		conf := config.GetGlobalConfig()
+		retryInterval, memoryPanels := conf.Grafana.RetryInterval, conf.Workdir.MemoryPanels
+		conf.Grafana.RetryInterval, conf.Workdir.MemoryPanels = 0, 0
+		defer func() { conf.Grafana.RetryInterval, conf.Workdir.MemoryPanels = retryInterval, memoryPanels }()
+
+		dir, err := ioutil.TempDir("", "workdir")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		workdir, err := report.NewWorkdir(dir, 0)
+		So(err, ShouldBeNil)
+		report.SetWorkdir(workdir)
+		report.SetFontDir("../../grafana_collector/ttf/")
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{grafana.NewV5Client, report.New, nil, nil}, newJobRegistry(0))
+
+		Convey("Its directory should be removed and its bytes released", func() {
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v5/report/abc?strict=true", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+			So(rec.Body.String(), ShouldContainSubstring, "strict mode")
+			entries, err := ioutil.ReadDir(dir)
+			So(err, ShouldBeNil)
+			So(entries, ShouldBeEmpty)
+			So(workdir.Used(), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestArchiveHandlers(t *testing.T) {
+	Convey("When reports are archived", t, func() {
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{nil, nil, nil, nil}, newJobRegistry(0))
+		rec := httptest.NewRecorder()
+
+		Convey("The archive API should be not found if the archive is disabled", func() {
+			req, _ := http.NewRequest("GET", "/api/archive", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		dir, err := ioutil.TempDir("", "archive")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		reportArchive, err = schedule.NewArchive(dir)
+		So(err, ShouldBeNil)
+		defer func() { reportArchive = nil }()
+		created := time.Date(2018, 12, 4, 1, 0, 0, 0, time.UTC)
+		daily, err := reportArchive.Save(schedule.Entry{Schedule: "daily", Format: "pdf", Created: created, FailedPanels: 1}, strings.NewReader("%PDF"))
+		So(err, ShouldBeNil)
+		_, err = reportArchive.Save(schedule.Entry{Schedule: "hourly", Format: "pdf", Created: created.Add(time.Hour)}, strings.NewReader("%PDF"))
+		So(err, ShouldBeNil)
+
+		Convey("Archived reports should be listed newest first, and filtered by schedule", func() {
+			req, _ := http.NewRequest("GET", "/api/archive", nil)
+			router.ServeHTTP(rec, req)
+			var entries []schedule.Entry
+			So(json.Unmarshal(rec.Body.Bytes(), &entries), ShouldBeNil)
+			So(entries, ShouldHaveLength, 2)
+			So(entries[0].Schedule, ShouldEqual, "hourly")
+
+			rec = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", "/api/archive?schedule=daily", nil)
+			router.ServeHTTP(rec, req)
+			So(json.Unmarshal(rec.Body.Bytes(), &entries), ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].ID, ShouldEqual, daily.ID)
+		})
+
+		Convey("An archived report should be downloaded", func() {
+			req, _ := http.NewRequest("GET", "/api/archive/"+daily.ID, nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldEqual, "%PDF")
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/pdf")
+			So(rec.Header().Get("Content-Disposition"), ShouldContainSubstring, daily.FileName())
+			So(rec.Header().Get(failedPanelsHeader), ShouldEqual, "1")
+		})
+
+		Convey("An archived report should be deleted, and unknown reports should be not found", func() {
+			req, _ := http.NewRequest("DELETE", "/api/archive/"+daily.ID, nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusNoContent)
+
+			for _, method := range []string{"GET", "DELETE"} {
+				rec = httptest.NewRecorder()
+				req, _ = http.NewRequest(method, "/api/archive/"+daily.ID, nil)
+				router.ServeHTTP(rec, req)
+				So(rec.Code, ShouldEqual, http.StatusNotFound)
+			}
+		})
+	})
+}
+
+func TestFormatHandlers(t *testing.T) {
+	Convey("When an html report is requested", t, func() {
+		var reporter *mockReport
+		newReport := func(grafana.Client, string, grafana.TimeRange) report.Report {
+			reporter = &mockReport{}
+			return reporter
+		}
+		router := mux.NewRouter()
+		jobs := newJobRegistry(time.Minute)
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil, nil}, jobs)
+		rec := httptest.NewRecorder()
+
+		Convey("The report should be generated as html, and unknown formats should be bad requests", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?format=html", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(reporter.format, ShouldEqual, report.HTML)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "text/html; charset=utf-8")
+
+			rec = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", "/api/v5/report/testDash?format=docx", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("The html file of a job should be downloaded by its format", func() {
+			req, _ := http.NewRequest("POST", "/api/v5/report/testDash/jobs?format=html", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusAccepted)
+			So(rec.Body.String(), ShouldContainSubstring, `"format": "html"`)
+			location := rec.Header().Get("Location")
+			j, ok := jobs.get(strings.TrimPrefix(location, "/api/jobs/"))
+			So(ok, ShouldBeTrue)
+			waitJob(j)
+
+			rec = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", location+"/html", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "text/html; charset=utf-8")
+
+			rec = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", location+"/pdf", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+		})
 	})
 }
@@ -0,0 +1,250 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/alert"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/config"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/report"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/schedule"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultIncidentWindow is the time range before and after the start of alert if the rule doesn't set them
+	defaultIncidentWindow = 30 * time.Minute
+	// incidentMemory is how long generated reports of alerts are remembered, so that notifications of the same
+	// alert don't generate them again
+	incidentMemory = 7 * 24 * time.Hour
+)
+
+// incidentStatus is what is done for an alert by a rule
+type incidentStatus string
+
+const (
+	incidentScheduled  incidentStatus = "scheduled"  // the report waits for the end of its time range
+	incidentGenerating incidentStatus = "generating" // the report is being generated
+	incidentSkipped    incidentStatus = "skipped"    // the time range of alert is reported already
+)
+
+// incidentInfo is the response of webhook for every alert matched by a rule
+type incidentInfo struct {
+	Rule   string         `json:"rule"`
+	Alert  string         `json:"alert"`
+	Status incidentStatus `json:"status"`
+	From   time.Time      `json:"from"`
+	To     time.Time      `json:"to"`
+}
+
+// incidentRule is an incident rule of config with its parsed matchers and variables
+type incidentRule struct {
+	name          string
+	rule          *alert.Rule
+	dashboard     string
+	before, after time.Duration
+	format        report.Format
+	// query is the parameters of the report request of rule except the time range and variables
+	query url.Values
+}
+
+// incidents generates the reports of dashboards around the alerts of Alertmanager webhooks, and saves them to archive
+type incidents struct {
+	rules        []incidentRule
+	reportServer ServeReportHandler
+	archive      *schedule.Archive
+	now          func() time.Time
+
+	mu       sync.Mutex
+	pending  map[string]*time.Timer // reports waiting for the end of their time range by rule and alert key
+	reported map[string]time.Time   // ends of the time ranges of generated reports by rule and alert key
+	// reports are generated one at a time, so that an alert storm doesn't flood Grafana
+	generating sync.Mutex
+	wg         sync.WaitGroup
+}
+
+// newIncidents creates the incident reports of the rules in config, which are generated by reportServer. Invalid
+// rules fail it
+func newIncidents(reportServer ServeReportHandler, archive *schedule.Archive) (*incidents, error) {
+	m := &incidents{
+		reportServer: reportServer,
+		archive:      archive,
+		now:          time.Now,
+		pending:      make(map[string]*time.Timer),
+		reported:     make(map[string]time.Time),
+	}
+	names := make(map[string]bool)
+	for _, conf := range config.GetGlobalConfig().Incident {
+		if !schedule.ValidName(conf.Name) {
+			return nil, errors.Errorf("incident rule name %q should be letters, digits, '_', '.' or '-'", conf.Name)
+		}
+		if names[conf.Name] {
+			return nil, errors.Errorf("incident rule %s is duplicated", conf.Name)
+		}
+		names[conf.Name] = true
+		if conf.Dashboard == "" {
+			return nil, errors.Errorf("incident rule %s has no dashboard", conf.Name)
+		}
+		if conf.Before < 0 || conf.After < 0 {
+			return nil, errors.Errorf("before and after of incident rule %s should not be negative", conf.Name)
+		}
+
+		r := incidentRule{name: conf.Name, dashboard: conf.Dashboard, before: defaultIncidentWindow, after: defaultIncidentWindow}
+		if conf.Before > 0 {
+			r.before = time.Duration(conf.Before) * time.Second
+		}
+		if conf.After > 0 {
+			r.after = time.Duration(conf.After) * time.Second
+		}
+		var err error
+		if r.rule, err = alert.NewRule(conf.Match, conf.Variables); err != nil {
+			return nil, errors.Wrapf(err, "incident rule %s", conf.Name)
+		}
+		if r.format, err = report.ParseFormat(conf.Format); err != nil {
+			return nil, errors.Wrapf(err, "incident rule %s", conf.Name)
+		}
+
+		r.query = url.Values{}
+		r.query.Set("format", string(r.format))
+		if conf.TZ != "" {
+			if _, err = grafana.NewTimeRange("", "", conf.TZ); err != nil {
+				return nil, errors.Wrapf(err, "incident rule %s", conf.Name)
+			}
+			r.query.Set("tz", conf.TZ)
+		}
+		if conf.Selection != "" {
+			r.query.Set("selection", conf.Selection)
+		}
+		if len(conf.Rows) > 0 {
+			r.query["rows"] = conf.Rows
+		}
+		if len(conf.Panels) > 0 {
+			r.query["panels"] = conf.Panels
+		}
+		m.rules = append(m.rules, r)
+	}
+	return m, nil
+}
+
+// handle generates the reports of alert by the rules matching it. The report of a firing alert is generated once the
+// time range after its start has passed, or at once to its end if it is resolved earlier. Notifications of the alert
+// whose time range is reported already are skipped
+func (m *incidents) handle(a alert.Alert) []incidentInfo {
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, to := range m.reported {
+		if now.Sub(to) > incidentMemory {
+			delete(m.reported, key)
+		}
+	}
+
+	var infos []incidentInfo
+	for _, r := range m.rules {
+		if !r.rule.Matches(a) {
+			continue
+		}
+		key := r.name + "/" + a.Key()
+		from, to := a.StartsAt.Add(-r.before), a.StartsAt.Add(r.after)
+		if a.Resolved() && a.EndsAt.After(a.StartsAt) {
+			to = a.EndsAt
+		}
+		info := incidentInfo{Rule: r.name, Alert: a.Name(), From: from, To: to}
+
+		timer, pending := m.pending[key]
+		reportedTo, reported := m.reported[key]
+		switch {
+		case pending && a.Resolved():
+			// the alert is resolved before the end of the time range of its pending report
+			timer.Stop()
+			delete(m.pending, key)
+			info.Status = m.start(key, r, a, from, to)
+		case pending || reported && !to.After(reportedTo):
+			info.Status = incidentSkipped
+		case to.After(now):
+			var t *time.Timer
+			t = time.AfterFunc(to.Sub(now), func() {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				// the report is generated by the resolved notification if the timer has been replaced or removed
+				if m.pending[key] != t {
+					return
+				}
+				delete(m.pending, key)
+				m.start(key, r, a, from, to)
+			})
+			m.pending[key] = t
+			info.Status = incidentScheduled
+		default:
+			info.Status = m.start(key, r, a, from, to)
+		}
+		log.Infof("incident rule %s %s alert %s from %v to %v", r.name, info.Status, a.Name(), from, to)
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// start generates the report of alert by rule r in the background, m.mu must be held
+func (m *incidents) start(key string, r incidentRule, a alert.Alert, from, to time.Time) incidentStatus {
+	m.reported[key] = to
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.generating.Lock()
+		defer m.generating.Unlock()
+		archived, err := m.generate(r, a, from, to)
+		if err != nil {
+			log.Errorf("generating incident report of rule %s for alert %s error: %v", r.name, a.Name(), err)
+			return
+		}
+		log.Infof("incident report of rule %s for alert %s is archived as %s", r.name, a.Name(), archived.ID)
+	}()
+	return incidentGenerating
+}
+
+// generate generates the report of alert by rule r like a report request with the alert on its cover page, and saves
+// it to archive
+func (m *incidents) generate(r incidentRule, a alert.Alert, from, to time.Time) (schedule.Entry, error) {
+	vars, err := r.rule.Variables(a)
+	if err != nil {
+		return schedule.Entry{}, errors.Wrapf(err, "incident rule %s", r.name)
+	}
+	query := url.Values{}
+	for k, v := range r.query {
+		query[k] = v
+	}
+	for k, v := range vars {
+		query[k] = v
+	}
+	e := schedule.Entry{
+		Schedule:  r.name,
+		Dashboard: r.dashboard,
+		From:      from.UTC().Format(time.RFC3339),
+		To:        to.UTC().Format(time.RFC3339),
+		Format:    string(r.format),
+		Created:   m.now(),
+		Alert:     a.Name(),
+	}
+	query.Set("from", e.From)
+	query.Set("to", e.To)
+	archived, _, err := archiveReport(m.reportServer, m.archive, reportModeIncident, query, e, func(rep report.Report) {
+		rep.SetDetails(report.Details{Title: "Alert: " + a.Name(), Lines: a.Details()})
+	})
+	return archived, errors.WithStack(err)
+}
@@ -0,0 +1,269 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/alert"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/report"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/schedule"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const incidentRules = `
+[[incident]]
+name = "tikv-down"
+match = { alertname = "TiKV_.*" }
+dashboard = "000000011"
+variables = { instance = ["{{.Labels.instance}}"] }
+rows = ["Cluster"]
+before = 600
+after = 600
+tz = "UTC"
+
+[[incident]]
+name = "overview"
+dashboard = "000000012"
+format = "html"
+`
+
+// waitArchived waits until the archive has n reports, and returns them
+func waitArchived(archive *schedule.Archive, n int) []schedule.Entry {
+	for i := 0; i < 100; i++ {
+		entries, err := archive.List("")
+		if err == nil && len(entries) >= n {
+			return entries
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	entries, _ := archive.List("")
+	return entries
+}
+
+func TestIncidents(t *testing.T) {
+	Convey("When incident reports are generated for alerts", t, func() {
+		dir, err := ioutil.TempDir("", "archive")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		archive, err := schedule.NewArchive(dir)
+		So(err, ShouldBeNil)
+
+		var (
+			mu        sync.Mutex
+			instances []string
+			reporters []*mockReport
+		)
+		newGrafanaClient := func(url string, credentials grafana.Credentials, variables url.Values, timeRange grafana.TimeRange) grafana.Client {
+			mu.Lock()
+			instances = append(instances, variables["var-instance"]...)
+			mu.Unlock()
+			return grafana.NewV5Client(url, credentials, variables, timeRange)
+		}
+		newReport := func(_ grafana.Client, dashName string, _ grafana.TimeRange) report.Report {
+			mu.Lock()
+			defer mu.Unlock()
+			rep := &mockReport{}
+			reporters = append(reporters, rep)
+			return rep
+		}
+		reportServer := ServeReportHandler{newGrafanaClient, newReport, nil, nil}
+
+		Convey("Invalid rules should be rejected", func() {
+			for _, rules := range []string{
+				"[[incident]]\nname = \"a b\"\ndashboard = \"d\"",
+				"[[incident]]\nname = \"a\"\ndashboard = \"d\"\n[[incident]]\nname = \"a\"\ndashboard = \"d\"",
+				"[[incident]]\nname = \"a\"",
+				"[[incident]]\nname = \"a\"\ndashboard = \"d\"\nmatch = { alertname = \"(\" }",
+				"[[incident]]\nname = \"a\"\ndashboard = \"d\"\nvariables = { instance = [\"{{.Labels\"] }",
+				"[[incident]]\nname = \"a\"\ndashboard = \"d\"\nbefore = -1",
+				"[[incident]]\nname = \"a\"\ndashboard = \"d\"\ntz = \"Mars/Olympus\"",
+				"[[incident]]\nname = \"a\"\ndashboard = \"d\"\nformat = \"docx\"",
+			} {
+				restore, err := setSchedules(rules)
+				So(err, ShouldBeNil)
+				_, err = newIncidents(reportServer, archive)
+				restore()
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		restore, err := setSchedules(incidentRules)
+		So(err, ShouldBeNil)
+		defer restore()
+		m, err := newIncidents(reportServer, archive)
+		So(err, ShouldBeNil)
+		now := time.Date(2018, 12, 4, 12, 0, 0, 0, time.UTC)
+		m.now = func() time.Time { return now }
+		a := alert.Alert{
+			Status:      "firing",
+			Labels:      map[string]string{"alertname": "TiKV_server_is_down", "instance": "tikv-1:20160"},
+			Annotations: map[string]string{"summary": "TiKV tikv-1:20160 is down"},
+			StartsAt:    now.Add(-time.Hour),
+			Fingerprint: "c5a5a0b9b1e0e6a2",
+		}
+
+		Convey("Reports of alerts whose time range has passed should be archived by all matching rules", func() {
+			infos := m.handle(a)
+			So(infos, ShouldHaveLength, 2)
+			So(infos[0].Status, ShouldEqual, incidentGenerating)
+			So(infos[0].From, ShouldResemble, now.Add(-70*time.Minute))
+			So(infos[0].To, ShouldResemble, now.Add(-50*time.Minute))
+			So(infos[1].To, ShouldResemble, now.Add(-30*time.Minute))
+			m.wg.Wait()
+
+			entries, err := archive.List("tikv-down")
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].Alert, ShouldEqual, "TiKV_server_is_down")
+			So(entries[0].Dashboard, ShouldEqual, "000000011")
+			So(entries[0].From, ShouldEqual, "2018-12-04T10:50:00Z")
+			So(entries[0].To, ShouldEqual, "2018-12-04T11:10:00Z")
+			entries, err = archive.List("overview")
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].Format, ShouldEqual, "html")
+
+			So(instances, ShouldResemble, []string{"tikv-1:20160"})
+			So(reporters, ShouldHaveLength, 2)
+			for _, rep := range reporters {
+				So(rep.details.Title, ShouldEqual, "Alert: TiKV_server_is_down")
+				So(rep.details.Lines, ShouldContain, "summary: TiKV tikv-1:20160 is down")
+			}
+
+			Convey("Notifications of the same alert should be skipped", func() {
+				infos := m.handle(a)
+				So(infos, ShouldHaveLength, 2)
+				So(infos[0].Status, ShouldEqual, incidentSkipped)
+				So(infos[1].Status, ShouldEqual, incidentSkipped)
+			})
+
+			Convey("An alert resolved after the time range should be reported again to its end", func() {
+				a.Status, a.EndsAt = "resolved", now
+				infos := m.handle(a)
+				So(infos[0].Status, ShouldEqual, incidentGenerating)
+				So(infos[0].To, ShouldResemble, now)
+				m.wg.Wait()
+				entries, err := archive.List("tikv-down")
+				So(err, ShouldBeNil)
+				So(entries, ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("Alerts which match no rule should not be reported", func() {
+			m.rules = m.rules[:1]
+			a.Labels["alertname"] = "PD_leader_change"
+			So(m.handle(a), ShouldBeEmpty)
+		})
+
+		Convey("The report of a firing alert should wait for the end of its time range", func() {
+			m.rules = m.rules[:1]
+			a.StartsAt = now.Add(-time.Minute)
+			infos := m.handle(a)
+			So(infos[0].Status, ShouldEqual, incidentScheduled)
+			So(m.pending, ShouldHaveLength, 1)
+			So(m.handle(a)[0].Status, ShouldEqual, incidentSkipped)
+
+			Convey("It should be generated at once to the end of alert if it is resolved earlier", func() {
+				a.Status, a.EndsAt = "resolved", now
+				infos := m.handle(a)
+				So(infos[0].Status, ShouldEqual, incidentGenerating)
+				So(infos[0].To, ShouldResemble, now)
+				So(m.pending, ShouldBeEmpty)
+				m.wg.Wait()
+				entries, err := archive.List("")
+				So(err, ShouldBeNil)
+				So(entries, ShouldHaveLength, 1)
+				So(entries[0].To, ShouldEqual, "2018-12-04T12:00:00Z")
+			})
+		})
+
+		Convey("A pending report should be generated once its time range has passed", func() {
+			m.rules = m.rules[:1]
+			m.rules[0].after = 50 * time.Millisecond
+			m.now = time.Now
+			a.StartsAt = time.Now()
+			So(m.handle(a)[0].Status, ShouldEqual, incidentScheduled)
+			So(waitArchived(archive, 1), ShouldHaveLength, 1)
+			m.mu.Lock()
+			So(m.pending, ShouldBeEmpty)
+			m.mu.Unlock()
+			m.wg.Wait()
+		})
+	})
+}
+
+func TestAlertWebhookHandler(t *testing.T) {
+	Convey("When Alertmanager posts alerts to the webhook", t, func() {
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil, nil}, ServeReportHandler{nil, nil, nil, nil}, newJobRegistry(0))
+		rec := httptest.NewRecorder()
+		body := `{"version": "4", "status": "firing", "alerts": [
+			{"status": "firing", "labels": {"alertname": "TiKV_server_is_down"}, "startsAt": "2018-12-04T00:00:00Z"},
+			{"status": "firing", "labels": {"alertname": "PD_leader_change"}, "startsAt": "2018-12-04T00:00:00Z"}]}`
+
+		Convey("The webhook should be not found without incident rules", func() {
+			req, _ := http.NewRequest("POST", "/api/alerts", strings.NewReader(body))
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		dir, err := ioutil.TempDir("", "archive")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		archive, err := schedule.NewArchive(dir)
+		So(err, ShouldBeNil)
+		restore, err := setSchedules(incidentRules)
+		So(err, ShouldBeNil)
+		defer restore()
+		newReport := func(_ grafana.Client, dashName string, _ grafana.TimeRange) report.Report {
+			return &mockReport{}
+		}
+		incidentReports, err = newIncidents(ServeReportHandler{grafana.NewV5Client, newReport, nil, nil}, archive)
+		So(err, ShouldBeNil)
+		defer func() { incidentReports = nil }()
+		incidentReports.rules = incidentReports.rules[:1]
+
+		Convey("Alerts matching rules should be reported", func() {
+			req, _ := http.NewRequest("POST", "/api/alerts", strings.NewReader(body))
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			var infos []incidentInfo
+			So(json.Unmarshal(rec.Body.Bytes(), &infos), ShouldBeNil)
+			So(infos, ShouldHaveLength, 1)
+			So(infos[0].Rule, ShouldEqual, "tikv-down")
+			So(infos[0].Alert, ShouldEqual, "TiKV_server_is_down")
+			So(infos[0].Status, ShouldEqual, incidentGenerating)
+			incidentReports.wg.Wait()
+			So(waitArchived(archive, 1), ShouldHaveLength, 1)
+		})
+
+		Convey("Invalid webhooks should be bad requests", func() {
+			req, _ := http.NewRequest("POST", "/api/alerts", strings.NewReader("{"))
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
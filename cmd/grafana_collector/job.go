@@ -0,0 +1,208 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/pborman/uuid"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/mail"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/report"
+)
+
+type jobStatus string
+
+// report job status
+const (
+	jobPending jobStatus = "pending"
+	jobRunning jobStatus = "running"
+	jobDone    jobStatus = "done"
+	jobFailed  jobStatus = "failed"
+)
+
+// job is a report which is generated in background
+type job struct {
+	id       string
+	dashID   string
+	reporter report.Report
+	strict   bool      // the job fails if any panel fails to render
+	mail     *delivery // the pdf is mailed when the job is done, nil if it is not mailed
+
+	mu       sync.Mutex
+	status   jobStatus
+	err      error
+	created  time.Time
+	finished time.Time
+	file     []byte // the pdf or html file of report
+}
+
+// jobInfo is the JSON representation of a job
+type jobInfo struct {
+	ID        string           `json:"id"`
+	Dashboard string           `json:"dashboard"`
+	Format    report.Format    `json:"format"`
+	Status    jobStatus        `json:"status"`
+	Error     string           `json:"error,omitempty"`
+	Created   time.Time        `json:"created"`
+	Finished  *time.Time       `json:"finished,omitempty"`
+	Progress  report.Progress  `json:"progress"`
+	Failures  []report.Failure `json:"failures,omitempty"`
+	Mail      *mailInfo        `json:"mail,omitempty"`
+}
+
+func (j *job) info() jobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	info := jobInfo{
+		ID:        j.id,
+		Dashboard: j.dashID,
+		Format:    j.reporter.Format(),
+		Status:    j.status,
+		Created:   j.created,
+		Progress:  j.reporter.Progress(),
+		Failures:  j.reporter.Failures(),
+	}
+	if j.err != nil {
+		info.Error = j.err.Error()
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		info.Finished = &finished
+	}
+	if j.mail != nil {
+		status := j.mail.status()
+		info.Mail = &status
+	}
+	return info
+}
+
+// result returns the report file and whether the job has finished successfully
+func (j *job) result() ([]byte, jobStatus) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file, j.status
+}
+
+func (j *job) setStatus(status jobStatus, file []byte, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+	j.file = file
+	j.err = err
+	if status == jobDone || status == jobFailed {
+		j.finished = time.Now()
+	}
+}
+
+// isExpired checks if a finished job is older than expire
+func (j *job) isExpired(now time.Time, expire time.Duration) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return !j.finished.IsZero() && now.Sub(j.finished) > expire
+}
+
+func (j *job) run() {
+	j.setStatus(jobRunning, nil, nil)
+	defer j.reporter.Clean()
+
+	// jobs outlive the submit requests, they are not cancelled by them
+	file, err := generate(context.Background(), reportModeJob, j.reporter, j.strict)
+	if err != nil {
+		log.Errorf("generating report for job %s error: %v", j.id, err)
+		j.setStatus(jobFailed, nil, err)
+		return
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		log.Errorf("reading report file of job %s error: %v", j.id, err)
+		j.setStatus(jobFailed, nil, err)
+		return
+	}
+	j.setStatus(jobDone, data, nil)
+	log.Infof("report job %s generated correctly", j.id)
+
+	if j.mail != nil {
+		format := j.reporter.Format()
+		// the job is done even if its mail fails, the file can still be downloaded
+		j.mail.send("job "+j.id, mail.Data{
+			ID:           j.id,
+			Dashboard:    j.dashID,
+			Title:        j.reporter.Title(),
+			From:         j.reporter.TimeRange().FromFormatted(),
+			To:           j.reporter.TimeRange().ToFormatted(),
+			FailedPanels: len(j.reporter.Failures()),
+		}, mail.Attachment{Name: j.dashID + "." + string(format), ContentType: format.ContentType(), Data: data})
+	}
+}
+
+// jobRegistry keeps report jobs until they are expired
+type jobRegistry struct {
+	mu     sync.Mutex
+	jobs   map[string]*job
+	expire time.Duration
+}
+
+func newJobRegistry(expire time.Duration) *jobRegistry {
+	return &jobRegistry{
+		jobs:   make(map[string]*job),
+		expire: expire,
+	}
+}
+
+// submit registers a new job and starts generating its report in background, the report is mailed by mailer if it is
+// not nil
+func (r *jobRegistry) submit(dashID string, reporter report.Report, strict bool, mailer *delivery) *job {
+	j := &job{
+		id:       uuid.New(),
+		dashID:   dashID,
+		reporter: reporter,
+		strict:   strict,
+		mail:     mailer,
+		status:   jobPending,
+		created:  time.Now(),
+	}
+
+	r.mu.Lock()
+	r.removeExpired(j.created)
+	r.jobs[j.id] = j
+	r.mu.Unlock()
+
+	go j.run()
+	return j
+}
+
+func (r *jobRegistry) get(id string) (*job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeExpired(time.Now())
+	j, ok := r.jobs[id]
+	return j, ok
+}
+
+// removeExpired drops finished jobs and their report files, the caller must hold r.mu
+func (r *jobRegistry) removeExpired(now time.Time) {
+	for id, j := range r.jobs {
+		if j.isExpired(now, r.expire) {
+			log.Infof("report job %s is expired", id)
+			delete(r.jobs, id)
+		}
+	}
+}
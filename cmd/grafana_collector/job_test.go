@@ -0,0 +1,77 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type failedReport struct {
+	mockReport
+}
+
+func (f failedReport) Generate(ctx context.Context) (io.ReadCloser, error) {
+	return nil, errors.New("rendering failed")
+}
+
+func waitJob(j *job) {
+	for i := 0; i < 100; i++ {
+		if _, status := j.result(); status == jobDone || status == jobFailed {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJobRegistry(t *testing.T) {
+	Convey("When report jobs are submitted to the registry", t, func() {
+		jobs := newJobRegistry(time.Minute)
+		done := jobs.submit("testDash", &mockReport{}, false, nil)
+		failed := jobs.submit("testDash", &failedReport{}, false, nil)
+		waitJob(done)
+		waitJob(failed)
+
+		Convey("Successful jobs should keep the pdf data", func() {
+			pdf, status := done.result()
+			So(status, ShouldEqual, jobDone)
+			So(pdf, ShouldNotBeNil)
+		})
+
+		Convey("Failed jobs should keep the error", func() {
+			_, status := failed.result()
+			So(status, ShouldEqual, jobFailed)
+			So(failed.info().Error, ShouldEqual, "rendering failed")
+		})
+
+		Convey("Finished jobs should be removed after they are expired", func() {
+			_, ok := jobs.get(done.id)
+			So(ok, ShouldBeTrue)
+
+			jobs.mu.Lock()
+			jobs.removeExpired(time.Now().Add(2 * time.Minute))
+			jobs.mu.Unlock()
+
+			_, ok = jobs.get(done.id)
+			So(ok, ShouldBeFalse)
+			_, ok = jobs.get(failed.id)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
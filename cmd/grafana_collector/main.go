@@ -36,12 +36,14 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/ngaut/log"
 	"github.com/pingcap/tidb-inspect-tools/grafana_collector/config"
 	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
 	"github.com/pingcap/tidb-inspect-tools/grafana_collector/report"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/schedule"
 	"github.com/pingcap/tidb-inspect-tools/pkg/utils"
 )
 
@@ -58,10 +60,18 @@ var (
 )
 
 var (
-	cfg *config.Config
+	cfg           *config.Config
+	panelCache    *grafana.PanelCache
+	renderLimiter *grafana.RenderLimiter
+	reportArchive *schedule.Archive
+	// incidentReports is nil if there are no incident rules in config
+	incidentReports *incidents
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		os.Exit(reportCommand(os.Args[2:], os.Stderr))
+	}
 	flag.Parse()
 
 	if *printVersion {
@@ -82,6 +92,26 @@ func main() {
 	}
 	report.SetFontDir(*fontDir)
 
+	workdir, err := report.NewWorkdir(cfg.Workdir.Dir, cfg.Workdir.MaxSize*1024*1024)
+	if err != nil {
+		log.Fatalf("creating work directory error: %v", err)
+	}
+	report.SetWorkdir(workdir)
+
+	if cfg.Cache.Enable {
+		panelCache, err = grafana.NewPanelCache(cfg.Cache.Dir, cfg.Cache.MaxSize*1024*1024, time.Duration(cfg.Cache.TTL)*time.Second)
+		if err != nil {
+			log.Fatalf("creating panel cache error: %v", err)
+		}
+	}
+
+	renderLimiter = grafana.NewRenderLimiter(cfg.Render.MaxConcurrent, cfg.Render.MaxQueued)
+
+	reportArchive, err = schedule.NewArchive(cfg.Archive.Dir)
+	if err != nil {
+		log.Fatalf("creating report archive error: %v", err)
+	}
+
 	log.SetLevelByString(*logLevel)
 	if *logFile != "" {
 		log.SetOutputByName(*logFile)
@@ -92,13 +122,27 @@ func main() {
 		}
 	}
 
-	log.Infof("grafana_collector is serving at '%s' and using grafana at '%s%s'", *port, *proto, *ip)
+	log.Infof("grafana_collector is serving at '%s' and using grafana at '%s'", *port, grafana.RedactURL(*proto+*ip))
+
+	reportServerV5 := ServeReportHandler{grafana.NewV5Client, report.New, report.NewBundle, report.NewComparison}
+	scheduler, err := newScheduler(reportServerV5, reportArchive, time.Duration(cfg.Archive.Retention)*time.Second)
+	if err != nil {
+		log.Fatalf("invalid schedule: %v", err)
+	}
+	scheduler.start()
+	if len(cfg.Incident) > 0 {
+		incidentReports, err = newIncidents(reportServerV5, reportArchive)
+		if err != nil {
+			log.Fatalf("invalid incident rule: %v", err)
+		}
+	}
 
 	router := mux.NewRouter()
 	RegisterHandlers(
 		router,
-		ServeReportHandler{grafana.NewV4Client, report.New},
-		ServeReportHandler{grafana.NewV5Client, report.New},
+		ServeReportHandler{grafana.NewV4Client, report.New, report.NewBundle, report.NewComparison},
+		reportServerV5,
+		newJobRegistry(time.Duration(cfg.Job.ExpireTime)*time.Second),
 	)
 
 	sc := make(chan os.Signal, 1)
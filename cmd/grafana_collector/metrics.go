@@ -0,0 +1,141 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/report"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "grafana_collector"
+
+// modes of requesting reports
+const (
+	reportModeSync     = "sync"
+	reportModeJob      = "job"
+	reportModeCLI      = "cli"      // reports generated by report command
+	reportModeSchedule = "schedule" // reports generated by schedules
+	reportModeIncident = "incident" // reports generated for alerts
+)
+
+// results of generated reports
+const (
+	reportSucceeded = "succeeded"
+	reportPartial   = "partial" // some panels failed to render and are drawn as placeholders
+	reportFailed    = "failed"
+	reportCancelled = "cancelled"
+)
+
+var (
+	reportDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "report",
+			Name:      "duration_seconds",
+			Help:      "Bucketed histogram of the time of generating reports, by how they are requested and their results.",
+			Buckets:   prometheus.ExponentialBuckets(0.5, 2, 14),
+		}, []string{"mode", "result"})
+
+	renderRunning = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "render",
+			Name:      "running",
+			Help:      "The number of panel renders which are running.",
+		}, func() float64 { return float64(renderStats().Running) })
+
+	renderQueued = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "render",
+			Name:      "queued",
+			Help:      "The number of panel renders which are waiting for the render limit.",
+		}, func() float64 { return float64(renderStats().Queued) })
+
+	cacheHits = prometheus.NewCounterFunc(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "hits_total",
+			Help:      "Counter of panel images found in cache.",
+		}, func() float64 { return float64(cacheStats().Hits) })
+
+	cacheMisses = prometheus.NewCounterFunc(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "misses_total",
+			Help:      "Counter of panel images not found in cache.",
+		}, func() float64 { return float64(cacheStats().Misses) })
+
+	cacheEntries = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "entries",
+			Help:      "The number of panel images in cache.",
+		}, func() float64 { return float64(cacheStats().Entries) })
+
+	cacheSize = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "size_bytes",
+			Help:      "The size of panel images in cache.",
+		}, func() float64 { return float64(cacheStats().Size) })
+)
+
+func init() {
+	prometheus.MustRegister(reportDuration)
+	prometheus.MustRegister(renderRunning)
+	prometheus.MustRegister(renderQueued)
+	prometheus.MustRegister(cacheHits)
+	prometheus.MustRegister(cacheMisses)
+	prometheus.MustRegister(cacheEntries)
+	prometheus.MustRegister(cacheSize)
+}
+
+// observeReport records the time since start of generating reporter and its result
+func observeReport(ctx context.Context, mode string, reporter report.Report, start time.Time, err error) {
+	result := reportSucceeded
+	switch {
+	case ctx.Err() != nil:
+		result = reportCancelled
+	case err != nil:
+		result = reportFailed
+	case len(reporter.Failures()) > 0:
+		result = reportPartial
+	}
+	reportDuration.WithLabelValues(mode, result).Observe(time.Since(start).Seconds())
+}
+
+// renderStats returns the stats of render limiter, which are zero without limiter
+func renderStats() grafana.RenderStats {
+	if renderLimiter == nil {
+		return grafana.RenderStats{}
+	}
+	return renderLimiter.Stats()
+}
+
+// cacheStats returns the stats of panel cache, which are zero if cache is disabled
+func cacheStats() grafana.CacheStats {
+	if panelCache == nil {
+		return grafana.CacheStats{}
+	}
+	return panelCache.Stats()
+}
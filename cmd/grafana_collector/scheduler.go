@@ -0,0 +1,277 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ngaut/log"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/config"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/mail"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/report"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/schedule"
+	"github.com/pkg/errors"
+)
+
+// scheduler generates the reports of the schedules in config by cron, and saves them to archive
+type scheduler struct {
+	entries      []scheduleEntry
+	reportServer ServeReportHandler
+	archive      *schedule.Archive
+	retention    time.Duration // archived reports are removed after retention, 0 keeps them forever
+
+	mu      sync.Mutex
+	running map[string]bool // names of schedules whose reports are being generated
+	wg      sync.WaitGroup
+}
+
+// scheduleEntry is a schedule of config with its parsed cron and time zone
+type scheduleEntry struct {
+	name      string
+	cron      schedule.Cron
+	tz        string // time zone of both cron and report, UTC by default
+	location  *time.Location
+	dashboard string
+	from, to  string
+	format    report.Format
+	// query is the parameters of the report request of schedule, except the time range resolved when it runs
+	query url.Values
+	mail  *mail.Template // the report is mailed by the template, nil if it is not mailed
+}
+
+// newScheduler creates the scheduler of the schedules in config, which generates reports by reportServer. Invalid
+// schedules fail it
+func newScheduler(reportServer ServeReportHandler, archive *schedule.Archive, retention time.Duration) (*scheduler, error) {
+	s := &scheduler{reportServer: reportServer, archive: archive, retention: retention, running: make(map[string]bool)}
+	names := make(map[string]bool)
+	for _, conf := range config.GetGlobalConfig().Schedule {
+		if !schedule.ValidName(conf.Name) {
+			return nil, errors.Errorf("schedule name %q should be letters, digits, '_', '.' or '-'", conf.Name)
+		}
+		if names[conf.Name] {
+			return nil, errors.Errorf("schedule %s is duplicated", conf.Name)
+		}
+		names[conf.Name] = true
+		if conf.Dashboard == "" {
+			return nil, errors.Errorf("schedule %s has no dashboard", conf.Name)
+		}
+
+		e := scheduleEntry{name: conf.Name, tz: conf.TZ, dashboard: conf.Dashboard, from: conf.From, to: conf.To}
+		var err error
+		if e.cron, err = schedule.ParseCron(conf.Cron); err != nil {
+			return nil, errors.Wrapf(err, "schedule %s", conf.Name)
+		}
+		// cron and the time range of report are in the same time zone, so that now-1d/d is the day before cron fires
+		if e.tz == "" {
+			e.tz = "UTC"
+		}
+		if e.location, err = time.LoadLocation(e.tz); err != nil {
+			return nil, errors.Errorf("unknown time zone %s of schedule %s", e.tz, conf.Name)
+		}
+		if e.from == "" {
+			e.from = "now-1d"
+		}
+		if e.to == "" {
+			e.to = "now"
+		}
+		if _, err = grafana.NewTimeRange(e.from, e.to, e.tz); err != nil {
+			return nil, errors.Wrapf(err, "schedule %s", conf.Name)
+		}
+		if e.format, err = report.ParseFormat(conf.Format); err != nil {
+			return nil, errors.Wrapf(err, "schedule %s", conf.Name)
+		}
+
+		if len(conf.MailTo) > 0 {
+			if e.mail, err = newMailTemplate(conf.MailTo, conf.MailSubject, conf.MailBody); err != nil {
+				return nil, errors.Wrapf(err, "schedule %s", conf.Name)
+			}
+		}
+
+		e.query = url.Values{}
+		e.query.Set("format", string(e.format))
+		e.query.Set("tz", e.tz)
+		if conf.Selection != "" {
+			e.query.Set("selection", conf.Selection)
+		}
+		for name, values := range conf.Variables {
+			e.query["var-"+name] = values
+		}
+		s.entries = append(s.entries, e)
+	}
+	return s, nil
+}
+
+// start runs the schedules at every minute which matches their cron, and removes expired reports from archive
+func (s *scheduler) start() {
+	log.Infof("starting scheduler of %d schedules", len(s.entries))
+	s.prune(time.Now())
+	go func() {
+		for {
+			now := time.Now()
+			next := now.Truncate(time.Minute).Add(time.Minute)
+			time.Sleep(next.Sub(now))
+			s.run(next)
+			s.prune(next)
+		}
+	}()
+}
+
+// run generates the reports of schedules whose cron matches the minute of now, a schedule is skipped if its last
+// report is still being generated
+func (s *scheduler) run(now time.Time) {
+	for _, e := range s.entries {
+		if !e.cron.Match(now.In(e.location)) {
+			continue
+		}
+		s.mu.Lock()
+		if s.running[e.name] {
+			s.mu.Unlock()
+			log.Warnf("skip schedule %s at %v, its last report is still being generated", e.name, now)
+			continue
+		}
+		s.running[e.name] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func(e scheduleEntry) {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.running, e.name)
+				s.mu.Unlock()
+			}()
+			archived, err := s.generate(e, now)
+			if err != nil {
+				log.Errorf("generating report of schedule %s error: %v", e.name, err)
+				return
+			}
+			log.Infof("report of schedule %s is archived as %s", e.name, archived.ID)
+		}(e)
+	}
+}
+
+// generate generates the report of schedule e like a report request, and saves it to archive. The relative time
+// range of schedule is resolved when it runs, so the archived report has the absolute time range it covers
+func (s *scheduler) generate(e scheduleEntry, now time.Time) (schedule.Entry, error) {
+	t, err := grafana.NewTimeRange(e.from, e.to, e.tz)
+	if err != nil {
+		return schedule.Entry{}, errors.Wrapf(err, "schedule %s", e.name)
+	}
+	from, err := t.FromTime()
+	if err != nil {
+		return schedule.Entry{}, errors.Wrapf(err, "schedule %s", e.name)
+	}
+	to, err := t.ToTime()
+	if err != nil {
+		return schedule.Entry{}, errors.Wrapf(err, "schedule %s", e.name)
+	}
+	query := url.Values{}
+	for k, v := range e.query {
+		query[k] = v
+	}
+	entry := schedule.Entry{
+		Schedule:  e.name,
+		Dashboard: e.dashboard,
+		From:      from.UTC().Format(time.RFC3339),
+		To:        to.UTC().Format(time.RFC3339),
+		Format:    string(e.format),
+		Created:   now,
+	}
+	query.Set("from", entry.From)
+	query.Set("to", entry.To)
+	archived, reporter, err := archiveReport(s.reportServer, s.archive, reportModeSchedule, query, entry, nil)
+	if err != nil {
+		return archived, errors.WithStack(err)
+	}
+	if e.mail != nil {
+		// the report stays in archive if its mail fails, failures are logged by the delivery
+		s.mail(e, archived, reporter)
+	}
+	return archived, nil
+}
+
+// archiveReport generates the report of the dashboard of e like a report request with the parameters of query, and
+// saves it to archive as e. prepare is called with the reporter before generating if it is not nil. The returned
+// reporter is cleaned, but its title and time range are still known
+func archiveReport(reportServer ServeReportHandler, archive *schedule.Archive, mode string, query url.Values, e schedule.Entry,
+	prepare func(report.Report)) (schedule.Entry, report.Report, error) {
+	req, err := http.NewRequest("GET", "/api/v5/report/"+url.PathEscape(e.Dashboard)+"?"+query.Encode(), nil)
+	if err != nil {
+		return e, nil, errors.WithStack(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"dashId": e.Dashboard})
+	reporter, err := reportServer.reporter(req)
+	if err != nil {
+		return e, nil, errors.WithStack(err)
+	}
+	defer reporter.Clean()
+	if prepare != nil {
+		prepare(reporter)
+	}
+
+	// archived reports are not cancelled, like report jobs
+	file, err := generate(context.Background(), mode, reporter, config.GetGlobalConfig().Report.Strict)
+	if err != nil {
+		return e, nil, errors.WithStack(err)
+	}
+	defer file.Close()
+	e.FailedPanels = len(reporter.Failures())
+	archived, err := archive.Save(e, file)
+	return archived, reporter, errors.WithStack(err)
+}
+
+// mail sends the archived report of schedule e by its mail template
+func (s *scheduler) mail(e scheduleEntry, archived schedule.Entry, reporter report.Report) error {
+	_, file, err := s.archive.Open(archived.ID)
+	if err != nil {
+		log.Errorf("opening archived report %s to mail error: %v", archived.ID, err)
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		log.Errorf("reading archived report %s to mail error: %v", archived.ID, err)
+		return errors.WithStack(err)
+	}
+	return newDelivery(e.mail).send("schedule "+e.name+" report "+archived.ID, mail.Data{
+		ID:           archived.ID,
+		Schedule:     e.name,
+		Dashboard:    e.dashboard,
+		Title:        reporter.Title(),
+		From:         reporter.TimeRange().FromFormatted(),
+		To:           reporter.TimeRange().ToFormatted(),
+		FailedPanels: archived.FailedPanels,
+	}, mail.Attachment{Name: archived.FileName(), ContentType: e.format.ContentType(), Data: data})
+}
+
+// prune removes the archived reports older than retention
+func (s *scheduler) prune(now time.Time) {
+	if s.retention <= 0 {
+		return
+	}
+	removed, err := s.archive.Prune(now.Add(-s.retention))
+	if err != nil {
+		log.Errorf("removing expired archived reports error: %v", err)
+	}
+	if removed > 0 {
+		log.Infof("removed %d archived reports older than %v", removed, s.retention)
+	}
+}
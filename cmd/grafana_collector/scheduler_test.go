@@ -0,0 +1,194 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/config"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/mail"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/report"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/schedule"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// setSchedules loads schedules of toml to the global config, and returns the function restoring it
+func setSchedules(schedules string) (func(), error) {
+	conf := config.GetGlobalConfig()
+	saved := *conf
+	file, err := ioutil.TempFile("", "config")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	fmt.Fprint(file, schedules)
+	file.Close()
+	return func() { *conf = saved }, conf.SetConfig(file.Name())
+}
+
+func TestScheduler(t *testing.T) {
+	Convey("When reports are scheduled", t, func() {
+		dir, err := ioutil.TempDir("", "archive")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		archive, err := schedule.NewArchive(dir)
+		So(err, ShouldBeNil)
+
+		var (
+			mu        sync.Mutex
+			instances []string
+			dashNames []string
+		)
+		// reports of schedules are generated concurrently
+		newGrafanaClient := func(url string, credentials grafana.Credentials, variables url.Values, timeRange grafana.TimeRange) grafana.Client {
+			mu.Lock()
+			instances = append(instances, variables["var-instance"]...)
+			mu.Unlock()
+			return grafana.NewV5Client(url, credentials, variables, timeRange)
+		}
+		newReport := func(_ grafana.Client, dashName string, _ grafana.TimeRange) report.Report {
+			mu.Lock()
+			dashNames = append(dashNames, dashName)
+			mu.Unlock()
+			return &mockReport{failures: []report.Failure{{PanelID: 2, Error: "timeout"}}}
+		}
+		reportServer := ServeReportHandler{newGrafanaClient, newReport, nil, nil}
+
+		Convey("Invalid schedules should be rejected", func() {
+			for _, schedules := range []string{
+				"[[schedule]]\nname = \"a b\"\ncron = \"@daily\"\ndashboard = \"d\"",
+				"[[schedule]]\nname = \"a\"\ncron = \"@daily\"\ndashboard = \"d\"\n[[schedule]]\nname = \"a\"\ncron = \"@daily\"\ndashboard = \"d\"",
+				"[[schedule]]\nname = \"a\"\ncron = \"0 25 * * *\"\ndashboard = \"d\"",
+				"[[schedule]]\nname = \"a\"\ncron = \"@daily\"",
+				"[[schedule]]\nname = \"a\"\ncron = \"@daily\"\ndashboard = \"d\"\ntz = \"Mars/Olympus\"",
+				"[[schedule]]\nname = \"a\"\ncron = \"@daily\"\ndashboard = \"d\"\nfrom = \"yesterday\"",
+				"[[schedule]]\nname = \"a\"\ncron = \"@daily\"\ndashboard = \"d\"\nformat = \"docx\"",
+			} {
+				restore, err := setSchedules(schedules)
+				So(err, ShouldBeNil)
+				_, err = newScheduler(reportServer, archive, 0)
+				restore()
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("Reports of schedules matching the minute should be archived", func() {
+			restore, err := setSchedules(`
+[[schedule]]
+name = "daily"
+cron = "0 1 * * *"
+dashboard = "000000011"
+variables = { instance = ["tikv-1", "tikv-2"] }
+from = "now-1d/d"
+to = "now-1d/d"
+tz = "UTC"
+
+[[schedule]]
+name = "hourly"
+cron = "@hourly"
+dashboard = "000000012"
+format = "html"
+`)
+			So(err, ShouldBeNil)
+			defer restore()
+			s, err := newScheduler(reportServer, archive, 0)
+			So(err, ShouldBeNil)
+			// cron of schedules without tz is in UTC like their time ranges
+			So(s.entries[1].location, ShouldEqual, time.UTC)
+			So(s.entries[1].query.Get("tz"), ShouldEqual, "UTC")
+
+			s.run(time.Date(2018, 12, 4, 1, 30, 0, 0, time.UTC))
+			s.wg.Wait()
+			So(dashNames, ShouldBeEmpty)
+
+			s.run(time.Date(2018, 12, 4, 1, 0, 0, 0, time.UTC))
+			s.wg.Wait()
+			today := time.Now().UTC().Truncate(24 * time.Hour)
+			So(dashNames, ShouldHaveLength, 2)
+			So(instances, ShouldResemble, []string{"tikv-1", "tikv-2"})
+			entries, err := archive.List("daily")
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].ID, ShouldEqual, "daily-20181204-010000")
+			So(entries[0].Dashboard, ShouldEqual, "000000011")
+			So(entries[0].From, ShouldEqual, today.Add(-24*time.Hour).Format(time.RFC3339))
+			So(entries[0].To, ShouldEqual, today.Format(time.RFC3339))
+			So(entries[0].Format, ShouldEqual, "pdf")
+			So(entries[0].FailedPanels, ShouldEqual, 1)
+			entries, err = archive.List("hourly")
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+			from, err := time.Parse(time.RFC3339, entries[0].From)
+			So(err, ShouldBeNil)
+			to, err := time.Parse(time.RFC3339, entries[0].To)
+			So(err, ShouldBeNil)
+			So(to.Sub(from), ShouldEqual, 24*time.Hour)
+			So(entries[0].Format, ShouldEqual, "html")
+		})
+
+		Convey("Reports of schedules should be mailed from archive", func() {
+			sink, err := mail.NewSink(nil, "", "")
+			So(err, ShouldBeNil)
+			defer sink.Close()
+			defer useSink(sink)()
+			restore, err := setSchedules(`
+[[schedule]]
+name = "daily"
+cron = "@daily"
+dashboard = "000000011"
+mail-to = ["{{.Schedule}}@example.com"]
+mail-subject = "{{.ID}}: {{.Title}}"
+`)
+			So(err, ShouldBeNil)
+			defer restore()
+			s, err := newScheduler(reportServer, archive, 0)
+			So(err, ShouldBeNil)
+
+			s.run(time.Date(2018, 12, 4, 0, 0, 0, 0, time.Local))
+			s.wg.Wait()
+			messages := sink.Messages()
+			So(messages, ShouldHaveLength, 1)
+			So(messages[0].To, ShouldResemble, []string{"daily@example.com"})
+			So(string(messages[0].Data), ShouldContainSubstring, "Subject: daily-2018120")
+			So(string(messages[0].Data), ShouldContainSubstring, ".pdf")
+		})
+
+		Convey("Reports older than retention should be removed", func() {
+			restore, err := setSchedules("")
+			So(err, ShouldBeNil)
+			defer restore()
+			s, err := newScheduler(reportServer, archive, time.Hour)
+			So(err, ShouldBeNil)
+			now := time.Now()
+			_, err = archive.Save(schedule.Entry{Schedule: "old", Format: "pdf", Created: now.Add(-2 * time.Hour)}, strings.NewReader("%PDF"))
+			So(err, ShouldBeNil)
+			_, err = archive.Save(schedule.Entry{Schedule: "new", Format: "pdf", Created: now}, strings.NewReader("%PDF"))
+			So(err, ShouldBeNil)
+
+			s.prune(now)
+			entries, err := archive.List("")
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].Schedule, ShouldEqual, "new")
+		})
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alert
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// timeFormat is the format of the times of alerts on the cover page
+const timeFormat = "2006-01-02 15:04:05 MST"
+
+// Webhook is the payload of an Alertmanager webhook notification, alerts of a group are notified together
+type Webhook struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+// Alert is an alert of webhook notification, EndsAt is only meaningful once it is resolved
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// Name ... returns the alertname label of alert
+func (a Alert) Name() string {
+	return a.Labels["alertname"]
+}
+
+// Resolved ... checks if alert is resolved, the alerts which are not resolved are firing
+func (a Alert) Resolved() bool {
+	return a.Status == "resolved"
+}
+
+// Key ... identifies an occurrence of alert, which is notified again while it is firing and once it is resolved. It is
+// the fingerprint of alert and its start, alerts of old Alertmanagers without fingerprint are identified by labels
+func (a Alert) Key() string {
+	id := a.Fingerprint
+	if id == "" {
+		id = strings.Join(pairs(a.Labels), ",")
+	}
+	return id + "@" + a.StartsAt.UTC().Format(time.RFC3339)
+}
+
+// Details ... returns the lines of the status, times, labels and annotations of alert, which are printed on the
+// cover page of its report
+func (a Alert) Details() []string {
+	status := a.Status
+	if status == "" {
+		status = "firing"
+	}
+	lines := []string{"Status: " + status, "Started at: " + a.StartsAt.UTC().Format(timeFormat)}
+	if a.Resolved() {
+		lines = append(lines, "Resolved at: "+a.EndsAt.UTC().Format(timeFormat))
+	}
+	if len(a.Labels) > 0 {
+		lines = append(lines, "Labels: "+strings.Join(pairs(a.Labels), ", "))
+	}
+	for _, k := range sortedKeys(a.Annotations) {
+		lines = append(lines, fmt.Sprintf("%s: %s", k, a.Annotations[k]))
+	}
+	if a.GeneratorURL != "" {
+		lines = append(lines, "Source: "+a.GeneratorURL)
+	}
+	return lines
+}
+
+// pairs ... returns name=value of labels in the order of their names
+func pairs(labels map[string]string) []string {
+	keys := sortedKeys(labels)
+	for i, k := range keys {
+		keys[i] = k + "=" + labels[k]
+	}
+	return keys
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alert
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const webhookJSON = `{
+  "version": "4",
+  "groupKey": "{}:{alertname=\"TiKV_server_is_down\"}",
+  "status": "resolved",
+  "receiver": "grafana_collector",
+  "groupLabels": {"alertname": "TiKV_server_is_down"},
+  "commonLabels": {"alertname": "TiKV_server_is_down", "severity": "critical"},
+  "commonAnnotations": {},
+  "externalURL": "http://alertmanager:9093",
+  "alerts": [{
+    "status": "resolved",
+    "labels": {"alertname": "TiKV_server_is_down", "instance": "tikv-1:20160", "severity": "critical"},
+    "annotations": {"summary": "TiKV tikv-1:20160 is down", "value": "0"},
+    "startsAt": "2018-12-04T08:00:00.123+08:00",
+    "endsAt": "2018-12-04T08:20:00+08:00",
+    "generatorURL": "http://prometheus:9090/graph?g0.expr=up",
+    "fingerprint": "c5a5a0b9b1e0e6a2"
+  }]
+}`
+
+func TestWebhook(t *testing.T) {
+	Convey("When an Alertmanager webhook is decoded", t, func() {
+		var w Webhook
+		So(json.Unmarshal([]byte(webhookJSON), &w), ShouldBeNil)
+		So(w.Alerts, ShouldHaveLength, 1)
+		a := w.Alerts[0]
+
+		Convey("The alert should have its name, status and times", func() {
+			So(a.Name(), ShouldEqual, "TiKV_server_is_down")
+			So(a.Resolved(), ShouldBeTrue)
+			So(a.EndsAt.Sub(a.StartsAt), ShouldEqual, 20*time.Minute-123*time.Millisecond)
+		})
+
+		Convey("The key should be the fingerprint and start of alert", func() {
+			So(a.Key(), ShouldEqual, "c5a5a0b9b1e0e6a2@2018-12-04T00:00:00Z")
+			a.Fingerprint = ""
+			So(a.Key(), ShouldEqual, "alertname=TiKV_server_is_down,instance=tikv-1:20160,severity=critical@2018-12-04T00:00:00Z")
+		})
+
+		Convey("The details should list the status, times, labels and annotations", func() {
+			So(a.Details(), ShouldResemble, []string{
+				"Status: resolved",
+				"Started at: 2018-12-04 00:00:00 UTC",
+				"Resolved at: 2018-12-04 00:20:00 UTC",
+				"Labels: alertname=TiKV_server_is_down, instance=tikv-1:20160, severity=critical",
+				"summary: TiKV tikv-1:20160 is down",
+				"value: 0",
+				"Source: http://prometheus:9090/graph?g0.expr=up",
+			})
+			a.Status = "firing"
+			So(a.Details()[0], ShouldEqual, "Status: firing")
+			So(a.Details()[2], ShouldStartWith, "Labels: ")
+		})
+	})
+}
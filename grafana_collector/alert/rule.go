@@ -0,0 +1,87 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alert
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"sort"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Rule matches alerts by their labels, and maps them to the template variables of a dashboard
+type Rule struct {
+	matchers  map[string]*regexp.Regexp
+	variables map[string][]*template.Template
+}
+
+// NewRule ... parses the regexes of labels which alerts must match, and the Go templates of variable values, e.g.
+// instance = ["{{.Labels.instance}}"]. Regexes are anchored like those of Alertmanager, and a missing label is
+// matched as an empty value. A rule without regexes matches every alert
+func NewRule(match map[string]string, variables map[string][]string) (*Rule, error) {
+	r := &Rule{matchers: make(map[string]*regexp.Regexp), variables: make(map[string][]*template.Template)}
+	for label, expr := range match {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, errors.Errorf("parsing regex %q of label %s error: %v", expr, label, err)
+		}
+		r.matchers[label] = re
+	}
+	for name, values := range variables {
+		for _, v := range values {
+			tmpl, err := template.New(name).Option("missingkey=zero").Parse(v)
+			if err != nil {
+				return nil, errors.Errorf("parsing template %q of variable %s error: %v", v, name, err)
+			}
+			r.variables[name] = append(r.variables[name], tmpl)
+		}
+	}
+	return r, nil
+}
+
+// Matches ... checks if the labels of alert match all regexes of rule
+func (r *Rule) Matches(a Alert) bool {
+	for label, re := range r.matchers {
+		if !re.MatchString(a.Labels[label]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Variables ... executes the templates of variables with alert, and returns the values as var-<name> parameters.
+// Empty values are dropped, so a variable whose values are all empty keeps its default of dashboard
+func (r *Rule) Variables(a Alert) (url.Values, error) {
+	names := make([]string, 0, len(r.variables))
+	for name := range r.variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	vars := url.Values{}
+	for _, name := range names {
+		for _, tmpl := range r.variables[name] {
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, a); err != nil {
+				return nil, errors.Errorf("executing template of variable %s error: %v", name, err)
+			}
+			if buf.Len() > 0 {
+				vars.Add("var-"+name, buf.String())
+			}
+		}
+	}
+	return vars, nil
+}
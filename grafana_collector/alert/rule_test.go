@@ -0,0 +1,66 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alert
+
+import (
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRule(t *testing.T) {
+	a := Alert{Labels: map[string]string{"alertname": "TiKV_server_is_down", "instance": "tikv-1:20160"}}
+
+	Convey("Alerts should match all anchored regexes of rule", t, func() {
+		r, err := NewRule(map[string]string{"alertname": "TiKV_.*", "instance": "tikv-1.*"}, nil)
+		So(err, ShouldBeNil)
+		So(r.Matches(a), ShouldBeTrue)
+
+		r, err = NewRule(map[string]string{"alertname": "TiKV"}, nil)
+		So(err, ShouldBeNil)
+		So(r.Matches(a), ShouldBeFalse)
+
+		r, err = NewRule(map[string]string{"alertname": "TiKV_.*", "severity": "critical"}, nil)
+		So(err, ShouldBeNil)
+		So(r.Matches(a), ShouldBeFalse)
+
+		r, err = NewRule(map[string]string{"severity": ""}, nil)
+		So(err, ShouldBeNil)
+		So(r.Matches(a), ShouldBeTrue)
+
+		r, err = NewRule(nil, nil)
+		So(err, ShouldBeNil)
+		So(r.Matches(a), ShouldBeTrue)
+	})
+
+	Convey("Variables should be executed with the labels of alert", t, func() {
+		r, err := NewRule(nil, map[string][]string{
+			"instance": {"{{.Labels.instance}}"},
+			"host":     {"{{.Labels.host}}"},
+			"db":       {"tidb", "{{.Labels.db}}"},
+		})
+		So(err, ShouldBeNil)
+		vars, err := r.Variables(a)
+		So(err, ShouldBeNil)
+		So(vars, ShouldResemble, url.Values{"var-instance": {"tikv-1:20160"}, "var-db": {"tidb"}})
+	})
+
+	Convey("Invalid regexes and templates should be rejected", t, func() {
+		_, err := NewRule(map[string]string{"alertname": "("}, nil)
+		So(err, ShouldNotBeNil)
+		_, err = NewRule(nil, map[string][]string{"instance": {"{{.Labels.instance"}})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chart
+
+import (
+	"math"
+	"time"
+)
+
+// timeSteps are the intervals between time ticks, the smallest one which gives few enough ticks is used
+var timeSteps = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 15 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 2 * 24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour,
+}
+
+// valueTicks ... returns at most about n evenly spaced ticks of round values, the first tick is at most min and the
+// last one is at least max
+func valueTicks(min, max float64, n int) []float64 {
+	if n < 1 {
+		n = 1
+	}
+	if max <= min {
+		// a flat series is drawn in the middle of the axis
+		pad := math.Abs(min) * 0.1
+		if pad == 0 {
+			pad = 1
+		}
+		min, max = min-pad, max+pad
+	}
+
+	step := niceStep((max - min) / float64(n))
+	first := math.Floor(min/step) * step
+	last := math.Ceil(max/step) * step
+	var ticks []float64
+	for i := 0; first+float64(i)*step <= last+step/2; i++ {
+		// ticks are computed from the first one instead of adding steps up, so they don't drift
+		v := first + float64(i)*step
+		if math.Abs(v) < step/1e6 {
+			v = 0
+		}
+		ticks = append(ticks, v)
+	}
+	return ticks
+}
+
+// niceStep ... rounds step up to 1, 2 or 5 times a power of 10
+func niceStep(step float64) float64 {
+	if step <= 0 || math.IsNaN(step) || math.IsInf(step, 0) {
+		return 1
+	}
+	exp := math.Pow(10, math.Floor(math.Log10(step)))
+	for _, m := range []float64{1, 2, 5} {
+		if step <= m*exp {
+			return m * exp
+		}
+	}
+	return 10 * exp
+}
+
+// timeTicks ... returns at most n ticks of round times between from and to in the time zone loc, and the step
+// between them
+func timeTicks(from, to time.Time, n int, loc *time.Location) ([]time.Time, time.Duration) {
+	if n < 1 {
+		n = 1
+	}
+	span := to.Sub(from)
+	step := timeSteps[len(timeSteps)-1]
+	for _, s := range timeSteps {
+		if span/s <= time.Duration(n) {
+			step = s
+			break
+		}
+	}
+
+	t := from.In(loc)
+	if step >= 24*time.Hour {
+		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	} else {
+		// round times in the time zone, e.g. 08:00 of UTC+8 is a round hour
+		_, offset := t.Zone()
+		shift := time.Duration(offset) * time.Second
+		t = t.Add(shift).Truncate(step).Add(-shift)
+	}
+	var ticks []time.Time
+	for ; !t.After(to); t = t.Add(step) {
+		if !t.Before(from) {
+			ticks = append(ticks, t)
+		}
+	}
+	return ticks, step
+}
+
+// timeLayout ... returns the layout of time tick labels with the step between ticks over span
+func timeLayout(step, span time.Duration) string {
+	switch {
+	case step >= 24*time.Hour:
+		return "01/02"
+	case span > 24*time.Hour:
+		return "01/02 15:04"
+	case step < time.Minute:
+		return "15:04:05"
+	}
+	return "15:04"
+}
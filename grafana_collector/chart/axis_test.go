@@ -0,0 +1,52 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chart
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValueTicks(t *testing.T) {
+	Convey("When computing ticks of values", t, func() {
+		So(valueTicks(3, 97, 5), ShouldResemble, []float64{0, 20, 40, 60, 80, 100})
+		So(valueTicks(0.012, 0.048, 4), ShouldResemble, []float64{0.01, 0.02, 0.03, 0.04, 0.05})
+		So(valueTicks(5, 5, 2), ShouldResemble, []float64{4.5, 5, 5.5})
+		So(valueTicks(0, 0, 2), ShouldResemble, []float64{-1, 0, 1})
+	})
+}
+
+func TestTimeTicks(t *testing.T) {
+	Convey("When computing ticks of a time range", t, func() {
+		loc := time.FixedZone("UTC+8", 8*3600)
+		from := time.Date(2018, 12, 4, 8, 7, 0, 0, loc)
+
+		Convey("Ticks should be at round times of the time zone", func() {
+			ticks, step := timeTicks(from, from.Add(time.Hour), 6, loc)
+			So(step, ShouldEqual, 10*time.Minute)
+			So(ticks, ShouldHaveLength, 6)
+			So(ticks[0].In(loc).Format("15:04"), ShouldEqual, "08:10")
+			So(timeLayout(step, time.Hour), ShouldEqual, "15:04")
+		})
+
+		Convey("Ticks of days should be at midnight", func() {
+			ticks, step := timeTicks(from, from.Add(7*24*time.Hour), 4, loc)
+			So(step, ShouldEqual, 2*24*time.Hour)
+			So(ticks[0].In(loc).Format("01/02 15:04"), ShouldEqual, "12/06 00:00")
+			So(timeLayout(step, 7*24*time.Hour), ShouldEqual, "01/02")
+		})
+	})
+}
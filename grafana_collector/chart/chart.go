@@ -0,0 +1,490 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package chart draws line, area and stat charts of time series in pure Go, for environments without the Grafana
+// image renderer
+package chart
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Kind is the kind of chart
+type Kind int
+
+const (
+	// Line is a chart of series lines over time
+	Line Kind = iota
+	// Area is a line chart with the area under lines filled
+	Area
+	// Stat is the last value of every series in big text
+	Stat
+)
+
+// areaAlpha is the opacity of the filled area of area charts, and maxStats is the number of series of stat charts
+const (
+	areaAlpha = 0.2
+	maxStats  = 6
+)
+
+// Point is a value of series at a time
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series is a named series of points in time order
+type Series struct {
+	Name   string
+	Points []Point
+}
+
+// Threshold colors the values from Value up to the next threshold, the base threshold is -Inf
+type Threshold struct {
+	Value float64
+	Color color.RGBA
+}
+
+// Chart is a chart of series over a time range
+type Chart struct {
+	Kind          Kind
+	Title         string
+	Width, Height int
+	Series        []Series
+	From, To      time.Time      // time range of x axis, the range of points if zero
+	Location      *time.Location // time zone of x axis labels
+	Format        func(v float64) string
+	Thresholds    []Threshold // thresholds in ascending order of values
+	Legend        bool
+	Theme         Theme
+}
+
+// WritePNG ... draws the chart and encodes it as png to w
+func (c Chart) WritePNG(w io.Writer) error {
+	return errors.Wrap(png.Encode(w, c.Draw()), "encode chart")
+}
+
+// Draw ... draws the chart
+func (c Chart) Draw() *image.RGBA {
+	width, height := c.Width, c.Height
+	if width <= 0 {
+		width = 1
+	}
+	if height <= 0 {
+		height = 1
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fillRect(img, img.Bounds(), c.Theme.Background)
+
+	scale := c.scale()
+	pad := 4 * scale
+	top := pad
+	if c.Title != "" {
+		title := truncateText(c.Title, width-2*pad, scale)
+		drawText(img, (width-textWidth(title, scale))/2, pad, title, scale, c.Theme.Text)
+		top += textHeight(scale) + pad
+	}
+	area := image.Rect(pad, top, width-pad, height-pad)
+	if !c.hasData() {
+		c.drawCentered(img, area, "No data", scale)
+		return img
+	}
+
+	switch c.Kind {
+	case Stat:
+		c.drawStats(img, area, scale)
+	default:
+		c.drawTimeSeries(img, area, scale)
+	}
+	return img
+}
+
+// scale ... returns the scale of text, so that text is readable in large charts and fits in small ones
+func (c Chart) scale() int {
+	scale := c.Width / 400
+	if s := c.Height / 150; s < scale {
+		scale = s
+	}
+	if scale < 1 {
+		return 1
+	}
+	if scale > 3 {
+		return 3
+	}
+	return scale
+}
+
+func (c Chart) format(v float64) string {
+	if c.Format != nil {
+		return c.Format(v)
+	}
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+func (c Chart) hasData() bool {
+	for _, s := range c.Series {
+		for _, p := range s.Points {
+			if isValid(p.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// thresholdColor ... returns the color of v by thresholds, ok is false without thresholds
+func (c Chart) thresholdColor(v float64) (color.RGBA, bool) {
+	var (
+		result color.RGBA
+		ok     bool
+	)
+	for _, t := range c.Thresholds {
+		if v >= t.Value || math.IsInf(t.Value, -1) {
+			result, ok = t.Color, true
+		}
+	}
+	return result, ok
+}
+
+// timeRange ... returns the time range of x axis, the range of points unless it is set
+func (c Chart) timeRange() (time.Time, time.Time) {
+	if !c.From.IsZero() && c.To.After(c.From) {
+		return c.From, c.To
+	}
+	var from, to time.Time
+	for _, s := range c.Series {
+		for _, p := range s.Points {
+			if from.IsZero() || p.Time.Before(from) {
+				from = p.Time
+			}
+			if p.Time.After(to) {
+				to = p.Time
+			}
+		}
+	}
+	if !to.After(from) {
+		to = from.Add(time.Minute)
+	}
+	return from, to
+}
+
+// valueRange ... returns the min and max of values, which include 0 for area charts as areas are filled to 0
+func (c Chart) valueRange() (float64, float64) {
+	min, max := math.Inf(1), math.Inf(-1)
+	if c.Kind == Area {
+		min, max = 0, 0
+	}
+	for _, s := range c.Series {
+		for _, p := range s.Points {
+			if isValid(p.Value) {
+				min = math.Min(min, p.Value)
+				max = math.Max(max, p.Value)
+			}
+		}
+	}
+	return min, max
+}
+
+// drawTimeSeries ... draws the axes, grid, thresholds, legend and lines of series in area
+func (c Chart) drawTimeSeries(img *image.RGBA, area image.Rectangle, scale int) {
+	lineHeight := textHeight(scale) + 2*scale
+	legend := c.legendLines(area.Dx(), area.Dy()/4/lineHeight, scale)
+	// time labels are below the plot, and the labels of the lowest value stick out below it by half of their height
+	plotBottom := area.Max.Y - len(legend)*lineHeight - 2*lineHeight
+
+	min, max := c.valueRange()
+	ticks := valueTicks(min, max, (plotBottom-area.Min.Y)/(3*lineHeight))
+	lo, hi := ticks[0], ticks[len(ticks)-1]
+	var labelWidth int
+	labels := make([]string, len(ticks))
+	for i, v := range ticks {
+		labels[i] = c.format(v)
+		if w := textWidth(labels[i], scale); w > labelWidth {
+			labelWidth = w
+		}
+	}
+
+	// the label of the last time tick sticks out to the right of the plot by half of its width
+	plot := image.Rect(area.Min.X+labelWidth+4*scale, area.Min.Y+lineHeight/2, area.Max.X-textWidth("00:00", scale)/2, plotBottom)
+	if plot.Dx() <= 0 || plot.Dy() <= 0 {
+		return
+	}
+	from, to := c.timeRange()
+	x := func(t time.Time) int {
+		return plot.Min.X + int(float64(plot.Dx())*float64(t.Sub(from))/float64(to.Sub(from)))
+	}
+	y := func(v float64) int {
+		return plot.Max.Y - int(float64(plot.Dy())*(v-lo)/(hi-lo))
+	}
+
+	// value grid and labels
+	for i, v := range ticks {
+		drawLine(img, plot.Min.X, y(v), plot.Max.X, y(v), c.Theme.Grid, 1)
+		drawText(img, plot.Min.X-4*scale-textWidth(labels[i], scale), y(v)-textHeight(scale)/2, labels[i], scale, c.Theme.Text)
+	}
+	// time grid and labels
+	loc := c.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	tickWidth := textWidth("01/02 15:04", scale) + 8*scale
+	times, step := timeTicks(from, to, plot.Dx()/tickWidth, loc)
+	layout := timeLayout(step, to.Sub(from))
+	for _, t := range times {
+		drawLine(img, x(t), plot.Min.Y, x(t), plot.Max.Y, c.Theme.Grid, 1)
+		label := t.In(loc).Format(layout)
+		drawText(img, x(t)-textWidth(label, scale)/2, plot.Max.Y+lineHeight/2+2*scale, label, scale, c.Theme.Text)
+	}
+	// thresholds are dashed lines
+	for _, t := range c.Thresholds {
+		if math.IsInf(t.Value, 0) || t.Value < lo || t.Value > hi {
+			continue
+		}
+		for px := plot.Min.X; px < plot.Max.X; px += 8 * scale {
+			drawLine(img, px, y(t.Value), px+4*scale, y(t.Value), t.Color, scale)
+		}
+	}
+
+	// series, areas are filled before lines so that they don't cover lines
+	zero := y(math.Max(lo, math.Min(0, hi)))
+	if c.Kind == Area {
+		for i, s := range c.Series {
+			c.eachSegment(s, x, y, func(x0, y0, x1, y1 int) {
+				fillArea(img, x0, y0, x1, y1, zero, seriesColor(i))
+			})
+		}
+	}
+	for i, s := range c.Series {
+		c.eachSegment(s, x, y, func(x0, y0, x1, y1 int) {
+			drawLine(img, x0, y0, x1, y1, seriesColor(i), scale)
+		})
+	}
+
+	// legend
+	ly := plotBottom + 2*lineHeight
+	for _, line := range legend {
+		lx := area.Min.X
+		for _, item := range line {
+			fillRect(img, image.Rect(lx, ly+scale, lx+textHeight(scale)-2*scale, ly+textHeight(scale)-scale), seriesColor(item.index))
+			lx += textHeight(scale)
+			drawText(img, lx, ly, item.name, scale, c.Theme.Text)
+			lx += textWidth(item.name, scale) + 4*glyphWidth*scale
+		}
+		ly += lineHeight
+	}
+}
+
+// eachSegment ... calls draw with the pixels of segments between points of series in time order. Series are broken
+// at invalid values and where points are missing, which are farther apart than twice the interval of series
+func (c Chart) eachSegment(s Series, x func(time.Time) int, y func(float64) int, draw func(x0, y0, x1, y1 int)) {
+	points := make([]Point, 0, len(s.Points))
+	for _, p := range s.Points {
+		if isValid(p.Value) {
+			points = append(points, p)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+
+	var interval time.Duration
+	for i := 1; i < len(s.Points); i++ {
+		if d := s.Points[i].Time.Sub(s.Points[i-1].Time); d > 0 && (interval == 0 || d < interval) {
+			interval = d
+		}
+	}
+	for i := range points {
+		if i == 0 || (interval > 0 && points[i].Time.Sub(points[i-1].Time) > 2*interval) {
+			// a single point is drawn as a dot
+			draw(x(points[i].Time), y(points[i].Value), x(points[i].Time), y(points[i].Value))
+			continue
+		}
+		draw(x(points[i-1].Time), y(points[i-1].Value), x(points[i].Time), y(points[i].Value))
+	}
+}
+
+// legendItem is a series in legend
+type legendItem struct {
+	index int
+	name  string
+}
+
+// legendLines ... wraps the series names into at most maxLines lines of width, the last line tells how many series
+// are left out
+func (c Chart) legendLines(width, maxLines, scale int) [][]legendItem {
+	if !c.Legend || len(c.Series) == 0 {
+		return nil
+	}
+	if maxLines < 1 {
+		maxLines = 1
+	}
+	var (
+		lines [][]legendItem
+		line  []legendItem
+		x     int
+	)
+	for i, s := range c.Series {
+		name := truncateText(s.Name, width-textHeight(scale), scale)
+		w := textHeight(scale) + textWidth(name, scale) + 4*glyphWidth*scale
+		if len(line) > 0 && x+w > width {
+			lines = append(lines, line)
+			line, x = nil, 0
+		}
+		if len(lines) == maxLines {
+			last := lines[maxLines-1]
+			// the last item of the last line is replaced too
+			more := "+" + strconv.Itoa(len(c.Series)-i+1) + " more"
+			lines[maxLines-1] = append(last[:len(last)-1], legendItem{index: last[len(last)-1].index, name: more})
+			return lines
+		}
+		line = append(line, legendItem{index: i, name: name})
+		x += w
+	}
+	return append(lines, line)
+}
+
+// drawStats ... draws the last value of every series in a row of cells, values are colored by thresholds
+func (c Chart) drawStats(img *image.RGBA, area image.Rectangle, scale int) {
+	series := c.Series
+	if len(series) > maxStats {
+		series = series[:maxStats]
+	}
+	cellWidth := area.Dx() / len(series)
+	valueHeight := area.Dy()
+	if len(series) > 1 {
+		// series names are under their values
+		valueHeight -= textHeight(scale) + scale
+	}
+
+	texts := make([]string, len(series))
+	lasts := make([]float64, len(series))
+	// values are as large as the cells allow, and all of them are in the same size
+	big := valueHeight / glyphHeight
+	for i, s := range series {
+		lasts[i] = math.NaN()
+		for _, p := range s.Points {
+			if isValid(p.Value) {
+				lasts[i] = p.Value
+			}
+		}
+		texts[i] = "No data"
+		if !math.IsNaN(lasts[i]) {
+			texts[i] = c.format(lasts[i])
+		}
+		if w := (cellWidth - 2*scale) / textWidth(texts[i], 1); w < big {
+			big = w
+		}
+	}
+	if big < 1 {
+		big = 1
+	}
+
+	for i, s := range series {
+		cell := image.Rect(area.Min.X+i*cellWidth, area.Min.Y, area.Min.X+(i+1)*cellWidth, area.Min.Y+valueHeight)
+		if len(series) > 1 {
+			name := truncateText(s.Name, cell.Dx(), scale)
+			drawText(img, cell.Min.X+(cell.Dx()-textWidth(name, scale))/2, area.Max.Y-textHeight(scale), name, scale, c.Theme.Text)
+		}
+		valueColor, ok := c.thresholdColor(lasts[i])
+		if !ok || math.IsNaN(lasts[i]) {
+			valueColor = c.Theme.Text
+		}
+		text := truncateText(texts[i], cell.Dx(), big)
+		drawText(img, cell.Min.X+(cell.Dx()-textWidth(text, big))/2, cell.Min.Y+(cell.Dy()-textHeight(big))/2, text, big, valueColor)
+	}
+}
+
+// drawCentered ... draws s in the middle of r
+func (c Chart) drawCentered(img *image.RGBA, r image.Rectangle, s string, scale int) {
+	drawText(img, r.Min.X+(r.Dx()-textWidth(s, scale))/2, r.Min.Y+(r.Dy()-textHeight(scale))/2, s, scale, c.Theme.Text)
+}
+
+func isValid(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// fillRect ... fills r of img with c, the part out of img is clipped
+func fillRect(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// drawLine ... draws a line from (x0, y0) to (x1, y1) of width pixels by Bresenham's algorithm
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA, width int) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	err := dx + dy
+	for {
+		fillRect(img, image.Rect(x0-width/2, y0-width/2, x0-width/2+width, y0-width/2+width), c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+// fillArea ... fills the area between the line from (x0, y0) to (x1, y1) and the horizontal line at base, it is
+// blended over the pixels of img. The column of x1 is left to the next segment, so that it is blended only once
+func fillArea(img *image.RGBA, x0, y0, x1, y1, base int, c color.RGBA) {
+	bounds := img.Bounds()
+	for x := x0; x < x1; x++ {
+		if x < bounds.Min.X || x >= bounds.Max.X {
+			continue
+		}
+		y := y0
+		if x1 > x0 {
+			y = y0 + (y1-y0)*(x-x0)/(x1-x0)
+		}
+		from, to := y, base
+		if from > to {
+			from, to = to, from
+		}
+		for py := from; py < to; py++ {
+			if py >= bounds.Min.Y && py < bounds.Max.Y {
+				img.SetRGBA(x, py, blend(img.RGBAAt(x, py), c, areaAlpha))
+			}
+		}
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// countColor counts the pixels of c in img
+func countColor(img *image.RGBA, c color.RGBA) int {
+	var n int
+	for i := 0; i < len(img.Pix); i += 4 {
+		if img.Pix[i] == c.R && img.Pix[i+1] == c.G && img.Pix[i+2] == c.B {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFont(t *testing.T) {
+	Convey("Every printable ASCII character should have a glyph", t, func() {
+		So(len(glyphs), ShouldEqual, '~'-' '+1)
+		So(glyph('A'), ShouldResemble, glyphs['A'-' '])
+		So(glyph('µ'), ShouldResemble, extraGlyphs['µ'])
+		So(glyph('中'), ShouldResemble, glyph('?'))
+		So(textWidth("abc", 2), ShouldEqual, (3*6-1)*2)
+		So(truncateText("instance-1", textWidth("inst..", 1), 1), ShouldEqual, "inst..")
+	})
+}
+
+func TestDraw(t *testing.T) {
+	Convey("When drawing charts", t, func() {
+		from := time.Date(2018, 12, 4, 8, 0, 0, 0, time.UTC)
+		var points []Point
+		for i := 0; i <= 60; i++ {
+			points = append(points, Point{Time: from.Add(time.Duration(i) * time.Minute), Value: float64(i)})
+		}
+		red := Threshold{Value: 50, Color: palette[4]}
+		c := Chart{
+			Kind:       Area,
+			Title:      "QPS",
+			Width:      800,
+			Height:     400,
+			Series:     []Series{{Name: "tidb-1", Points: points}, {Name: "tidb-2", Points: points[:10]}},
+			From:       from,
+			To:         from.Add(time.Hour),
+			Thresholds: []Threshold{{Value: math.Inf(-1), Color: palette[0]}, red},
+			Legend:     true,
+			Theme:      DarkTheme,
+		}
+		seriesPixels := func(img *image.RGBA, index int) int {
+			return countColor(img, seriesColor(index))
+		}
+
+		Convey("Time series charts should draw lines of every series", func() {
+			img := c.Draw()
+			So(img.Bounds(), ShouldResemble, image.Rect(0, 0, 800, 400))
+			So(seriesPixels(img, 0), ShouldBeGreaterThan, 100)
+			So(seriesPixels(img, 1), ShouldBeGreaterThan, 10)
+
+			var buf bytes.Buffer
+			So(c.WritePNG(&buf), ShouldBeNil)
+			_, err := png.Decode(&buf)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Stat charts should color the last value by thresholds", func() {
+			c.Kind = Stat
+			c.Series = c.Series[:1]
+			valueColor, ok := c.thresholdColor(60)
+			So(ok, ShouldBeTrue)
+			So(valueColor, ShouldResemble, red.Color)
+			valueColor, _ = c.thresholdColor(10)
+			So(valueColor, ShouldResemble, palette[0])
+			So(seriesPixels(c.Draw(), 4), ShouldBeGreaterThan, 100)
+		})
+
+		Convey("Charts without data should say so", func() {
+			c.Series = nil
+			So(c.hasData(), ShouldBeFalse)
+			img := c.Draw()
+			So(img.Bounds().Dx(), ShouldEqual, 800)
+		})
+
+		Convey("Legends should be cut off with the number of left out series", func() {
+			c.Series = nil
+			for i := 0; i < 30; i++ {
+				c.Series = append(c.Series, Series{Name: "a-long-series-name", Points: points})
+			}
+			lines := c.legendLines(400, 2, 1)
+			So(lines, ShouldHaveLength, 2)
+			last := lines[1][len(lines[1])-1]
+			So(last.name, ShouldEndWith, " more")
+		})
+	})
+}
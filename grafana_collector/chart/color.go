@@ -0,0 +1,141 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chart
+
+import (
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// Theme is the colors of chart parts, like the dark and light themes of Grafana
+type Theme struct {
+	Background color.RGBA
+	Text       color.RGBA
+	Grid       color.RGBA
+}
+
+var (
+	// DarkTheme is the dark theme of Grafana
+	DarkTheme = Theme{
+		Background: color.RGBA{0x18, 0x1b, 0x1f, 0xff},
+		Text:       color.RGBA{0xd8, 0xd9, 0xda, 0xff},
+		Grid:       color.RGBA{0x33, 0x36, 0x3b, 0xff},
+	}
+	// LightTheme is the light theme of Grafana
+	LightTheme = Theme{
+		Background: color.RGBA{0xff, 0xff, 0xff, 0xff},
+		Text:       color.RGBA{0x46, 0x4c, 0x54, 0xff},
+		Grid:       color.RGBA{0xe0, 0xe0, 0xe0, 0xff},
+	}
+)
+
+// palette is the classic series colors of Grafana
+var palette = []color.RGBA{
+	{0x7e, 0xb2, 0x6d, 0xff}, {0xea, 0xb8, 0x39, 0xff}, {0x6e, 0xd0, 0xe0, 0xff}, {0xef, 0x84, 0x3c, 0xff},
+	{0xe2, 0x4d, 0x42, 0xff}, {0x1f, 0x78, 0xc1, 0xff}, {0xba, 0x43, 0xa9, 0xff}, {0x70, 0x5d, 0xa0, 0xff},
+	{0x50, 0x86, 0x42, 0xff}, {0xcc, 0xa3, 0x00, 0xff},
+}
+
+// namedColors are the color names of Grafana thresholds
+var namedColors = map[string]color.RGBA{
+	"green":            {0x73, 0xbf, 0x69, 0xff},
+	"dark-green":       {0x37, 0x87, 0x2d, 0xff},
+	"semi-dark-green":  {0x56, 0xa6, 0x4b, 0xff},
+	"light-green":      {0x96, 0xd9, 0x8d, 0xff},
+	"red":              {0xf2, 0x49, 0x5c, 0xff},
+	"dark-red":         {0xc4, 0x16, 0x2a, 0xff},
+	"semi-dark-red":    {0xe0, 0x2f, 0x44, 0xff},
+	"light-red":        {0xff, 0x73, 0x83, 0xff},
+	"orange":           {0xff, 0x98, 0x30, 0xff},
+	"dark-orange":      {0xfa, 0x64, 0x00, 0xff},
+	"semi-dark-orange": {0xff, 0x78, 0x0a, 0xff},
+	"light-orange":     {0xff, 0xb3, 0x57, 0xff},
+	"yellow":           {0xfa, 0xde, 0x2a, 0xff},
+	"dark-yellow":      {0xe0, 0xb4, 0x00, 0xff},
+	"semi-dark-yellow": {0xf2, 0xcc, 0x0c, 0xff},
+	"light-yellow":     {0xff, 0xee, 0x52, 0xff},
+	"blue":             {0x57, 0x94, 0xf2, 0xff},
+	"dark-blue":        {0x1f, 0x60, 0xc4, 0xff},
+	"semi-dark-blue":   {0x37, 0x74, 0xcc, 0xff},
+	"light-blue":       {0x8a, 0xb8, 0xff, 0xff},
+	"purple":           {0xb8, 0x77, 0xd9, 0xff},
+	"dark-purple":      {0x8f, 0x3b, 0xb8, 0xff},
+	"semi-dark-purple": {0xa3, 0x52, 0xcc, 0xff},
+	"light-purple":     {0xca, 0x95, 0xe5, 0xff},
+	"white":            {0xff, 0xff, 0xff, 0xff},
+	"black":            {0x00, 0x00, 0x00, 0xff},
+	"transparent":      {0x00, 0x00, 0x00, 0x00},
+}
+
+// seriesColor ... returns the color of the series of index
+func seriesColor(index int) color.RGBA {
+	return palette[index%len(palette)]
+}
+
+// ParseColor ... parses colors of panel JSON: color names of Grafana, #rrggbb, #rgb, rgb(r, g, b) and
+// rgba(r, g, b, a)
+func ParseColor(s string) (color.RGBA, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if c, ok := namedColors[s]; ok {
+		return c, true
+	}
+
+	if strings.HasPrefix(s, "#") {
+		hex := s[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) != 6 {
+			return color.RGBA{}, false
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return color.RGBA{}, false
+		}
+		return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, true
+	}
+
+	var args string
+	switch {
+	case strings.HasPrefix(s, "rgba(") && strings.HasSuffix(s, ")"):
+		args = s[len("rgba(") : len(s)-1]
+	case strings.HasPrefix(s, "rgb(") && strings.HasSuffix(s, ")"):
+		args = s[len("rgb(") : len(s)-1]
+	default:
+		return color.RGBA{}, false
+	}
+	parts := strings.Split(args, ",")
+	if len(parts) != 3 && len(parts) != 4 {
+		return color.RGBA{}, false
+	}
+	var rgb [3]uint8
+	for i := range rgb {
+		v, err := strconv.ParseUint(strings.TrimSpace(parts[i]), 10, 8)
+		if err != nil {
+			return color.RGBA{}, false
+		}
+		rgb[i] = uint8(v)
+	}
+	// the alpha of colors is dropped, they are drawn over the background of chart
+	return color.RGBA{rgb[0], rgb[1], rgb[2], 0xff}, true
+}
+
+// blend ... mixes c over bg by alpha from 0 to 1
+func blend(bg, c color.RGBA, alpha float64) color.RGBA {
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a)*(1-alpha) + float64(b)*alpha + 0.5)
+	}
+	return color.RGBA{mix(bg.R, c.R), mix(bg.G, c.G), mix(bg.B, c.B), 0xff}
+}
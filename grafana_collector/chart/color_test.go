@@ -0,0 +1,46 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chart
+
+import (
+	"image/color"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseColor(t *testing.T) {
+	Convey("When parsing colors of panel JSON", t, func() {
+		c, ok := ParseColor("green")
+		So(ok, ShouldBeTrue)
+		So(c, ShouldResemble, color.RGBA{0x73, 0xbf, 0x69, 0xff})
+
+		c, ok = ParseColor("#E24D42")
+		So(ok, ShouldBeTrue)
+		So(c, ShouldResemble, color.RGBA{0xe2, 0x4d, 0x42, 0xff})
+
+		c, ok = ParseColor("#fff")
+		So(ok, ShouldBeTrue)
+		So(c, ShouldResemble, color.RGBA{0xff, 0xff, 0xff, 0xff})
+
+		c, ok = ParseColor("rgba(50, 172, 45, 0.97)")
+		So(ok, ShouldBeTrue)
+		So(c, ShouldResemble, color.RGBA{50, 172, 45, 0xff})
+
+		for _, s := range []string{"", "#12345", "rgb(1, 2)", "rgb(300, 0, 0)", "unknown"} {
+			_, ok = ParseColor(s)
+			So(ok, ShouldBeFalse)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chart
+
+import (
+	"image"
+	"image/color"
+)
+
+// glyphs are drawn in cells of glyphWidth x glyphHeight pixels, with glyphSpacing pixels between them. The font
+// is a 5x7 bitmap font with descenders in the 8th row, it is scaled up by whole pixels for large text
+const (
+	glyphWidth   = 5
+	glyphHeight  = 8
+	glyphSpacing = 1
+)
+
+// glyphs are the printable ASCII characters from ' ' to '~'. Every glyph is 5 columns from left to right, bit 0
+// of a column is its top pixel
+var glyphs = [...][glyphWidth]byte{
+	{0x00, 0x00, 0x00, 0x00, 0x00}, // ' '
+	{0x00, 0x00, 0x5F, 0x00, 0x00}, // !
+	{0x00, 0x07, 0x00, 0x07, 0x00}, // "
+	{0x14, 0x7F, 0x14, 0x7F, 0x14}, // #
+	{0x24, 0x2A, 0x7F, 0x2A, 0x12}, // $
+	{0x23, 0x13, 0x08, 0x64, 0x62}, // %
+	{0x36, 0x49, 0x56, 0x20, 0x50}, // &
+	{0x00, 0x08, 0x07, 0x03, 0x00}, // '
+	{0x00, 0x1C, 0x22, 0x41, 0x00}, // (
+	{0x00, 0x41, 0x22, 0x1C, 0x00}, // )
+	{0x2A, 0x1C, 0x7F, 0x1C, 0x2A}, // *
+	{0x08, 0x08, 0x3E, 0x08, 0x08}, // +
+	{0x00, 0x80, 0x70, 0x30, 0x00}, // ,
+	{0x08, 0x08, 0x08, 0x08, 0x08}, // -
+	{0x00, 0x00, 0x60, 0x60, 0x00}, // .
+	{0x20, 0x10, 0x08, 0x04, 0x02}, // /
+	{0x3E, 0x51, 0x49, 0x45, 0x3E}, // 0
+	{0x00, 0x42, 0x7F, 0x40, 0x00}, // 1
+	{0x72, 0x49, 0x49, 0x49, 0x46}, // 2
+	{0x21, 0x41, 0x49, 0x4D, 0x33}, // 3
+	{0x18, 0x14, 0x12, 0x7F, 0x10}, // 4
+	{0x27, 0x45, 0x45, 0x45, 0x39}, // 5
+	{0x3C, 0x4A, 0x49, 0x49, 0x31}, // 6
+	{0x41, 0x21, 0x11, 0x09, 0x07}, // 7
+	{0x36, 0x49, 0x49, 0x49, 0x36}, // 8
+	{0x46, 0x49, 0x49, 0x29, 0x1E}, // 9
+	{0x00, 0x00, 0x14, 0x00, 0x00}, // :
+	{0x00, 0x40, 0x34, 0x00, 0x00}, // ;
+	{0x00, 0x08, 0x14, 0x22, 0x41}, // <
+	{0x14, 0x14, 0x14, 0x14, 0x14}, // =
+	{0x00, 0x41, 0x22, 0x14, 0x08}, // >
+	{0x02, 0x01, 0x59, 0x09, 0x06}, // ?
+	{0x3E, 0x41, 0x5D, 0x59, 0x4E}, // @
+	{0x7C, 0x12, 0x11, 0x12, 0x7C}, // A
+	{0x7F, 0x49, 0x49, 0x49, 0x36}, // B
+	{0x3E, 0x41, 0x41, 0x41, 0x22}, // C
+	{0x7F, 0x41, 0x41, 0x41, 0x3E}, // D
+	{0x7F, 0x49, 0x49, 0x49, 0x41}, // E
+	{0x7F, 0x09, 0x09, 0x09, 0x01}, // F
+	{0x3E, 0x41, 0x41, 0x51, 0x73}, // G
+	{0x7F, 0x08, 0x08, 0x08, 0x7F}, // H
+	{0x00, 0x41, 0x7F, 0x41, 0x00}, // I
+	{0x20, 0x40, 0x41, 0x3F, 0x01}, // J
+	{0x7F, 0x08, 0x14, 0x22, 0x41}, // K
+	{0x7F, 0x40, 0x40, 0x40, 0x40}, // L
+	{0x7F, 0x02, 0x1C, 0x02, 0x7F}, // M
+	{0x7F, 0x04, 0x08, 0x10, 0x7F}, // N
+	{0x3E, 0x41, 0x41, 0x41, 0x3E}, // O
+	{0x7F, 0x09, 0x09, 0x09, 0x06}, // P
+	{0x3E, 0x41, 0x51, 0x21, 0x5E}, // Q
+	{0x7F, 0x09, 0x19, 0x29, 0x46}, // R
+	{0x26, 0x49, 0x49, 0x49, 0x32}, // S
+	{0x03, 0x01, 0x7F, 0x01, 0x03}, // T
+	{0x3F, 0x40, 0x40, 0x40, 0x3F}, // U
+	{0x1F, 0x20, 0x40, 0x20, 0x1F}, // V
+	{0x3F, 0x40, 0x38, 0x40, 0x3F}, // W
+	{0x63, 0x14, 0x08, 0x14, 0x63}, // X
+	{0x03, 0x04, 0x78, 0x04, 0x03}, // Y
+	{0x61, 0x59, 0x49, 0x4D, 0x43}, // Z
+	{0x00, 0x7F, 0x41, 0x41, 0x41}, // [
+	{0x02, 0x04, 0x08, 0x10, 0x20}, // \
+	{0x00, 0x41, 0x41, 0x41, 0x7F}, // ]
+	{0x04, 0x02, 0x01, 0x02, 0x04}, // ^
+	{0x40, 0x40, 0x40, 0x40, 0x40}, // _
+	{0x00, 0x03, 0x07, 0x08, 0x00}, // `
+	{0x20, 0x54, 0x54, 0x78, 0x40}, // a
+	{0x7F, 0x28, 0x44, 0x44, 0x38}, // b
+	{0x38, 0x44, 0x44, 0x44, 0x28}, // c
+	{0x38, 0x44, 0x44, 0x28, 0x7F}, // d
+	{0x38, 0x54, 0x54, 0x54, 0x18}, // e
+	{0x00, 0x08, 0x7E, 0x09, 0x02}, // f
+	{0x18, 0xA4, 0xA4, 0x9C, 0x78}, // g
+	{0x7F, 0x08, 0x04, 0x04, 0x78}, // h
+	{0x00, 0x44, 0x7D, 0x40, 0x00}, // i
+	{0x20, 0x40, 0x40, 0x3D, 0x00}, // j
+	{0x7F, 0x10, 0x28, 0x44, 0x00}, // k
+	{0x00, 0x41, 0x7F, 0x40, 0x00}, // l
+	{0x7C, 0x04, 0x78, 0x04, 0x78}, // m
+	{0x7C, 0x08, 0x04, 0x04, 0x78}, // n
+	{0x38, 0x44, 0x44, 0x44, 0x38}, // o
+	{0xFC, 0x18, 0x24, 0x24, 0x18}, // p
+	{0x18, 0x24, 0x24, 0x18, 0xFC}, // q
+	{0x7C, 0x08, 0x04, 0x04, 0x08}, // r
+	{0x48, 0x54, 0x54, 0x54, 0x24}, // s
+	{0x04, 0x04, 0x3F, 0x44, 0x24}, // t
+	{0x3C, 0x40, 0x40, 0x20, 0x7C}, // u
+	{0x1C, 0x20, 0x40, 0x20, 0x1C}, // v
+	{0x3C, 0x40, 0x30, 0x40, 0x3C}, // w
+	{0x44, 0x28, 0x10, 0x28, 0x44}, // x
+	{0x4C, 0x90, 0x90, 0x90, 0x7C}, // y
+	{0x44, 0x64, 0x54, 0x4C, 0x44}, // z
+	{0x00, 0x08, 0x36, 0x41, 0x00}, // {
+	{0x00, 0x00, 0x77, 0x00, 0x00}, // |
+	{0x00, 0x41, 0x36, 0x08, 0x00}, // }
+	{0x02, 0x01, 0x02, 0x04, 0x02}, // ~
+}
+
+// extraGlyphs are the characters out of ASCII which are used by units, e.g. µs
+var extraGlyphs = map[rune][glyphWidth]byte{
+	'µ': {0xFC, 0x20, 0x40, 0x20, 0x1C},
+	'°': {0x00, 0x06, 0x09, 0x09, 0x06},
+}
+
+// glyph ... returns the bitmap of r, unknown characters are drawn as ?
+func glyph(r rune) [glyphWidth]byte {
+	if r >= ' ' && int(r-' ') < len(glyphs) {
+		return glyphs[r-' ']
+	}
+	if g, ok := extraGlyphs[r]; ok {
+		return g
+	}
+	return glyphs['?'-' ']
+}
+
+// textWidth ... returns the width in pixels of s drawn in scale
+func textWidth(s string, scale int) int {
+	n := len([]rune(s))
+	if n == 0 {
+		return 0
+	}
+	return (n*(glyphWidth+glyphSpacing) - glyphSpacing) * scale
+}
+
+// textHeight ... returns the height in pixels of a line of text drawn in scale
+func textHeight(scale int) int {
+	return glyphHeight * scale
+}
+
+// drawText ... draws s with its top left corner at (x, y), every pixel of glyphs is a square of scale pixels
+func drawText(img *image.RGBA, x, y int, s string, scale int, c color.RGBA) {
+	for _, r := range s {
+		g := glyph(r)
+		for col, bits := range g {
+			for row := 0; row < glyphHeight; row++ {
+				if bits&(1<<uint(row)) != 0 {
+					fillRect(img, image.Rect(x+col*scale, y+row*scale, x+(col+1)*scale, y+(row+1)*scale), c)
+				}
+			}
+		}
+		x += (glyphWidth + glyphSpacing) * scale
+	}
+}
+
+// truncateText ... shortens s with trailing .. until it is at most width pixels wide in scale
+func truncateText(s string, width int, scale int) string {
+	runes := []rune(s)
+	if textWidth(s, scale) <= width {
+		return s
+	}
+	for len(runes) > 0 && textWidth(string(runes)+"..", scale) > width {
+		runes = runes[:len(runes)-1]
+	}
+	if len(runes) == 0 {
+		return ""
+	}
+	return string(runes) + ".."
+}
@@ -1,16 +1,34 @@
 package config
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/BurntSushi/toml"
 	"github.com/juju/errors"
 )
 
 // Config contains configuration options.
 type Config struct {
-	Grafana  grafana
-	Font     font
-	Rect     map[string]rect
-	Position position
+	Grafana   grafana
+	Auth      map[string]auth
+	Font      font
+	Page      page
+	Rect      map[string]rect
+	PanelSize map[string]rect `toml:"panel-size"`
+	Position  position
+	Summary   summary
+	Cache     cache
+	Bundle    map[string]bundle
+	Selection map[string]selection
+	Job       job
+	Report    report
+	Render    render
+	Workdir   workdir
+	Schedule  []schedule
+	Incident  []incident
+	Archive   archive
+	SMTP      smtp
 }
 
 type grafana struct {
@@ -18,6 +36,80 @@ type grafana struct {
 	ClientTimeout int `toml:"client-timeout"`
 	ServerTimeout int `toml:"server-timeout"`
 	RetryInterval int `toml:"retry-interval"`
+	Renderer      string
+	Prometheus    string
+}
+
+// auth is the credentials of a Grafana server, a service account token or basic auth
+type auth struct {
+	Token    string
+	Username string
+	Password string
+	OrgID    int `toml:"org-id"`
+}
+
+// String hides the token and password when the config is logged
+func (a auth) String() string {
+	hide := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return "xxxxx"
+	}
+	return fmt.Sprintf("{Token:%s Username:%s Password:%s OrgID:%d}", hide(a.Token), a.Username, hide(a.Password), a.OrgID)
+}
+
+// smtp is the SMTP server which mails reports, and the default templates of their subject and body
+type smtp struct {
+	Addr               string
+	From               string
+	Username           string
+	Password           string
+	StartTLS           bool `toml:"starttls"`
+	InsecureSkipVerify bool `toml:"insecure-skip-verify"`
+	Timeout            int
+	Retries            int
+	RetryInterval      int `toml:"retry-interval"`
+	Subject            string
+	Body               string
+}
+
+// String hides the password when the config is logged
+func (s smtp) String() string {
+	password := ""
+	if s.Password != "" {
+		password = "xxxxx"
+	}
+	return fmt.Sprintf("{Addr:%s From:%s Username:%s Password:%s StartTLS:%v InsecureSkipVerify:%v Timeout:%d Retries:%d RetryInterval:%d}",
+		s.Addr, s.From, s.Username, password, s.StartTLS, s.InsecureSkipVerify, s.Timeout, s.Retries, s.RetryInterval)
+}
+
+// workdir is the work directory of reports in progress, reports of at most memory-panels panels are kept in memory
+type workdir struct {
+	Dir          string
+	MaxSize      int64 `toml:"max-size"`
+	MemoryPanels int   `toml:"memory-panels"`
+}
+
+type job struct {
+	ExpireTime int `toml:"expire-time"`
+}
+
+type report struct {
+	Strict bool
+}
+
+// render limits the concurrent panel renders of all reports
+type render struct {
+	MaxConcurrent int `toml:"max-concurrent"`
+	MaxQueued     int `toml:"max-queued"`
+	RetryAfter    int `toml:"retry-after"`
+}
+
+type page struct {
+	Size        string
+	Orientation string
+	Margin      float64
 }
 
 type font struct {
@@ -31,13 +123,87 @@ type rect struct {
 	Height float64
 }
 
+type summary struct {
+	Enable    bool
+	MaxSeries int `toml:"max-series"`
+	Points    int
+}
+
+type cache struct {
+	Enable  bool
+	Dir     string
+	MaxSize int64 `toml:"max-size"`
+	TTL     int
+}
+
+type bundle struct {
+	Title      string
+	Dashboards []bundleDashboard `toml:"dashboard"`
+}
+
+type bundleDashboard struct {
+	Dashboard string              // name of Grafana v4 dashboard or uid of Grafana v5 dashboard
+	Variables map[string][]string // selected values of template variables, e.g. instance = ["tikv-1"]
+	Selection string              // name of panel selection, e.g. write-stall
+}
+
+// schedule is a report generated by cron to the archive, e.g. a daily report of yesterday
+type schedule struct {
+	Name      string              // name of schedule, which prefixes the IDs of its archived reports
+	Cron      string              // minute hour day-of-month month day-of-week, or @hourly, @daily, @weekly, @monthly
+	Dashboard string              // uid of Grafana v5 dashboard
+	Variables map[string][]string // selected values of template variables, e.g. instance = ["tikv-1"]
+	Selection string              // name of panel selection, e.g. write-stall
+	From      string              // relative start of time range, e.g. now-1d/d
+	To        string              // relative end of time range, e.g. now-1d/d
+	TZ        string              `toml:"tz"` // time zone of cron and report, UTC by default
+	Format    string              // output format of report, pdf or html
+	// Go templates of the recipients, subject and body of the mail of report, it is not mailed without recipients
+	MailTo      []string `toml:"mail-to"`
+	MailSubject string   `toml:"mail-subject"`
+	MailBody    string   `toml:"mail-body"`
+}
+
+// incident is a rule which generates the report of a dashboard around alerts of Alertmanager webhooks, from before
+// seconds before an alert starts to after seconds after it starts, or to its end once it is resolved
+type incident struct {
+	Name      string              // name of rule, which prefixes the IDs of its archived reports
+	Match     map[string]string   // regexes of alert labels which must all match, e.g. alertname = "TiKV_.*"
+	Dashboard string              // uid of Grafana v5 dashboard
+	Variables map[string][]string // Go templates of variable values, e.g. instance = ["{{.Labels.instance}}"]
+	Rows      []string            // IDs or regexes of titles of rows in report, all rows by default
+	Panels    []string            // IDs or regexes of titles of panels in report, all panels by default
+	Selection string              // name of panel selection, e.g. write-stall
+	Before    int                 // 1800 seconds by default
+	After     int                 // 1800 seconds by default
+	TZ        string              `toml:"tz"` // time zone of report, the time zone of dashboard by default
+	Format    string              // output format of report, pdf or html
+}
+
+// archive is the directory of scheduled and incident reports, they are removed after retention seconds
+type archive struct {
+	Dir       string
+	Retention int
+}
+
+// selection is a named panel filter, every value is a panel ID or a regex of titles
+type selection struct {
+	Rows          []string
+	Panels        []string
+	ExcludeRows   []string `toml:"exclude-rows"`
+	ExcludePanels []string `toml:"exclude-panels"`
+}
+
 type position struct {
-	X       float64
-	TitleY1 float64 `toml:"title-y1"`
-	TitleY2 float64 `toml:"title-y2"`
-	ImageY1 float64 `toml:"image-y1"`
-	ImageY2 float64 `toml:"image-y2"`
-	Br      float64
+	X  float64
+	Br float64
+}
+
+// pageSizes are portrait page sizes in points
+var pageSizes = map[string]rect{
+	"a4":     {Width: 595.28, Height: 841.89},
+	"a3":     {Width: 841.89, Height: 1190.55},
+	"letter": {Width: 612, Height: 792},
 }
 
 var defaultConf = Config{
@@ -46,33 +212,77 @@ var defaultConf = Config{
 		ClientTimeout: 300,
 		ServerTimeout: 300,
 		RetryInterval: 10,
+		Renderer:      "grafana",
+	},
+	Job: job{
+		ExpireTime: 3600,
+	},
+	Archive: archive{
+		Dir:       "archive",
+		Retention: 30 * 24 * 3600,
+	},
+	SMTP: smtp{
+		From:          "grafana_collector@localhost",
+		StartTLS:      true,
+		Timeout:       60,
+		Retries:       3,
+		RetryInterval: 60,
+		Subject:       "Grafana report: {{.Title}}",
+		Body:          "The report of {{.Title}} from {{.From}} to {{.To}} is attached.{{if .FailedPanels}} {{.FailedPanels}} panels failed to render.{{end}}\n",
+	},
+	Workdir: workdir{
+		Dir:          "tmp",
+		MaxSize:      2048,
+		MemoryPanels: 20,
+	},
+	Render: render{
+		MaxConcurrent: 10,
+		MaxQueued:     50,
+		RetryAfter:    30,
 	},
 	Font: font{
 		Family: "opensans",
 		Ttf:    "OpenSans-Regular.ttf",
 		Size:   14,
 	},
+	Page: page{
+		Size:        "A4",
+		Orientation: "portrait",
+		Margin:      40.0,
+	},
 	Rect: map[string]rect{
 		"page": {
 			Width:  595.28,
 			Height: 841.89,
 		},
-		"graph": {
-			Width:  480.0,
-			Height: 240.0,
-		},
-		"singlestat": {
-			Width:  480.0,
-			Height: 93.0,
-		},
+	},
+	PanelSize: map[string]rect{
+		"default":    {Width: 1000, Height: 500},
+		"graph":      {Width: 1000, Height: 500},
+		"timeseries": {Width: 1000, Height: 500},
+		"heatmap":    {Width: 1000, Height: 500},
+		"table":      {Width: 1000, Height: 600},
+		"singlestat": {Width: 480, Height: 93},
+		"stat":       {Width: 480, Height: 160},
+		"gauge":      {Width: 480, Height: 240},
+		"bargauge":   {Width: 1000, Height: 240},
+		"piechart":   {Width: 500, Height: 500},
+		"text":       {Width: 1000, Height: 200},
 	},
 	Position: position{
-		X:       50.0,
-		TitleY1: 60.0,
-		TitleY2: 350.0,
-		ImageY1: 80.0,
-		ImageY2: 370.0,
-		Br:      20.0,
+		X:  50.0,
+		Br: 20.0,
+	},
+	Summary: summary{
+		Enable:    true,
+		MaxSeries: 10,
+		Points:    500,
+	},
+	Cache: cache{
+		Enable:  false,
+		Dir:     "cache",
+		MaxSize: 512,
+		TTL:     86400,
 	},
 }
 
@@ -88,3 +298,22 @@ func (c *Config) SetConfig(configFile string) error {
 	_, err := toml.DecodeFile(configFile, c)
 	return errors.Trace(err)
 }
+
+// PageSize ... returns the width and height of PDF pages in points, the custom size is [rect.page]
+func (c *Config) PageSize() (width float64, height float64, err error) {
+	size, ok := pageSizes[strings.ToLower(c.Page.Size)]
+	if strings.ToLower(c.Page.Size) == "custom" {
+		size, ok = c.Rect["page"]
+	}
+	if !ok {
+		return 0, 0, errors.Errorf("unknown page size %s, it should be A4, A3, Letter or custom", c.Page.Size)
+	}
+
+	switch strings.ToLower(c.Page.Orientation) {
+	case "", "portrait":
+		return size.Width, size.Height, nil
+	case "landscape":
+		return size.Height, size.Width, nil
+	}
+	return 0, 0, errors.Errorf("unknown page orientation %s, it should be portrait or landscape", c.Page.Orientation)
+}
@@ -30,6 +30,7 @@
 package grafana
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -49,23 +50,31 @@ var (
 
 // Client is a Grafana API client
 type Client interface {
-	GetDashboard(dashName string) (Dashboard, error)
-	GetPanelPng(p Panel, dashName string, t TimeRange) (io.ReadCloser, error)
+	GetDashboard(ctx context.Context, dashName string) (Dashboard, error)
+	GetPanelPng(ctx context.Context, p Panel, dashName string, t TimeRange) (io.ReadCloser, error)
+	GetPanelSummary(ctx context.Context, p Panel, t TimeRange) ([]SeriesSummary, error)
 }
 
 type client struct {
 	url              string
 	getDashEndpoint  func(dashName string) string
 	getPanelEndpoint func(dashName string, vals url.Values) string
-	apiToken         string
+	credentials      Credentials
+	variables        url.Values
 	timeRange        TimeRange
+	datasources      *datasourceCache
+	prometheusURL    string // queries of panel targets are sent to Prometheus directly instead of data source proxy
+	native           bool   // panels are drawn from the queries of their targets by the native renderer
+	// ctx is the context of the request in progress, every request method sets it on its own copy of client, so that
+	// the requests of dashboard processing and data source queries are cancelled with it
+	ctx context.Context
 }
 
-// NewV4Client creates a new Grafana 4 Client. If apiToken is the empty string,
+// NewV4Client creates a new Grafana 4 Client. If credentials are empty,
 // authorization headers will be omitted from requests.
 // variables are Grafana template variable url values of the form
 // var-{name}={value}, e.g. var-host=dev
-func NewV4Client(grafanaURL string, apiToken string, timeRange TimeRange) Client {
+func NewV4Client(grafanaURL string, credentials Credentials, variables url.Values, timeRange TimeRange) Client {
 	getDashEndpoint := func(dashName string) string {
 		dashURL := grafanaURL + "/api/dashboards/db/" + dashName
 		return dashURL
@@ -74,14 +83,22 @@ func NewV4Client(grafanaURL string, apiToken string, timeRange TimeRange) Client
 	getPanelEndpoint := func(dashName string, vals url.Values) string {
 		return fmt.Sprintf("%s/render/dashboard-solo/db/%s?%s", grafanaURL, dashName, vals.Encode())
 	}
-	return client{grafanaURL, getDashEndpoint, getPanelEndpoint, apiToken, timeRange}
+	return client{
+		url:              grafanaURL,
+		getDashEndpoint:  getDashEndpoint,
+		getPanelEndpoint: getPanelEndpoint,
+		credentials:      credentials,
+		variables:        variables,
+		timeRange:        timeRange,
+		datasources:      &datasourceCache{},
+	}
 }
 
-// NewV5Client creates a new Grafana 5 Client. If apiToken is the empty string,
+// NewV5Client creates a new Grafana 5 Client. If credentials are empty,
 // authorization headers will be omitted from requests.
 // variables are Grafana template variable url values of the form
 // var-{name}={value}, e.g. var-host=dev
-func NewV5Client(grafanaURL string, apiToken string, timeRange TimeRange) Client {
+func NewV5Client(grafanaURL string, credentials Credentials, variables url.Values, timeRange TimeRange) Client {
 	getDashEndpoint := func(dashName string) string {
 		dashURL := grafanaURL + "/api/dashboards/uid/" + dashName
 		return dashURL
@@ -90,42 +107,57 @@ func NewV5Client(grafanaURL string, apiToken string, timeRange TimeRange) Client
 	getPanelEndpoint := func(dashName string, vals url.Values) string {
 		return fmt.Sprintf("%s/render/d-solo/%s/_?%s", grafanaURL, dashName, vals.Encode())
 	}
-	return client{grafanaURL, getDashEndpoint, getPanelEndpoint, apiToken, timeRange}
+	return client{
+		url:              grafanaURL,
+		getDashEndpoint:  getDashEndpoint,
+		getPanelEndpoint: getPanelEndpoint,
+		credentials:      credentials,
+		variables:        variables,
+		timeRange:        timeRange,
+		datasources:      &datasourceCache{},
+	}
+}
+
+// Ping checks that Grafana at grafanaURL is reachable and accepts credentials, by requesting the current
+// organization which every user, API key and service account can read
+func Ping(ctx context.Context, grafanaURL string, credentials Credentials) error {
+	g := client{url: grafanaURL, credentials: credentials, ctx: ctx}
+	_, err := g.get(grafanaURL + "/api/org")
+	return errors.WithStack(err)
 }
 
-func (g client) GetDashboard(dashName string) (Dashboard, error) {
+func (g client) GetDashboard(ctx context.Context, dashName string) (Dashboard, error) {
+	g.ctx = ctx
 	dashURL := g.getDashEndpoint(dashName)
-	log.Infof("connecting to dashboard at %s", dashURL)
+	log.Infof("connecting to dashboard at %s with %s", g.redact(dashURL), g.credentials)
 
 	clientTimeout := time.Duration(cfg.Grafana.ClientTimeout) * time.Second
 	client := &http.Client{Timeout: clientTimeout}
-	req, err := http.NewRequest("GET", dashURL, nil)
+	req, err := g.newRequest(dashURL)
 	if err != nil {
-		return Dashboard{}, errors.Errorf("creating getDashboard request for %s error: %v", dashURL, err)
-	}
-
-	if g.apiToken != "" {
-		req.Header.Add("Authorization", "Bearer "+g.apiToken)
+		return Dashboard{}, errors.Wrap(err, "create getDashboard request")
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return Dashboard{}, errors.Errorf("executing getDashboard request for %s error: %v", dashURL, err)
+		return Dashboard{}, g.errorf("executing getDashboard request for %s error: %v", dashURL, err)
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return Dashboard{}, errors.Errorf("reading getDashboard response body from %s: %v error", dashURL, err)
+		return Dashboard{}, g.errorf("reading getDashboard response body from %s: %v error", dashURL, err)
 	}
 
 	if resp.StatusCode != 200 {
-		return Dashboard{}, errors.Errorf("obtaining dashboard from %s error, got status %s, message: %s", dashURL, resp.Status, string(body))
+		return Dashboard{}, g.errorf("obtaining dashboard from %s error, got status %s, message: %s", dashURL, resp.Status, string(body))
 	}
 
-	return NewDashboard(body, g.url, g.apiToken, g.timeRange)
+	return newDashboard(body, g)
 }
 
-func (g client) GetPanelPng(p Panel, dashName string, t TimeRange) (io.ReadCloser, error) {
+func (g client) GetPanelPng(ctx context.Context, p Panel, dashName string, t TimeRange) (io.ReadCloser, error) {
+	g.ctx = ctx
+	start := time.Now()
 	panelURL := g.getPanelURL(p, dashName, t)
 
 	clientTimeout := time.Duration(cfg.Grafana.ClientTimeout) * time.Second
@@ -135,53 +167,74 @@ func (g client) GetPanelPng(p Panel, dashName string, t TimeRange) (io.ReadClose
 		},
 		Timeout: clientTimeout,
 	}
-	req, err := http.NewRequest("GET", panelURL, nil)
+	req, err := g.newRequest(panelURL)
 	if err != nil {
-		return nil, errors.Errorf("creating getPanelPng request for %s error: %v", panelURL, err)
-	}
-	if g.apiToken != "" {
-		req.Header.Add("Authorization", "Bearer "+g.apiToken)
+		return nil, errors.Wrap(err, "create getPanelPng request")
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, errors.Errorf("executing getPanelPng request for %s error: %v", panelURL, err)
+		observeRender(GrafanaRenderer, start, renderStatus(ctx))
+		return nil, g.errorf("executing getPanelPng request for %s error: %v", panelURL, err)
 	}
 
 	for retries := 1; retries < 3 && resp.StatusCode != 200; retries++ {
 		getPanelRetryInterval := time.Duration(cfg.Grafana.RetryInterval) * time.Second
 		delay := getPanelRetryInterval * time.Duration(retries)
 		log.Errorf("obtaining render for panel %+v error, status: %d, retrying after %v...", p, resp.StatusCode, delay)
-		time.Sleep(delay)
+		resp.Body.Close()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			observeRender(GrafanaRenderer, start, renderStatusCancelled)
+			return nil, errors.Wrapf(ctx.Err(), "render panel %d", p.ID)
+		}
+		panelRenderRetries.Inc()
 		resp, err = client.Do(req)
 		if err != nil {
-			return nil, errors.Errorf("executing getPanelPng retry request for %s error: %v", panelURL, err)
+			observeRender(GrafanaRenderer, start, renderStatus(ctx))
+			return nil, g.errorf("executing getPanelPng retry request for %s error: %v", panelURL, err)
 		}
 	}
 
 	if resp.StatusCode != 200 {
-		log.Errorf("obtaining panel image request from %s is not successful, status: %s", panelURL, resp.Status)
-		return nil, errors.Errorf("obtaining panel image request from %s is not successful, status: %s", panelURL, resp.Status)
+		resp.Body.Close()
+		observeRender(GrafanaRenderer, start, strconv.Itoa(resp.StatusCode))
+		err = g.errorf("obtaining panel image request from %s is not successful, status: %s", panelURL, resp.Status)
+		log.Errorf("%v", err)
+		return nil, err
 	}
 
+	observeRender(GrafanaRenderer, start, "")
 	return resp.Body, nil
 }
 
 func (g client) getPanelURL(p Panel, dashName string, t TimeRange) string {
 	values := url.Values{}
 	values.Add("theme", cfg.Grafana.Theme)
-	values.Add("panelId", strconv.Itoa(p.ID))
+	values.Add("panelId", strconv.Itoa(p.renderID()))
 	values.Add("from", t.From)
 	values.Add("to", t.To)
-	if p.IsSingleStat() {
-		values.Add("width", "480")
-		values.Add("height", "93")
-	} else {
-		values.Add("width", "1000")
-		values.Add("height", "500")
-	}
+	width, height := p.PixelSize()
+	values.Add("width", strconv.Itoa(width))
+	values.Add("height", strconv.Itoa(height))
 	values.Add("timeout", strconv.Itoa(cfg.Grafana.ServerTimeout))
+	if g.credentials.OrgID > 0 {
+		values.Add("orgId", strconv.Itoa(g.credentials.OrgID))
+	}
+	// render panels in the same time zone as the report, Grafana uses the browser time zone of local server otherwise
+	if t.Location != nil && t.Location != time.Local {
+		values.Add("tz", t.Location.String())
+	}
+
+	// values of repeated rows override the selected values of the same variable
+	for k, v := range g.variables {
+		values[k] = v
+	}
+	for name, v := range p.ScopedVars {
+		values.Set(variablePrefix+name, v.Value)
+	}
 
 	url := g.getPanelEndpoint(dashName, values)
-	log.Infof("downloading image: %d %s", p.ID, url)
+	log.Infof("downloading image: %d %s", p.ID, g.redact(url))
 	return url
 }
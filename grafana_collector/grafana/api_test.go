@@ -30,9 +30,11 @@
 package grafana
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -47,10 +49,10 @@ func TestGrafanaClientFetchesDashboard(t *testing.T) {
 		}))
 		defer ts.Close()
 
-		timeRange := TimeRange{"now-1h", "now"}
+		timeRange := TimeRange{From: "now-1h", To: "now"}
 		Convey("When using the Grafana v4 client", func() {
-			grf := NewV4Client(ts.URL, "", timeRange)
-			grf.GetDashboard("testDash")
+			grf := NewV4Client(ts.URL, Credentials{}, nil, timeRange)
+			grf.GetDashboard(context.Background(), "testDash")
 
 			Convey("It should use the v4 dashboards endpoint", func() {
 				So(requestURI, ShouldEqual, "/api/dashboards/db/testDash")
@@ -58,8 +60,8 @@ func TestGrafanaClientFetchesDashboard(t *testing.T) {
 		})
 
 		Convey("When using the Grafana v5 client", func() {
-			grf := NewV5Client(ts.URL, "", timeRange)
-			grf.GetDashboard("rYy7Paekz")
+			grf := NewV5Client(ts.URL, Credentials{}, nil, timeRange)
+			grf.GetDashboard(context.Background(), "rYy7Paekz")
 
 			Convey("It should use the v5 dashboards endpoint", func() {
 				So(requestURI, ShouldEqual, "/api/dashboards/uid/rYy7Paekz")
@@ -81,18 +83,18 @@ func TestGrafanaClientFetchesPanelPNG(t *testing.T) {
 		defer ts.Close()
 
 		apiToken := "1234"
-		timeRange := TimeRange{"now-1h", "now"}
+		timeRange := TimeRange{From: "now-1h", To: "now"}
 
 		cases := map[string]struct {
 			client      Client
 			pngEndpoint string
 		}{
-			"v4": {NewV4Client(ts.URL, apiToken, timeRange), "/render/dashboard-solo/db/testDash"},
-			"v5": {NewV5Client(ts.URL, apiToken, timeRange), "/render/d-solo/testDash/_"},
+			"v4": {NewV4Client(ts.URL, Credentials{Token: apiToken}, nil, timeRange), "/render/dashboard-solo/db/testDash"},
+			"v5": {NewV5Client(ts.URL, Credentials{Token: apiToken}, nil, timeRange), "/render/d-solo/testDash/_"},
 		}
 		for clientDesc, cl := range cases {
 			grf := cl.client
-			grf.GetPanelPng(Panel{44, "singlestat", "title", "rowtitle", nil}, "testDash", TimeRange{"now-1h", "now"})
+			grf.GetPanelPng(context.Background(), Panel{ID: 44, Type: "singlestat", Title: "title", RowTitle: "rowtitle"}, "testDash", TimeRange{From: "now-1h", To: "now"})
 
 			Convey(fmt.Sprintf("The %s client should use the render endpoint with the dashboard name", clientDesc), func() {
 				So(requestURI, ShouldStartWith, cl.pngEndpoint)
@@ -117,15 +119,52 @@ func TestGrafanaClientFetchesPanelPNG(t *testing.T) {
 			})
 
 			Convey(fmt.Sprintf("The %s client should request other panels in a larger size", clientDesc), func() {
-				grf.GetPanelPng(Panel{44, "graph", "title", "rowtitle", nil}, "testDash", TimeRange{"now", "now-1h"})
+				grf.GetPanelPng(context.Background(), Panel{ID: 44, Type: "graph", Title: "title", RowTitle: "rowtitle"}, "testDash", TimeRange{From: "now", To: "now-1h"})
 				So(requestURI, ShouldContainSubstring, "width=1000")
 				So(requestURI, ShouldContainSubstring, "height=500")
 			})
+
+			Convey(fmt.Sprintf("The %s client should request panels with grid position in their grid size", clientDesc), func() {
+				grf.GetPanelPng(context.Background(), Panel{ID: 44, Type: "graph", GridPos: GridPos{X: 12, Y: 0, W: 12, H: 8}}, "testDash", TimeRange{From: "now-1h", To: "now"})
+				So(requestURI, ShouldContainSubstring, "width=600")
+				So(requestURI, ShouldContainSubstring, "height=296")
+			})
 		}
 
 	})
 }
 
+func TestGrafanaClientForwardsVariables(t *testing.T) {
+	Convey("When fetching a panel PNG with template variables", t, func() {
+		var query url.Values
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+		}))
+		defer ts.Close()
+
+		variables := url.Values{"var-instance": {"a", "b"}, "var-db": {"kv"}}
+		grf := NewV5Client(ts.URL, Credentials{}, variables, TimeRange{From: "now-1h", To: "now"})
+
+		Convey("It should forward all selected values", func() {
+			grf.GetPanelPng(context.Background(), Panel{ID: 44, Type: "graph", Title: "title", RowTitle: "rowtitle"}, "testDash", TimeRange{From: "now-1h", To: "now"})
+			So(query["var-instance"], ShouldResemble, []string{"a", "b"})
+			So(query.Get("var-db"), ShouldEqual, "kv")
+		})
+
+		Convey("The scoped variables of the panel should override the selected values", func() {
+			scopedVars := map[string]ScopedVar{"instance": {Text: "c", Value: "c"}}
+			grf.GetPanelPng(context.Background(), Panel{ID: 44, Type: "graph", Title: "title", RowTitle: "rowtitle", ScopedVars: scopedVars}, "testDash", TimeRange{From: "now-1h", To: "now"})
+			So(query["var-instance"], ShouldResemble, []string{"c"})
+			So(query.Get("var-db"), ShouldEqual, "kv")
+		})
+
+		Convey("The time zone of the time range should be forwarded", func() {
+			grf.GetPanelPng(context.Background(), Panel{ID: 44, Type: "graph"}, "testDash", TimeRange{From: "now-1h", To: "now"}.WithDashboardTimezone("utc"))
+			So(query.Get("tz"), ShouldEqual, "UTC")
+		})
+	})
+}
+
 func TestGrafanaClientFetchPanelPNGErrorHandling(t *testing.T) {
 	Convey("When trying to fetching a panel from the server sometimes returns an error", t, func() {
 		try := 0
@@ -139,9 +178,9 @@ func TestGrafanaClientFetchPanelPNGErrorHandling(t *testing.T) {
 		}))
 		defer ts.Close()
 
-		grf := NewV4Client(ts.URL, "", TimeRange{"now-1h", "now"})
+		grf := NewV4Client(ts.URL, Credentials{}, nil, TimeRange{From: "now-1h", To: "now"})
 
-		_, err := grf.GetPanelPng(Panel{44, "singlestat", "title", "rowtitle", nil}, "testDash", TimeRange{"now-1h", "now"})
+		_, err := grf.GetPanelPng(context.Background(), Panel{ID: 44, Type: "singlestat", Title: "title", RowTitle: "rowtitle"}, "testDash", TimeRange{From: "now-1h", To: "now"})
 
 		Convey("It should retry a couple of times if it receives errors", func() {
 			So(err, ShouldBeNil)
@@ -154,12 +193,34 @@ func TestGrafanaClientFetchPanelPNGErrorHandling(t *testing.T) {
 		}))
 		defer ts.Close()
 
-		grf := NewV4Client(ts.URL, "", TimeRange{"now-1h", "now"})
+		grf := NewV4Client(ts.URL, Credentials{}, nil, TimeRange{From: "now-1h", To: "now"})
 
-		_, err := grf.GetPanelPng(Panel{44, "singlestat", "title", "rowtitle", nil}, "testDash", TimeRange{"now-1h", "now"})
+		_, err := grf.GetPanelPng(context.Background(), Panel{ID: 44, Type: "singlestat", Title: "title", RowTitle: "rowtitle"}, "testDash", TimeRange{From: "now-1h", To: "now"})
 
 		Convey("The Grafana API should return an error", func() {
 			So(err, ShouldNotBeNil)
 		})
 	})
 }
+
+func TestPing(t *testing.T) {
+	Convey("When checking if Grafana is ready", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/org" || r.Header.Get("Authorization") != "Bearer glsa_token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"id":1, "name":"Main Org."}`)
+		}))
+		defer ts.Close()
+
+		Convey("Grafana should accept the credentials", func() {
+			So(Ping(context.Background(), ts.URL, Credentials{Token: "glsa_token"}), ShouldBeNil)
+		})
+
+		Convey("Wrong credentials and unreachable Grafana should fail", func() {
+			So(Ping(context.Background(), ts.URL, Credentials{Token: "wrong"}), ShouldNotBeNil)
+			So(Ping(context.Background(), "http://127.0.0.1:1", Credentials{}), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// redacted replaces secrets in logs and error messages
+const redacted = "xxxxx"
+
+// Credentials authorize requests to Grafana. The Authorization header forwarded from the caller takes precedence
+// over the token, which takes precedence over basic auth
+type Credentials struct {
+	Authorization string // Authorization header forwarded from the caller
+	Token         string // API key or service account token, sent as a bearer token
+	Username      string
+	Password      string
+	OrgID         int // organization of multi-org Grafana, 0 is the current organization of user
+}
+
+// authorize ... adds the authorization and organization headers to req
+func (c Credentials) authorize(req *http.Request) {
+	switch {
+	case c.Authorization != "":
+		req.Header.Set("Authorization", c.Authorization)
+	case c.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	case c.Username != "":
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	if c.OrgID > 0 {
+		req.Header.Set("X-Grafana-Org-Id", strconv.Itoa(c.OrgID))
+	}
+}
+
+// String ... describes the credentials without their secrets
+func (c Credentials) String() string {
+	var s string
+	switch {
+	case c.Authorization != "":
+		s = "forwarded authorization header"
+	case c.Token != "":
+		s = "token " + redacted
+	case c.Username != "":
+		s = fmt.Sprintf("basic auth of user %s", c.Username)
+	default:
+		s = "anonymous"
+	}
+	if c.OrgID > 0 {
+		s += fmt.Sprintf(", org %d", c.OrgID)
+	}
+	return s
+}
+
+// secrets ... returns the secrets of credentials as they may appear in messages
+func (c Credentials) secrets() []string {
+	secrets := []string{c.Authorization, c.Token, c.Password}
+	if fields := strings.Fields(c.Authorization); len(fields) == 2 {
+		secrets = append(secrets, fields[1])
+	}
+	if c.Username != "" && c.Password != "" {
+		secrets = append(secrets, base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password)))
+	}
+	return secrets
+}
+
+// redact ... replaces the secrets of client in s, including the password in the Grafana URL
+func (g client) redact(s string) string {
+	secrets := g.credentials.secrets()
+	if u, err := url.Parse(g.url); err == nil && u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			secrets = append(secrets, password, url.QueryEscape(password))
+		}
+	}
+	for _, secret := range secrets {
+		// short secrets would redact unrelated text
+		if len(secret) >= 4 {
+			s = strings.Replace(s, secret, redacted, -1)
+		}
+	}
+	return s
+}
+
+// RedactURL ... hides the password of the user info in URL u
+func RedactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.User == nil {
+		return u
+	}
+	if password, ok := parsed.User.Password(); ok && password != "" {
+		return strings.Replace(u, ":"+password+"@", ":"+redacted+"@", 1)
+	}
+	return u
+}
+
+// errorf ... formats an error message without the secrets of client
+func (g client) errorf(format string, args ...interface{}) error {
+	return errors.New(g.redact(fmt.Sprintf(format, args...)))
+}
+
+// newRequest ... creates a GET request to Grafana with the credentials of client
+func (g client) newRequest(reqURL string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, g.errorf("creating request for %s error: %v", reqURL, err)
+	}
+	if g.ctx != nil {
+		req = req.WithContext(g.ctx)
+	}
+	g.credentials.authorize(req)
+	return req, nil
+}
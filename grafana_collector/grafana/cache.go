@@ -0,0 +1,298 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/pkg/errors"
+)
+
+// cacheFileExt is the extension of cached panel images, files are named by their cache key
+const cacheFileExt = ".png"
+
+// PanelCache caches rendered panel images on disk. Images expire after ttl, and the least recently used images are
+// evicted when the total size exceeds maxSize
+type PanelCache struct {
+	dir     string
+	maxSize int64
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is the most recently used entry
+	size    int64
+	hits    uint64
+	misses  uint64
+}
+
+// cacheEntry is a cached panel image
+type cacheEntry struct {
+	key     string
+	size    int64
+	created time.Time
+}
+
+// CacheStats are the counters of panel cache
+type CacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+	Size    int64  `json:"size"`
+}
+
+// NewPanelCache ... creates a panel cache in dir, images which are cached by previous processes are kept
+func NewPanelCache(dir string, maxSize int64, ttl time.Duration) (*PanelCache, error) {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return nil, errors.Errorf("creating cache directory %s error: %v", dir, err)
+	}
+	c := &PanelCache{
+		dir:     dir,
+		maxSize: maxSize,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Errorf("reading cache directory %s error: %v", dir, err)
+	}
+	// the least recently modified images are the least recently used ones
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+	for _, f := range files {
+		if strings.Contains(f.Name(), ".tmp") {
+			// an image being written when the process exited
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		if f.IsDir() || filepath.Ext(f.Name()) != cacheFileExt {
+			continue
+		}
+		entry := &cacheEntry{key: strings.TrimSuffix(f.Name(), cacheFileExt), size: f.Size(), created: f.ModTime()}
+		c.entries[entry.key] = c.lru.PushBack(entry)
+		c.size += entry.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// Get ... returns the cached image of key. Expired images are removed. The lock of cache is held only to look up the
+// image, its file is opened without it
+func (c *PanelCache) Get(key string) (io.ReadCloser, bool) {
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if ok && c.ttl > 0 && c.now().Sub(elem.Value.(*cacheEntry).created) > c.ttl {
+		c.remove(elem)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	c.hits++
+	c.mu.Unlock()
+
+	file, err := os.Open(c.path(key))
+	if err != nil {
+		log.Errorf("opening cached image %s error: %v", key, err)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// the image may be replaced while it is opened
+		if c.entries[key] == elem {
+			c.remove(elem)
+		}
+		c.hits--
+		c.misses++
+		return nil, false
+	}
+	return file, true
+}
+
+// Put ... caches image of key, and evicts the least recently used images if the cache is full. The image is written
+// to a temporary file without holding the lock of cache, which is renamed to the cached image with it
+func (c *PanelCache) Put(key string, image []byte) error {
+	// images are written to temporary files first, so that a cached image is never partly written
+	tmp, err := ioutil.TempFile(c.dir, key+".tmp")
+	if err != nil {
+		return errors.Errorf("creating cache file of %s error: %v", key, err)
+	}
+	_, err = tmp.Write(image)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return errors.Errorf("writing cache file of %s error: %v", key, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err = os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Errorf("writing cache file of %s error: %v", key, err)
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.lru.Remove(elem)
+		c.size -= elem.Value.(*cacheEntry).size
+	}
+	entry := &cacheEntry{key: key, size: int64(len(image)), created: c.now()}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += entry.size
+	c.evict()
+	return nil
+}
+
+// Stats ... returns the counters of cache
+func (c *PanelCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Entries: len(c.entries), Size: c.size}
+}
+
+// evict ... removes the least recently used images until the total size is within maxSize, the caller must hold c.mu
+func (c *PanelCache) evict() {
+	for c.maxSize > 0 && c.size > c.maxSize && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove ... removes the image of elem from cache, the caller must hold c.mu
+func (c *PanelCache) remove(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	if err := os.Remove(c.path(entry.key)); err != nil && !os.IsNotExist(err) {
+		log.Errorf("removing cached image %s error: %v", entry.key, err)
+	}
+}
+
+func (c *PanelCache) path(key string) string {
+	return filepath.Join(c.dir, key+cacheFileExt)
+}
+
+// cachingClient looks up panel images in cache before rendering them by Grafana
+type cachingClient struct {
+	Client
+	cache   *PanelCache
+	refresh bool
+
+	mu   sync.Mutex
+	dash *Dashboard // the dashboard of report, panel images of unknown dashboards are not cached
+}
+
+// NewCachingClient ... creates a client which caches panel images of client g. If refresh is true, images are
+// always rendered again and replace the cached ones
+func NewCachingClient(g Client, cache *PanelCache, refresh bool) Client {
+	return &cachingClient{Client: g, cache: cache, refresh: refresh}
+}
+
+func (c *cachingClient) GetDashboard(ctx context.Context, dashName string) (Dashboard, error) {
+	dash, err := c.Client.GetDashboard(ctx, dashName)
+	if err == nil {
+		c.mu.Lock()
+		c.dash = &dash
+		c.mu.Unlock()
+	}
+	return dash, err
+}
+
+func (c *cachingClient) GetPanelPng(ctx context.Context, p Panel, dashName string, t TimeRange) (io.ReadCloser, error) {
+	c.mu.Lock()
+	dash := c.dash
+	c.mu.Unlock()
+	// images of relative time ranges like now-1h change as time goes by, only fixed time ranges are cached
+	if dash == nil || !t.isFixed() {
+		return c.Client.GetPanelPng(ctx, p, dashName, t)
+	}
+
+	key, err := panelCacheKey(*dash, dashName, p, t)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !c.refresh {
+		if image, ok := c.cache.Get(key); ok {
+			log.Infof("panel %d of dashboard %s is found in cache", p.ID, dashName)
+			return image, nil
+		}
+	}
+
+	body, err := c.Client.GetPanelPng(ctx, p, dashName, t)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer body.Close()
+	image, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, errors.Errorf("reading image of panel %d error: %v", p.ID, err)
+	}
+	if err = c.cache.Put(key, image); err != nil {
+		log.Errorf("caching image of panel %d error: %v", p.ID, err)
+	}
+	return ioutil.NopCloser(bytes.NewReader(image)), nil
+}
+
+// panelCacheKey ... returns the hash of everything a panel image depends on: the Grafana and Prometheus servers, the
+// organization, the dashboard and its version, the panel, selected and scoped variables, absolute time range, time zone,
+// theme, size and renderer. Dashboards provisioned to several clusters share UID and version, so the servers are hashed
+func panelCacheKey(dash Dashboard, dashName string, p Panel, t TimeRange) (string, error) {
+	from, err := t.FromTime()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	to, err := t.ToTime()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	names := make([]string, 0, len(p.ScopedVars))
+	for name := range p.ScopedVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	scopedVars := make([]string, 0, len(names))
+	for _, name := range names {
+		scopedVars = append(scopedVars, name+"="+p.ScopedVars[name].Value)
+	}
+
+	width, height := p.PixelSize()
+	h := sha256.New()
+	fmt.Fprintf(h, "grafana=%s\nprometheus=%s\ndashboard=%s\norg=%d\nuid=%s\nversion=%d\npanel=%d\nvariables=%s\nscoped=%s\nfrom=%d\nto=%d\ntz=%s\ntheme=%s\nsize=%dx%d\nrenderer=%s\n",
+		dash.client.url, dash.client.prometheusURL, dashName, dash.OrgID, dash.UID, dash.Version, p.renderID(), dash.Variables.Encode(), strings.Join(scopedVars, "&"),
+		from.UnixNano()/int64(time.Millisecond), to.UnixNano()/int64(time.Millisecond), t.location(), cfg.Grafana.Theme, width, height,
+		dash.Renderer)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// countingClient returns the panel ID as image and counts rendered panels
+type countingClient struct {
+	dash     Dashboard
+	rendered int
+}
+
+func (c *countingClient) GetDashboard(ctx context.Context, dashName string) (Dashboard, error) {
+	return c.dash, nil
+}
+
+func (c *countingClient) GetPanelPng(ctx context.Context, p Panel, dashName string, t TimeRange) (io.ReadCloser, error) {
+	c.rendered++
+	return ioutil.NopCloser(bytes.NewReader([]byte{byte(p.ID)})), nil
+}
+
+func (c *countingClient) GetPanelSummary(ctx context.Context, p Panel, t TimeRange) ([]SeriesSummary, error) {
+	return nil, nil
+}
+
+func readImage(r io.ReadCloser, err error) []byte {
+	So(err, ShouldBeNil)
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	So(err, ShouldBeNil)
+	return b
+}
+
+func TestPanelCache(t *testing.T) {
+	Convey("When caching panel images", t, func() {
+		dir, err := ioutil.TempDir("", "cache")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		cache, err := NewPanelCache(dir, 10, time.Hour)
+		So(err, ShouldBeNil)
+		now := time.Now()
+		cache.now = func() time.Time { return now }
+
+		So(cache.Put("a", []byte("aaaa")), ShouldBeNil)
+		So(cache.Put("b", []byte("bbbb")), ShouldBeNil)
+
+		Convey("Cached images should be returned", func() {
+			image, ok := cache.Get("a")
+			So(ok, ShouldBeTrue)
+			So(readImage(image, nil), ShouldResemble, []byte("aaaa"))
+			_, ok = cache.Get("c")
+			So(ok, ShouldBeFalse)
+			So(cache.Stats(), ShouldResemble, CacheStats{Hits: 1, Misses: 1, Entries: 2, Size: 8})
+		})
+
+		Convey("The least recently used images should be evicted when the cache is full", func() {
+			_, ok := cache.Get("a")
+			So(ok, ShouldBeTrue)
+			So(cache.Put("c", []byte("cccc")), ShouldBeNil)
+			_, ok = cache.Get("b")
+			So(ok, ShouldBeFalse)
+			_, ok = cache.Get("a")
+			So(ok, ShouldBeTrue)
+			_, err := os.Stat(cache.path("b"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("Expired images should be removed", func() {
+			now = now.Add(2 * time.Hour)
+			_, ok := cache.Get("a")
+			So(ok, ShouldBeFalse)
+			So(cache.Stats().Entries, ShouldEqual, 1)
+		})
+
+		Convey("Cached images should be kept for a new cache in the same directory", func() {
+			reopened, err := NewPanelCache(dir, 10, time.Hour)
+			So(err, ShouldBeNil)
+			So(reopened.Stats(), ShouldResemble, CacheStats{Entries: 2, Size: 8})
+		})
+	})
+}
+
+func TestCachingClient(t *testing.T) {
+	Convey("When rendering panels with a caching client", t, func() {
+		dir, err := ioutil.TempDir("", "cache")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		cache, err := NewPanelCache(dir, 0, time.Hour)
+		So(err, ShouldBeNil)
+
+		g := &countingClient{dash: Dashboard{UID: "abc", Version: 3, Variables: url.Values{"var-db": {"kv"}},
+			client: client{url: "http://grafana-a:3000"}}}
+		timeRange := TimeRange{From: "1543886699000", To: "1543890299000"}
+		panel := Panel{ID: 1, Type: "graph"}
+		render := func(g Client, p Panel) []byte {
+			_, err := g.GetDashboard(context.Background(), "testDash")
+			So(err, ShouldBeNil)
+			return readImage(g.GetPanelPng(context.Background(), p, "testDash", timeRange))
+		}
+
+		So(render(NewCachingClient(g, cache, false), panel), ShouldResemble, []byte{1})
+		So(render(NewCachingClient(g, cache, false), panel), ShouldResemble, []byte{1})
+
+		Convey("The same panel should be rendered only once", func() {
+			So(g.rendered, ShouldEqual, 1)
+			So(cache.Stats().Hits, ShouldEqual, 1)
+		})
+
+		Convey("Panels should be rendered again for refresh, other variables or a new dashboard version", func() {
+			render(NewCachingClient(g, cache, true), panel)
+			So(g.rendered, ShouldEqual, 2)
+
+			panel.ScopedVars = map[string]ScopedVar{"instance": {Text: "tikv-1", Value: "tikv-1"}}
+			render(NewCachingClient(g, cache, false), panel)
+			So(g.rendered, ShouldEqual, 3)
+
+			g.dash.Version = 4
+			render(NewCachingClient(g, cache, false), panel)
+			So(g.rendered, ShouldEqual, 4)
+		})
+
+		Convey("The same dashboard of another Grafana should be rendered again", func() {
+			key, err := panelCacheKey(g.dash, "testDash", panel, timeRange)
+			So(err, ShouldBeNil)
+			g.dash.client.url = "http://grafana-b:3000"
+			otherKey, err := panelCacheKey(g.dash, "testDash", panel, timeRange)
+			So(err, ShouldBeNil)
+			So(otherKey, ShouldNotEqual, key)
+
+			render(NewCachingClient(g, cache, false), panel)
+			So(g.rendered, ShouldEqual, 2)
+		})
+
+		Convey("Panels of relative time ranges should not be cached", func() {
+			timeRange = TimeRange{From: "1543886699000", To: "now"}
+			render(NewCachingClient(g, cache, false), panel)
+			render(NewCachingClient(g, cache, false), panel)
+			So(g.rendered, ShouldEqual, 3)
+			So(cache.Stats().Entries, ShouldEqual, 1)
+		})
+	})
+}
@@ -31,11 +31,10 @@ package grafana
 
 import (
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
+	"math"
+	"net/url"
 	"regexp"
-	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -44,10 +43,48 @@ import (
 )
 
 var (
-	// query templating variable, we only support label_values(metric, label) format, see http://docs.grafana.org/features/datasources/prometheus/.
-	// regexp: https://github.com/grafana/grafana/blob/v4.6.3/public/app/plugins/datasource/prometheus/metric_find_query.js#L14
-	queryMetricRegexp = regexp.MustCompile(`^label_values\((.+),\s*([a-zA-Z_][a-zA-Z0-9_]+)\)$`)
-	variableRegexp    = regexp.MustCompile(`\$.+`)
+	// panelTypeAliases are the types of older versions or plugin versions of built-in panels
+	panelTypeAliases = map[string]string{
+		"table-old":                TablePanel,
+		"grafana-piechart-panel":   PiechartPanel,
+		"grafana-singlestat-panel": SinglestatPanel,
+	}
+
+	// variable syntax: $name, ${name}, ${name:format} and [[name]]
+	variableRegexp = regexp.MustCompile(`\$(\w+)|\$\{(\w+)(?::\w+)?\}|\[\[(\w+)\]\]`)
+)
+
+const (
+	// variablePrefix is the prefix of Grafana template variables in url values, e.g. var-host=dev
+	variablePrefix = "var-"
+	// allValue is the value of the "All" option of Grafana template variables
+	allValue = "$__all"
+)
+
+const (
+	// GridColumnCount is the width of Grafana v5 dashboard grid
+	GridColumnCount = 24
+	// GridColumnWidth is the width of a grid column in pixels when panels are rendered, a dashboard is 1200px wide
+	GridColumnWidth = 50
+	// GridCellHeight and GridCellVMargin are the height and vertical margin of a grid row in pixels
+	GridCellHeight  = 30
+	GridCellVMargin = 8
+
+	// Grafana panel types
+	GraphPanel      = "graph"
+	TimeseriesPanel = "timeseries"
+	SinglestatPanel = "singlestat"
+	StatPanel       = "stat"
+	GaugePanel      = "gauge"
+	BarGaugePanel   = "bargauge"
+	TablePanel      = "table"
+	HeatmapPanel    = "heatmap"
+	PiechartPanel   = "piechart"
+	TextPanel       = "text"
+
+	// v4 panels are 4 of 12 columns wide and rows are 250px high by default
+	defaultV4PanelSpan = 4
+	defaultV4RowHeight = 250
 )
 
 // ScopedVar represents template variable
@@ -56,13 +93,94 @@ type ScopedVar struct {
 	Value string
 }
 
+// GridPos represents the position of a panel on the grid of Grafana v5 dashboard
+type GridPos struct {
+	X int
+	Y int
+	W int
+	H int
+}
+
 // Panel represents a Grafana dashboard panel
 type Panel struct {
-	ID         int
-	Type       string // Panel Type: Graph/Singlestat
-	Title      string
-	RowTitle   string
-	ScopedVars map[string]ScopedVar
+	ID              int
+	Type            string // Panel Type: graph, singlestat, table, text, etc.
+	Title           string
+	RowTitle        string
+	ScopedVars      map[string]ScopedVar
+	GridPos         GridPos
+	Span            float64 // Grafana v4 panel width in 12 columns
+	Repeat          string  // Grafana v5 repeats the panel for every value of this templating variable
+	RepeatDirection string  // h or v
+	MaxPerRow       int     // max number of repeated panels per row for horizontal repeats
+	RepeatPanelID   int     // ID of the source panel if the panel is a repeated clone
+	Collapsed       bool    // Grafana v5 row panel is collapsed
+	Panels          []Panel // panels of a collapsed Grafana v5 row panel
+	Description     string  // description of panel, which is shown under its title in html reports
+	Content         string  // markdown or html content of text panel
+	Mode            string  // markdown, html or text
+	Options         PanelOptions
+	Datasource      DatasourceRef // data source of targets, the default data source if empty
+	Targets         []Target      // queries of the panel
+	Format          string        // unit of singlestat panel
+	Yaxes           []Axis        // Grafana v4 and v5 graph axes, the unit of the left axis is the unit of panel
+	FieldConfig     FieldConfig   // Grafana v7+ keeps the unit in field config
+	Fill            int           // Grafana v4 and v5 graph fills the area under lines if it is more than 0
+	Thresholds      Thresholds    // thresholds of Grafana v4 and v5 graph and singlestat panels
+	Colors          []string      // colors of singlestat values below, between and above thresholds
+	ValueName       string        // reducer of singlestat values, e.g. avg, current, max
+	Legend          *Legend       // Grafana v4 and v5 graph legend, it is shown if missing
+}
+
+// PanelOptions represents the options of Grafana v7+ panels, text panels keep their content in options
+type PanelOptions struct {
+	Content string
+	Mode    string
+	Legend  struct {
+		DisplayMode string // list, table or hidden
+		ShowLegend  *bool
+	}
+	ReduceOptions struct {
+		Calcs []string // reducers of stat values, e.g. lastNotNull, mean, max
+	}
+}
+
+// Legend represents the legend of Grafana v4 and v5 graph panel
+type Legend struct {
+	Show bool
+}
+
+// Target represents a query of panel
+type Target struct {
+	RefID        string
+	Expr         string // PromQL expression
+	LegendFormat string // series name with {{label}} replaced by label values, e.g. {{instance}}-{{type}}
+	Hide         bool
+	Datasource   DatasourceRef // data source of target in panels of mixed data sources
+}
+
+// Axis represents an Y axis of Grafana v4 and v5 graph panel
+type Axis struct {
+	Format string
+}
+
+// FieldConfig represents the field config of Grafana v7+ panels
+type FieldConfig struct {
+	Defaults struct {
+		Unit   string
+		Custom struct {
+			FillOpacity float64
+		}
+		Thresholds struct {
+			Steps []ThresholdStep
+		}
+	}
+}
+
+// ThresholdStep represents a threshold of Grafana v7+ panels, the base step has no value
+type ThresholdStep struct {
+	Color string
+	Value *float64
 }
 
 // Row represents a container for Panels
@@ -73,117 +191,99 @@ type Row struct {
 	Repeat          string
 	RepeatIteration int64
 	RepeatRowID     int
+	Height          PixelHeight // Grafana v4 row height
 	Panels          []Panel
+	ScopedVars      map[string]ScopedVar
 }
 
 // TemplatingVariable represents templating variable
 type TemplatingVariable struct {
 	Name       string
-	Datasource string
+	Type       string // query, custom, constant, interval, textbox or datasource
+	Datasource DatasourceRef
 	Query      string
+	Regex      string // regex to filter or capture part of query values, e.g. /.*instance="([^"]*).*/
+	Multi      bool
+	IncludeAll bool
+	AllValue   string // custom value of the "All" option
+	Current    VariableOption
 }
 
-// Dashboard represents a Grafana dashboard
-// This is used to unmarshal the dashbaord JSON
-type Dashboard struct {
-	Title      string
-	Templating map[string][]TemplatingVariable
-	Rows       []Row
-	Panels     []Panel
-	url        string
-	apiToken   string
-	timeRange  TimeRange
-	iteration  int64
-}
-
-type dashContainer struct {
-	Dashboard Dashboard
-	Meta      struct {
-		Slug string
-	}
-}
-
-// MetircResult represents templating variable metric result
-type MetircResult struct {
-	Status string
-	Data   []map[string]interface{}
+// VariableOption represents an option of templating variable
+type VariableOption struct {
+	Text  VariableValue
+	Value VariableValue
 }
 
-// handleMetircResult ... handles query templating variable result,  and gets label's unique and sorted values
-func handleMetircResult(mr MetircResult, label string) []string {
-	result := make([]string, 0, len(mr.Data))
-	filter := make(map[string]bool)
+// VariableValue represents the value of templating variable option, which is a single value or a list of values
+type VariableValue []string
 
-	for _, m := range mr.Data {
-		if v, ok := m[label].(string); ok {
-			if _, exist := filter[v]; !exist {
-				filter[v] = true
-				result = append(result, v)
-			}
-		}
+// UnmarshalJSON ... accepts both "value": "a" and "value": ["a", "b"]
+func (v *VariableValue) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*v = VariableValue{s}
+		return nil
 	}
 
-	sort.Strings(result)
-	return result
-}
-
-// getMetricAndLabel ... gets metric and label from templating variable's query, example: label_values(tikv_engine_block_cache_size_bytes, db)
-func getMetricAndLabel(tv TemplatingVariable) (string, string, error) {
-	matched := queryMetricRegexp.FindStringSubmatch(tv.Query)
-
-	if matched != nil {
-		metric := matched[1]
-		label := matched[2]
-		return metric, label, nil
+	var values []string
+	if err := json.Unmarshal(b, &values); err != nil {
+		return errors.Errorf("unmarshaling variable value %s error: %v", string(b), err)
 	}
-
-	return "", "", errors.Errorf("%s should be label_values(metric, label) format", tv.Query)
+	*v = values
+	return nil
 }
 
-// getTemplatingVariableValue ... creates request to grafana, and get templating variable's value, example: http://172.16.30.193:3000/api/datasources/proxy/1/api/v1/series?match[]=tikv_engine_block_cache_size_bytes&start=1543890299&end=1543893899
-func (d *Dashboard) getTemplatingVariableValue(tv TemplatingVariable) ([]string, error) {
-	metric, label, err := getMetricAndLabel(tv)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-
-	metricURL := fmt.Sprintf("%s/api/datasources/proxy/1/api/v1/series?match[]=%s&start=%d&end=%d", d.url, metric, d.timeRange.FromToUnix(), d.timeRange.ToToUnix())
-
-	log.Infof("request metric at %s\n", metricURL)
+// PixelHeight represents the height of Grafana v4 row, which is a number or a string like "250px"
+type PixelHeight float64
 
-	clientTimeout := time.Duration(60) * time.Second
-	client := &http.Client{Timeout: clientTimeout}
-	req, err := http.NewRequest("GET", metricURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("creating metric request for %s error: %v", metricURL, err)
+// UnmarshalJSON ... accepts both "height": 250 and "height": "250px"
+func (h *PixelHeight) UnmarshalJSON(b []byte) error {
+	var f float64
+	if err := json.Unmarshal(b, &f); err == nil {
+		*h = PixelHeight(f)
+		return nil
 	}
 
-	if d.apiToken != "" {
-		req.Header.Add("Authorization", "Bearer "+d.apiToken)
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.Errorf("unmarshaling height %s error: %v", string(b), err)
 	}
-	resp, err := client.Do(req)
+	f, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "px"), 64)
 	if err != nil {
-		return nil, fmt.Errorf("executing metric request for %s error: %v", metricURL, err)
+		return errors.Errorf("unmarshaling height %s error: %v", string(b), err)
 	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading metric response body from %s error: %v", metricURL, err)
-	}
-
-	var result MetircResult
-	err = json.Unmarshal(body, &result)
+	*h = PixelHeight(f)
+	return nil
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("unmarshaling metric response from %s error: %v", metricURL, err)
-	}
+// Dashboard represents a Grafana dashboard
+// This is used to unmarshal the dashbaord JSON
+type Dashboard struct {
+	UID        string
+	Version    int // version of dashboard, it is increased by every save
+	OrgID      int // organization of dashboard in multi-org Grafana, 0 is the current organization of user
+	Title      string
+	Timezone   string // utc, browser or IANA time zone name, empty means browser
+	Templating map[string][]TemplatingVariable
+	Rows       []Row
+	Panels     []Panel
+	Variables  url.Values
+	Filter     PanelFilter // rows and panels selected by ApplyFilter
+	Renderer   string      // renderer of panel images, empty for the Grafana image renderer
+	client     client
+	timeRange  TimeRange
+	iteration  int64
+	// values of resolved templating variables, and variables being resolved for detecting cyclic dependencies
+	options   map[string][]string
+	resolving map[string]bool
+}
 
-	if result.Status != "success" {
-		return nil, fmt.Errorf("metric request status from %s is not successful: %v", metricURL, err)
+type dashContainer struct {
+	Dashboard Dashboard
+	Meta      struct {
+		Slug string
 	}
-
-	return handleMetircResult(result, label), nil
 }
 
 // process ... adds dynamic Row data structure to dashboard, and handles Panel ID and ScopedVars, we only impliement repeatRow logic, see https://github.com/grafana/grafana/blob/v4.6.3/public/app/features/dashboard/dynamic_dashboard_srv.ts#L19
@@ -217,23 +317,10 @@ func (d *Dashboard) removeRow(rowIndex int) {
 }
 
 func (d *Dashboard) repeatRow(row Row, rowIndex int) error {
-	var (
-		err      error
-		exist    bool
-		selected []string
-	)
-
 	label := row.Repeat
-	for _, tv := range d.Templating["list"] {
-		if tv.Name == label {
-			exist = true
-			selected, err = d.getTemplatingVariableValue(tv)
-			if err != nil {
-				log.Errorf("getting templateing varaible value error: %v\n", err)
-				return errors.Errorf("getting templateing varaible value error: %v\n", err)
-			}
-			break
-		}
+	selected, exist, err := d.variableValues(label)
+	if err != nil {
+		return errors.WithStack(err)
 	}
 
 	if !exist {
@@ -248,13 +335,7 @@ func (d *Dashboard) repeatRow(row Row, rowIndex int) error {
 }
 
 func (d *Dashboard) getRowClone(sourceRow Row, repeatIndex int, sourceRowIndex int, label string, option string) {
-	rowTitle := sourceRow.Title
-	matched := variableRegexp.FindString(rowTitle)
-	if matched != "" {
-		if strings.TrimPrefix(matched, "$") == label {
-			rowTitle = variableRegexp.ReplaceAllString(rowTitle, option)
-		}
-	}
+	rowTitle := interpolate(sourceRow.Title, map[string]ScopedVar{label: {Text: option, Value: option}})
 
 	if repeatIndex == 0 {
 		d.Rows[sourceRowIndex].Title = rowTitle
@@ -312,14 +393,26 @@ func (d *Dashboard) getNextPanelID() int {
 }
 
 // NewDashboard creates Dashboard from Grafana's internal JSON dashboard definition
-func NewDashboard(dashJSON []byte, url string, apiToken string, timeRange TimeRange) (Dashboard, error) {
+// variables are Grafana template variable url values of the form var-{name}={value}
+func NewDashboard(dashJSON []byte, url string, credentials Credentials, variables url.Values, timeRange TimeRange) (Dashboard, error) {
+	g := client{
+		url:         url,
+		credentials: credentials,
+		variables:   variables,
+		timeRange:   timeRange,
+		datasources: &datasourceCache{},
+	}
+	return newDashboard(dashJSON, g)
+}
+
+func newDashboard(dashJSON []byte, g client) (Dashboard, error) {
 	var dash dashContainer
 	err := json.Unmarshal(dashJSON, &dash)
 	if err != nil {
-		return Dashboard{}, errors.Errorf("unmarshaling dashbaord %s error: %v", url, err)
+		return Dashboard{}, g.errorf("unmarshaling dashbaord %s error: %v", g.url, err)
 	}
 
-	d, err := dash.NewDashboard(url, apiToken, timeRange)
+	d, err := dash.NewDashboard(g)
 	if err != nil {
 		return Dashboard{}, errors.Wrap(err, "populate dashboard data structure error")
 	}
@@ -333,21 +426,48 @@ func NewDashboard(dashJSON []byte, url string, apiToken string, timeRange TimeRa
 	return d, nil
 }
 
-func (dc dashContainer) NewDashboard(url string, apiToken string, timeRange TimeRange) (Dashboard, error) {
+func (dc dashContainer) NewDashboard(g client) (Dashboard, error) {
 	var dash Dashboard
 	iteration := UnixSecond(time.Now())
 
+	dash.UID = dc.Dashboard.UID
+	dash.Version = dc.Dashboard.Version
+	dash.OrgID = g.credentials.OrgID
 	dash.Title = dc.Dashboard.Title
+	dash.Timezone = dc.Dashboard.Timezone
 	dash.Templating = dc.Dashboard.Templating
-	dash.url = url
-	dash.apiToken = apiToken
-	dash.timeRange = timeRange
+	dash.Variables = g.variables
+	dash.client = g
+	// variables are queried over the time range in the time zone of dashboard, like the panels of report
+	dash.timeRange = g.timeRange.WithDashboardTimezone(dc.Dashboard.Timezone)
 	dash.iteration = iteration
+	dash.options = make(map[string][]string)
+	dash.resolving = make(map[string]bool)
 
+	var err error
 	if len(dc.Dashboard.Rows) == 0 {
-		return populatePanelsFromV5JSON(dash, dc)
+		dash, err = populatePanelsFromV5JSON(dash, dc)
+	} else {
+		dash, err = populatePanelsFromV4JSON(dash, dc)
 	}
-	return populatePanelsFromV4JSON(dash, dc)
+	if err != nil {
+		return dash, errors.WithStack(err)
+	}
+
+	// text panels are written to report by ourselves, so variables in their content are replaced here
+	for i, p := range dash.Panels {
+		if p.IsText() {
+			dash.Panels[i].Content = dash.interpolate(p.Content, p.ScopedVars)
+			dash.Panels[i].Options.Content = dash.interpolate(p.Options.Content, p.ScopedVars)
+		}
+	}
+	// targets are queried by ourselves for summary tables and native charts, so variables in them are replaced here
+	if cfg.Summary.Enable || g.native {
+		for i := range dash.Panels {
+			dash.interpolateTargets(&dash.Panels[i])
+		}
+	}
+	return dash, nil
 }
 
 func populatePanelsFromV4JSON(dash Dashboard, dc dashContainer) (Dashboard, error) {
@@ -360,6 +480,7 @@ func populatePanelsFromV4JSON(dash Dashboard, dc dashContainer) (Dashboard, erro
 	if err != nil {
 		return dash, errors.WithStack(err)
 	}
+	dash.layoutV4Rows()
 
 	for _, row := range dash.Rows {
 		for _, p := range row.Panels {
@@ -370,21 +491,112 @@ func populatePanelsFromV4JSON(dash Dashboard, dc dashContainer) (Dashboard, erro
 }
 
 func populatePanelsFromV5JSON(dash Dashboard, dc dashContainer) (Dashboard, error) {
-	for _, p := range dc.Dashboard.Panels {
-		if p.Type == "row" {
-			continue
+	// handle Row repeats, Panel repeats, collapsed Rows and RowTitle
+	err := dash.processV5(groupV5Panels(dc.Dashboard.Panels))
+	if err != nil {
+		return dash, errors.WithStack(err)
+	}
+
+	for _, row := range dash.Rows {
+		for _, p := range row.Panels {
+			dash.Panels = append(dash.Panels, p)
 		}
-		dash.Panels = append(dash.Panels, p)
 	}
 	return dash, nil
 }
 
+// layoutV4Rows ... places panels of Grafana v4 rows on the grid of Grafana v5 dashboard, the same as Grafana does
+// when it upgrades dashboards, see https://github.com/grafana/grafana/blob/v5.0.0/public/app/features/dashboard/dashboard_migration.ts#L383
+func (d *Dashboard) layoutV4Rows() {
+	y := 0
+	for i, row := range d.Rows {
+		height := row.Height
+		if height <= 0 {
+			height = defaultV4RowHeight
+		}
+		h := int(math.Ceil(float64(height) / (GridCellHeight + GridCellVMargin)))
+
+		x := 0
+		for j, p := range row.Panels {
+			span := p.Span
+			if span <= 0 {
+				span = defaultV4PanelSpan
+			}
+			w := int(math.Max(1, math.Floor(span*GridColumnCount/12)))
+			if x+w > GridColumnCount {
+				x = 0
+				y += h
+			}
+			d.Rows[i].Panels[j].GridPos = GridPos{X: x, Y: y, W: w, H: h}
+			x += w
+		}
+		if len(row.Panels) > 0 {
+			y += h
+		}
+	}
+}
+
+// PixelSize ... returns the size in pixels to render the panel, the same as it is shown on a 1200px wide dashboard
+func (p Panel) PixelSize() (width int, height int) {
+	if p.GridPos.W <= 0 || p.GridPos.H <= 0 {
+		size, ok := cfg.PanelSize[p.Kind()]
+		if !ok {
+			size = cfg.PanelSize["default"]
+		}
+		return int(size.Width), int(size.Height)
+	}
+	return p.GridPos.W * GridColumnWidth, p.GridPos.H*(GridCellHeight+GridCellVMargin) - GridCellVMargin
+}
+
+// Kind ... returns the panel type, older versions and plugin versions of built-in panels are the same kind
+func (p Panel) Kind() string {
+	if kind, ok := panelTypeAliases[p.Type]; ok {
+		return kind
+	}
+	return p.Type
+}
+
 // IsSingleStat ... checks if Panel is singlestat
 func (p Panel) IsSingleStat() bool {
-	if p.Type == "singlestat" {
-		return true
+	return p.Kind() == SinglestatPanel
+}
+
+// IsTable ... checks if Panel is table
+func (p Panel) IsTable() bool {
+	return p.Kind() == TablePanel
+}
+
+// IsText ... checks if Panel is text, which is written to report as text instead of image
+func (p Panel) IsText() bool {
+	return p.Kind() == TextPanel
+}
+
+// TextContent ... returns the content and mode of text panel, Grafana v7+ keeps them in options
+func (p Panel) TextContent() (content string, mode string) {
+	if p.Options.Content != "" {
+		return p.Options.Content, p.Options.Mode
+	}
+	return p.Content, p.Mode
+}
+
+// Unit ... returns the unit of panel values, e.g. bytes, percent or s
+func (p Panel) Unit() string {
+	if p.FieldConfig.Defaults.Unit != "" {
+		return p.FieldConfig.Defaults.Unit
+	}
+	if len(p.Yaxes) > 0 && p.Yaxes[0].Format != "" {
+		return p.Yaxes[0].Format
+	}
+	return p.Format
+}
+
+// renderID ... returns the panel ID used by Grafana v5 to render the panel. Repeated clones are rendered from
+// their source panel, and the scoped variables select the repeated value
+func (p Panel) renderID() int {
+	if p.RepeatPanelID != 0 {
+		return p.RepeatPanelID
 	}
-	return false
+	return p.ID
 }
 
 // IsVisible ... checks if Row is visible
@@ -30,6 +30,7 @@
 package grafana
 
 import (
+	"net/url"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -55,7 +56,7 @@ func TestV4Dashboard(t *testing.T) {
 "Meta":
 	{"Slug":"testDash"}
 }`
-		dash, err := NewDashboard([]byte(v4DashJSON), "", "", TimeRange{"now-1h", "now"})
+		dash, err := NewDashboard([]byte(v4DashJSON), "", Credentials{}, nil, TimeRange{From: "now-1h", To: "now"})
 
 		Convey("Panel IsSingelStat should work for all panels", func() {
 			So(err, ShouldBeNil)
@@ -70,6 +71,78 @@ func TestV4Dashboard(t *testing.T) {
 	})
 }
 
+func TestV4DashboardLayout(t *testing.T) {
+	Convey("When creating a new dashboard from Grafana v4 dashboard JSON with spans and row heights", t, func() {
+		const v4DashJSON = `
+{"Dashboard":
+	{
+		"Rows":
+			[{"Panels": [{"Type":"graph", "ID":1, "Span":6}, {"Type":"graph", "ID":2, "Span":6}, {"Type":"graph", "ID":3, "Span":12}], "Height": "300px"},
+			{"Panels": [{"Type":"singlestat", "ID":4}], "Height": 100}],
+		"title":"DashTitle #"
+	}
+}`
+		dash, err := NewDashboard([]byte(v4DashJSON), "", Credentials{}, nil, TimeRange{From: "now-1h", To: "now"})
+
+		Convey("Panels should be placed on the grid side by side and wrap when a row is full", func() {
+			So(err, ShouldBeNil)
+			So(dash.Panels[0].GridPos, ShouldResemble, GridPos{X: 0, Y: 0, W: 12, H: 8})
+			So(dash.Panels[1].GridPos, ShouldResemble, GridPos{X: 12, Y: 0, W: 12, H: 8})
+			So(dash.Panels[2].GridPos, ShouldResemble, GridPos{X: 0, Y: 8, W: 24, H: 8})
+			So(dash.Panels[3].GridPos, ShouldResemble, GridPos{X: 0, Y: 16, W: 8, H: 3})
+		})
+	})
+}
+
+func TestPanelTypes(t *testing.T) {
+	Convey("When creating a dashboard with panels of different types", t, func() {
+		const v5DashJSON = `
+{"Dashboard":
+	{
+		"Panels":
+			[{"Type":"text", "ID":1, "Content":"# Instance $instance", "Mode":"markdown", "ScopedVars":{"instance":{"Text":"tikv-1", "Value":"tikv-1"}}},
+			{"Type":"text", "ID":2, "Options":{"Content":"<b>$instance</b>", "Mode":"html"}},
+			{"Type":"table-old", "ID":3},
+			{"Type":"grafana-piechart-panel", "ID":4},
+			{"Type":"bargauge", "ID":5}],
+		"title":"DashTitle #"
+	}
+}`
+		dash, err := NewDashboard([]byte(v5DashJSON), "", Credentials{}, url.Values{"var-instance": {"tikv-2"}}, TimeRange{From: "now-1h", To: "now"})
+		So(err, ShouldBeNil)
+
+		Convey("Variables in the content of text panels should be replaced", func() {
+			So(dash.Panels[0].IsText(), ShouldBeTrue)
+			content, mode := dash.Panels[0].TextContent()
+			So(content, ShouldEqual, "# Instance tikv-1")
+			So(mode, ShouldEqual, "markdown")
+
+			content, mode = dash.Panels[1].TextContent()
+			So(content, ShouldEqual, "<b>tikv-2</b>")
+			So(mode, ShouldEqual, "html")
+		})
+
+		Convey("Older and plugin versions of panels should have the kind of built-in panels", func() {
+			So(dash.Panels[2].IsTable(), ShouldBeTrue)
+			So(dash.Panels[3].Kind(), ShouldEqual, PiechartPanel)
+		})
+
+		Convey("Panels without grid position should be rendered in the size of their type", func() {
+			width, height := dash.Panels[3].PixelSize()
+			So(width, ShouldEqual, 500)
+			So(height, ShouldEqual, 500)
+
+			width, height = dash.Panels[4].PixelSize()
+			So(width, ShouldEqual, 1000)
+			So(height, ShouldEqual, 240)
+
+			width, height = Panel{Type: "unknown"}.PixelSize()
+			So(width, ShouldEqual, 1000)
+			So(height, ShouldEqual, 500)
+		})
+	})
+}
+
 func TestV5Dashboard(t *testing.T) {
 	Convey("When creating a new dashboard from Grafana v5 dashboard JSON", t, func() {
 		const v5DashJSON = `
@@ -86,7 +159,7 @@ func TestV5Dashboard(t *testing.T) {
 "Meta":
 	{"Slug":"testDash"}
 }`
-		dash, err := NewDashboard([]byte(v5DashJSON), "", "", TimeRange{"now-1h", "now"})
+		dash, err := NewDashboard([]byte(v5DashJSON), "", Credentials{}, nil, TimeRange{From: "now-1h", To: "now"})
 
 		Convey("Panel IsSingelStat should work for all panels", func() {
 			So(err, ShouldBeNil)
@@ -104,9 +177,44 @@ func TestV5Dashboard(t *testing.T) {
 	})
 }
 
+func TestV4DashboardRepeatRowWithSelectedVariable(t *testing.T) {
+	Convey("When creating a new dashboard with a repeated row and selected values", t, func() {
+		const v4DashJSON = `
+{"Dashboard":
+	{
+		"Rows":
+			[{
+				"Panels":
+					[{"Type":"graph", "ID":1},
+					{"Type":"graph", "ID":2}],
+				"Title": "Instance $instance",
+				"Repeat": "instance"
+			}],
+		"Templating":
+			{"list": [{"Name":"instance", "Query":"label_values(up, instance)"}]},
+		"title":"DashTitle #"
+	}
+}`
+		variables := url.Values{"var-instance": {"a", "b"}}
+		dash, err := NewDashboard([]byte(v4DashJSON), "", Credentials{}, variables, TimeRange{From: "now-1h", To: "now"})
+
+		Convey("The row should be repeated for every selected value", func() {
+			So(err, ShouldBeNil)
+			So(dash.Variables, ShouldResemble, variables)
+			So(dash.Rows, ShouldHaveLength, 2)
+			So(dash.Panels, ShouldHaveLength, 4)
+			So(dash.Panels[0].RowTitle, ShouldEqual, "Instance a")
+			So(dash.Panels[0].ScopedVars["instance"].Value, ShouldEqual, "a")
+			So(dash.Panels[2].RowTitle, ShouldEqual, "Instance b")
+			So(dash.Panels[2].ScopedVars["instance"].Value, ShouldEqual, "b")
+			So(dash.Panels[2].ID, ShouldNotEqual, dash.Panels[0].ID)
+		})
+	})
+}
+
 func TestGetMetricAndLabel(t *testing.T) {
 	Convey("When analysing a correct TemplatingVariable ", t, func() {
-		variable := TemplatingVariable{"db", "test-cluster", "label_values(tikv_engine_block_cache_size_bytes, db)"}
+		variable := TemplatingVariable{Name: "db", Datasource: DatasourceRef{Name: "test-cluster"}, Query: "label_values(tikv_engine_block_cache_size_bytes, db)"}
 		metric, label, err := getMetricAndLabel(variable)
 
 		Convey("metric and label should not be empty and correct", func() {
@@ -119,8 +227,8 @@ func TestGetMetricAndLabel(t *testing.T) {
 
 func TestGetMetricAndLabelErrorHandling(t *testing.T) {
 	Convey("When analysing a wrong TemplatingVariable", t, func() {
-		v1 := TemplatingVariable{"db", "test-cluster", "label_values(tikv_engine_block_cache_size_bytes, 2db)"}
-		v2 := TemplatingVariable{"db", "test-cluster", "db, db"}
+		v1 := TemplatingVariable{Name: "db", Datasource: DatasourceRef{Name: "test-cluster"}, Query: "label_values(tikv_engine_block_cache_size_bytes, 2db)"}
+		v2 := TemplatingVariable{Name: "db", Datasource: DatasourceRef{Name: "test-cluster"}, Query: "db, db"}
 
 		metric1, label1, err1 := getMetricAndLabel(v1)
 		metric2, label2, err2 := getMetricAndLabel(v2)
@@ -0,0 +1,224 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/pkg/errors"
+)
+
+// Datasource represents a Grafana data source, see http://docs.grafana.org/http_api/data_source/
+type Datasource struct {
+	ID        int    `json:"id"`
+	UID       string `json:"uid"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	IsDefault bool   `json:"isDefault"`
+}
+
+// DatasourceRef references a data source by name, or by uid and type in Grafana v7+ dashboards.
+// An empty reference means the default data source
+type DatasourceRef struct {
+	Name string
+	UID  string
+	Type string
+}
+
+// UnmarshalJSON ... accepts both "datasource": "name" and "datasource": {"uid": "...", "type": "..."}
+func (r *DatasourceRef) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*r = DatasourceRef{Name: name}
+		return nil
+	}
+
+	var ref struct {
+		UID  string
+		Type string
+	}
+	if err := json.Unmarshal(b, &ref); err != nil {
+		return errors.Errorf("unmarshaling datasource %s error: %v", string(b), err)
+	}
+	*r = DatasourceRef{UID: ref.UID, Type: ref.Type}
+	return nil
+}
+
+func (r DatasourceRef) String() string {
+	if r.UID != "" {
+		return "uid " + r.UID
+	}
+	if r.Name == "" {
+		return "default"
+	}
+	return r.Name
+}
+
+// datasourceCache caches data sources of a Grafana server, it is shared by all copies of a client
+type datasourceCache struct {
+	mu          sync.Mutex
+	datasources []Datasource
+}
+
+// frontendSettings is the data sources part of /api/frontend/settings. Unlike /api/datasources, which needs the
+// org admin role in Grafana 5 and 6, every signed-in user or API key can read it
+type frontendSettings struct {
+	Datasources       map[string]Datasource `json:"datasources"`
+	DefaultDatasource string                `json:"defaultDatasource"`
+}
+
+// getDatasources ... lists data sources of Grafana, they are requested only once for every client
+func (g client) getDatasources() ([]Datasource, error) {
+	g.datasources.mu.Lock()
+	defer g.datasources.mu.Unlock()
+
+	if g.datasources.datasources != nil {
+		return g.datasources.datasources, nil
+	}
+
+	body, err := g.get(g.url + "/api/frontend/settings")
+	if err != nil {
+		return nil, errors.Wrap(err, "list datasources")
+	}
+
+	var settings frontendSettings
+	err = json.Unmarshal(body, &settings)
+	if err != nil {
+		return nil, errors.Errorf("unmarshaling datasources error: %v", err)
+	}
+	datasources := make([]Datasource, 0, len(settings.Datasources))
+	for name, ds := range settings.Datasources {
+		// built-in data sources like -- Grafana -- and -- Mixed -- can't be proxied
+		if ds.ID <= 0 {
+			continue
+		}
+		if ds.Name == "" {
+			ds.Name = name
+		}
+		ds.IsDefault = ds.IsDefault || ds.Name == settings.DefaultDatasource
+		datasources = append(datasources, ds)
+	}
+	sort.Slice(datasources, func(i, j int) bool { return datasources[i].ID < datasources[j].ID })
+	g.datasources.datasources = datasources
+	return datasources, nil
+}
+
+// findDatasource ... looks up data source by reference
+func (g client) findDatasource(ref DatasourceRef) (Datasource, error) {
+	datasources, err := g.getDatasources()
+	if err != nil {
+		return Datasource{}, errors.WithStack(err)
+	}
+
+	for _, ds := range datasources {
+		switch {
+		case ref.UID != "":
+			if ds.UID == ref.UID {
+				return ds, nil
+			}
+		case ref.Name == "" || ref.Name == "default":
+			if ds.IsDefault {
+				return ds, nil
+			}
+		case ds.Name == ref.Name || ds.UID == ref.Name:
+			return ds, nil
+		}
+	}
+	return Datasource{}, errors.Errorf("datasource %s is not found", ref)
+}
+
+// datasourceProxyGet ... requests path of data source through the Grafana data source proxy, example:
+// http://172.16.30.193:3000/api/datasources/proxy/1/api/v1/series?match[]=tikv_engine_block_cache_size_bytes
+func (g client) datasourceProxyGet(ds Datasource, path string, params url.Values) ([]byte, error) {
+	proxyURL := fmt.Sprintf("%s/api/datasources/proxy/%d%s?%s", g.url, ds.ID, path, params.Encode())
+	body, err := g.get(proxyURL)
+	return body, errors.Wrapf(err, "query datasource %s", ds.Name)
+}
+
+// get ... sends GET request to Grafana and returns the response body
+func (g client) get(reqURL string) ([]byte, error) {
+	log.Infof("request grafana at %s", g.redact(reqURL))
+
+	req, err := g.newRequest(reqURL)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return g.do(req)
+}
+
+// do ... sends req and returns the response body
+func (g client) do(req *http.Request) ([]byte, error) {
+	reqURL := req.URL.String()
+	clientTimeout := time.Duration(cfg.Grafana.ClientTimeout) * time.Second
+	client := &http.Client{Timeout: clientTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, g.errorf("executing request for %s error: %v", reqURL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, g.errorf("reading response body from %s error: %v", reqURL, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, g.errorf("request to %s error, got status %s, message: %s", reqURL, resp.Status, string(body))
+	}
+	return body, nil
+}
+
+// datasource ... resolves the data source of templating variable, $datasource-style references are replaced with
+// the selected value of the data source variable
+func (d *Dashboard) datasource(ref DatasourceRef) (Datasource, error) {
+	return d.client.findDatasource(d.interpolateDatasource(ref, nil))
+}
+
+// interpolateDatasource ... replaces variables in data source reference with scoped values or the selected value
+// of the data source variable. The value of data source variable is a name or uid, so it is looked up as a name
+func (d *Dashboard) interpolateDatasource(ref DatasourceRef, scopedVars map[string]ScopedVar) DatasourceRef {
+	ref.Name = d.datasourceVariableValue(interpolate(ref.Name, scopedVars))
+	if uid := interpolate(ref.UID, scopedVars); variableRegexp.MatchString(uid) || uid != ref.UID {
+		ref.Name, ref.UID = d.datasourceVariableValue(uid), ""
+	}
+	return ref
+}
+
+func (d *Dashboard) datasourceVariableValue(s string) string {
+	matched := variableRegexp.FindStringSubmatch(s)
+	if matched == nil {
+		return s
+	}
+
+	name := strings.Join(matched[1:], "")
+	if selected := d.selectedValues(name); len(selected) > 0 {
+		return selected[0]
+	}
+	for _, tv := range d.Templating["list"] {
+		if tv.Name == name && len(tv.Current.Value) > 0 && tv.Current.Value[0] != allValue {
+			return tv.Current.Value[0]
+		}
+	}
+	// the default data source is used if no data source is selected
+	return ""
+}
@@ -0,0 +1,150 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const datasourcesJSON = `[
+	{"id":1, "uid":"abc", "name":"other-cluster", "type":"prometheus", "isDefault":true},
+	{"id":3, "uid":"def", "name":"tidb-cluster", "type":"prometheus", "isDefault":false}
+]`
+
+const frontendSettingsJSON = `{"defaultDatasource":"other-cluster", "datasources":{
+	"-- Grafana --": {"name":"-- Grafana --", "type":"datasource"},
+	"other-cluster": {"id":1, "uid":"abc", "name":"other-cluster", "type":"prometheus"},
+	"tidb-cluster": {"id":3, "uid":"def", "name":"tidb-cluster", "type":"prometheus"}
+}}`
+
+const datasourceDashJSON = `
+{"Dashboard":
+	{
+		"Rows":
+			[{"Panels": [{"Type":"graph", "ID":1}], "Title": "Row $db", "Repeat": "db"},
+			{"Panels": [{"Type":"graph", "ID":2}], "Title": "Row $instance", "Repeat": "instance"}],
+		"Templating":
+			{"list": [
+				{"Name":"ds", "Type":"datasource", "Query":"prometheus", "Current":{"Text":"tidb-cluster", "Value":"tidb-cluster"}},
+				{"Name":"db", "Datasource":"tidb-cluster", "Query":"label_values(tikv_engine_size_bytes, db)"},
+				{"Name":"instance", "Datasource":"$ds", "Query":"label_values(up, instance)"}]},
+		"title":"DashTitle #"
+	}
+}`
+
+func TestTemplatingDatasources(t *testing.T) {
+	Convey("When a dashboard has templating variables of named datasources", t, func() {
+		var (
+			datasourceRequests int
+			proxyPaths         []string
+		)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/datasources":
+				// only org admins can list data sources in Grafana 5 and 6
+				w.WriteHeader(http.StatusForbidden)
+			case "/api/frontend/settings":
+				datasourceRequests++
+				fmt.Fprint(w, frontendSettingsJSON)
+			case "/api/dashboards/uid/testDash":
+				fmt.Fprint(w, datasourceDashJSON)
+			default:
+				proxyPaths = append(proxyPaths, r.URL.Path)
+				fmt.Fprint(w, `{"status":"success", "data":[{"db":"kv", "instance":"tikv-1"}]}`)
+			}
+		}))
+		defer ts.Close()
+
+		grf := NewV5Client(ts.URL, Credentials{}, nil, TimeRange{From: "now-1h", To: "now"})
+		dash, err := grf.GetDashboard(context.Background(), "testDash")
+
+		Convey("Variables should be queried through the proxy of the named datasource", func() {
+			So(err, ShouldBeNil)
+			So(proxyPaths, ShouldResemble, []string{
+				"/api/datasources/proxy/3/api/v1/series",
+				"/api/datasources/proxy/3/api/v1/series",
+			})
+			So(dash.Panels, ShouldHaveLength, 2)
+			So(dash.Panels[0].RowTitle, ShouldEqual, "Row kv")
+			So(dash.Panels[1].RowTitle, ShouldEqual, "Row tikv-1")
+		})
+
+		Convey("Datasources should be requested only once for a client, without the org admin role", func() {
+			So(datasourceRequests, ShouldEqual, 1)
+		})
+
+		Convey("Datasources should be read from frontend settings without built-in datasources", func() {
+			g := grf.(client)
+			So(g.datasources.datasources, ShouldResemble, []Datasource{
+				{ID: 1, UID: "abc", Name: "other-cluster", Type: "prometheus", IsDefault: true},
+				{ID: 3, UID: "def", Name: "tidb-cluster", Type: "prometheus"},
+			})
+		})
+
+		Convey("The selected value of a datasource variable should be used", func() {
+			proxyPaths = nil
+			grf = NewV5Client(ts.URL, Credentials{}, url.Values{"var-ds": {"other-cluster"}}, TimeRange{From: "now-1h", To: "now"})
+			_, err = grf.GetDashboard(context.Background(), "testDash")
+			So(err, ShouldBeNil)
+			So(proxyPaths, ShouldResemble, []string{
+				"/api/datasources/proxy/3/api/v1/series",
+				"/api/datasources/proxy/1/api/v1/series",
+			})
+		})
+	})
+}
+
+func TestFindDatasource(t *testing.T) {
+	Convey("When looking up datasources", t, func() {
+		g := client{datasources: &datasourceCache{}}
+		json.Unmarshal([]byte(datasourcesJSON), &g.datasources.datasources)
+
+		Convey("Datasources should be found by name, uid or default", func() {
+			ds, err := g.findDatasource(DatasourceRef{Name: "tidb-cluster"})
+			So(err, ShouldBeNil)
+			So(ds.ID, ShouldEqual, 3)
+
+			ds, err = g.findDatasource(DatasourceRef{UID: "def", Type: "prometheus"})
+			So(err, ShouldBeNil)
+			So(ds.ID, ShouldEqual, 3)
+
+			ds, err = g.findDatasource(DatasourceRef{})
+			So(err, ShouldBeNil)
+			So(ds.ID, ShouldEqual, 1)
+		})
+
+		Convey("Unknown datasources should return error", func() {
+			_, err := g.findDatasource(DatasourceRef{Name: "unknown"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("When unmarshaling datasource references", t, func() {
+		var refs []DatasourceRef
+		err := json.Unmarshal([]byte(`["tidb-cluster", {"uid":"def", "type":"prometheus"}, null]`), &refs)
+
+		Convey("Both names and uid objects should be accepted", func() {
+			So(err, ShouldBeNil)
+			So(refs, ShouldResemble, []DatasourceRef{{Name: "tidb-cluster"}, {UID: "def", Type: "prometheus"}, {}})
+		})
+	})
+}
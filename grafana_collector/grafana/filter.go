@@ -0,0 +1,198 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+
+	"github.com/ngaut/log"
+	"github.com/pkg/errors"
+)
+
+// PanelFilter selects rows and panels of dashboard. Every value is a panel ID if it is a number, or a regex of
+// titles otherwise. If there are included rows or panels, only panels of the included rows and the included panels
+// are kept, and then panels of the excluded rows and the excluded panels are removed
+type PanelFilter struct {
+	Rows          []string
+	Panels        []string
+	ExcludeRows   []string
+	ExcludePanels []string
+}
+
+// IsEmpty ... checks if the filter keeps all panels
+func (f PanelFilter) IsEmpty() bool {
+	return len(f.Rows) == 0 && len(f.Panels) == 0 && len(f.ExcludeRows) == 0 && len(f.ExcludePanels) == 0
+}
+
+// Merge ... returns a filter with the values of both filters
+func (f PanelFilter) Merge(other PanelFilter) PanelFilter {
+	return PanelFilter{
+		Rows:          append(append([]string(nil), f.Rows...), other.Rows...),
+		Panels:        append(append([]string(nil), f.Panels...), other.Panels...),
+		ExcludeRows:   append(append([]string(nil), f.ExcludeRows...), other.ExcludeRows...),
+		ExcludePanels: append(append([]string(nil), f.ExcludePanels...), other.ExcludePanels...),
+	}
+}
+
+// Validate ... checks if all regexes of filter are valid
+func (f PanelFilter) Validate() error {
+	_, err := f.compile()
+	return errors.WithStack(err)
+}
+
+// selector matches rows or panels by IDs or title regexes
+type selector struct {
+	ids     map[int]bool
+	regexps []*regexp.Regexp
+}
+
+func newSelector(values []string) (selector, error) {
+	s := selector{ids: make(map[int]bool)}
+	for _, v := range values {
+		if id, err := strconv.Atoi(v); err == nil {
+			s.ids[id] = true
+			continue
+		}
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return s, errors.Errorf("compiling filter regex %s error: %v", v, err)
+		}
+		s.regexps = append(s.regexps, re)
+	}
+	return s, nil
+}
+
+func (s selector) isEmpty() bool {
+	return len(s.ids) == 0 && len(s.regexps) == 0
+}
+
+func (s selector) match(id int, title string) bool {
+	if s.ids[id] {
+		return true
+	}
+	for _, re := range s.regexps {
+		if re.MatchString(title) {
+			return true
+		}
+	}
+	return false
+}
+
+// compiledFilter is the selectors of a filter, in the order of Rows, Panels, ExcludeRows and ExcludePanels
+type compiledFilter [4]selector
+
+func (f PanelFilter) compile() (compiledFilter, error) {
+	var c compiledFilter
+	for i, values := range [][]string{f.Rows, f.Panels, f.ExcludeRows, f.ExcludePanels} {
+		s, err := newSelector(values)
+		if err != nil {
+			return c, errors.WithStack(err)
+		}
+		c[i] = s
+	}
+	return c, nil
+}
+
+// keep ... checks if panel p of row is kept. Repeated clones are matched by the ID of their source panel too
+func (c compiledFilter) keep(row Row, p Panel) bool {
+	rows, panels, excludeRows, excludePanels := c[0], c[1], c[2], c[3]
+	matchPanel := func(s selector) bool {
+		return s.match(p.ID, p.Title) || (p.RepeatPanelID != 0 && s.match(p.RepeatPanelID, p.Title))
+	}
+
+	included := rows.isEmpty() && panels.isEmpty()
+	if !rows.isEmpty() && rows.match(row.ID, row.Title) {
+		included = true
+	}
+	if !panels.isEmpty() && matchPanel(panels) {
+		included = true
+	}
+	return included && !excludeRows.match(row.ID, row.Title) && !matchPanel(excludePanels)
+}
+
+// ApplyFilter ... removes rows and panels which are not selected by filter, it is called after rows and panels are
+// repeated so that repeated rows and panels can be selected by their titles. Rows without panels are removed. Row IDs
+// fail the filter if no row of dashboard has an ID, like the rows of Grafana v4 dashboards
+func (d *Dashboard) ApplyFilter(f PanelFilter) error {
+	if f.IsEmpty() {
+		return nil
+	}
+	c, err := f.compile()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// rows of Grafana v4 dashboards have no IDs, they can only be selected by titles
+	if len(c[0].ids) > 0 || len(c[2].ids) > 0 {
+		hasRowIDs := false
+		for _, row := range d.Rows {
+			if row.ID != 0 {
+				hasRowIDs = true
+				break
+			}
+		}
+		if !hasRowIDs {
+			return errors.Errorf("rows of dashboard %s have no IDs, select them by title regexes instead", d.Title)
+		}
+	}
+
+	kept := make(map[int]bool)
+	rows := make([]Row, 0, len(d.Rows))
+	for _, row := range d.Rows {
+		panels := make([]Panel, 0, len(row.Panels))
+		for _, p := range row.Panels {
+			if c.keep(row, p) {
+				panels = append(panels, p)
+				kept[p.ID] = true
+			}
+		}
+		if len(panels) > 0 {
+			row.Panels = panels
+			rows = append(rows, row)
+		}
+	}
+
+	panels := make([]Panel, 0, len(kept))
+	for _, p := range d.Panels {
+		if kept[p.ID] {
+			panels = append(panels, p)
+		}
+	}
+	if len(panels) == 0 {
+		log.Warnf("no panel of dashboard %s is selected by filter %+v", d.Title, f)
+	}
+	d.Rows, d.Panels, d.Filter = rows, panels, f
+	return nil
+}
+
+// filteringClient removes rows and panels which are not selected by filter from dashboards
+type filteringClient struct {
+	Client
+	filter PanelFilter
+}
+
+// NewFilteringClient ... creates a client which applies filter to the dashboards of client g
+func NewFilteringClient(g Client, filter PanelFilter) Client {
+	return filteringClient{Client: g, filter: filter}
+}
+
+func (c filteringClient) GetDashboard(ctx context.Context, dashName string) (Dashboard, error) {
+	dash, err := c.Client.GetDashboard(ctx, dashName)
+	if err != nil {
+		return dash, errors.WithStack(err)
+	}
+	err = dash.ApplyFilter(c.filter)
+	return dash, errors.Wrapf(err, "filter dashboard %s", dashName)
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestApplyFilter(t *testing.T) {
+	Convey("When filtering panels of a dashboard with repeats", t, func() {
+		const v5DashJSON = `
+{"Dashboard":
+	{
+		"Panels":
+			[{"Type":"singlestat", "ID":1, "Title":"Uptime"},
+			{"Type":"row", "ID":2, "Title":"Instance $instance", "Repeat":"instance"},
+			{"Type":"graph", "ID":3, "Title":"CPU of $instance", "GridPos":{"X":0, "Y":1, "W":24, "H":8}},
+			{"Type":"row", "ID":4, "Title":"RocksDB"},
+			{"Type":"graph", "ID":5, "Title":"Write Stall", "GridPos":{"X":0, "Y":10, "W":12, "H":8}},
+			{"Type":"graph", "ID":6, "Title":"Size of $db", "Repeat":"db", "GridPos":{"X":12, "Y":10, "W":12, "H":8}}],
+		"Templating":
+			{"list": [{"Name":"instance", "Query":"label_values(up, instance)"},
+				{"Name":"db", "Query":"label_values(tikv_engine_size_bytes, db)"}]},
+		"Title":"DashTitle #"
+	}
+}`
+		variables := url.Values{"var-instance": {"a", "b"}, "var-db": {"kv", "raft"}}
+		newDashboard := func() Dashboard {
+			dash, err := NewDashboard([]byte(v5DashJSON), "", Credentials{}, variables, TimeRange{From: "now-1h", To: "now"})
+			So(err, ShouldBeNil)
+			So(dash.Panels, ShouldHaveLength, 6)
+			return dash
+		}
+		titles := func(dash Dashboard) []string {
+			var titles []string
+			for _, p := range dash.Panels {
+				titles = append(titles, p.Title)
+			}
+			return titles
+		}
+
+		Convey("Rows should be selected by title regexes after they are repeated", func() {
+			dash := newDashboard()
+			So(dash.ApplyFilter(PanelFilter{Rows: []string{"Instance b$"}}), ShouldBeNil)
+			So(titles(dash), ShouldResemble, []string{"CPU of b"})
+			So(dash.Rows, ShouldHaveLength, 1)
+			So(dash.Filter.Rows, ShouldResemble, []string{"Instance b$"})
+		})
+
+		Convey("Repeated rows and panels should be selected by the IDs of their source", func() {
+			dash := newDashboard()
+			So(dash.ApplyFilter(PanelFilter{Rows: []string{"2"}, Panels: []string{"6"}}), ShouldBeNil)
+			So(titles(dash), ShouldResemble, []string{"CPU of a", "CPU of b", "Size of kv", "Size of raft"})
+			So(dash.Rows, ShouldHaveLength, 3)
+			So(dash.Rows[2].Panels, ShouldHaveLength, 2)
+		})
+
+		Convey("Excluded rows and panels should be removed from the selected ones", func() {
+			dash := newDashboard()
+			So(dash.ApplyFilter(PanelFilter{ExcludeRows: []string{"^Instance"}, ExcludePanels: []string{"raft", "1"}}), ShouldBeNil)
+			So(titles(dash), ShouldResemble, []string{"Write Stall", "Size of kv"})
+		})
+
+		Convey("An empty filter should keep all panels", func() {
+			dash := newDashboard()
+			So(dash.ApplyFilter(PanelFilter{}), ShouldBeNil)
+			So(dash.Panels, ShouldHaveLength, 6)
+		})
+
+		Convey("An invalid regex should be an error", func() {
+			dash := newDashboard()
+			So(PanelFilter{Panels: []string{"("}}.Validate(), ShouldNotBeNil)
+			So(dash.ApplyFilter(PanelFilter{Panels: []string{"("}}), ShouldNotBeNil)
+			So(dash.Panels, ShouldHaveLength, 6)
+		})
+	})
+}
+
+func TestApplyFilterV4(t *testing.T) {
+	Convey("When filtering panels of a Grafana v4 dashboard, whose rows have no IDs", t, func() {
+		const v4DashJSON = `
+{"Dashboard":
+	{
+		"Rows":
+			[{"Panels": [{"Type":"graph", "ID":1, "Title":"QPS"}], "Title": "TiDB"},
+			{"Panels": [{"Type":"graph", "ID":2, "Title":"Write Stall"}, {"Type":"graph", "ID":3, "Title":"Size"}], "Title": "RocksDB"}],
+		"Title":"DashTitle #"
+	}
+}`
+		dash, err := NewDashboard([]byte(v4DashJSON), "", Credentials{}, url.Values{}, TimeRange{From: "now-1h", To: "now"})
+		So(err, ShouldBeNil)
+
+		Convey("Rows should be selected by title regexes", func() {
+			So(dash.ApplyFilter(PanelFilter{Rows: []string{"^RocksDB$"}, ExcludePanels: []string{"3"}}), ShouldBeNil)
+			So(dash.Panels, ShouldHaveLength, 1)
+			So(dash.Panels[0].Title, ShouldEqual, "Write Stall")
+		})
+
+		Convey("Row IDs should be an error instead of selecting nothing", func() {
+			err := dash.ApplyFilter(PanelFilter{Rows: []string{"1"}})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "select them by title regexes")
+			So(dash.ApplyFilter(PanelFilter{ExcludeRows: []string{"2"}}), ShouldNotBeNil)
+			So(dash.Panels, ShouldHaveLength, 3)
+		})
+	})
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// RenderLimiter limits the number of concurrent panel renders of all reports in the process, renders over the limit
+// wait in a queue for a free slot
+type RenderLimiter struct {
+	slots     chan struct{}
+	maxQueued int
+
+	mu     sync.Mutex
+	queued int
+}
+
+// RenderStats are the counters of render limiter
+type RenderStats struct {
+	Running   int `json:"running"`
+	Queued    int `json:"queued"`
+	Limit     int `json:"limit"`
+	MaxQueued int `json:"max_queued"`
+}
+
+// NewRenderLimiter ... creates a limiter of maxRenders concurrent renders, it is full when maxQueued renders are
+// waiting. maxQueued 0 means the queue is never full
+func NewRenderLimiter(maxRenders, maxQueued int) *RenderLimiter {
+	if maxRenders <= 0 {
+		maxRenders = 1
+	}
+	return &RenderLimiter{slots: make(chan struct{}, maxRenders), maxQueued: maxQueued}
+}
+
+// Acquire ... waits for a free render slot, it fails if ctx is done before. Release the slot after rendering
+func (l *RenderLimiter) Acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	l.mu.Lock()
+	l.queued++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}()
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "wait for render slot")
+	}
+}
+
+// Release ... frees a render slot acquired by Acquire
+func (l *RenderLimiter) Release() {
+	<-l.slots
+}
+
+// Full ... checks if the queue of renders is full, new reports should be rejected until renders finish
+func (l *RenderLimiter) Full() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.maxQueued > 0 && l.queued >= l.maxQueued
+}
+
+// Stats ... returns the number of running and queued renders
+func (l *RenderLimiter) Stats() RenderStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return RenderStats{Running: len(l.slots), Queued: l.queued, Limit: cap(l.slots), MaxQueued: l.maxQueued}
+}
+
+// limitingClient renders panels of client only when the render limiter has a free slot
+type limitingClient struct {
+	Client
+	limiter *RenderLimiter
+}
+
+// NewLimitingClient ... creates a client whose panel renders are limited by limiter together with all other clients
+// of the limiter
+func NewLimitingClient(g Client, limiter *RenderLimiter) Client {
+	return limitingClient{Client: g, limiter: limiter}
+}
+
+func (c limitingClient) GetPanelPng(ctx context.Context, p Panel, dashName string, t TimeRange) (io.ReadCloser, error) {
+	if err := c.limiter.Acquire(ctx); err != nil {
+		return nil, errors.Wrapf(err, "render panel %d", p.ID)
+	}
+	body, err := c.Client.GetPanelPng(ctx, p, dashName, t)
+	if err != nil {
+		c.limiter.Release()
+		return nil, errors.WithStack(err)
+	}
+	// the image is still being downloaded from the renderer until the body is closed
+	return &releasingBody{ReadCloser: body, release: c.limiter.Release}, nil
+}
+
+// releasingBody releases the render slot when the image body is closed
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
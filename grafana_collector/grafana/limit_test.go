@@ -0,0 +1,55 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRenderLimiter(t *testing.T) {
+	Convey("When renders are limited to one slot and one queued render", t, func() {
+		limiter := NewRenderLimiter(1, 1)
+		So(limiter.Acquire(context.Background()), ShouldBeNil)
+
+		Convey("Renders over the limit should wait in the queue until it is full", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			acquired := make(chan error)
+			go func() { acquired <- limiter.Acquire(ctx) }()
+			for i := 0; i < 100 && !limiter.Full(); i++ {
+				time.Sleep(time.Millisecond)
+			}
+			So(limiter.Full(), ShouldBeTrue)
+			So(limiter.Stats(), ShouldResemble, RenderStats{Running: 1, Queued: 1, Limit: 1, MaxQueued: 1})
+
+			cancel()
+			So(<-acquired, ShouldNotBeNil)
+			So(limiter.Full(), ShouldBeFalse)
+		})
+
+		Convey("Limited clients should release the slot when the image is closed", func() {
+			limiter.Release()
+			g := NewLimitingClient(&countingClient{}, limiter)
+			image, err := g.GetPanelPng(context.Background(), Panel{ID: 1}, "testDash", TimeRange{From: "now-1h", To: "now"})
+			So(err, ShouldBeNil)
+			So(limiter.Stats().Running, ShouldEqual, 1)
+			image.Close()
+			image.Close()
+			So(limiter.Stats().Running, ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// namespace is the namespace of grafana_collector metrics
+const namespace = "grafana_collector"
+
+// statuses of panel render failures besides HTTP status codes of Grafana
+const (
+	renderStatusError     = "error"
+	renderStatusCancelled = "cancelled"
+)
+
+var (
+	panelRenderDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "panel",
+			Name:      "render_duration_seconds",
+			Help:      "Bucketed histogram of the time of rendering panel images, including retries.",
+			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+		}, []string{"renderer"})
+
+	panelRenderFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "panel",
+			Name:      "render_failures_total",
+			Help:      "Counter of panel images which failed to render, by the status code of Grafana, error or cancelled.",
+		}, []string{"renderer", "status"})
+
+	panelRenderRetries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "panel",
+			Name:      "render_retries_total",
+			Help:      "Counter of retried panel render requests to Grafana.",
+		})
+)
+
+func init() {
+	prometheus.MustRegister(panelRenderDuration)
+	prometheus.MustRegister(panelRenderFailures)
+	prometheus.MustRegister(panelRenderRetries)
+}
+
+// observeRender ... records the time since start of rendering a panel by renderer, status is empty for succeeded
+// renders
+func observeRender(renderer string, start time.Time, status string) {
+	panelRenderDuration.WithLabelValues(renderer).Observe(time.Since(start).Seconds())
+	if status != "" {
+		panelRenderFailures.WithLabelValues(renderer, status).Inc()
+	}
+}
+
+// renderStatus ... returns the status of a render which failed without response of Grafana
+func renderStatus(ctx context.Context) string {
+	if ctx.Err() != nil {
+		return renderStatusCancelled
+	}
+	return renderStatusError
+}
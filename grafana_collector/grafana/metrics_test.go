@@ -0,0 +1,76 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// counterValue returns the current value of counter c
+func counterValue(c prometheus.Counter) float64 {
+	var m dto.Metric
+	c.Write(&m)
+	return m.GetCounter().GetValue()
+}
+
+func TestRenderMetrics(t *testing.T) {
+	Convey("When panels are rendered", t, func() {
+		retryInterval := cfg.Grafana.RetryInterval
+		cfg.Grafana.RetryInterval = 0
+		defer func() { cfg.Grafana.RetryInterval = retryInterval }()
+		status := http.StatusOK
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		defer ts.Close()
+		g := NewV5Client(ts.URL, Credentials{}, nil, TimeRange{From: "now-1h", To: "now"})
+		panel := Panel{ID: 44, Type: "graph"}
+
+		Convey("Failed renders should be counted by status code with their retries", func() {
+			status = http.StatusServiceUnavailable
+			failures := panelRenderFailures.WithLabelValues(GrafanaRenderer, "503")
+			before, retriesBefore := counterValue(failures), counterValue(panelRenderRetries)
+			_, err := g.GetPanelPng(context.Background(), panel, "testDash", TimeRange{From: "now-1h", To: "now"})
+			So(err, ShouldNotBeNil)
+			So(counterValue(failures)-before, ShouldEqual, 1)
+			So(counterValue(panelRenderRetries)-retriesBefore, ShouldEqual, 2)
+		})
+
+		Convey("Cancelled renders should be counted as cancelled", func() {
+			failures := panelRenderFailures.WithLabelValues(GrafanaRenderer, renderStatusCancelled)
+			before := counterValue(failures)
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := g.GetPanelPng(ctx, panel, "testDash", TimeRange{From: "now-1h", To: "now"})
+			So(err, ShouldNotBeNil)
+			So(counterValue(failures)-before, ShouldEqual, 1)
+		})
+
+		Convey("Succeeded renders should not be failures", func() {
+			failures := panelRenderFailures.WithLabelValues(GrafanaRenderer, renderStatusError)
+			before := counterValue(failures)
+			img, err := g.GetPanelPng(context.Background(), panel, "testDash", TimeRange{From: "now-1h", To: "now"})
+			So(err, ShouldBeNil)
+			img.Close()
+			So(counterValue(failures), ShouldEqual, before)
+		})
+	})
+}
@@ -0,0 +1,319 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/chart"
+	"github.com/pkg/errors"
+)
+
+const (
+	// GrafanaRenderer is the renderer which requests panel images of Grafana image renderer
+	GrafanaRenderer = "grafana"
+	// NativeRenderer is the renderer which draws panels from Prometheus queries in Go, without Grafana image renderer
+	NativeRenderer = "native"
+)
+
+// GraphThreshold represents a threshold of Grafana v4 and v5 graph panel, or a value of singlestat thresholds
+type GraphThreshold struct {
+	Value     float64
+	ColorMode string // critical, warning, ok or custom
+	LineColor string // color of custom thresholds
+}
+
+// Thresholds represents the thresholds of graph panel, or the comma separated thresholds of singlestat panel
+type Thresholds []GraphThreshold
+
+// UnmarshalJSON ... parses thresholds of graph panels, and the thresholds of singlestat panels, e.g. "80,90"
+func (t *Thresholds) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = nil
+		for _, v := range strings.Split(s, ",") {
+			if strings.TrimSpace(v) == "" {
+				continue
+			}
+			value, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return errors.Errorf("threshold %s is not a number", v)
+			}
+			*t = append(*t, GraphThreshold{Value: value})
+		}
+		return nil
+	}
+	var thresholds []GraphThreshold
+	if err := json.Unmarshal(b, &thresholds); err != nil {
+		return errors.Errorf("unmarshaling thresholds %s error: %v", b, err)
+	}
+	*t = thresholds
+	return nil
+}
+
+// graphThresholdColors are the colors of graph thresholds by their color modes
+var graphThresholdColors = map[string]string{
+	"critical": "rgba(245, 54, 54, 0.9)",
+	"warning":  "rgba(237, 129, 40, 0.89)",
+	"ok":       "rgba(50, 172, 45, 0.97)",
+}
+
+// nativeClient renders panel images by drawing the series of panel queries, dashboards are requested from Grafana
+type nativeClient struct {
+	client
+}
+
+// NewNativeClient ... creates a client which draws panel images itself with the dashboards of Grafana client g. The
+// queries of panels are sent to prometheusURL directly, or through the Grafana data source proxy if it is empty.
+// g must be created by NewV4Client or NewV5Client
+func NewNativeClient(g Client, prometheusURL string) (Client, error) {
+	c, ok := g.(client)
+	if !ok {
+		return nil, errors.Errorf("native renderer needs a Grafana API client instead of %T", g)
+	}
+	c.prometheusURL = strings.TrimSuffix(prometheusURL, "/")
+	c.native = true
+	return nativeClient{client: c}, nil
+}
+
+func (g nativeClient) GetDashboard(ctx context.Context, dashName string) (Dashboard, error) {
+	dash, err := g.client.GetDashboard(ctx, dashName)
+	// cached images of other renderers are not used for native ones
+	dash.Renderer = NativeRenderer
+	return dash, errors.WithStack(err)
+}
+
+func (g nativeClient) GetPanelPng(ctx context.Context, p Panel, dashName string, t TimeRange) (img io.ReadCloser, err error) {
+	start := time.Now()
+	defer func() {
+		status := ""
+		if err != nil {
+			status = renderStatus(ctx)
+		}
+		observeRender(NativeRenderer, start, status)
+	}()
+	g.ctx = ctx
+	kind, err := chartKind(p)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	from, err := t.FromTime()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	to, err := t.ToTime()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	width, height := p.PixelSize()
+	log.Infof("drawing panel %d of dashboard %s natively", p.ID, dashName)
+
+	series, err := g.queryTargets(p, t, chartStep(int64(to.Sub(from).Seconds()), width))
+	if err != nil {
+		return nil, errors.Wrapf(err, "query panel %d", p.ID)
+	}
+	if kind == chart.Stat {
+		series = reduceSeries(series, reducer(p))
+	}
+	theme := chart.DarkTheme
+	if cfg.Grafana.Theme == "light" {
+		theme = chart.LightTheme
+	}
+	unit := p.Unit()
+	c := chart.Chart{
+		Kind:       kind,
+		Title:      p.Title,
+		Width:      width,
+		Height:     height,
+		Series:     series,
+		From:       from,
+		To:         to,
+		Location:   t.location(),
+		Format:     func(v float64) string { return FormatValue(v, unit) },
+		Thresholds: chartThresholds(p),
+		Legend:     showLegend(p),
+		Theme:      theme,
+	}
+	var buf bytes.Buffer
+	if err = c.WritePNG(&buf); err != nil {
+		return nil, errors.Wrapf(err, "draw panel %d", p.ID)
+	}
+	return ioutil.NopCloser(&buf), nil
+}
+
+// prometheusGet ... requests path of Prometheus directly, the credentials of Grafana are not sent to Prometheus
+func (g client) prometheusGet(path string, params url.Values) ([]byte, error) {
+	reqURL := g.prometheusURL + path + "?" + params.Encode()
+	log.Infof("request prometheus at %s", g.redact(reqURL))
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, g.errorf("creating request for %s error: %v", reqURL, err)
+	}
+	if g.ctx != nil {
+		req = req.WithContext(g.ctx)
+	}
+	return g.do(req)
+}
+
+// chartKind ... returns the kind of chart which panel p is drawn as, panels of other types are not supported
+func chartKind(p Panel) (chart.Kind, error) {
+	switch p.Kind() {
+	case GraphPanel:
+		if p.Fill > 0 {
+			return chart.Area, nil
+		}
+		return chart.Line, nil
+	case TimeseriesPanel:
+		if p.FieldConfig.Defaults.Custom.FillOpacity > 0 {
+			return chart.Area, nil
+		}
+		return chart.Line, nil
+	case SinglestatPanel, StatPanel, GaugePanel, BarGaugePanel:
+		return chart.Stat, nil
+	}
+	return 0, errors.Errorf("%s panel %d is not supported by the native renderer", p.Kind(), p.ID)
+}
+
+// chartStep ... returns the step in seconds of range queries over seconds for a chart of width pixels, so that
+// there is a point every 2 pixels
+func chartStep(seconds int64, width int) int64 {
+	points := int64(width / 2)
+	if points <= 0 {
+		points = 1
+	}
+	step := (seconds + points - 1) / points
+	if step < 1 {
+		return 1
+	}
+	return step
+}
+
+// chartThresholds ... returns the thresholds of panel in ascending order. Grafana v7+ thresholds are steps, the
+// thresholds of singlestat panels are colored by colors, and graph thresholds by their color modes
+func chartThresholds(p Panel) []chart.Threshold {
+	var thresholds []chart.Threshold
+	add := func(value float64, colorName string) {
+		if c, ok := chart.ParseColor(colorName); ok {
+			thresholds = append(thresholds, chart.Threshold{Value: value, Color: c})
+		} else if colorName != "" {
+			log.Warnf("unknown threshold color %s of panel %d", colorName, p.ID)
+		}
+	}
+
+	switch {
+	case len(p.FieldConfig.Defaults.Thresholds.Steps) > 0:
+		for _, step := range p.FieldConfig.Defaults.Thresholds.Steps {
+			value := math.Inf(-1)
+			if step.Value != nil {
+				value = *step.Value
+			}
+			add(value, step.Color)
+		}
+	case p.Kind() == SinglestatPanel:
+		// colors are the colors of values below the first threshold, between thresholds and above the last one
+		for i, color := range p.Colors {
+			value := math.Inf(-1)
+			if i > 0 {
+				if i > len(p.Thresholds) {
+					break
+				}
+				value = p.Thresholds[i-1].Value
+			}
+			add(value, color)
+		}
+	default:
+		for _, t := range p.Thresholds {
+			color := graphThresholdColors[t.ColorMode]
+			if t.ColorMode == "custom" {
+				color = t.LineColor
+			}
+			add(t.Value, color)
+		}
+	}
+	sort.SliceStable(thresholds, func(i, j int) bool { return thresholds[i].Value < thresholds[j].Value })
+	return thresholds
+}
+
+// showLegend ... checks if the legend of panel is shown, it is shown unless it is hidden in panel JSON
+func showLegend(p Panel) bool {
+	if p.Legend != nil && !p.Legend.Show {
+		return false
+	}
+	legend := p.Options.Legend
+	return legend.DisplayMode != "hidden" && (legend.ShowLegend == nil || *legend.ShowLegend)
+}
+
+// reducer ... returns how stat panel reduces series to values, Grafana reduces singlestat series to their average
+// and stat series to their last values by default
+func reducer(p Panel) string {
+	if len(p.Options.ReduceOptions.Calcs) > 0 {
+		return p.Options.ReduceOptions.Calcs[0]
+	}
+	if p.ValueName != "" {
+		return p.ValueName
+	}
+	if p.Kind() == SinglestatPanel {
+		return "avg"
+	}
+	return "lastNotNull"
+}
+
+// reduceSeries ... reduces every series to a point of its last time and the value of calc, which is a reducer of
+// Grafana, e.g. avg, mean, min, max, total, sum, first, current or lastNotNull
+func reduceSeries(series []chart.Series, calc string) []chart.Series {
+	reduced := make([]chart.Series, 0, len(series))
+	for _, s := range series {
+		if len(s.Points) == 0 {
+			continue
+		}
+		last := s.Points[len(s.Points)-1]
+		var value float64
+		switch calc {
+		case "avg", "mean", "total", "sum":
+			for _, p := range s.Points {
+				value += p.Value
+			}
+			if calc == "avg" || calc == "mean" {
+				value /= float64(len(s.Points))
+			}
+		case "min", "max":
+			value = s.Points[0].Value
+			for _, p := range s.Points {
+				if calc == "min" {
+					value = math.Min(value, p.Value)
+				} else {
+					value = math.Max(value, p.Value)
+				}
+			}
+		case "first", "firstNotNull":
+			value = s.Points[0].Value
+		default:
+			value = last.Value
+		}
+		reduced = append(reduced, chart.Series{Name: s.Name, Points: []chart.Point{{Time: last.Time, Value: value}}})
+	}
+	return reduced
+}
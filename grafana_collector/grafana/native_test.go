@@ -0,0 +1,190 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/chart"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const nativeQueryResult = `{"status":"success", "data":{"resultType":"matrix", "result":[
+	{"metric":{"type":"select"}, "values":[[1543886699, "1"], [1543888499, "3"], [1543890299, "2"]]}]}}`
+
+func TestNativeClient(t *testing.T) {
+	Convey("When drawing panels natively", t, func() {
+		var grafanaPaths, prometheusPaths, prometheusQueries []string
+		grafanaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			grafanaPaths = append(grafanaPaths, r.URL.Path)
+			switch r.URL.Path {
+			case "/api/dashboards/uid/Summary":
+				fmt.Fprint(w, summaryDashJSON)
+			case "/api/frontend/settings":
+				fmt.Fprint(w, summaryFrontendSettingsJSON)
+			case "/api/datasources/proxy/3/api/v1/query_range":
+				fmt.Fprint(w, nativeQueryResult)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer grafanaServer.Close()
+		prometheusServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			prometheusPaths = append(prometheusPaths, r.URL.Path)
+			prometheusQueries = append(prometheusQueries, r.URL.Query().Get("query"))
+			fmt.Fprint(w, nativeQueryResult)
+		}))
+		defer prometheusServer.Close()
+
+		timeRange := TimeRange{From: "1543886699000", To: "1543890299000"}
+		g := NewV5Client(grafanaServer.URL, Credentials{Token: "secret"}, url.Values{}, timeRange)
+		dash, err := newDashboard([]byte(summaryDashJSON), g.(client))
+		So(err, ShouldBeNil)
+		panel := dash.Panels[0]
+
+		Convey("Queries should be sent through the data source proxy of Grafana without Prometheus address", func() {
+			native, err := NewNativeClient(g, "")
+			So(err, ShouldBeNil)
+			img, err := native.GetPanelPng(context.Background(), panel, "Summary", timeRange)
+			So(err, ShouldBeNil)
+			defer img.Close()
+			decoded, err := png.Decode(img)
+			So(err, ShouldBeNil)
+			width, height := panel.PixelSize()
+			So(decoded.Bounds().Dx(), ShouldEqual, width)
+			So(decoded.Bounds().Dy(), ShouldEqual, height)
+			So(grafanaPaths, ShouldContain, "/api/datasources/proxy/3/api/v1/query_range")
+			So(prometheusPaths, ShouldBeEmpty)
+		})
+
+		Convey("Queries should be sent to Prometheus directly with Prometheus address", func() {
+			native, err := NewNativeClient(g, prometheusServer.URL+"/")
+			So(err, ShouldBeNil)
+			img, err := native.GetPanelPng(context.Background(), panel, "Summary", timeRange)
+			So(err, ShouldBeNil)
+			img.Close()
+			So(prometheusPaths, ShouldNotBeEmpty)
+			So(prometheusPaths[0], ShouldEqual, "/api/v1/query_range")
+			So(grafanaPaths, ShouldBeEmpty)
+		})
+
+		Convey("Variables in targets should be replaced even if summary tables are disabled", func() {
+			enable := cfg.Summary.Enable
+			cfg.Summary.Enable = false
+			defer func() { cfg.Summary.Enable = enable }()
+			native, err := NewNativeClient(g, prometheusServer.URL)
+			So(err, ShouldBeNil)
+			dash, err := native.GetDashboard(context.Background(), "Summary")
+			So(err, ShouldBeNil)
+			img, err := native.GetPanelPng(context.Background(), dash.Panels[0], "Summary", timeRange)
+			So(err, ShouldBeNil)
+			img.Close()
+			So(prometheusQueries, ShouldNotBeEmpty)
+			So(prometheusQueries[0], ShouldStartWith, `sum(rate(tidb_qps{instance=~"tidb-1"}[`)
+			So(prometheusQueries[0], ShouldNotContainSubstring, "$")
+		})
+
+		Convey("Panels which can't be drawn as charts should fail", func() {
+			native, err := NewNativeClient(g, prometheusServer.URL)
+			So(err, ShouldBeNil)
+			_, err = native.GetPanelPng(context.Background(), Panel{ID: 7, Type: HeatmapPanel}, "Summary", timeRange)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not supported by the native renderer")
+		})
+
+		Convey("Clients which don't talk to Grafana API can't render natively", func() {
+			_, err := NewNativeClient(NewFilteringClient(g, PanelFilter{}), "")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestChartKind(t *testing.T) {
+	Convey("When choosing charts of panels", t, func() {
+		kind, err := chartKind(Panel{Type: GraphPanel})
+		So(err, ShouldBeNil)
+		So(kind, ShouldEqual, chart.Line)
+		kind, err = chartKind(Panel{Type: GraphPanel, Fill: 1})
+		So(err, ShouldBeNil)
+		So(kind, ShouldEqual, chart.Area)
+		kind, err = chartKind(Panel{Type: SinglestatPanel})
+		So(err, ShouldBeNil)
+		So(kind, ShouldEqual, chart.Stat)
+		_, err = chartKind(Panel{Type: TablePanel})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestChartThresholds(t *testing.T) {
+	Convey("When reading thresholds of panels", t, func() {
+		Convey("Thresholds of singlestat panels should be a comma separated string colored by colors", func() {
+			var p Panel
+			So(json.Unmarshal([]byte(`{"type":"singlestat", "thresholds":"80,90", "colors":["green", "#ff9830", "rgba(242, 73, 92, 0.9)"]}`), &p), ShouldBeNil)
+			thresholds := chartThresholds(p)
+			So(thresholds, ShouldHaveLength, 3)
+			So(math.IsInf(thresholds[0].Value, -1), ShouldBeTrue)
+			So(thresholds[1].Value, ShouldEqual, 80)
+			So(thresholds[2].Value, ShouldEqual, 90)
+			red, _ := chart.ParseColor("rgb(242, 73, 92)")
+			So(thresholds[2].Color, ShouldResemble, red)
+		})
+
+		Convey("Thresholds of graph panels should be colored by their color modes", func() {
+			var p Panel
+			So(json.Unmarshal([]byte(`{"type":"graph", "thresholds":[
+				{"value":90, "colorMode":"critical"}, {"value":50, "colorMode":"custom", "lineColor":"#00ff00"}]}`), &p), ShouldBeNil)
+			thresholds := chartThresholds(p)
+			So(thresholds, ShouldHaveLength, 2)
+			So(thresholds[0].Value, ShouldEqual, 50)
+			green, _ := chart.ParseColor("#00ff00")
+			So(thresholds[0].Color, ShouldResemble, green)
+			So(thresholds[1].Value, ShouldEqual, 90)
+		})
+
+		Convey("Steps of Grafana v7+ panels should start from the base step", func() {
+			var p Panel
+			So(json.Unmarshal([]byte(`{"type":"stat", "fieldConfig":{"defaults":{"thresholds":{"steps":[
+				{"color":"green", "value":null}, {"color":"red", "value":80}]}}}}`), &p), ShouldBeNil)
+			thresholds := chartThresholds(p)
+			So(thresholds, ShouldHaveLength, 2)
+			So(math.IsInf(thresholds[0].Value, -1), ShouldBeTrue)
+			So(thresholds[1].Value, ShouldEqual, 80)
+		})
+
+		Convey("Malformed thresholds should fail", func() {
+			var p Panel
+			So(json.Unmarshal([]byte(`{"thresholds":"80,high"}`), &p), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestReduceSeries(t *testing.T) {
+	Convey("When reducing series of stat panels", t, func() {
+		series := []chart.Series{{Name: "a", Points: []chart.Point{{Value: 1}, {Value: 4}, {Value: 2}}}, {Name: "empty"}}
+		So(reduceSeries(series, "avg")[0].Points[0].Value, ShouldEqual, 7.0/3)
+		So(reduceSeries(series, "max")[0].Points[0].Value, ShouldEqual, 4)
+		So(reduceSeries(series, "lastNotNull")[0].Points[0].Value, ShouldEqual, 2)
+		So(reduceSeries(series, "sum"), ShouldHaveLength, 1)
+		So(reducer(Panel{Type: SinglestatPanel}), ShouldEqual, "avg")
+		So(reducer(Panel{Type: StatPanel}), ShouldEqual, "lastNotNull")
+	})
+}
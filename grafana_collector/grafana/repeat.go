@@ -0,0 +1,259 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultMaxPerRow is the default max number of horizontally repeated panels per row
+	defaultMaxPerRow = 4
+)
+
+// groupV5Panels ... groups the flat panels of Grafana v5 dashboard into rows. Panels above the first
+// row panel belong to a row without title, and the nested panels of collapsed rows are expanded
+func groupV5Panels(panels []Panel) []Row {
+	var rows []Row
+	for _, p := range panels {
+		if p.Type == "row" {
+			row := Row{ID: p.ID, Showtitle: true, Title: p.Title, Repeat: p.Repeat}
+			if p.Collapsed {
+				row.Panels = append(row.Panels, p.Panels...)
+			}
+			rows = append(rows, row)
+			continue
+		}
+		if len(rows) == 0 {
+			rows = append(rows, Row{})
+		}
+		rows[len(rows)-1].Panels = append(rows[len(rows)-1].Panels, p)
+	}
+	return rows
+}
+
+// processV5 ... repeats Rows and Panels of Grafana v5 dashboard and handles Panel ID, ScopedVars and RowTitle,
+// see https://github.com/grafana/grafana/blob/v5.4.0/public/app/features/dashboard/dashboard_model.ts#L300
+func (d *Dashboard) processV5(rows []Row) error {
+	nextID := maxPanelID(rows) + 1
+
+	d.Rows = nil
+	for _, row := range rows {
+		repeated, err := d.repeatV5Row(row, &nextID)
+		if err != nil {
+			return errors.Wrapf(err, "repeat Row %s error", row.Title)
+		}
+
+		for _, r := range repeated {
+			r.Panels, err = d.repeatV5Panels(r, &nextID)
+			if err != nil {
+				return errors.Wrapf(err, "repeat Panels of Row %s error", r.Title)
+			}
+			for i := range r.Panels {
+				r.Panels[i].RowTitle = r.Title
+				r.Panels[i].Title = d.interpolate(r.Panels[i].Title, r.Panels[i].ScopedVars)
+			}
+			d.Rows = append(d.Rows, r)
+		}
+	}
+	return nil
+}
+
+// repeatV5Row ... clones row and its panels for every value of the repeat variable
+func (d *Dashboard) repeatV5Row(row Row, nextID *int) ([]Row, error) {
+	if row.Repeat == "" {
+		return []Row{row}, nil
+	}
+
+	values, exist, err := d.variableValues(row.Repeat)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !exist || len(values) == 0 {
+		return []Row{row}, nil
+	}
+
+	rows := make([]Row, 0, len(values))
+	for i, value := range values {
+		clone := row
+		clone.ScopedVars = withScopedVar(row.ScopedVars, row.Repeat, value)
+		clone.Title = d.interpolate(row.Title, clone.ScopedVars)
+		clone.Panels = make([]Panel, 0, len(row.Panels))
+		for _, p := range row.Panels {
+			if i > 0 {
+				p = clonePanel(p, nextID)
+			}
+			p.ScopedVars = withScopedVar(p.ScopedVars, row.Repeat, value)
+			clone.Panels = append(clone.Panels, p)
+		}
+		rows = append(rows, clone)
+	}
+	return rows, nil
+}
+
+// repeatV5Panels ... clones panels of row for every value of their repeat variable, and moves down the panels
+// below the repeated ones
+func (d *Dashboard) repeatV5Panels(row Row, nextID *int) ([]Panel, error) {
+	type shift struct {
+		belowY int
+		height int
+	}
+	var (
+		shifts []shift
+		panels = make([]Panel, 0, len(row.Panels))
+	)
+
+	for _, p := range row.Panels {
+		originY := p.GridPos.Y
+		for _, s := range shifts {
+			if originY >= s.belowY {
+				p.GridPos.Y += s.height
+			}
+		}
+		p.ScopedVars = mergeScopedVars(row.ScopedVars, p.ScopedVars)
+
+		if p.Repeat == "" {
+			panels = append(panels, p)
+			continue
+		}
+
+		values, exist, err := d.variableValues(p.Repeat)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if !exist || len(values) == 0 {
+			panels = append(panels, p)
+			continue
+		}
+
+		bottom := p.GridPos.Y + p.GridPos.H
+		for i, value := range values {
+			clone := p
+			if i > 0 {
+				clone = clonePanel(p, nextID)
+			}
+			clone.ScopedVars = withScopedVar(p.ScopedVars, p.Repeat, value)
+			clone.GridPos = repeatedGridPos(p, i, len(values))
+			if y := clone.GridPos.Y + clone.GridPos.H; y > bottom {
+				bottom = y
+			}
+			panels = append(panels, clone)
+		}
+		shifts = append(shifts, shift{originY + p.GridPos.H, bottom - (p.GridPos.Y + p.GridPos.H)})
+	}
+	return panels, nil
+}
+
+// repeatedGridPos ... returns the position of the index-th clone of a repeated panel
+func repeatedGridPos(p Panel, index int, count int) GridPos {
+	pos := p.GridPos
+	if p.RepeatDirection == "v" {
+		pos.Y += index * pos.H
+		return pos
+	}
+
+	// maxPerRow comes from dashboard JSON, it is kept within the grid so that clones are at least a column wide
+	maxPerRow := p.MaxPerRow
+	if maxPerRow <= 0 {
+		maxPerRow = defaultMaxPerRow
+	}
+	if maxPerRow > GridColumnCount {
+		maxPerRow = GridColumnCount
+	}
+	pos.W = GridColumnCount / count
+	if min := GridColumnCount / maxPerRow; pos.W < min {
+		pos.W = min
+	}
+	if pos.W < 1 {
+		pos.W = 1
+	}
+	perRow := GridColumnCount / pos.W
+	pos.X = (index % perRow) * pos.W
+	pos.Y += (index / perRow) * pos.H
+	return pos
+}
+
+// clonePanel ... returns a copy of panel with a new ID, the source panel ID is kept for rendering
+func clonePanel(p Panel, nextID *int) Panel {
+	if p.RepeatPanelID == 0 {
+		p.RepeatPanelID = p.ID
+	}
+	p.ID = *nextID
+	*nextID++
+	return p
+}
+
+func maxPanelID(rows []Row) int {
+	max := 0
+	for _, row := range rows {
+		if row.ID > max {
+			max = row.ID
+		}
+		for _, p := range row.Panels {
+			if p.ID > max {
+				max = p.ID
+			}
+		}
+	}
+	return max
+}
+
+// withScopedVar ... returns a copy of vars with variable name set to value
+func withScopedVar(vars map[string]ScopedVar, name string, value string) map[string]ScopedVar {
+	return mergeScopedVars(vars, map[string]ScopedVar{name: {Text: value, Value: value}})
+}
+
+// mergeScopedVars ... returns a new map with all variables, variables of the latter maps take precedence
+func mergeScopedVars(vars ...map[string]ScopedVar) map[string]ScopedVar {
+	merged := make(map[string]ScopedVar)
+	for _, m := range vars {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// interpolate ... replaces templating variables in s with scoped variables, and the selected values of the dashboard
+func (d *Dashboard) interpolate(s string, scopedVars map[string]ScopedVar) string {
+	vars := make(map[string]ScopedVar)
+	for k, v := range d.Variables {
+		if strings.HasPrefix(k, variablePrefix) && len(v) > 0 {
+			value := strings.Join(v, " + ")
+			vars[strings.TrimPrefix(k, variablePrefix)] = ScopedVar{Text: value, Value: value}
+		}
+	}
+	return interpolate(s, mergeScopedVars(vars, scopedVars))
+}
+
+// interpolate ... replaces templating variables in s with their text, unknown variables are kept
+func interpolate(s string, vars map[string]ScopedVar) string {
+	return variableRegexp.ReplaceAllStringFunc(s, func(matched string) string {
+		sub := variableRegexp.FindStringSubmatch(matched)
+		for _, name := range sub[1:] {
+			if name == "" {
+				continue
+			}
+			if v, ok := vars[name]; ok {
+				return v.Text
+			}
+		}
+		return matched
+	})
+}
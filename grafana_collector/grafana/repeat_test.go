@@ -0,0 +1,150 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestV5DashboardRows(t *testing.T) {
+	Convey("When creating a new dashboard from Grafana v5 dashboard JSON with rows", t, func() {
+		const v5DashJSON = `
+{"Dashboard":
+	{
+		"Panels":
+			[{"Type":"singlestat", "ID":1, "Title":"Uptime"},
+			{"Type":"row", "ID":2, "Title":"Cluster", "Collapsed":false},
+			{"Type":"graph", "ID":3, "Title":"QPS"},
+			{"Type":"row", "ID":4, "Title":"RocksDB", "Collapsed":true,
+				"Panels": [{"Type":"graph", "ID":5, "Title":"Write Stall"}]}],
+		"Title":"DashTitle #"
+	}
+}`
+		dash, err := NewDashboard([]byte(v5DashJSON), "", Credentials{}, nil, TimeRange{From: "now-1h", To: "now"})
+
+		Convey("Panels should be grouped by rows and panels of collapsed rows should be expanded", func() {
+			So(err, ShouldBeNil)
+			So(dash.Rows, ShouldHaveLength, 3)
+			So(dash.Rows[0].Showtitle, ShouldBeFalse)
+			So(dash.Panels, ShouldHaveLength, 3)
+			So(dash.Panels[0].RowTitle, ShouldBeEmpty)
+			So(dash.Panels[1].RowTitle, ShouldEqual, "Cluster")
+			So(dash.Panels[2].RowTitle, ShouldEqual, "RocksDB")
+			So(dash.Panels[2].ID, ShouldEqual, 5)
+		})
+	})
+}
+
+func TestV5DashboardRepeats(t *testing.T) {
+	Convey("When creating a new dashboard from Grafana v5 dashboard JSON with repeats", t, func() {
+		const v5DashJSON = `
+{"Dashboard":
+	{
+		"Panels":
+			[{"Type":"row", "ID":1, "Title":"Instance $instance", "Repeat":"instance"},
+			{"Type":"graph", "ID":2, "Title":"CPU of $instance", "GridPos":{"X":0, "Y":1, "W":24, "H":8}},
+			{"Type":"row", "ID":3, "Title":"Storage"},
+			{"Type":"graph", "ID":4, "Title":"Size of ${db}", "Repeat":"db", "GridPos":{"X":0, "Y":10, "W":24, "H":8}},
+			{"Type":"graph", "ID":5, "Title":"Below", "GridPos":{"X":0, "Y":18, "W":24, "H":8}},
+			{"Type":"graph", "ID":6, "Title":"[[db]] stacked", "Repeat":"db", "RepeatDirection":"v", "GridPos":{"X":0, "Y":26, "W":12, "H":4}}],
+		"Templating":
+			{"list": [{"Name":"instance", "Query":"label_values(up, instance)"},
+				{"Name":"db", "Query":"label_values(tikv_engine_size_bytes, db)"}]},
+		"Title":"DashTitle #"
+	}
+}`
+		variables := url.Values{"var-instance": {"a", "b"}, "var-db": {"kv", "raft", "lock", "write", "default"}}
+		dash, err := NewDashboard([]byte(v5DashJSON), "", Credentials{}, variables, TimeRange{From: "now-1h", To: "now"})
+		So(err, ShouldBeNil)
+
+		Convey("Repeated rows should be cloned for every selected value", func() {
+			So(dash.Rows, ShouldHaveLength, 3)
+			So(dash.Rows[0].Title, ShouldEqual, "Instance a")
+			So(dash.Rows[1].Title, ShouldEqual, "Instance b")
+
+			first, second := dash.Panels[0], dash.Panels[1]
+			So(first.ID, ShouldEqual, 2)
+			So(first.Title, ShouldEqual, "CPU of a")
+			So(first.RowTitle, ShouldEqual, "Instance a")
+			So(first.ScopedVars["instance"].Value, ShouldEqual, "a")
+			So(second.ID, ShouldNotEqual, 2)
+			So(second.Title, ShouldEqual, "CPU of b")
+			So(second.ScopedVars["instance"].Value, ShouldEqual, "b")
+			So(second.renderID(), ShouldEqual, 2)
+		})
+
+		Convey("Repeated panels should be cloned horizontally by default", func() {
+			panels := dash.Rows[2].Panels
+			So(panels, ShouldHaveLength, 11)
+			for i := 0; i < 5; i++ {
+				So(panels[i].RowTitle, ShouldEqual, "Storage")
+				So(panels[i].renderID(), ShouldEqual, 4)
+				So(panels[i].GridPos.W, ShouldEqual, 6)
+			}
+			So(panels[0].Title, ShouldEqual, "Size of kv")
+			So(panels[4].Title, ShouldEqual, "Size of default")
+			So(panels[3].GridPos, ShouldResemble, GridPos{X: 18, Y: 10, W: 6, H: 8})
+			So(panels[4].GridPos, ShouldResemble, GridPos{X: 0, Y: 18, W: 6, H: 8})
+
+			Convey("Panels below should be moved down", func() {
+				So(panels[5].Title, ShouldEqual, "Below")
+				So(panels[5].GridPos.Y, ShouldEqual, 26)
+			})
+
+			Convey("Vertically repeated panels should be stacked", func() {
+				So(panels[6].Title, ShouldEqual, "kv stacked")
+				So(panels[6].GridPos, ShouldResemble, GridPos{X: 0, Y: 34, W: 12, H: 4})
+				So(panels[7].GridPos, ShouldResemble, GridPos{X: 0, Y: 38, W: 12, H: 4})
+			})
+		})
+
+		Convey("All panel IDs should be unique", func() {
+			ids := make(map[int]bool)
+			for _, p := range dash.Panels {
+				So(ids[p.ID], ShouldBeFalse)
+				ids[p.ID] = true
+			}
+		})
+	})
+}
+
+func TestRepeatedGridPos(t *testing.T) {
+	Convey("When placing clones of a panel repeated more than the grid columns", t, func() {
+		p := Panel{GridPos: GridPos{X: 0, Y: 2, W: 24, H: 8}, MaxPerRow: 30}
+
+		Convey("Malformed maxPerRow should be kept within the grid", func() {
+			So(repeatedGridPos(p, 0, 30), ShouldResemble, GridPos{X: 0, Y: 2, W: 1, H: 8})
+			So(repeatedGridPos(p, 25, 30), ShouldResemble, GridPos{X: 1, Y: 10, W: 1, H: 8})
+			p.MaxPerRow = -1
+			So(repeatedGridPos(p, 25, 30).W, ShouldEqual, GridColumnCount/defaultMaxPerRow)
+		})
+	})
+}
+
+func TestInterpolate(t *testing.T) {
+	Convey("When interpolating templating variables", t, func() {
+		vars := map[string]ScopedVar{"db": {Text: "kv", Value: "kv"}}
+
+		Convey("All variable syntaxes should be replaced", func() {
+			So(interpolate("$db / ${db} / ${db:regex} / [[db]]", vars), ShouldEqual, "kv / kv / kv / kv")
+		})
+
+		Convey("Unknown variables should be kept", func() {
+			So(interpolate("$instance - $db", vars), ShouldEqual, "$instance - kv")
+		})
+	})
+}
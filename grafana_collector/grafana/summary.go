@@ -0,0 +1,242 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"context"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/chart"
+	"github.com/pkg/errors"
+)
+
+// defaultScrapeInterval is the Prometheus scrape interval in seconds assumed by $__rate_interval
+const defaultScrapeInterval = 15
+
+// legend format syntax: {{label}}
+var legendFormatRegexp = regexp.MustCompile(`\{\{\s*(.+?)\s*\}\}`)
+
+// SeriesSummary represents the statistics of a series over the time range of report
+type SeriesSummary struct {
+	Name string
+	Min  float64
+	Avg  float64
+	Max  float64
+	Last float64
+	P99  float64
+}
+
+// rangeQueryResult represents the result of Prometheus range query API
+type rangeQueryResult struct {
+	Status string
+	Data   struct {
+		ResultType string
+		Result     []struct {
+			Metric map[string]string
+			Values [][]interface{}
+		}
+	}
+}
+
+// GetPanelSummary ... runs the Prometheus queries of panel targets over the time range through the data source proxy,
+// and returns the statistics of every series. Targets of other data sources are skipped
+func (g client) GetPanelSummary(ctx context.Context, p Panel, t TimeRange) ([]SeriesSummary, error) {
+	g.ctx = ctx
+	from, err := t.FromToUnix()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	to, err := t.ToToUnix()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	series, err := g.queryTargets(p, t, queryStep(to-from))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	summaries := make([]SeriesSummary, 0, len(series))
+	for _, s := range series {
+		values := make([]float64, 0, len(s.Points))
+		for _, point := range s.Points {
+			values = append(values, point.Value)
+		}
+		summaries = append(summaries, summarize(s.Name, values))
+	}
+	return summaries, nil
+}
+
+// queryTargets ... runs the Prometheus queries of panel targets over the time range with step seconds, and returns
+// their series named by legend format. NaN values are left out, and so are targets of other data sources
+func (g client) queryTargets(p Panel, t TimeRange, step int64) ([]chart.Series, error) {
+	from, err := t.FromToUnix()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	to, err := t.ToToUnix()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	params := url.Values{}
+	params.Add("start", strconv.FormatInt(from, 10))
+	params.Add("end", strconv.FormatInt(to, 10))
+	params.Add("step", strconv.FormatInt(step, 10))
+
+	var series []chart.Series
+	for _, target := range p.Targets {
+		if target.Hide || target.Expr == "" {
+			continue
+		}
+		params.Set("query", target.Expr)
+		body, err := g.rangeQuery(p, target, params)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if body == nil {
+			continue
+		}
+		var result rangeQueryResult
+		if err = unmarshalPrometheusResult(body, &result, &result.Status); err != nil {
+			return nil, errors.Wrapf(err, "range query result of %s", target.Expr)
+		}
+		if result.Data.ResultType != "matrix" {
+			return nil, errors.Errorf("result type %s of range query %s is not supported", result.Data.ResultType, target.Expr)
+		}
+
+		for _, s := range result.Data.Result {
+			points := make([]chart.Point, 0, len(s.Values))
+			for _, sample := range s.Values {
+				if len(sample) != 2 {
+					continue
+				}
+				ts, ok := sample[0].(float64)
+				if !ok {
+					continue
+				}
+				value, ok := sample[1].(string)
+				if !ok {
+					continue
+				}
+				v, err := strconv.ParseFloat(value, 64)
+				if err != nil || math.IsNaN(v) {
+					continue
+				}
+				points = append(points, chart.Point{Time: time.Unix(0, int64(ts*float64(time.Second))), Value: v})
+			}
+			if len(points) == 0 {
+				continue
+			}
+			series = append(series, chart.Series{Name: seriesName(s.Metric, target.LegendFormat), Points: points})
+		}
+	}
+	return series, nil
+}
+
+// rangeQuery ... sends the range query of target to Prometheus directly if its URL is set, otherwise through the
+// data source proxy. The body is nil if the data source of target is not Prometheus
+func (g client) rangeQuery(p Panel, target Target, params url.Values) ([]byte, error) {
+	if g.prometheusURL != "" {
+		body, err := g.prometheusGet("/api/v1/query_range", params)
+		return body, errors.Wrapf(err, "target %s of panel %d", target.RefID, p.ID)
+	}
+
+	ref := target.Datasource
+	if ref == (DatasourceRef{}) {
+		ref = p.Datasource
+	}
+	ds, err := g.findDatasource(ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "target %s of panel %d", target.RefID, p.ID)
+	}
+	if ds.Type != "prometheus" {
+		log.Warnf("skip target %s of panel %d, datasource %s is %s instead of prometheus", target.RefID, p.ID, ds.Name, ds.Type)
+		return nil, nil
+	}
+	body, err := g.datasourceProxyGet(ds, "/api/v1/query_range", params)
+	return body, errors.WithStack(err)
+}
+
+// summarize ... computes min, avg, max, last and the 99th percentile of values, which is the nearest rank
+func summarize(name string, values []float64) SeriesSummary {
+	summary := SeriesSummary{Name: name, Last: values[len(values)-1]}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	summary.Min = sorted[0]
+	summary.Max = sorted[len(sorted)-1]
+	summary.Avg = sum / float64(len(sorted))
+	summary.P99 = sorted[int(math.Ceil(0.99*float64(len(sorted))))-1]
+	return summary
+}
+
+// seriesName ... names series by the legend format of target, or as metric{label="value"} like Grafana does
+// without legend format. Labels which the series doesn't have are kept as their names
+func seriesName(metric map[string]string, legendFormat string) string {
+	if legendFormat == "" {
+		return formatSample(metric, nil)
+	}
+	return legendFormatRegexp.ReplaceAllStringFunc(legendFormat, func(matched string) string {
+		label := legendFormatRegexp.FindStringSubmatch(matched)[1]
+		if v, ok := metric[label]; ok {
+			return v
+		}
+		return label
+	})
+}
+
+// queryStep ... returns the step in seconds of range queries over a time range of seconds long, so that every
+// series has at most cfg.Summary.Points points
+func queryStep(seconds int64) int64 {
+	points := int64(cfg.Summary.Points)
+	if points <= 0 {
+		points = 1
+	}
+	step := (seconds + points - 1) / points
+	if step < 1 {
+		return 1
+	}
+	return step
+}
+
+// interpolateTargets ... replaces variables in the queries and data sources of panel targets. Repeated clones
+// share targets with their source panel, so the targets are copied
+func (d *Dashboard) interpolateTargets(p *Panel) {
+	if len(p.Targets) == 0 {
+		return
+	}
+	p.Datasource = d.interpolateDatasource(p.Datasource, p.ScopedVars)
+
+	targets := make([]Target, len(p.Targets))
+	for i, target := range p.Targets {
+		expr, err := d.interpolateQuery(interpolate(target.Expr, p.ScopedVars))
+		if err != nil {
+			log.Errorf("interpolating query %s of panel %d error: %v", target.Expr, p.ID, err)
+		}
+		target.Expr = expr
+		target.Datasource = d.interpolateDatasource(target.Datasource, p.ScopedVars)
+		targets[i] = target
+	}
+	p.Targets = targets
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const summaryDashJSON = `
+{"Dashboard":
+	{
+		"Templating":
+			{"list": [
+				{"Name":"instance", "Type":"custom", "Query":"tidb-1,tidb-2", "Multi":true, "Current":{"Text":"tidb-1", "Value":["tidb-1"]}},
+				{"Name":"ds", "Type":"datasource", "Query":"prometheus", "Current":{"Text":"tidb-cluster", "Value":"tidb-cluster"}}]},
+		"Panels":[
+			{"Type":"graph", "ID":1, "Datasource":"$ds", "GridPos":{"X":0, "Y":0, "W":24, "H":8},
+				"Yaxes":[{"Format":"ops"}, {"Format":"short"}],
+				"Targets":[
+					{"RefID":"A", "Expr":"sum(rate(tidb_qps{instance=~\"$instance\"}[$__rate_interval])) by (type)", "LegendFormat":"{{type}} {{result}}"},
+					{"RefID":"B", "Expr":"tidb_hidden", "Hide":true},
+					{"RefID":"C", "Expr":"tidb_influx", "Datasource":"influx"}]}],
+		"title":"Summary"
+	}
+}`
+
+const summaryFrontendSettingsJSON = `{"defaultDatasource":"other-cluster", "datasources":{
+	"other-cluster": {"id":1, "uid":"abc", "name":"other-cluster", "type":"prometheus"},
+	"tidb-cluster": {"id":3, "uid":"def", "name":"tidb-cluster", "type":"prometheus"},
+	"influx": {"id":5, "uid":"ghi", "name":"influx", "type":"influxdb"}
+}}`
+
+func TestGetPanelSummary(t *testing.T) {
+	Convey("When querying summaries of panel targets", t, func() {
+		var queries []url.Values
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/frontend/settings":
+				fmt.Fprint(w, summaryFrontendSettingsJSON)
+			case "/api/datasources/proxy/3/api/v1/query_range":
+				queries = append(queries, r.URL.Query())
+				fmt.Fprint(w, `{"status":"success", "data":{"resultType":"matrix", "result":[
+					{"metric":{"type":"select"}, "values":[[1543890299, "1"], [1543890307, "NaN"], [1543890315, "3"], [1543890323, "2"]]},
+					{"metric":{"type":"insert"}, "values":[]}]}}`)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer ts.Close()
+
+		timeRange := TimeRange{From: "1543886699000", To: "1543890299000"}
+		g := NewV5Client(ts.URL, Credentials{}, url.Values{}, timeRange).(client)
+		dash, err := newDashboard([]byte(summaryDashJSON), g)
+		So(err, ShouldBeNil)
+		So(dash.Panels[0].Unit(), ShouldEqual, "ops")
+
+		summaries, err := g.GetPanelSummary(context.Background(), dash.Panels[0], timeRange)
+		So(err, ShouldBeNil)
+
+		Convey("Visible Prometheus targets should be queried with variables replaced over the time range", func() {
+			So(queries, ShouldHaveLength, 1)
+			So(queries[0].Get("query"), ShouldEqual, `sum(rate(tidb_qps{instance=~"tidb-1"}[60s])) by (type)`)
+			So(queries[0].Get("start"), ShouldEqual, "1543886699")
+			So(queries[0].Get("end"), ShouldEqual, "1543890299")
+			So(queries[0].Get("step"), ShouldEqual, "8")
+		})
+
+		Convey("Series should be named by legend format and NaN and empty series should be skipped", func() {
+			So(summaries, ShouldResemble, []SeriesSummary{{Name: "select result", Min: 1, Avg: 2, Max: 3, Last: 2, P99: 3}})
+		})
+	})
+}
+
+func TestSummarize(t *testing.T) {
+	Convey("When summarizing values of a series", t, func() {
+		values := make([]float64, 0, 200)
+		for i := 200; i > 0; i-- {
+			values = append(values, float64(i))
+		}
+		summary := summarize("s", values)
+
+		Convey("The 99th percentile should be the nearest rank and the last value should be the latest", func() {
+			So(summary.P99, ShouldEqual, 198)
+			So(summary.Last, ShouldEqual, 1)
+			So(summary.Avg, ShouldEqual, 100.5)
+		})
+
+		Convey("Series without legend format should be named like Grafana", func() {
+			So(seriesName(map[string]string{"__name__": "up", "job": "tidb"}, ""), ShouldEqual, `up{job="tidb"}`)
+		})
+	})
+}
+
+func TestPanelUnit(t *testing.T) {
+	Convey("When getting the unit of panels", t, func() {
+		p := Panel{Format: "percent"}
+		So(p.Unit(), ShouldEqual, "percent")
+		p.Yaxes = []Axis{{Format: "bytes"}}
+		So(p.Unit(), ShouldEqual, "bytes")
+		p.FieldConfig.Defaults.Unit = "s"
+		So(p.Unit(), ShouldEqual, "s")
+	})
+}
@@ -0,0 +1,565 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ngaut/log"
+	"github.com/pkg/errors"
+)
+
+var (
+	// query forms of Prometheus templating variable, see http://docs.grafana.org/features/datasources/prometheus/.
+	// regexp: https://github.com/grafana/grafana/blob/v5.4.0/public/app/plugins/datasource/prometheus/metric_find_query.ts
+	queryMetricRegexp = regexp.MustCompile(`^label_values\((?:(.+),\s*)?([a-zA-Z_][a-zA-Z0-9_]*)\)\s*$`)
+	metricNamesRegexp = regexp.MustCompile(`^metrics\((.+)\)\s*$`)
+	queryResultRegexp = regexp.MustCompile(`^query_result\((.+)\)\s*$`)
+	// characters escaped in multi-value regex of Prometheus queries
+	regexSpecialRegexp = regexp.MustCompile(`[\^$*+?.()|{}\[\]]`)
+)
+
+// MetircResult represents templating variable metric result
+type MetircResult struct {
+	Status string
+	Data   []map[string]interface{}
+}
+
+// labelValuesResult represents the result of Prometheus label values API
+type labelValuesResult struct {
+	Status string
+	Data   []string
+}
+
+// queryResult represents the result of Prometheus instant query API
+type queryResult struct {
+	Status string
+	Data   struct {
+		ResultType string
+		Result     json.RawMessage
+	}
+}
+
+// handleMetircResult ... handles query templating variable result,  and gets label's unique and sorted values
+func handleMetircResult(mr MetircResult, label string) []string {
+	result := make([]string, 0, len(mr.Data))
+	filter := make(map[string]bool)
+
+	for _, m := range mr.Data {
+		if v, ok := m[label].(string); ok {
+			if _, exist := filter[v]; !exist {
+				filter[v] = true
+				result = append(result, v)
+			}
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// getMetricAndLabel ... gets metric and label from templating variable's query, example: label_values(tikv_engine_block_cache_size_bytes, db).
+// metric is empty for label_values(label) format
+func getMetricAndLabel(tv TemplatingVariable) (string, string, error) {
+	matched := queryMetricRegexp.FindStringSubmatch(tv.Query)
+
+	if matched != nil {
+		metric := matched[1]
+		label := matched[2]
+		return metric, label, nil
+	}
+
+	return "", "", errors.Errorf("%s should be label_values(label) or label_values(metric, label) format", tv.Query)
+}
+
+// variableValues ... returns values of templating variable, and whether the variable exists.
+// Values selected by var-{name} url values are used, otherwise all values of the variable are resolved
+func (d *Dashboard) variableValues(name string) ([]string, bool, error) {
+	tv, exist := d.templatingVariable(name)
+	if !exist {
+		return nil, false, nil
+	}
+
+	if selected := d.selectedValues(name); len(selected) > 0 {
+		return selected, true, nil
+	}
+	values, err := d.resolveVariable(tv)
+	if err != nil {
+		log.Errorf("getting templateing varaible value error: %v\n", err)
+		return nil, true, errors.Errorf("getting templateing varaible value error: %v\n", err)
+	}
+	return values, true, nil
+}
+
+// selectedValues ... returns values of templating variable which are selected by var-{name} url values, "All" selects nothing
+func (d *Dashboard) selectedValues(name string) []string {
+	selected := d.Variables[variablePrefix+name]
+	if isAllValue(selected) {
+		return nil
+	}
+	return selected
+}
+
+func (d *Dashboard) templatingVariable(name string) (TemplatingVariable, bool) {
+	for _, tv := range d.Templating["list"] {
+		if tv.Name == name {
+			return tv, true
+		}
+	}
+	return TemplatingVariable{}, false
+}
+
+// resolveVariable ... returns all values of templating variable. The variables referenced by its query are resolved first,
+// and the values are kept for the dashboard
+func (d *Dashboard) resolveVariable(tv TemplatingVariable) ([]string, error) {
+	if d.options == nil {
+		d.options = make(map[string][]string)
+		d.resolving = make(map[string]bool)
+	}
+	if values, ok := d.options[tv.Name]; ok {
+		return values, nil
+	}
+	if d.resolving[tv.Name] {
+		return nil, errors.Errorf("templating variable %s has cyclic dependency", tv.Name)
+	}
+	d.resolving[tv.Name] = true
+	defer delete(d.resolving, tv.Name)
+
+	var (
+		values []string
+		err    error
+	)
+	switch tv.Type {
+	case "custom", "interval":
+		values = splitCustomQuery(tv.Query)
+	case "constant":
+		values = []string{tv.Query}
+	case "textbox":
+		values = tv.Current.Value
+		if len(values) == 0 {
+			values = []string{tv.Query}
+		}
+	case "datasource":
+		values, err = d.datasourceVariableValues(tv)
+	case "query", "":
+		values, err = d.queryVariableValues(tv)
+	default:
+		err = errors.Errorf("type %s is not supported", tv.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolve templating variable %s", tv.Name)
+	}
+
+	d.options[tv.Name] = values
+	return values, nil
+}
+
+// datasourceVariableValues ... returns names of the data sources whose type is the query of variable
+func (d *Dashboard) datasourceVariableValues(tv TemplatingVariable) ([]string, error) {
+	datasources, err := d.client.getDatasources()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var names []string
+	for _, ds := range datasources {
+		if ds.Type == tv.Query {
+			names = append(names, ds.Name)
+		}
+	}
+	return d.applyVariableRegex(names, tv.Regex)
+}
+
+// queryVariableValues ... queries values of templating variable through its data source
+func (d *Dashboard) queryVariableValues(tv TemplatingVariable) ([]string, error) {
+	query, err := d.interpolateQuery(tv.Query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	ds, err := d.datasource(tv.Datasource)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolve datasource of templating variable %s", tv.Name)
+	}
+
+	var values []string
+	tv.Query = query
+	if metric, label, e := getMetricAndLabel(tv); e == nil {
+		values, err = d.labelValues(ds, metric, label)
+	} else if matched := metricNamesRegexp.FindStringSubmatch(query); matched != nil {
+		values, err = d.metricNames(ds, matched[1])
+	} else if matched := queryResultRegexp.FindStringSubmatch(query); matched != nil {
+		values, err = d.queryResult(ds, matched[1])
+	} else {
+		err = errors.Errorf("%s should be label_values(label), label_values(metric, label), metrics(regex) or query_result(query) format", query)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return d.applyVariableRegex(values, tv.Regex)
+}
+
+func (d *Dashboard) timeRangeParams() (url.Values, error) {
+	from, err := d.timeRange.FromToUnix()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	to, err := d.timeRange.ToToUnix()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	params := url.Values{}
+	params.Add("start", strconv.FormatInt(from, 10))
+	params.Add("end", strconv.FormatInt(to, 10))
+	return params, nil
+}
+
+// labelValues ... requests values of label, example: http://172.16.30.193:3000/api/datasources/proxy/1/api/v1/series?match[]=tikv_engine_block_cache_size_bytes&start=1543890299&end=1543893899
+func (d *Dashboard) labelValues(ds Datasource, metric string, label string) ([]string, error) {
+	params, err := d.timeRangeParams()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if metric == "" {
+		body, err := d.client.datasourceProxyGet(ds, "/api/v1/label/"+label+"/values", params)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var result labelValuesResult
+		if err = unmarshalPrometheusResult(body, &result, &result.Status); err != nil {
+			return nil, errors.Wrapf(err, "label values of %s", label)
+		}
+		return result.Data, nil
+	}
+
+	params.Add("match[]", metric)
+	body, err := d.client.datasourceProxyGet(ds, "/api/v1/series", params)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var result MetircResult
+	if err = unmarshalPrometheusResult(body, &result, &result.Status); err != nil {
+		return nil, errors.Wrapf(err, "series of %s", metric)
+	}
+	return handleMetircResult(result, label), nil
+}
+
+// metricNames ... requests names of metrics which match regex
+func (d *Dashboard) metricNames(ds Datasource, regex string) ([]string, error) {
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, errors.Errorf("compiling metric name regex %s error: %v", regex, err)
+	}
+
+	params, err := d.timeRangeParams()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	body, err := d.client.datasourceProxyGet(ds, "/api/v1/label/__name__/values", params)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var result labelValuesResult
+	if err = unmarshalPrometheusResult(body, &result, &result.Status); err != nil {
+		return nil, errors.Wrap(err, "metric names")
+	}
+
+	var names []string
+	for _, name := range result.Data {
+		if re.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// queryResult ... runs instant query at the end of time range, every series is formatted as
+// metric{label="value"} value timestamp, which is the same as Grafana
+func (d *Dashboard) queryResult(ds Datasource, expr string) ([]string, error) {
+	end, err := d.timeRange.ToToUnix()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	params := url.Values{}
+	params.Add("query", expr)
+	params.Add("time", strconv.FormatInt(end, 10))
+	body, err := d.client.datasourceProxyGet(ds, "/api/v1/query", params)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var result queryResult
+	if err = unmarshalPrometheusResult(body, &result, &result.Status); err != nil {
+		return nil, errors.Wrapf(err, "query result of %s", expr)
+	}
+
+	switch result.Data.ResultType {
+	case "vector":
+		var vector []struct {
+			Metric map[string]string
+			Value  []interface{}
+		}
+		if err = json.Unmarshal(result.Data.Result, &vector); err != nil {
+			return nil, errors.Errorf("unmarshaling vector result of %s error: %v", expr, err)
+		}
+		values := make([]string, 0, len(vector))
+		for _, s := range vector {
+			values = append(values, formatSample(s.Metric, s.Value))
+		}
+		return values, nil
+	case "scalar", "string":
+		var sample []interface{}
+		if err = json.Unmarshal(result.Data.Result, &sample); err != nil {
+			return nil, errors.Errorf("unmarshaling %s result of %s error: %v", result.Data.ResultType, expr, err)
+		}
+		return []string{formatSample(nil, sample)}, nil
+	}
+	return nil, errors.Errorf("result type %s of %s is not supported", result.Data.ResultType, expr)
+}
+
+// formatSample ... formats a sample of query result as metric{label="value"} value timestamp
+func formatSample(metric map[string]string, value []interface{}) string {
+	names := make([]string, 0, len(metric))
+	for k := range metric {
+		if k != "__name__" {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	labels := make([]string, 0, len(names))
+	for _, k := range names {
+		labels = append(labels, fmt.Sprintf("%s=%q", k, metric[k]))
+	}
+
+	text := metric["__name__"] + "{" + strings.Join(labels, ",") + "}"
+	if len(value) == 2 {
+		if ts, ok := value[0].(float64); ok {
+			text += fmt.Sprintf(" %v %d", value[1], int64(ts*1000))
+		}
+	}
+	return text
+}
+
+func unmarshalPrometheusResult(body []byte, result interface{}, status *string) error {
+	if err := json.Unmarshal(body, result); err != nil {
+		return errors.Errorf("unmarshaling response error: %v", err)
+	}
+	if *status != "success" {
+		return errors.Errorf("request status is not successful: %s", *status)
+	}
+	return nil
+}
+
+// applyVariableRegex ... filters values with regex of templating variable, the first capture group is used as value if it exists
+func (d *Dashboard) applyVariableRegex(values []string, regex string) ([]string, error) {
+	if regex == "" {
+		return values, nil
+	}
+
+	regex, err := d.interpolateQuery(regex)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	re, err := compileVariableRegex(regex)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	result := make([]string, 0, len(values))
+	filter := make(map[string]bool)
+	for _, v := range values {
+		matched := re.FindStringSubmatch(v)
+		if matched == nil {
+			continue
+		}
+		value := matched[0]
+		if len(matched) > 1 {
+			value = matched[1]
+		}
+		if !filter[value] {
+			filter[value] = true
+			result = append(result, value)
+		}
+	}
+	return result, nil
+}
+
+// compileVariableRegex ... compiles javascript style regex of the form /pattern/flags
+func compileVariableRegex(regex string) (*regexp.Regexp, error) {
+	pattern := regex
+	if i := strings.LastIndex(regex, "/"); strings.HasPrefix(regex, "/") && i > 0 {
+		pattern = regex[1:i]
+		if strings.Contains(regex[i+1:], "i") {
+			pattern = "(?i)" + pattern
+		}
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Errorf("compiling templating variable regex %s error: %v", regex, err)
+	}
+	return re, nil
+}
+
+// splitCustomQuery ... splits comma separated values of custom variable, "\," is an escaped comma
+func splitCustomQuery(query string) []string {
+	var (
+		values  []string
+		current []rune
+		escaped bool
+	)
+	for _, c := range query {
+		switch {
+		case escaped:
+			if c != ',' {
+				current = append(current, '\\')
+			}
+			current = append(current, c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == ',':
+			values = append(values, strings.TrimSpace(string(current)))
+			current = current[:0]
+		default:
+			current = append(current, c)
+		}
+	}
+	if escaped {
+		current = append(current, '\\')
+	}
+	if value := strings.TrimSpace(string(current)); value != "" || len(values) > 0 {
+		values = append(values, value)
+	}
+	return values
+}
+
+// interpolateQuery ... replaces templating variables in query with their selected values formatted for Prometheus.
+// Built-in $__range variables are supported, and unknown variables are kept
+func (d *Dashboard) interpolateQuery(query string) (string, error) {
+	var err error
+	result := variableRegexp.ReplaceAllStringFunc(query, func(matched string) string {
+		name := strings.Join(variableRegexp.FindStringSubmatch(matched)[1:], "")
+		if value, ok, e := d.builtinVariable(name); ok {
+			if e != nil {
+				err = e
+				return matched
+			}
+			return value
+		}
+		tv, exist := d.templatingVariable(name)
+		if !exist {
+			return matched
+		}
+		value, e := d.queryValue(tv)
+		if e != nil {
+			err = e
+			return matched
+		}
+		return value
+	})
+	return result, errors.WithStack(err)
+}
+
+func (d *Dashboard) builtinVariable(name string) (string, bool, error) {
+	switch name {
+	case "__range", "__range_s", "__range_ms", "__interval", "__interval_ms", "__rate_interval":
+	default:
+		return "", false, nil
+	}
+
+	from, err := d.timeRange.FromToUnix()
+	if err != nil {
+		return "", true, errors.WithStack(err)
+	}
+	to, err := d.timeRange.ToToUnix()
+	if err != nil {
+		return "", true, errors.WithStack(err)
+	}
+	seconds := to - from
+	// the interval is the step of range queries for summary tables
+	step := queryStep(seconds)
+	switch name {
+	case "__range":
+		return fmt.Sprintf("%ds", seconds), true, nil
+	case "__range_s":
+		return strconv.FormatInt(seconds, 10), true, nil
+	case "__range_ms":
+		return strconv.FormatInt(seconds*1000, 10), true, nil
+	case "__interval":
+		return fmt.Sprintf("%ds", step), true, nil
+	case "__interval_ms":
+		return strconv.FormatInt(step*1000, 10), true, nil
+	}
+	// $__rate_interval is the interval plus a scrape interval, and at least 4 scrape intervals, the same as Grafana
+	rateInterval := step + defaultScrapeInterval
+	if rateInterval < 4*defaultScrapeInterval {
+		rateInterval = 4 * defaultScrapeInterval
+	}
+	return fmt.Sprintf("%ds", rateInterval), true, nil
+}
+
+// queryValue ... returns the selected values of templating variable formatted for Prometheus queries. Without any
+// selection the current value of dashboard is used, and the "All" option means all values or the custom all value
+func (d *Dashboard) queryValue(tv TemplatingVariable) (string, error) {
+	selected := d.Variables[variablePrefix+tv.Name]
+	if len(selected) == 0 {
+		selected = tv.Current.Value
+	}
+
+	all := isAllValue(selected)
+	if all && tv.AllValue != "" {
+		return tv.AllValue, nil
+	}
+	if all || len(selected) == 0 {
+		values, err := d.resolveVariable(tv)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		// like Grafana, the first value is selected by default
+		selected = values
+		if !all && len(values) > 0 {
+			selected = values[:1]
+		}
+	}
+
+	if !tv.Multi && !tv.IncludeAll {
+		return strings.Join(selected, ","), nil
+	}
+	// the regex is quoted in Prometheus queries, so special characters are escaped twice
+	escaped := make([]string, 0, len(selected))
+	for _, v := range selected {
+		v = strings.Replace(v, `\`, `\\\\`, -1)
+		escaped = append(escaped, regexSpecialRegexp.ReplaceAllString(v, `\\$0`))
+	}
+	if len(escaped) == 1 {
+		return escaped[0], nil
+	}
+	return "(" + strings.Join(escaped, "|") + ")", nil
+}
+
+// isAllValue ... checks if the "All" option is selected
+func isAllValue(values []string) bool {
+	for _, v := range values {
+		if v == allValue || v == "All" {
+			return true
+		}
+	}
+	return false
+}
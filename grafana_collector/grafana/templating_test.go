@@ -0,0 +1,164 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const templatingDashJSON = `
+{"Dashboard":
+	{
+		"Templating":
+			{"list": [
+				{"Name":"job", "Type":"custom", "Query":"tikv, pd\\,tidb", "Multi":true},
+				{"Name":"instance", "Type":"query", "Query":"label_values(up{job=~\"$job\"}, instance)", "IncludeAll":true, "AllValue":".*"},
+				{"Name":"db", "Type":"query", "Query":"label_values(db)", "Current":{"Text":"All", "Value":"$__all"}, "IncludeAll":true},
+				{"Name":"metric", "Type":"query", "Query":"metrics(^tikv_engine_.*)"},
+				{"Name":"store", "Type":"query", "Query":"query_result(tikv_store_size{db=~\"$db\",instance=~\"$instance\"})", "Regex":"/.*store=\"([^\"]*)\".*/"},
+				{"Name":"interval", "Type":"interval", "Query":"1m,10m,1h"},
+				{"Name":"version", "Type":"constant", "Query":"v2.1"},
+				{"Name":"filter", "Type":"textbox", "Query":"default", "Current":{"Text":"kv", "Value":"kv"}},
+				{"Name":"a", "Type":"query", "Query":"label_values($b, a)"},
+				{"Name":"b", "Type":"query", "Query":"label_values($a, b)"},
+				{"Name":"unknown", "Type":"adhoc"}]},
+		"Panels":[],
+		"title":"DashTitle #"
+	}
+}`
+
+func TestTemplatingVariables(t *testing.T) {
+	Convey("When resolving templating variables of a dashboard", t, func() {
+		queries := make(map[string]url.Values)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			queries[r.URL.Path] = r.URL.Query()
+			switch r.URL.Path {
+			case "/api/frontend/settings":
+				fmt.Fprint(w, frontendSettingsJSON)
+			case "/api/datasources/proxy/1/api/v1/series":
+				fmt.Fprint(w, `{"status":"success", "data":[{"instance":"tikv-2"}, {"instance":"tikv-1"}]}`)
+			case "/api/datasources/proxy/1/api/v1/label/db/values":
+				fmt.Fprint(w, `{"status":"success", "data":["kv", "raft"]}`)
+			case "/api/datasources/proxy/1/api/v1/label/__name__/values":
+				fmt.Fprint(w, `{"status":"success", "data":["tidb_up", "tikv_engine_size_bytes", "tikv_engine_flow_bytes"]}`)
+			case "/api/datasources/proxy/1/api/v1/query":
+				fmt.Fprint(w, `{"status":"success", "data":{"resultType":"vector", "result":[
+					{"metric":{"__name__":"tikv_store_size", "store":"1"}, "value":[1543890299, "10"]},
+					{"metric":{"__name__":"tikv_store_size", "store":"4"}, "value":[1543890299, "20"]}]}}`)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer ts.Close()
+
+		dash, err := NewDashboard([]byte(templatingDashJSON), ts.URL, Credentials{}, url.Values{"var-job": {"tikv", "pd"}}, TimeRange{From: "now-1h", To: "now"})
+		So(err, ShouldBeNil)
+
+		Convey("label_values(metric{selector}, label) should interpolate the selected values of other variables", func() {
+			values, exist, err := dash.variableValues("instance")
+			So(err, ShouldBeNil)
+			So(exist, ShouldBeTrue)
+			So(values, ShouldResemble, []string{"tikv-1", "tikv-2"})
+			So(queries["/api/datasources/proxy/1/api/v1/series"].Get("match[]"), ShouldEqual, `up{job=~"(tikv|pd)"}`)
+		})
+
+		Convey("label_values(label) should request the values of label", func() {
+			values, _, err := dash.variableValues("db")
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"kv", "raft"})
+		})
+
+		Convey("metrics(regex) should filter metric names", func() {
+			values, _, err := dash.variableValues("metric")
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"tikv_engine_size_bytes", "tikv_engine_flow_bytes"})
+		})
+
+		Convey("query_result(query) should resolve dependencies first and extract values with regex", func() {
+			values, _, err := dash.variableValues("store")
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"1", "4"})
+			So(queries["/api/datasources/proxy/1/api/v1/query"].Get("query"), ShouldEqual, `tikv_store_size{db=~"(kv|raft)",instance=~"tikv-1"}`)
+		})
+
+		Convey("The custom all value should be used for All option", func() {
+			dash.Variables = url.Values{"var-instance": {"$__all"}}
+			value, err := dash.queryValue(TemplatingVariable{Name: "instance", IncludeAll: true, AllValue: ".*"})
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, ".*")
+		})
+
+		Convey("Custom, interval, constant and textbox variables should not request datasource", func() {
+			values, _, err := dash.variableValues("interval")
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"1m", "10m", "1h"})
+
+			values, _, err = dash.variableValues("version")
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"v2.1"})
+
+			values, _, err = dash.variableValues("filter")
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"kv"})
+
+			dash.Variables = nil
+			values, _, err = dash.variableValues("job")
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"tikv", "pd,tidb"})
+		})
+
+		Convey("Cyclic dependencies and unsupported types should return error", func() {
+			_, _, err := dash.variableValues("a")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "cyclic dependency")
+
+			_, _, err = dash.variableValues("unknown")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCompileVariableRegex(t *testing.T) {
+	Convey("When compiling regex of templating variables", t, func() {
+		Convey("Javascript style regex and flags should be supported", func() {
+			re, err := compileVariableRegex("/^TiKV.*/i")
+			So(err, ShouldBeNil)
+			So(re.MatchString("tikv-1"), ShouldBeTrue)
+		})
+
+		Convey("Plain regex should be supported", func() {
+			re, err := compileVariableRegex("tikv-[0-9]")
+			So(err, ShouldBeNil)
+			So(re.MatchString("tikv-1"), ShouldBeTrue)
+		})
+	})
+}
+
+func TestGetMetricAndLabelWithoutMetric(t *testing.T) {
+	Convey("When analysing a label_values(label) TemplatingVariable", t, func() {
+		metric, label, err := getMetricAndLabel(TemplatingVariable{Name: "db", Query: "label_values(db)"})
+
+		Convey("metric should be empty", func() {
+			So(err, ShouldBeNil)
+			So(metric, ShouldBeEmpty)
+			So(label, ShouldEqual, "db")
+		})
+	})
+}
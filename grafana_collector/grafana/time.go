@@ -32,24 +32,32 @@ package grafana
 import (
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 // TimeRange ... TimeRange from grafana url
 type TimeRange struct {
 	From string
 	To   string
+	// Location is the time zone of absolute times in report, UTC is used if it is nil
+	Location *time.Location
 }
 
 // Used to parse grafana time specifications. These can take various forms:
-//	 * relative: "now", "now-1h", "now-2d", "now-3w", "now-5M", "now-1y"
-//   * human friendly boundary:
-// 			From:"now/d" -> start of today
-//			To:  "now/d" -> end of today
-//			To:  "now/w" -> end of the week
-//			To:  "now-1d/d" -> end of yesterday
-//			When used as boundary, the same string will evaluate to a different time if used in 'From' or 'To'
-//	 * absolute unix time: "142321234"
+//   - relative: "now", "now-30s", "now-1h", "now-2d", "now-3w", "now-5M", "now-1y"
+//   - human friendly boundary:
+//     From:"now/d" -> start of today
+//     To:  "now/d" -> end of today
+//     To:  "now/w" -> end of the week
+//     To:  "now-1d/d" -> end of yesterday
+//     From:"now-1h/h" -> start of the last hour
+//     When used as boundary, the same string will evaluate to a different time if used in 'From' or 'To'
+//   - absolute unix time in milliseconds: "142321234"
+//   - absolute ISO-8601 time: "2016-01-06T16:34:32Z", "2016-01-06T16:34:32+08:00", "2016-01-06 16:34:32", "2016-01-06".
+//     Times without time zone are in the location of the time range
 //
 // The required behaviour is clearly documented in the unit tests, time_test.go.
 type now time.Time
@@ -63,8 +71,25 @@ const (
 )
 
 const (
-	relTimeRegExp      = "^now(-[0-9]+)([mhdwMy])$"
-	boundaryTimeRegExp = "^(.*?)/([dwMy])$"
+	relTimeRegExp = "^now((?:[-+][0-9]+[smhdwMy]|/[smhdwMy])*)$"
+	timeOpRegExp  = "([-+])([0-9]+)([smhdwMy])|/([smhdwMy])"
+)
+
+var (
+	relTimeRegexp = regexp.MustCompile(relTimeRegExp)
+	timeOpRegexp  = regexp.MustCompile(timeOpRegExp)
+	shiftRegexp   = regexp.MustCompile("^([0-9]+)([smhdwMy])$")
+
+	// layouts of absolute ISO-8601 times without time zone
+	absTimeLayouts = []string{
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04",
+		"2006-01-02",
+	}
+	// naiveTimeLayout is the layout of shifted times without time zone
+	naiveTimeLayout = absTimeLayouts[0]
 )
 
 // UnixSecond ... returns the number of seconds elapsed since January 1, 1970 UTC.
@@ -72,100 +97,280 @@ func UnixSecond(t time.Time) int64 {
 	return int64(t.UnixNano() / int64(time.Second))
 }
 
-// NewTimeRange ... creates a new TimeRange
-func NewTimeRange(from, to string) TimeRange {
+// NewTimeRange ... creates a new TimeRange, and returns error if from, to or tz is invalid, or from is not earlier than to.
+// tz is an IANA time zone name like "Asia/Shanghai", "utc", or "browser" for the local time zone of server. If it is empty,
+// the time zone of dashboard can be used by WithDashboardTimezone.
+// Absolute ISO-8601 times are converted to unix milliseconds, which are accepted by Grafana. Without tz, times without
+// time zone are kept until WithDashboardTimezone converts them in the time zone of dashboard
+func NewTimeRange(from, to, tz string) (TimeRange, error) {
 	if from == "" {
 		from = "now-1h"
 	}
 	if to == "" {
 		to = "now"
 	}
-	return TimeRange{from, to}
+
+	tr := TimeRange{From: from, To: to}
+	if tz != "" {
+		loc, err := parseTimezone(tz)
+		if err != nil {
+			return TimeRange{}, errors.WithStack(err)
+		}
+		tr.Location = loc
+	}
+
+	n := newNow(tr.location())
+	fromTime, err := n.parseFrom(from)
+	if err != nil {
+		return TimeRange{}, errors.Wrap(err, "invalid from")
+	}
+	toTime, err := n.parseTo(to)
+	if err != nil {
+		return TimeRange{}, errors.Wrap(err, "invalid to")
+	}
+	if !fromTime.Before(toTime) {
+		return TimeRange{}, errors.Errorf("from %s should be earlier than to %s", from, to)
+	}
+
+	if isISOTime(from) && (tr.Location != nil || !isNaiveTime(from)) {
+		tr.From = unixMilli(fromTime)
+	}
+	if isISOTime(to) && (tr.Location != nil || !isNaiveTime(to)) {
+		tr.To = unixMilli(toTime)
+	}
+	return tr, nil
+}
+
+// WithDashboardTimezone ... returns the time range in the time zone of dashboard, unless its time zone is set already.
+// The dashboard time zone is "utc", "browser" or an IANA time zone name. Times without time zone are converted to
+// unix milliseconds in the time zone of time range, UTC if neither is set
+func (tr TimeRange) WithDashboardTimezone(tz string) TimeRange {
+	if tr.Location == nil && tz != "" {
+		if loc, err := parseTimezone(tz); err == nil {
+			tr.Location = loc
+		}
+	}
+	if isNaiveTime(tr.From) {
+		if t, err := tr.FromTime(); err == nil {
+			tr.From = unixMilli(t)
+		}
+	}
+	if isNaiveTime(tr.To) {
+		if t, err := tr.ToTime(); err == nil {
+			tr.To = unixMilli(t)
+		}
+	}
+	return tr
+}
+
+// Shift ... returns the absolute time range which is earlier than tr by shift, e.g. "7d" or "1w", in the same time zone
+func (tr TimeRange) Shift(shift string) (TimeRange, error) {
+	matches := shiftRegexp.FindStringSubmatch(shift)
+	if matches == nil {
+		return TimeRange{}, errors.Errorf("%s is not a recognised time shift, e.g. 7d", shift)
+	}
+	i, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return TimeRange{}, errors.Errorf("%s is not a recognised time shift: %v", shift, err)
+	}
+
+	from, err := tr.FromTime()
+	if err != nil {
+		return TimeRange{}, errors.Wrap(err, "invalid from")
+	}
+	to, err := tr.ToTime()
+	if err != nil {
+		return TimeRange{}, errors.Wrap(err, "invalid to")
+	}
+	shifted := TimeRange{
+		From:     unixMilli(addUnits(from, -i, matches[2])),
+		To:       unixMilli(addUnits(to, -i, matches[2])),
+		Location: tr.Location,
+	}
+	// times without time zone are shifted by their wall clock, they are still in the time zone of dashboard
+	if isNaiveTime(tr.From) {
+		shifted.From = addUnits(from, -i, matches[2]).Format(naiveTimeLayout)
+	}
+	if isNaiveTime(tr.To) {
+		shifted.To = addUnits(to, -i, matches[2]).Format(naiveTimeLayout)
+	}
+	return shifted, nil
+}
+
+func parseTimezone(tz string) (*time.Location, error) {
+	switch strings.ToLower(tz) {
+	case "utc":
+		return time.UTC, nil
+	case "browser", "local":
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, errors.Errorf("%s is not a recognised time zone: %v", tz, err)
+	}
+	return loc, nil
+}
+
+func (tr TimeRange) location() *time.Location {
+	if tr.Location == nil {
+		return time.UTC
+	}
+	return tr.Location
+}
+
+// FromTime ... parses Grafana 'From' time spec in the location of time range
+func (tr TimeRange) FromTime() (time.Time, error) {
+	return newNow(tr.location()).parseFrom(tr.From)
+}
+
+// ToTime ... parses Grafana 'To' time spec in the location of time range
+func (tr TimeRange) ToTime() (time.Time, error) {
+	return newNow(tr.location()).parseTo(tr.To)
 }
 
 // FromToUnix ... Formats Grafana 'From' time spec into a Unix time, the number
 // of seconds elapsed since January 1, 1970 UTC.
-func (tr TimeRange) FromToUnix() int64 {
-	n := newNow()
-	t := n.parseFrom(tr.From)
-	return UnixSecond(t)
+func (tr TimeRange) FromToUnix() (int64, error) {
+	t, err := tr.FromTime()
+	return UnixSecond(t), errors.WithStack(err)
 }
 
 // ToToUnix ... Formats Grafana 'To' time spec into a Unix time, the number
 // of seconds elapsed since January 1, 1970 UTC.
-func (tr TimeRange) ToToUnix() int64 {
-	n := newNow()
-	t := n.parseTo(tr.To)
-	return UnixSecond(t)
+func (tr TimeRange) ToToUnix() (int64, error) {
+	t, err := tr.ToTime()
+	return UnixSecond(t), errors.WithStack(err)
 }
 
-// FromFormatted ... Formats Grafana 'From' time spec into absolute printable UTC time
+// FromFormatted ... Formats Grafana 'From' time spec into absolute printable time in the location of time range.
+// Unrecognised time spec is returned as it is
 func (tr TimeRange) FromFormatted() string {
-	n := newNow()
-	return n.parseFrom(tr.From).UTC().Format(time.UnixDate)
+	t, err := tr.FromTime()
+	if err != nil {
+		return tr.From
+	}
+	return t.Format(time.UnixDate)
 }
 
-// ToFormatted ... Formats Grafana 'To' time spec into absolute printable UTC time
+// ToFormatted ... Formats Grafana 'To' time spec into absolute printable time in the location of time range.
+// Unrecognised time spec is returned as it is
 func (tr TimeRange) ToFormatted() string {
-	n := newNow()
-	return n.parseTo(tr.To).UTC().Format(time.UnixDate)
+	t, err := tr.ToTime()
+	if err != nil {
+		return tr.To
+	}
+	return t.Format(time.UnixDate)
+}
+
+// Format ... formats absolute time t in the location of time range
+func (tr TimeRange) Format(t time.Time) string {
+	return t.In(tr.location()).Format(time.UnixDate)
 }
 
-func newNow() now {
-	return now(time.Now())
+func newNow(loc *time.Location) now {
+	return now(time.Now().In(loc))
 }
 
 func (n now) asTime() time.Time {
 	return time.Time(n)
 }
 
-func (n now) parseFrom(s string) time.Time {
-	return n.parseHumanFriendlyBoundary(s, From)
+func (n now) parseFrom(s string) (time.Time, error) {
+	return n.parse(s, From)
 }
 
-func (n now) parseTo(s string) time.Time {
-	return n.parseHumanFriendlyBoundary(s, To)
+func (n now) parseTo(s string) (time.Time, error) {
+	return n.parse(s, To)
 }
 
-func (n now) parseHumanFriendlyBoundary(s string, b boundary) time.Time {
-	if !isHumanFriendlyBoundray(s) {
-		return n.parseMoment(s)
+func (n now) parse(s string, b boundary) (time.Time, error) {
+	if strings.HasPrefix(s, "now") {
+		return n.parseRelativeTime(s, b)
+	}
+	return n.parseAbsTime(s)
+}
+
+// parseRelativeTime ... applies the operations after "now" in order, e.g. "now-1d/d" moves back one day and then rounds to the day boundary
+func (n now) parseRelativeTime(s string, b boundary) (time.Time, error) {
+	matches := relTimeRegexp.FindStringSubmatch(s)
+	if matches == nil {
+		return time.Time{}, unrecognized(s)
+	}
+
+	t := n.asTime()
+	for _, op := range timeOpRegexp.FindAllStringSubmatch(matches[1], -1) {
+		if op[4] != "" {
+			t = roundMomentToBoundary(t, b, op[4])
+			continue
+		}
+
+		i, err := strconv.Atoi(op[2])
+		if err != nil {
+			return time.Time{}, unrecognized(s)
+		}
+		if op[1] == "-" {
+			i = -i
+		}
+		t = addUnits(t, i, op[3])
 	}
-	moment, boundaryUnit := n.parseMomentAndBoundaryUnit(s)
-	return roundMomentToBoundary(moment, b, boundaryUnit)
+	return t, nil
 }
 
-func (n now) parseMomentAndBoundaryUnit(s string) (time.Time, string) {
-	re := regexp.MustCompile(boundaryTimeRegExp)
-	matches := re.FindStringSubmatch(s)
-	if len(matches) != 3 {
-		panic(unrecognized(s))
+func addUnits(t time.Time, i int, unit string) time.Time {
+	switch unit {
+	case "s":
+		return t.Add(time.Duration(i) * time.Second)
+	case "m":
+		return t.Add(time.Duration(i) * time.Minute)
+	case "h":
+		return t.Add(time.Duration(i) * time.Hour)
+	case "d":
+		return t.AddDate(0, 0, i)
+	case "w":
+		return t.AddDate(0, 0, i*7)
+	case "M":
+		return t.AddDate(0, i, 0)
+	case "y":
+		return t.AddDate(i, 0, 0)
 	}
-	moment := n.parseMoment(matches[1])
-	boundaryUnit := matches[2]
-	return moment, boundaryUnit
+	return t
 }
 
 func roundMomentToBoundary(moment time.Time, b boundary, boundaryUnit string) time.Time {
 	y := moment.Year()
 	M := moment.Month()
 	d := moment.Day()
+	h := moment.Hour()
+	m := moment.Minute()
+	s := moment.Second()
 
 	switch boundaryUnit {
+	case "s":
+		s += add(b)
+	case "m":
+		s = 0
+		m += add(b)
+	case "h":
+		s, m = 0, 0
+		h += add(b)
 	case "d":
+		s, m, h = 0, 0, 0
 		d += add(b)
 	case "w":
+		s, m, h = 0, 0, 0
 		d += daysToWeekBoundary(moment.Weekday(), b)
 	case "M":
+		s, m, h = 0, 0, 0
 		d = 1
 		M = time.Month(int(M) + add(b))
 	case "y":
+		s, m, h = 0, 0, 0
 		d = 1
 		M = time.January
 		y += add(b)
 	}
 
-	return time.Date(y, M, d, 0, 0, 0, 0, moment.Location())
+	return time.Date(y, M, d, h, m, s, 0, moment.Location())
 }
 
 func add(b boundary) int {
@@ -182,71 +387,50 @@ func daysToWeekBoundary(wd time.Weekday, b boundary) int {
 	return -int(wd)
 }
 
-func (n now) parseMoment(s string) time.Time {
-	if s == "now" {
-		return n.asTime()
-	} else if isRelativeTime(s) {
-		return n.parseRelativeTime(s)
-	} else {
-		return parseAbsTime(s)
+func (n now) parseAbsTime(s string) (time.Time, error) {
+	loc := n.asTime().Location()
+	if timeInMs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(timeInMs/1000, 0).In(loc), nil
 	}
-}
-
-func (n now) parseRelativeTime(s string) time.Time {
-	re := regexp.MustCompile(relTimeRegExp)
-
-	matches := re.FindStringSubmatch(s)
-	if len(matches) != 3 {
-		panic(unrecognized(s))
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.In(loc), nil
 	}
-	unit := matches[2]
-	number := matches[1]
-
-	i, err := strconv.Atoi(number)
-	stopIf(err)
-
-	switch unit {
-	case "m", "h":
-		d, err := time.ParseDuration(number + unit)
-		stopIf(err)
-		return n.asTime().Add(d)
-	case "d":
-		return n.asTime().AddDate(0, 0, i)
-	case "w":
-		return n.asTime().AddDate(0, 0, i*7)
-	case "M":
-		return n.asTime().AddDate(0, i, 0)
-	case "y":
-		return n.asTime().AddDate(i, 0, 0)
+	for _, layout := range absTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
 	}
-
-	return n.asTime()
+	return time.Time{}, unrecognized(s)
 }
 
-func parseAbsTime(s string) time.Time {
-	if timeInMs, err := strconv.ParseInt(s, 10, 64); err == nil {
-		return time.Unix(timeInMs/1000, 0)
-	}
-
-	panic(unrecognized(s))
+func unrecognized(s string) error {
+	return errors.Errorf("%s is not a recognised time format", s)
 }
 
-func unrecognized(s string) string {
-	return s + " is not a recognised time format"
+// isFixed ... checks if both ends of time range are absolute times, which don't change with the time of request
+func (tr TimeRange) isFixed() bool {
+	return !strings.HasPrefix(tr.From, "now") && !strings.HasPrefix(tr.To, "now")
 }
 
-func stopIf(err error) {
-	if err != nil {
-		panic(err)
-	}
+// unixMilli ... formats t as unix milliseconds, which are accepted by Grafana
+func unixMilli(t time.Time) string {
+	return strconv.FormatInt(UnixSecond(t)*1000, 10)
 }
 
-func isRelativeTime(s string) bool {
-	matched, _ := regexp.MatchString(relTimeRegExp, s)
-	return matched
+// isNaiveTime ... checks if s is an ISO-8601 time without time zone, which is in the time zone of time range
+func isNaiveTime(s string) bool {
+	if !isISOTime(s) {
+		return false
+	}
+	_, err := time.Parse(time.RFC3339Nano, s)
+	return err != nil
 }
 
-func isHumanFriendlyBoundray(s string) bool {
-	matched, _ := regexp.MatchString(boundaryTimeRegExp, s)
-	return matched
+// isISOTime ... checks if s is an absolute time which is neither relative nor unix milliseconds
+func isISOTime(s string) bool {
+	if strings.HasPrefix(s, "now") {
+		return false
+	}
+	_, err := strconv.ParseInt(s, 10, 64)
+	return err != nil
 }
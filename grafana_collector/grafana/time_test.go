@@ -46,116 +46,161 @@ func sameTimeAs(actual interface{}, expected ...interface{}) string {
 	return fmt.Sprintf("Times differ:\n\t Actual: %q\n\tExpected: %q\n", a, e)
 }
 
+func parsedFrom(n now, s string) time.Time {
+	t, err := n.parseFrom(s)
+	So(err, ShouldBeNil)
+	return t
+}
+
+func parsedTo(n now, s string) time.Time {
+	t, err := n.parseTo(s)
+	So(err, ShouldBeNil)
+	return t
+}
+
 func TestTimeParsing(tst *testing.T) {
 	testNow, _ := time.Parse(time.RFC1123, "Wed, 06 Jan 2016 16:34:32 UTC")
 	t := now(testNow)
 
 	Convey("When parsing relative time", tst, func() {
 		Convey("'now' should return the time it was initialised with", func() {
-			So(t.parseTo("now"), sameTimeAs, testNow)
+			So(parsedTo(t, "now"), sameTimeAs, testNow)
 		})
 
 		Convey("Minutes are supported", func() {
 			d, _ := time.ParseDuration("-1m")
-			So(t.parseTo("now-1m"), sameTimeAs, testNow.Add(d))
+			So(parsedTo(t, "now-1m"), sameTimeAs, testNow.Add(d))
 
 			d, _ = time.ParseDuration("-58m")
-			So(t.parseTo("now-58m"), sameTimeAs, testNow.Add(d))
+			So(parsedTo(t, "now-58m"), sameTimeAs, testNow.Add(d))
 		})
 
 		Convey("Hours are supported", func() {
 			d, _ := time.ParseDuration("-3h")
-			So(t.parseTo("now-3h"), sameTimeAs, testNow.Add(d))
+			So(parsedTo(t, "now-3h"), sameTimeAs, testNow.Add(d))
 
 			d, _ = time.ParseDuration("-82h")
-			So(t.parseTo("now-82h"), sameTimeAs, testNow.Add(d))
+			So(parsedTo(t, "now-82h"), sameTimeAs, testNow.Add(d))
 		})
 
 		Convey("Days are supported", func() {
-			So(t.parseTo("now-1d"), sameTimeAs, testNow.AddDate(0, 0, -1))
-			So(t.parseTo("now-105d"), sameTimeAs, testNow.AddDate(0, 0, -105))
+			So(parsedTo(t, "now-1d"), sameTimeAs, testNow.AddDate(0, 0, -1))
+			So(parsedTo(t, "now-105d"), sameTimeAs, testNow.AddDate(0, 0, -105))
 		})
 
 		Convey("Weeks are supported", func() {
-			So(t.parseTo("now-1w"), sameTimeAs, testNow.AddDate(0, 0, -1*7))
-			So(t.parseTo("now-33w"), sameTimeAs, testNow.AddDate(0, 0, -33*7))
+			So(parsedTo(t, "now-1w"), sameTimeAs, testNow.AddDate(0, 0, -1*7))
+			So(parsedTo(t, "now-33w"), sameTimeAs, testNow.AddDate(0, 0, -33*7))
 		})
 
 		Convey("Months are supported", func() {
-			So(t.parseTo("now-1M"), sameTimeAs, testNow.AddDate(0, -1, 0))
-			So(t.parseTo("now-33M"), sameTimeAs, testNow.AddDate(0, -33, 0))
+			So(parsedTo(t, "now-1M"), sameTimeAs, testNow.AddDate(0, -1, 0))
+			So(parsedTo(t, "now-33M"), sameTimeAs, testNow.AddDate(0, -33, 0))
 		})
 
 		Convey("Years are supported", func() {
-			So(t.parseTo("now-1y"), sameTimeAs, testNow.AddDate(-1, 0, 0))
-			So(t.parseTo("now-33y"), sameTimeAs, testNow.AddDate(-33, 0, 0))
+			So(parsedTo(t, "now-1y"), sameTimeAs, testNow.AddDate(-1, 0, 0))
+			So(parsedTo(t, "now-33y"), sameTimeAs, testNow.AddDate(-33, 0, 0))
 		})
 
 	})
 
 	//?from=1463464226537&to=1463472462258
 	Convey("Should be able to parse absolute time ", tst, func() {
-		So(t.parseTo("1463464226537"), sameTimeAs, time.Unix(1463464226537/1000, 0))
+		So(parsedTo(t, "1463464226537"), sameTimeAs, time.Unix(1463464226537/1000, 0).UTC())
+	})
+
+	Convey("Should be able to parse absolute ISO-8601 time", tst, func() {
+		expected := time.Date(2016, time.January, 6, 16, 34, 32, 0, time.UTC)
+		at, err := t.parseTo("2016-01-06T16:34:32Z")
+		So(err, ShouldBeNil)
+		So(at.Equal(expected), ShouldBeTrue)
+
+		at, err = t.parseTo("2016-01-07T00:34:32+08:00")
+		So(err, ShouldBeNil)
+		So(at.Equal(expected), ShouldBeTrue)
+
+		So(parsedTo(t, "2016-01-06 16:34:32"), sameTimeAs, expected)
+		So(parsedTo(t, "2016-01-06"), sameTimeAs, time.Date(2016, time.January, 6, 0, 0, 0, 0, time.UTC))
+
+		shanghai, err := time.LoadLocation("Asia/Shanghai")
+		So(err, ShouldBeNil)
+		at, err = now(testNow.In(shanghai)).parseTo("2016-01-07 00:34:32")
+		So(err, ShouldBeNil)
+		So(at.Equal(expected), ShouldBeTrue)
 	})
 
-	Convey("Should panic on accept unrecognised formats", tst, func() {
-		So(func() { t.parseTo("not-a-time") }, ShouldPanic)
-		So(func() { t.parseTo("now-43k") }, ShouldPanic)
-		So(func() { t.parseTo("1235032k") }, ShouldPanic)
+	Convey("Should return error on unrecognised formats", tst, func() {
+		for _, s := range []string{"not-a-time", "now-43k", "1235032k", "now-1h/k", "now1h", "2016-13-01"} {
+			_, err := t.parseTo(s)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "is not a recognised time format")
+		}
+	})
+
+	Convey("When parsing relative time with seconds and several operations", tst, func() {
+		So(parsedTo(t, "now-30s"), sameTimeAs, testNow.Add(-30*time.Second))
+		So(parsedTo(t, "now+1h"), sameTimeAs, testNow.Add(time.Hour))
+		So(parsedTo(t, "now-1d-2h"), sameTimeAs, testNow.AddDate(0, 0, -1).Add(-2*time.Hour))
+
+		startOfLastHour := time.Date(2016, time.January, 6, 15, 0, 0, 0, time.UTC)
+		So(parsedFrom(t, "now-1h/h"), sameTimeAs, startOfLastHour)
+		So(parsedTo(t, "now-1h/h"), sameTimeAs, startOfLastHour.Add(time.Hour))
+		So(parsedFrom(t, "now/d-1h"), sameTimeAs, time.Date(2016, time.January, 5, 23, 0, 0, 0, time.UTC))
 	})
 
 	Convey("When parsing human frienly start time boundaries, parseFrom()", tst, func() {
 		Convey("Should return the same time as parseTo() if boundary specifier ('/') is missing", func() {
-			So(t.parseFrom("now"), sameTimeAs, t.parseTo("now"))
-			So(t.parseFrom("now-3M"), sameTimeAs, t.parseTo("now-3M"))
-			So(t.parseFrom("14123456789"), sameTimeAs, t.parseTo("14123456789"))
+			So(parsedFrom(t, "now"), sameTimeAs, parsedTo(t, "now"))
+			So(parsedFrom(t, "now-3M"), sameTimeAs, parsedTo(t, "now-3M"))
+			So(parsedFrom(t, "14123456789"), sameTimeAs, parsedTo(t, "14123456789"))
 		})
 
 		//now = Wed, 06 Jan 2016 16:34:32 UTC
 		Convey("Should support days", func() {
 			startOfTheDay, _ := time.Parse(time.RFC1123, "Wed, 06 Jan 2016 00:00:00 UTC")
-			So(t.parseFrom("now/d"), sameTimeAs, startOfTheDay)
-			So(t.parseFrom("now-1m/d"), sameTimeAs, startOfTheDay)
-			So(t.parseFrom("now-72m/d"), sameTimeAs, startOfTheDay)
+			So(parsedFrom(t, "now/d"), sameTimeAs, startOfTheDay)
+			So(parsedFrom(t, "now-1m/d"), sameTimeAs, startOfTheDay)
+			So(parsedFrom(t, "now-72m/d"), sameTimeAs, startOfTheDay)
 
 			startOfYesterday, _ := time.Parse(time.RFC1123, "Tue, 05 Jan 2016 00:00:00 UTC")
-			So(t.parseFrom("now-1d/d"), sameTimeAs, startOfYesterday)
-			So(t.parseFrom("now-24h/d"), sameTimeAs, startOfYesterday)
+			So(parsedFrom(t, "now-1d/d"), sameTimeAs, startOfYesterday)
+			So(parsedFrom(t, "now-24h/d"), sameTimeAs, startOfYesterday)
 		})
 
 		Convey("Should support weeks", func() {
 			startOfTheWeek, _ := time.Parse(time.RFC1123, "Sun, 03 Jan 2016 00:00:00 UTC")
-			So(t.parseFrom("now/w"), sameTimeAs, startOfTheWeek)
-			So(t.parseFrom("now-82m/w"), sameTimeAs, startOfTheWeek)
-			So(t.parseFrom("now-33h/w"), sameTimeAs, startOfTheWeek)
-			So(t.parseFrom("now-2d/w"), sameTimeAs, startOfTheWeek)
+			So(parsedFrom(t, "now/w"), sameTimeAs, startOfTheWeek)
+			So(parsedFrom(t, "now-82m/w"), sameTimeAs, startOfTheWeek)
+			So(parsedFrom(t, "now-33h/w"), sameTimeAs, startOfTheWeek)
+			So(parsedFrom(t, "now-2d/w"), sameTimeAs, startOfTheWeek)
 
 			startOfLastWeek, _ := time.Parse(time.RFC1123, "Sun, 27 Dec 2015 00:00:00 UTC")
-			So(t.parseFrom("now-1w/w"), sameTimeAs, startOfLastWeek)
+			So(parsedFrom(t, "now-1w/w"), sameTimeAs, startOfLastWeek)
 		})
 
 		Convey("Should support months", func() {
 			startOfTheMonth, _ := time.Parse(time.RFC1123, "Fri, 01 Jan 2016 00:00:00 UTC")
 			So(time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC), sameTimeAs, startOfTheMonth)
 
-			So(t.parseFrom("now/M"), sameTimeAs, startOfTheMonth)
-			So(t.parseFrom("now-82m/M"), sameTimeAs, startOfTheMonth)
-			So(t.parseFrom("now-33h/M"), sameTimeAs, startOfTheMonth)
-			So(t.parseFrom("now-2d/M"), sameTimeAs, startOfTheMonth)
+			So(parsedFrom(t, "now/M"), sameTimeAs, startOfTheMonth)
+			So(parsedFrom(t, "now-82m/M"), sameTimeAs, startOfTheMonth)
+			So(parsedFrom(t, "now-33h/M"), sameTimeAs, startOfTheMonth)
+			So(parsedFrom(t, "now-2d/M"), sameTimeAs, startOfTheMonth)
 
 			startOfLastMonth, _ := time.Parse(time.RFC1123, "Tue, 01 Dec 2015 00:00:00 UTC")
-			So(t.parseFrom("now-1M/M"), sameTimeAs, startOfLastMonth)
+			So(parsedFrom(t, "now-1M/M"), sameTimeAs, startOfLastMonth)
 		})
 
 		Convey("Should support years", func() {
 			startOfTheYear, _ := time.Parse(time.RFC1123, "Fri, 01 Jan 2016 00:00:00 UTC")
-			So(t.parseFrom("now/y"), sameTimeAs, startOfTheYear)
-			So(t.parseFrom("now-82m/y"), sameTimeAs, startOfTheYear)
-			So(t.parseFrom("now-33h/y"), sameTimeAs, startOfTheYear)
-			So(t.parseFrom("now-2d/y"), sameTimeAs, startOfTheYear)
+			So(parsedFrom(t, "now/y"), sameTimeAs, startOfTheYear)
+			So(parsedFrom(t, "now-82m/y"), sameTimeAs, startOfTheYear)
+			So(parsedFrom(t, "now-33h/y"), sameTimeAs, startOfTheYear)
+			So(parsedFrom(t, "now-2d/y"), sameTimeAs, startOfTheYear)
 
 			startOfLastYear, _ := time.Parse(time.RFC1123, "Thu, 01 Jan 2015 00:00:00 UTC")
-			So(t.parseFrom("now-1y/y"), sameTimeAs, startOfLastYear)
+			So(parsedFrom(t, "now-1y/y"), sameTimeAs, startOfLastYear)
 		})
 
 	})
@@ -164,46 +209,134 @@ func TestTimeParsing(tst *testing.T) {
 		//now = Wed, 06 Jan 2016 16:34:32 UTC
 		Convey("Should support days", func() {
 			endOfToday, _ := time.Parse(time.RFC1123, "Thu, 07 Jan 2016 00:00:00 UTC")
-			So(t.parseTo("now/d"), sameTimeAs, endOfToday)
-			So(t.parseTo("now-1m/d"), sameTimeAs, endOfToday)
-			So(t.parseTo("now-72m/d"), sameTimeAs, endOfToday)
+			So(parsedTo(t, "now/d"), sameTimeAs, endOfToday)
+			So(parsedTo(t, "now-1m/d"), sameTimeAs, endOfToday)
+			So(parsedTo(t, "now-72m/d"), sameTimeAs, endOfToday)
 
 			endOfYesterday, _ := time.Parse(time.RFC1123, "Wed, 06 Jan 2016 00:00:00 UTC")
-			So(t.parseTo("now-1d/d"), sameTimeAs, endOfYesterday)
+			So(parsedTo(t, "now-1d/d"), sameTimeAs, endOfYesterday)
 		})
 
 		Convey("Should support weeks", func() {
 			endOfTheWeek, _ := time.Parse(time.RFC1123, "Sun, 10 Jan 2016 00:00:00 UTC")
-			So(t.parseTo("now/w"), sameTimeAs, endOfTheWeek)
-			So(t.parseTo("now-82m/w"), sameTimeAs, endOfTheWeek)
-			So(t.parseTo("now-33h/w"), sameTimeAs, endOfTheWeek)
-			So(t.parseTo("now-2d/w"), sameTimeAs, endOfTheWeek)
+			So(parsedTo(t, "now/w"), sameTimeAs, endOfTheWeek)
+			So(parsedTo(t, "now-82m/w"), sameTimeAs, endOfTheWeek)
+			So(parsedTo(t, "now-33h/w"), sameTimeAs, endOfTheWeek)
+			So(parsedTo(t, "now-2d/w"), sameTimeAs, endOfTheWeek)
 
 			endOfLastWeek, _ := time.Parse(time.RFC1123, "Sun, 03 Jan 2016 00:00:00 UTC")
-			So(t.parseTo("now-1w/w"), sameTimeAs, endOfLastWeek)
+			So(parsedTo(t, "now-1w/w"), sameTimeAs, endOfLastWeek)
 		})
 
 		Convey("Should support months", func() {
 			endOfTheMonth, _ := time.Parse(time.RFC1123, "Mon, 01 Feb 2016 00:00:00 UTC")
-			So(t.parseTo("now/M"), sameTimeAs, endOfTheMonth)
-			So(t.parseTo("now-82m/M"), sameTimeAs, endOfTheMonth)
-			So(t.parseTo("now-33h/M"), sameTimeAs, endOfTheMonth)
-			So(t.parseTo("now-2d/M"), sameTimeAs, endOfTheMonth)
+			So(parsedTo(t, "now/M"), sameTimeAs, endOfTheMonth)
+			So(parsedTo(t, "now-82m/M"), sameTimeAs, endOfTheMonth)
+			So(parsedTo(t, "now-33h/M"), sameTimeAs, endOfTheMonth)
+			So(parsedTo(t, "now-2d/M"), sameTimeAs, endOfTheMonth)
 
 			endOfLastMonth, _ := time.Parse(time.RFC1123, "Fri, 01 Jan 2016 00:00:00 UTC")
-			So(t.parseTo("now-1M/M"), sameTimeAs, endOfLastMonth)
+			So(parsedTo(t, "now-1M/M"), sameTimeAs, endOfLastMonth)
 		})
 
 		Convey("Should support years", func() {
 			endOfTheYear, _ := time.Parse(time.RFC1123, "Sun, 01 Jan 2017 00:00:00 UTC")
-			So(t.parseTo("now/y"), sameTimeAs, endOfTheYear)
-			So(t.parseTo("now-82m/y"), sameTimeAs, endOfTheYear)
-			So(t.parseTo("now-33h/y"), sameTimeAs, endOfTheYear)
-			So(t.parseTo("now-2d/y"), sameTimeAs, endOfTheYear)
+			So(parsedTo(t, "now/y"), sameTimeAs, endOfTheYear)
+			So(parsedTo(t, "now-82m/y"), sameTimeAs, endOfTheYear)
+			So(parsedTo(t, "now-33h/y"), sameTimeAs, endOfTheYear)
+			So(parsedTo(t, "now-2d/y"), sameTimeAs, endOfTheYear)
 
 			endOfLastYear, _ := time.Parse(time.RFC1123, "Fri, 01 Jan 2016 00:00:00 UTC")
-			So(t.parseTo("now-1y/y"), sameTimeAs, endOfLastYear)
+			So(parsedTo(t, "now-1y/y"), sameTimeAs, endOfLastYear)
 		})
 
 	})
 }
+
+func TestNewTimeRange(tst *testing.T) {
+	Convey("When creating a time range", tst, func() {
+		Convey("Defaults should be used for empty from and to", func() {
+			tr, err := NewTimeRange("", "", "")
+			So(err, ShouldBeNil)
+			So(tr.From, ShouldEqual, "now-1h")
+			So(tr.To, ShouldEqual, "now")
+			So(tr.Location, ShouldBeNil)
+		})
+
+		Convey("ISO-8601 times should be converted to unix milliseconds", func() {
+			tr, err := NewTimeRange("2016-01-06T16:34:32Z", "2016-01-07 01:34:32", "Asia/Shanghai")
+			So(err, ShouldBeNil)
+			So(tr.From, ShouldEqual, "1452098072000")
+			So(tr.To, ShouldEqual, "1452101672000")
+		})
+
+		Convey("ISO-8601 times without time zone should be in the time zone of dashboard without tz", func() {
+			tr, err := NewTimeRange("2020-01-01T08:00:00", "2020-01-01 09:00", "")
+			So(err, ShouldBeNil)
+			So(tr.From, ShouldEqual, "2020-01-01T08:00:00")
+
+			shanghai := tr.WithDashboardTimezone("Asia/Shanghai")
+			So(shanghai.From, ShouldEqual, "1577836800000")
+			So(shanghai.To, ShouldEqual, "1577840400000")
+			So(shanghai.FromFormatted(), ShouldEqual, "Wed Jan  1 08:00:00 CST 2020")
+			So(tr.WithDashboardTimezone("").From, ShouldEqual, "1577865600000")
+
+			shifted, err := tr.Shift("1d")
+			So(err, ShouldBeNil)
+			So(shifted.WithDashboardTimezone("Asia/Shanghai").From, ShouldEqual, "1577750400000")
+		})
+
+		Convey("Invalid times, time zones and ranges should return error", func() {
+			_, err := NewTimeRange("now-1x", "now", "")
+			So(err, ShouldNotBeNil)
+			_, err = NewTimeRange("now-1h", "now", "Mars/Olympus")
+			So(err, ShouldNotBeNil)
+			_, err = NewTimeRange("now", "now-1h", "")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Times should be formatted in the time zone of the time range", func() {
+			tr, err := NewTimeRange("1452098072000", "1452101672000", "utc")
+			So(err, ShouldBeNil)
+			So(tr.FromFormatted(), ShouldEqual, "Wed Jan  6 16:34:32 UTC 2016")
+
+			tr = TimeRange{From: "1452098072000", To: "1452101672000"}.WithDashboardTimezone("Asia/Shanghai")
+			So(tr.FromFormatted(), ShouldEqual, "Thu Jan  7 00:34:32 CST 2016")
+			So(tr.WithDashboardTimezone("utc").Location, ShouldEqual, tr.Location)
+		})
+	})
+}
+
+func TestShiftTimeRange(tst *testing.T) {
+	Convey("When shifting a time range back", tst, func() {
+		tr, err := NewTimeRange("2018-12-04T08:00:00Z", "2018-12-04T09:00:00Z", "Asia/Shanghai")
+		So(err, ShouldBeNil)
+
+		Convey("Both ends should be moved back by the shift in the same time zone", func() {
+			shifted, err := tr.Shift("7d")
+			So(err, ShouldBeNil)
+			So(shifted.Location, ShouldEqual, tr.Location)
+			from, err := shifted.FromTime()
+			So(err, ShouldBeNil)
+			So(from, sameTimeAs, time.Date(2018, 11, 27, 8, 0, 0, 0, time.UTC).In(tr.Location))
+			to, err := shifted.ToTime()
+			So(err, ShouldBeNil)
+			So(to, sameTimeAs, time.Date(2018, 11, 27, 9, 0, 0, 0, time.UTC).In(tr.Location))
+		})
+
+		Convey("Relative time ranges should be shifted to absolute ones", func() {
+			shifted, err := TimeRange{From: "now-1h", To: "now"}.Shift("1w")
+			So(err, ShouldBeNil)
+			to, err := shifted.ToTime()
+			So(err, ShouldBeNil)
+			So(time.Since(to), ShouldBeBetween, 7*24*time.Hour-time.Minute, 7*24*time.Hour+time.Minute)
+		})
+
+		Convey("Unrecognised shifts should be errors", func() {
+			for _, shift := range []string{"", "7", "-7d", "7x", "now-7d"} {
+				_, err := tr.Shift(shift)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
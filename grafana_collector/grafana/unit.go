@@ -0,0 +1,100 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+var (
+	byteUnits     = []string{" B", " KiB", " MiB", " GiB", " TiB", " PiB"}
+	decByteUnits  = []string{" B", " kB", " MB", " GB", " TB", " PB"}
+	bitUnits      = []string{" b", " Kib", " Mib", " Gib", " Tib", " Pib"}
+	shortUnits    = []string{"", " K", " Mil", " Bil", " Tri"}
+	rateUnitNames = map[string]string{
+		"ops":   " ops/s",
+		"reqps": " req/s",
+		"rps":   " rd/s",
+		"wps":   " wr/s",
+		"iops":  " io/s",
+		"opm":   " ops/min",
+	}
+)
+
+// FormatValue ... formats value in the unit of panel like Grafana does, values in unknown units are formatted as
+// short numbers with the unit
+func FormatValue(v float64, unit string) string {
+	if math.IsInf(v, 0) {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	switch unit {
+	case "", "none", "short":
+		return scaleValue(v, 1000, shortUnits)
+	case "percent":
+		return fmt.Sprintf("%.2f%%", v)
+	case "percentunit":
+		return fmt.Sprintf("%.2f%%", v*100)
+	case "bytes":
+		return scaleValue(v, 1024, byteUnits)
+	case "decbytes":
+		return scaleValue(v, 1000, decByteUnits)
+	case "bits":
+		return scaleValue(v, 1024, bitUnits)
+	case "ns":
+		return formatDuration(v / 1e9)
+	case "µs":
+		return formatDuration(v / 1e6)
+	case "ms":
+		return formatDuration(v / 1e3)
+	case "s":
+		return formatDuration(v)
+	}
+	if suffix, ok := rateUnitNames[unit]; ok {
+		return scaleValue(v, 1000, shortUnits) + suffix
+	}
+	return scaleValue(v, 1000, shortUnits) + " " + unit
+}
+
+// scaleValue ... divides v by base until it is less than base, and formats it with the unit of its scale
+func scaleValue(v float64, base float64, units []string) string {
+	i := 0
+	for ; math.Abs(v) >= base && i < len(units)-1; i++ {
+		v /= base
+	}
+	return fmt.Sprintf("%.2f%s", v, units[i])
+}
+
+// formatDuration ... formats seconds in the largest unit in which it is at least 1
+func formatDuration(seconds float64) string {
+	abs := math.Abs(seconds)
+	switch {
+	case abs == 0:
+		return "0 s"
+	case abs < 1e-6:
+		return fmt.Sprintf("%.2f ns", seconds*1e9)
+	case abs < 1e-3:
+		return fmt.Sprintf("%.2f µs", seconds*1e6)
+	case abs < 1:
+		return fmt.Sprintf("%.2f ms", seconds*1e3)
+	case abs < 60:
+		return fmt.Sprintf("%.2f s", seconds)
+	case abs < 3600:
+		return fmt.Sprintf("%.2f min", seconds/60)
+	case abs < 86400:
+		return fmt.Sprintf("%.2f hour", seconds/3600)
+	}
+	return fmt.Sprintf("%.2f day", seconds/86400)
+}
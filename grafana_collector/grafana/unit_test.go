@@ -0,0 +1,34 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFormatValue(t *testing.T) {
+	Convey("When formatting values in units of panels", t, func() {
+		So(FormatValue(1234567, ""), ShouldEqual, "1.23 Mil")
+		So(FormatValue(12.345, "percent"), ShouldEqual, "12.35%")
+		So(FormatValue(0.5, "percentunit"), ShouldEqual, "50.00%")
+		So(FormatValue(3*1024*1024, "bytes"), ShouldEqual, "3.00 MiB")
+		So(FormatValue(1500, "decbytes"), ShouldEqual, "1.50 kB")
+		So(FormatValue(0.0025, "s"), ShouldEqual, "2.50 ms")
+		So(FormatValue(90, "s"), ShouldEqual, "1.50 min")
+		So(FormatValue(1500, "ops"), ShouldEqual, "1.50 K ops/s")
+		So(FormatValue(7, "celsius"), ShouldEqual, "7.00 celsius")
+	})
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mail
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Attachment is a file attached to a mail
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Message is a mail of plain text body with attachments
+type Message struct {
+	To          []string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// Sender sends mails through an SMTP server
+type Sender struct {
+	Addr     string // host:port of SMTP server
+	From     string
+	Username string // mails are sent without AUTH if it is empty
+	Password string
+	// StartTLS requires the server to upgrade the connection by STARTTLS, otherwise mails are sent in plain text,
+	// which is only meant for local servers
+	StartTLS           bool
+	InsecureSkipVerify bool
+	Timeout            time.Duration // timeout of a whole SMTP session, 0 is unlimited
+}
+
+// Send ... sends msg in one SMTP session, it is not retried
+func (s Sender) Send(msg Message) error {
+	if len(msg.To) == 0 {
+		return errors.New("mail has no recipients")
+	}
+	for _, addr := range append([]string{s.From}, msg.To...) {
+		if _, err := netmail.ParseAddress(addr); err != nil {
+			return errors.Errorf("invalid mail address %q: %v", addr, err)
+		}
+	}
+	data, err := s.encode(msg, time.Now())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return errors.Errorf("invalid smtp address %s: %v", s.Addr, err)
+	}
+	conn, err := net.DialTimeout("tcp", s.Addr, s.Timeout)
+	if err != nil {
+		return errors.Errorf("connecting smtp server %s error: %v", s.Addr, err)
+	}
+	if s.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.Timeout))
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return errors.Errorf("greeting smtp server %s error: %v", s.Addr, err)
+	}
+	defer c.Close()
+
+	if s.StartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.Errorf("smtp server %s doesn't support STARTTLS", s.Addr)
+		}
+		if err = c.StartTLS(&tls.Config{ServerName: host, InsecureSkipVerify: s.InsecureSkipVerify}); err != nil {
+			return errors.Errorf("STARTTLS with smtp server %s error: %v", s.Addr, err)
+		}
+	}
+	if s.Username != "" {
+		// PlainAuth refuses to send the password over an unencrypted connection to servers other than localhost
+		if err = c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return errors.Errorf("authenticating with smtp server %s error: %v", s.Addr, err)
+		}
+	}
+	if err = c.Mail(s.From); err != nil {
+		return errors.Errorf("smtp server %s rejected sender %s: %v", s.Addr, s.From, err)
+	}
+	for _, to := range msg.To {
+		if err = c.Rcpt(to); err != nil {
+			return errors.Errorf("smtp server %s rejected recipient %s: %v", s.Addr, to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return errors.Errorf("sending mail to smtp server %s error: %v", s.Addr, err)
+	}
+	if _, err = w.Write(data); err != nil {
+		return errors.Errorf("sending mail to smtp server %s error: %v", s.Addr, err)
+	}
+	if err = w.Close(); err != nil {
+		return errors.Errorf("smtp server %s rejected mail: %v", s.Addr, err)
+	}
+	// the mail is accepted, errors of QUIT are ignored
+	c.Quit()
+	return nil
+}
+
+// encode ... returns msg as a MIME multipart mail, the body is the first part and every attachment is a base64 part
+func (s Sender) encode(msg Message, date time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", s.From)
+	header("To", strings.Join(msg.To, ", "))
+	// line breaks of templates must not end the header
+	subject := strings.Join(strings.Fields(msg.Subject), " ")
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "multipart/mixed; boundary="+w.Boundary())
+	buf.WriteString("\r\n")
+
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err = qp.Write([]byte(msg.Body)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = qp.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, a := range msg.Attachments {
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		part, err = w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": a.Name})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		// base64 lines of mails are at most 76 characters
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	if err = w.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mail
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	netmail "net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// selfSignedTLS returns the TLS config of a self-signed certificate for 127.0.0.1
+func selfSignedTLS() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, nil
+}
+
+func TestSend(t *testing.T) {
+	Convey("When mails are sent to a local SMTP sink", t, func() {
+		msg := Message{
+			To:          []string{"ops@example.com", "dba@example.com"},
+			Subject:     "Report of TiKV\r\nBcc: evil@example.com",
+			Body:        "Report of TiKV is attached.",
+			Attachments: []Attachment{{Name: "tikv.pdf", ContentType: "application/pdf", Data: bytes.Repeat([]byte("%PDF"), 100)}},
+		}
+
+		Convey("A mail should be sent in plain text with its attachment", func() {
+			sink, err := NewSink(nil, "", "")
+			So(err, ShouldBeNil)
+			defer sink.Close()
+			sender := Sender{Addr: sink.Addr(), From: "collector@example.com", Timeout: 10 * time.Second}
+			So(sender.Send(msg), ShouldBeNil)
+
+			messages := sink.Messages()
+			So(messages, ShouldHaveLength, 1)
+			So(messages[0].From, ShouldEqual, "collector@example.com")
+			So(messages[0].To, ShouldResemble, msg.To)
+
+			m, err := netmail.ReadMessage(bytes.NewReader(messages[0].Data))
+			So(err, ShouldBeNil)
+			So(m.Header.Get("To"), ShouldEqual, "ops@example.com, dba@example.com")
+			So(m.Header.Get("Bcc"), ShouldBeEmpty)
+			subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+			So(err, ShouldBeNil)
+			So(subject, ShouldEqual, "Report of TiKV Bcc: evil@example.com")
+
+			_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+			So(err, ShouldBeNil)
+			r := multipart.NewReader(m.Body, params["boundary"])
+			part, err := r.NextPart()
+			So(err, ShouldBeNil)
+			body, err := ioutil.ReadAll(part)
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, msg.Body)
+			part, err = r.NextPart()
+			So(err, ShouldBeNil)
+			So(part.FileName(), ShouldEqual, "tikv.pdf")
+			So(part.Header.Get("Content-Type"), ShouldStartWith, "application/pdf")
+			So(part.Header.Get("Content-Transfer-Encoding"), ShouldEqual, "base64")
+		})
+
+		Convey("A mail should be sent over STARTTLS with AUTH", func() {
+			tlsConfig, err := selfSignedTLS()
+			So(err, ShouldBeNil)
+			sink, err := NewSink(tlsConfig, "collector", "secret")
+			So(err, ShouldBeNil)
+			defer sink.Close()
+
+			sender := Sender{Addr: sink.Addr(), From: "collector@example.com", Username: "collector", Password: "secret",
+				StartTLS: true, Timeout: 10 * time.Second}
+			err = sender.Send(msg)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "STARTTLS")
+
+			sender.InsecureSkipVerify = true
+			So(sender.Send(msg), ShouldBeNil)
+			So(sink.Messages(), ShouldHaveLength, 1)
+
+			sender.Password = "wrong"
+			err = sender.Send(msg)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "authenticating")
+		})
+
+		Convey("STARTTLS should be required if it is enabled", func() {
+			sink, err := NewSink(nil, "", "")
+			So(err, ShouldBeNil)
+			defer sink.Close()
+			sender := Sender{Addr: sink.Addr(), From: "collector@example.com", StartTLS: true}
+			err = sender.Send(msg)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "doesn't support STARTTLS")
+			So(sink.Messages(), ShouldBeEmpty)
+		})
+
+		Convey("Rejected mails and invalid addresses should fail", func() {
+			sink, err := NewSink(nil, "", "")
+			So(err, ShouldBeNil)
+			defer sink.Close()
+			sender := Sender{Addr: sink.Addr(), From: "collector@example.com"}
+			sink.Reject(1)
+			err = sender.Send(msg)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "451")
+			So(sender.Send(msg), ShouldBeNil)
+
+			msg.To = []string{"ops@example.com\r\nDATA"}
+			So(sender.Send(msg), ShouldNotBeNil)
+			msg.To = nil
+			So(sender.Send(msg), ShouldNotBeNil)
+			So(sink.Messages(), ShouldHaveLength, 1)
+			So(strings.Count(string(sink.Messages()[0].Data), "tikv.pdf"), ShouldBeGreaterThan, 0)
+		})
+	})
+}
@@ -0,0 +1,208 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mail
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// SinkMessage is a mail received by Sink
+type SinkMessage struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// Sink is a local SMTP server which keeps the mails it receives, to test mail delivery without a mail server
+type Sink struct {
+	listener  net.Listener
+	tlsConfig *tls.Config // STARTTLS is offered if it is not nil
+	username  string      // AUTH PLAIN is required if it is not empty
+	password  string
+
+	mu       sync.Mutex
+	messages []SinkMessage
+	failures int // the number of next mails to reject temporarily
+}
+
+// NewSink ... starts a sink at a random port of 127.0.0.1. It offers STARTTLS with tlsConfig if it is not nil, and
+// requires AUTH PLAIN with username and password if username is not empty
+func NewSink(tlsConfig *tls.Config, username, password string) (*Sink, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Errorf("listening smtp sink error: %v", err)
+	}
+	s := &Sink{listener: l, tlsConfig: tlsConfig, username: username, password: password}
+	go s.serve()
+	return s, nil
+}
+
+// Addr ... returns the host:port of sink
+func (s *Sink) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close ... stops accepting connections
+func (s *Sink) Close() error {
+	return errors.WithStack(s.listener.Close())
+}
+
+// Messages ... returns the mails received so far
+func (s *Sink) Messages() []SinkMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SinkMessage(nil), s.messages...)
+}
+
+// Reject ... rejects the next n mails with a temporary error, to test retries
+func (s *Sink) Reject(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = n
+}
+
+func (s *Sink) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle ... talks SMTP with a client until it quits, only the commands used by net/smtp are supported
+func (s *Sink) handle(conn net.Conn) {
+	text := textproto.NewConn(conn)
+	defer func() { text.Close() }()
+	var (
+		msg     SinkMessage
+		secured bool
+		authed  bool
+	)
+	reply := func(code int, lines ...string) {
+		for i, line := range lines {
+			sep := "-"
+			if i == len(lines)-1 {
+				sep = " "
+			}
+			text.PrintfLine("%d%s%s", code, sep, line)
+		}
+	}
+
+	reply(220, "grafana_collector smtp sink")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			lines := []string{"grafana_collector smtp sink"}
+			if s.tlsConfig != nil && !secured {
+				lines = append(lines, "STARTTLS")
+			}
+			if s.username != "" {
+				lines = append(lines, "AUTH PLAIN")
+			}
+			reply(250, lines...)
+		case "STARTTLS":
+			if s.tlsConfig == nil || secured {
+				reply(502, "STARTTLS is not supported")
+				continue
+			}
+			reply(220, "ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err = tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, text, secured = tlsConn, textproto.NewConn(tlsConn), true
+		case "AUTH":
+			fields := strings.Fields(arg)
+			if len(fields) != 2 || strings.ToUpper(fields[0]) != "PLAIN" {
+				reply(504, "only AUTH PLAIN with initial response is supported")
+				continue
+			}
+			credentials, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil || !bytes.Equal(credentials, []byte("\x00"+s.username+"\x00"+s.password)) {
+				reply(535, "authentication failed")
+				continue
+			}
+			authed = true
+			reply(235, "authenticated")
+		case "MAIL":
+			if s.username != "" && !authed {
+				reply(530, "authentication required")
+				continue
+			}
+			msg = SinkMessage{From: address(arg)}
+			reply(250, "ok")
+		case "RCPT":
+			msg.To = append(msg.To, address(arg))
+			reply(250, "ok")
+		case "DATA":
+			reply(354, "end data with <CR><LF>.<CR><LF>")
+			if msg.Data, err = text.ReadDotBytes(); err != nil {
+				return
+			}
+			s.mu.Lock()
+			rejected := s.failures > 0
+			if rejected {
+				s.failures--
+			} else {
+				s.messages = append(s.messages, msg)
+			}
+			s.mu.Unlock()
+			if rejected {
+				reply(451, "try again later")
+			} else {
+				reply(250, "ok")
+			}
+			msg = SinkMessage{}
+		case "RSET":
+			msg = SinkMessage{}
+			reply(250, "ok")
+		case "NOOP":
+			reply(250, "ok")
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			reply(502, "command is not supported")
+		}
+	}
+}
+
+// address ... returns the address of MAIL FROM:<a> or RCPT TO:<a>
+func address(arg string) string {
+	if i := strings.IndexByte(arg, '<'); i >= 0 {
+		arg = arg[i+1:]
+	}
+	if i := strings.IndexByte(arg, '>'); i >= 0 {
+		arg = arg[:i]
+	}
+	return arg
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mail
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Data is the fields of a report which the templates of mails can use, e.g. {{.Title}} or {{.From}}
+type Data struct {
+	ID           string // ID of report job or archived report
+	Schedule     string // name of schedule, empty for report jobs
+	Dashboard    string // uid or name of dashboard
+	Title        string // title of dashboard or bundle
+	From         string // formatted start of time range
+	To           string // formatted end of time range
+	FailedPanels int
+}
+
+// Template is the Go templates of the recipients, subject and body of mails
+type Template struct {
+	to      []*template.Template
+	subject *template.Template
+	body    *template.Template
+}
+
+// NewTemplate ... parses the templates of mails. A recipient template can give several comma separated addresses
+func NewTemplate(to []string, subject, body string) (*Template, error) {
+	if len(to) == 0 {
+		return nil, errors.New("mail has no recipients")
+	}
+	t := &Template{}
+	for _, s := range to {
+		tmpl, err := template.New("to").Parse(s)
+		if err != nil {
+			return nil, errors.Errorf("parsing template of recipient %q error: %v", s, err)
+		}
+		t.to = append(t.to, tmpl)
+	}
+	var err error
+	if t.subject, err = template.New("subject").Parse(subject); err != nil {
+		return nil, errors.Errorf("parsing template of subject error: %v", err)
+	}
+	if t.body, err = template.New("body").Parse(body); err != nil {
+		return nil, errors.Errorf("parsing template of body error: %v", err)
+	}
+	return t, nil
+}
+
+// Message ... executes the templates with data, empty recipients are dropped
+func (t *Template) Message(data Data) (Message, error) {
+	var msg Message
+	execute := func(tmpl *template.Template) (string, error) {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return "", errors.Errorf("executing template of %s error: %v", tmpl.Name(), err)
+		}
+		return buf.String(), nil
+	}
+	for _, tmpl := range t.to {
+		s, err := execute(tmpl)
+		if err != nil {
+			return msg, errors.WithStack(err)
+		}
+		for _, addr := range strings.Split(s, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				msg.To = append(msg.To, addr)
+			}
+		}
+	}
+	if len(msg.To) == 0 {
+		return msg, errors.New("mail has no recipients")
+	}
+	var err error
+	if msg.Subject, err = execute(t.subject); err != nil {
+		return msg, errors.WithStack(err)
+	}
+	msg.Body, err = execute(t.body)
+	return msg, errors.WithStack(err)
+}
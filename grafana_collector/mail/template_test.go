@@ -0,0 +1,56 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mail
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTemplate(t *testing.T) {
+	Convey("When mails are built from templates", t, func() {
+		data := Data{ID: "daily-20181204-010000", Schedule: "daily", Dashboard: "000000011", Title: "TiKV",
+			From: "2018-12-03 00:00:00", To: "2018-12-04 00:00:00", FailedPanels: 2}
+
+		Convey("Recipients, subject and body should be executed with the report", func() {
+			tmpl, err := NewTemplate([]string{"{{.Schedule}}@example.com", " ops@example.com, ,dba@example.com"},
+				"Report of {{.Title}}", "{{.Title}} from {{.From}} to {{.To}}{{if .FailedPanels}}, {{.FailedPanels}} panels failed{{end}}")
+			So(err, ShouldBeNil)
+			msg, err := tmpl.Message(data)
+			So(err, ShouldBeNil)
+			So(msg.To, ShouldResemble, []string{"daily@example.com", "ops@example.com", "dba@example.com"})
+			So(msg.Subject, ShouldEqual, "Report of TiKV")
+			So(msg.Body, ShouldEqual, "TiKV from 2018-12-03 00:00:00 to 2018-12-04 00:00:00, 2 panels failed")
+		})
+
+		Convey("Invalid templates and mails without recipients should fail", func() {
+			_, err := NewTemplate(nil, "", "")
+			So(err, ShouldNotBeNil)
+			_, err = NewTemplate([]string{"{{.Title"}, "", "")
+			So(err, ShouldNotBeNil)
+			_, err = NewTemplate([]string{"ops@example.com"}, "{{if}}", "")
+			So(err, ShouldNotBeNil)
+
+			tmpl, err := NewTemplate([]string{"{{.Owner}}"}, "", "")
+			So(err, ShouldBeNil)
+			_, err = tmpl.Message(data)
+			So(err, ShouldNotBeNil)
+			tmpl, err = NewTemplate([]string{"{{if .Schedule}}{{.Schedule}}@example.com{{end}}"}, "", "")
+			So(err, ShouldBeNil)
+			_, err = tmpl.Message(Data{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
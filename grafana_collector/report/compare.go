@@ -0,0 +1,157 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"math"
+
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	"github.com/pkg/errors"
+	"github.com/signintech/gopdf"
+)
+
+const (
+	// labelFontSize is the font size of the time range labels of comparison reports
+	labelFontSize = 8
+	labelHeight   = labelFontSize * 1.6
+	// comparedGutter is the space between the side-by-side panels of two time ranges
+	comparedGutter = 10
+)
+
+// comparison is the second time range of a comparison report, every panel is rendered for both time ranges
+type comparison struct {
+	time    grafana.TimeRange
+	stacked bool // panels of the second range are placed below the ones of the first range, instead of on the right
+}
+
+// linePart is a line of panels placed for one or both time ranges, with the labels of the ranges above the panels
+type linePart struct {
+	items     []layoutItem
+	height    float64
+	labels    []layoutItem
+	sliceable bool // a tall table may be sliced across pages
+}
+
+// NewComparison ... creates a Report which places the panels of dashboard for timeRange and compareRange next to
+// each other, side by side or stacked
+func NewComparison(g grafana.Client, dashName string, timeRange grafana.TimeRange, compareRange grafana.TimeRange, stacked bool) Report {
+	rep := new(g, dashName, timeRange)
+	rep.compare = &comparison{time: compareRange, stacked: stacked}
+	return rep
+}
+
+// periods ... returns the number of time ranges of report, panels are rendered for every time range
+func (rep *report) periods() int {
+	if rep.compare != nil {
+		return 2
+	}
+	return 1
+}
+
+// periodTime ... returns the time range of period, 0 is the time range of report and 1 is the compared one
+func (rep *report) periodTime(period int) grafana.TimeRange {
+	if period > 0 && rep.compare != nil {
+		return rep.compare.time
+	}
+	return rep.time
+}
+
+// rangeLabels ... returns the labels of both time ranges of comparison report
+func (rep *report) rangeLabels() [2]string {
+	var labels [2]string
+	if rep.compare == nil {
+		return labels
+	}
+	for i, name := range []string{"A", "B"} {
+		t := rep.periodTime(i)
+		labels[i] = name + ": " + t.FromFormatted() + " to " + t.ToFormatted()
+	}
+	return labels
+}
+
+// compareParts ... places a line of panels for both time ranges of comparison report. Text panels don't change with
+// time ranges, so lines of text panels are placed once. Side-by-side panels are scaled to half of the page width
+func (l layout) compareParts(line []grafana.Panel) []linePart {
+	textOnly := true
+	for _, p := range line {
+		if !p.IsText() {
+			textOnly = false
+		}
+	}
+	if l.labels[0] == "" || textOnly {
+		items, height := l.placeLine(line)
+		return []linePart{{items: items, height: height, sliceable: true}}
+	}
+
+	if l.stacked {
+		parts := make([]linePart, 0, len(l.labels))
+		for period, label := range l.labels {
+			items, height := l.placePeriod(line, period)
+			parts = append(parts, linePart{
+				items:     items,
+				height:    height,
+				labels:    []layoutItem{{title: label, label: true, period: period, x: l.margin, w: l.width - 2*l.margin, h: labelHeight}},
+				sliceable: true,
+			})
+		}
+		return parts
+	}
+
+	half := l
+	half.width = (l.width-2*l.margin-comparedGutter)/2 + 2*l.margin
+	offset := half.width - 2*l.margin + comparedGutter
+	var part linePart
+	for period, label := range l.labels {
+		items, height := half.placePeriod(line, period)
+		for i := range items {
+			items[i].x += float64(period) * offset
+		}
+		part.items = append(part.items, items...)
+		part.height = math.Max(part.height, height)
+		part.labels = append(part.labels, layoutItem{
+			title: label, label: true, period: period, x: l.margin + float64(period)*offset, w: half.width - 2*l.margin, h: labelHeight,
+		})
+	}
+	return []linePart{part}
+}
+
+// placePeriod ... places a line of panels with the summaries of period
+func (l layout) placePeriod(line []grafana.Panel, period int) ([]layoutItem, float64) {
+	if period > 0 {
+		l.summaries = l.compareSummaries
+	}
+	items, height := l.placeLine(line)
+	for i := range items {
+		items[i].period = period
+	}
+	return items, height
+}
+
+// drawRangeLabel ... writes the time range label above the panels of a time range
+func (rep *report) drawRangeLabel(pdf *gopdf.GoPdf, item layoutItem) error {
+	err := pdf.SetFont(cfg.Font.Family, "", labelFontSize)
+	if err != nil {
+		return errors.Wrap(err, "set label font")
+	}
+	text, _, err := fitText(pdf, item.title, item.w)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	pdf.SetTextColor(90, 90, 90)
+	pdf.SetX(item.x)
+	pdf.SetY(item.y + (item.h-labelFontSize)/2)
+	pdf.Cell(nil, text)
+	pdf.SetTextColor(0, 0, 0)
+	return errors.Wrap(pdf.SetFont(cfg.Font.Family, "", cfg.Font.Size), "set font")
+}
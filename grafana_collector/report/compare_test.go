@@ -0,0 +1,111 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sort"
+	"testing"
+
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestComparisonLayout(t *testing.T) {
+	Convey("When placing panels of a comparison report", t, func() {
+		// 1200 points wide content makes 1 point per pixel
+		l := layout{width: 1240, height: 1000, margin: 20, titleHeight: 20, labels: [2]string{"A", "B"}}
+		panels := []grafana.Panel{
+			{ID: 1, RowTitle: "row", GridPos: grafana.GridPos{W: 24, H: 8}},
+			{ID: 2, Type: "text", RowTitle: "row", GridPos: grafana.GridPos{Y: 8, W: 24, H: 4}},
+		}
+
+		Convey("Panels of both time ranges should be placed side by side under their labels", func() {
+			page := l.place(panels)[0]
+			So(page, ShouldHaveLength, 1+2+2+1)
+			So(page[0].title, ShouldEqual, "row")
+			So(page[1].label, ShouldBeTrue)
+			So(page[2].title, ShouldEqual, "B")
+			So(page[2].x, ShouldEqual, 20+595+comparedGutter)
+
+			left, right := page[3], page[4]
+			So(left.period, ShouldEqual, 0)
+			So(right.period, ShouldEqual, 1)
+			So(left.y, ShouldEqual, 40+labelHeight)
+			So(right.y, ShouldEqual, left.y)
+			So(right.x, ShouldEqual, left.x+595+comparedGutter)
+			So(right.w, ShouldEqual, left.w)
+			So(left.w+right.w, ShouldBeLessThan, 1200)
+
+			Convey("Text panels should be placed once", func() {
+				So(page[5].panel.ID, ShouldEqual, 2)
+				So(page[5].w, ShouldBeGreaterThan, 1100)
+			})
+		})
+
+		Convey("Panels of the compared time range should be stacked below the first range", func() {
+			l.stacked = true
+			page := l.place(panels)[0]
+			So(page, ShouldHaveLength, 1+2+2+1)
+			So(page[1].title, ShouldEqual, "A")
+			So(page[2].period, ShouldEqual, 0)
+			So(page[3].title, ShouldEqual, "B")
+			So(page[3].y, ShouldEqual, page[2].y+page[2].h+grafana.GridCellVMargin*l.scale())
+			So(page[4].period, ShouldEqual, 1)
+			So(page[4].w, ShouldEqual, page[2].w)
+		})
+
+		Convey("Labels and compared panels should not be in the outline", func() {
+			doc := newDocument(l, []section{{panels: panels}})
+			So(doc.outline, ShouldHaveLength, 1)
+			So(doc.outline[0].children, ShouldHaveLength, 2)
+		})
+	})
+}
+
+func TestGenerateComparison(t *testing.T) {
+	Convey("When generating a comparison report", t, func() {
+		panels := []grafana.Panel{{ID: 1, Type: "graph", Title: "QPS", Targets: []grafana.Target{{RefID: "A", Expr: "up"}}, GridPos: grafana.GridPos{W: 24, H: 8}}}
+		g := &mockClient{dash: grafana.Dashboard{Title: "Dash", Panels: panels}}
+		SetFontDir("../ttf/")
+		before := grafana.TimeRange{From: "1543910400000", To: "1543914000000"}
+		rep := NewComparison(g, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, before, false).(*report)
+		rep.ws = newTestWorkspace()
+		defer rep.Clean()
+
+		pdf, err := rep.Generate(context.Background())
+		So(err, ShouldBeNil)
+		defer pdf.Close()
+		b, err := ioutil.ReadAll(pdf)
+		So(err, ShouldBeNil)
+
+		Convey("Every panel should be rendered for both time ranges", func() {
+			sort.Strings(g.renderedAt)
+			So(g.renderedAt, ShouldResemble, []string{"1543910400000", "now-1h"})
+			So(rep.dashboards[0].compareSummaries[1], ShouldHaveLength, 12)
+			So(rep.Progress(), ShouldResemble, Progress{Total: 2, Done: 2})
+			_, err := rep.ws.read(imgFileName(0, panels[0], 1))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Both time ranges should be labelled on the panel page", func() {
+			labels := rep.rangeLabels()
+			So(labels[0], ShouldStartWith, "A: ")
+			So(labels[1], ShouldStartWith, "B: "+before.FromFormatted())
+			So(bytes.Count(b, []byte("/Type /Page\n")), ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,174 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/signintech/gopdf"
+)
+
+// appendixTitle is the heading of the appendix which lists failed panels
+const appendixTitle = "Failed panels"
+
+// Failure is a panel which failed to render, it is drawn as a placeholder box and listed in the appendix
+type Failure struct {
+	Dashboard string `json:"dashboard"`
+	PanelID   int    `json:"panelId"`
+	Title     string `json:"title"`
+	Range     string `json:"range,omitempty"` // B for the compared time range of comparison report
+	Error     string `json:"error"`
+}
+
+// failedPanel is a failure with the position of its panel in report
+type failedPanel struct {
+	Failure
+	order  int // order of render task, failures are listed in the order of panels
+	index  int // index of the dashboard in report
+	period int
+}
+
+// Failures returns the panels which failed to render in the order of panels, it is safe to call it while Generate
+// is running
+func (rep *report) Failures() []Failure {
+	rep.mu.Lock()
+	failed := append([]failedPanel(nil), rep.failures...)
+	rep.mu.Unlock()
+
+	sort.Slice(failed, func(i, j int) bool { return failed[i].order < failed[j].order })
+	failures := make([]Failure, 0, len(failed))
+	for _, f := range failed {
+		failures = append(failures, f.Failure)
+	}
+	return failures
+}
+
+// recordFailure ... records the panel of task which failed to render, the caller must hold rep.mu
+func (rep *report) recordFailure(t renderTask, err error) {
+	f := failedPanel{
+		Failure: Failure{Dashboard: t.dashboard.dash.Title, PanelID: t.panel.ID, Title: panelTitle(t.panel), Error: err.Error()},
+		order:   t.order,
+		index:   t.index,
+		period:  t.period,
+	}
+	if t.period > 0 {
+		f.Range = "B"
+	}
+	rep.failures = append(rep.failures, f)
+}
+
+// failure ... returns the failure of the panel of item, if it failed to render
+func (rep *report) failure(item layoutItem) (Failure, bool) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	for _, f := range rep.failures {
+		if f.index == item.section && f.period == item.period && f.PanelID == item.panel.ID {
+			return f.Failure, true
+		}
+	}
+	return Failure{}, false
+}
+
+// drawPlaceholder ... draws a box with the title of failed panel and the reason in place of its image
+func (rep *report) drawPlaceholder(pdf *gopdf.GoPdf, item layoutItem, f Failure) error {
+	pdf.SetLineWidth(0.5)
+	pdf.SetStrokeColor(200, 80, 80)
+	pdf.SetFillColor(253, 236, 236)
+	pdf.RectFromUpperLeftWithStyle(item.x, item.y, item.w, item.h-item.tableHeight, "FD")
+	pdf.SetFillColor(0, 0, 0)
+	pdf.SetStrokeColor(0, 0, 0)
+
+	paragraphs := []string{f.Title, "", "Failed to render: " + f.Error}
+	err := writeParagraphs(pdf, paragraphs, item.x, item.y, item.w, item.h-item.tableHeight)
+	return errors.Wrapf(err, "write placeholder of panel %d", f.PanelID)
+}
+
+// appendixLineHeight ... returns the height of a line of the appendix
+func appendixLineHeight() float64 {
+	return float64(textFontSize) * 1.4
+}
+
+// planAppendix ... wraps the failures into lines of appendix pages, and adds the pages and their bookmark to doc.
+// There is no appendix if no panel failed
+func (rep *report) planAppendix(pdf *gopdf.GoPdf, doc *document) ([][]string, error) {
+	failures := rep.Failures()
+	if len(failures) == 0 {
+		return nil, nil
+	}
+	err := pdf.SetFont(cfg.Font.Family, "", textFontSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "set text font")
+	}
+
+	l := doc.layout
+	var lines []string
+	for _, f := range failures {
+		entry := fmt.Sprintf("%s / %s (panel %d)", f.Dashboard, f.Title, f.PanelID)
+		if f.Range != "" {
+			entry += ", range " + f.Range
+		}
+		entryLines, err := wrapText(pdf.MeasureTextWidth, entry+": "+f.Error, l.width-2*l.margin)
+		if err != nil {
+			return nil, errors.Wrap(err, "wrap failure")
+		}
+		lines = append(lines, entryLines...)
+	}
+
+	perPage := int((l.height - 2*l.margin - l.titleHeight) / appendixLineHeight())
+	if perPage < 1 {
+		perPage = 1
+	}
+	var pages [][]string
+	for len(lines) > perPage {
+		pages = append(pages, lines[:perPage])
+		lines = lines[perPage:]
+	}
+	pages = append(pages, lines)
+
+	doc.appendixPages = len(pages)
+	doc.outline = append(doc.outline, outlineItem{title: appendixTitle, page: doc.firstPanelPage() + len(doc.pages), y: l.margin})
+	return pages, errors.Wrap(pdf.SetFont(cfg.Font.Family, "", cfg.Font.Size), "set font")
+}
+
+// createAppendix ... adds the appendix pages which list failed panels after panel pages
+func (rep *report) createAppendix(pdf *gopdf.GoPdf, doc document, pages [][]string) error {
+	l := doc.layout
+	for i, lines := range pages {
+		pdf.AddPage()
+		err := rep.drawHeaderFooter(pdf, doc, rep.reportTitle(), doc.firstPanelPage()+len(doc.pages)+i)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		pdf.SetX(l.margin)
+		pdf.SetY(l.margin)
+		pdf.Cell(nil, appendixTitle)
+
+		err = pdf.SetFont(cfg.Font.Family, "", textFontSize)
+		if err != nil {
+			return errors.Wrap(err, "set text font")
+		}
+		for j, line := range lines {
+			pdf.SetX(l.margin)
+			pdf.SetY(l.margin + l.titleHeight + float64(j)*appendixLineHeight())
+			pdf.Cell(nil, line)
+		}
+		err = pdf.SetFont(cfg.Font.Family, "", cfg.Font.Size)
+		if err != nil {
+			return errors.Wrap(err, "set font")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGeneratePartialFailure(t *testing.T) {
+	Convey("When generating a report with failed panels", t, func() {
+		var panels []grafana.Panel
+		for i := 0; i < 3; i++ {
+			panels = append(panels, grafana.Panel{ID: i + 1, Type: "graph", Title: "Panel", GridPos: grafana.GridPos{Y: i * 8, W: 24, H: 8},
+				Targets: []grafana.Target{{RefID: "A", Expr: "sum(rate(tidb_server_query_total[1m]))"}}})
+		}
+		g := &mockClient{dash: grafana.Dashboard{Title: "Dash", Panels: panels}, failed: map[int]bool{2: true}}
+		rep := newTestReport(g)
+		defer rep.Clean()
+
+		pdf, err := rep.Generate(context.Background())
+		So(err, ShouldBeNil)
+		defer pdf.Close()
+		b, err := ioutil.ReadAll(pdf)
+		So(err, ShouldBeNil)
+
+		Convey("The report should be generated with the other panels", func() {
+			So(g.rendered, ShouldHaveLength, 3)
+			So(rep.Progress(), ShouldResemble, Progress{Total: 3, Done: 2, Failed: 1})
+			So(rep.Failures(), ShouldResemble, []Failure{{Dashboard: "Dash", PanelID: 2, Title: "Panel", Error: rep.Failures()[0].Error}})
+			So(rep.Failures()[0].Error, ShouldContainSubstring, "rendering panel 2 timeout")
+		})
+
+		Convey("The failure reason should not dump the panel", func() {
+			So(rep.Failures()[0].Error, ShouldStartWith, "render panel 2: ")
+			So(rep.Failures()[0].Error, ShouldNotContainSubstring, "GridPos")
+			So(rep.Failures()[0].Error, ShouldNotContainSubstring, "tidb_server_query_total")
+		})
+
+		Convey("Failed panels should be listed in an appendix page with a bookmark", func() {
+			So(bytes.Count(b, []byte("/Type /Page\n")), ShouldEqual, 1+1+1)
+			So(string(b), ShouldContainSubstring, pdfString(appendixTitle))
+		})
+	})
+
+	Convey("When every panel of a report fails", t, func() {
+		panels := []grafana.Panel{{ID: 1, Type: "graph", GridPos: grafana.GridPos{W: 24, H: 8}}}
+		g := &mockClient{dash: grafana.Dashboard{Title: "Dash", Panels: panels}, failed: map[int]bool{1: true}}
+		rep := newTestReport(g)
+		defer rep.Clean()
+
+		_, err := rep.Generate(context.Background())
+
+		Convey("The report should fail", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "all 1 panels failed")
+		})
+	})
+}
@@ -0,0 +1,357 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io"
+
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	"github.com/pkg/errors"
+)
+
+// htmlWriter writes reports as a single html file, panel images are embedded as base64 data URIs and the table of
+// contents sticks to the side of the page
+type htmlWriter struct{}
+
+// htmlPage is the content of html report
+type htmlPage struct {
+	Title      string
+	Ranges     []string // the time range of report, or the labelled time ranges of comparison report
+	Bundle     bool
+	Dashboards []htmlDashboard
+	Details    *Details
+	Failures   []Failure
+}
+
+// htmlDashboard is a dashboard of html report with its selected variables and filters
+type htmlDashboard struct {
+	Title     string
+	Anchor    string
+	Variables []string
+	Filters   []string
+	Rows      []htmlRow
+}
+
+// htmlRow is a row of dashboard, panels which are not in any row are in a row without title
+type htmlRow struct {
+	Title  string
+	Anchor string
+	Lines  [][]htmlPanel // side-by-side panels
+}
+
+// htmlPanel is a panel with its images for the time ranges of report, or the text of text panel
+type htmlPanel struct {
+	Title       string
+	Description string
+	Anchor      string
+	Width       float64 // percent of the dashboard width
+	Text        []string
+	Images      []htmlImage
+	Stacked     bool // images of compared time ranges are placed one below the other
+}
+
+// htmlImage is a panel image or the error of a failed panel, with the summary table of its series
+type htmlImage struct {
+	Label   string // A or B in comparison report
+	Src     template.URL
+	Error   string
+	Summary [][]string
+	More    int // the number of series left out of summary
+}
+
+func (htmlWriter) write(rep *report) (io.ReadCloser, error) {
+	page, err := rep.htmlPage()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var buf bytes.Buffer
+	if err = htmlTemplate.Execute(&buf, page); err != nil {
+		return nil, errors.Errorf("executing html template error: %v", err)
+	}
+	if err = rep.ws.write(reportHTML, buf.Bytes()); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	file, err := rep.ws.open(reportHTML)
+	return file, errors.Wrap(err, "open html file")
+}
+
+// htmlPage ... collects the dashboards, rows and panel images of report, panels are grouped into rows and lines as
+// they are placed on pdf pages
+func (rep *report) htmlPage() (htmlPage, error) {
+	page := htmlPage{Title: rep.reportTitle(), Bundle: rep.isBundle(), Details: rep.details, Failures: rep.Failures()}
+	if rep.compare != nil {
+		labels := rep.rangeLabels()
+		page.Ranges = labels[:]
+	} else {
+		page.Ranges = []string{rep.time.FromFormatted() + " to " + rep.time.ToFormatted()}
+	}
+
+	for i, d := range rep.dashboards {
+		hd := htmlDashboard{
+			Title:     d.dash.Title,
+			Anchor:    fmt.Sprintf("dashboard-%d", i),
+			Variables: variableLines(d.dash.Variables),
+			Filters:   filterLines(d.dash.Filter),
+		}
+		for _, line := range gridLines(d.dash.Panels) {
+			title := line[0].RowTitle
+			if len(hd.Rows) == 0 || hd.Rows[len(hd.Rows)-1].Title != title {
+				hd.Rows = append(hd.Rows, htmlRow{Title: title, Anchor: fmt.Sprintf("row-%d-%d", i, len(hd.Rows))})
+			}
+			panels := make([]htmlPanel, 0, len(line))
+			for _, p := range line {
+				hp, err := rep.htmlPanel(i, d, p)
+				if err != nil {
+					return page, errors.WithStack(err)
+				}
+				panels = append(panels, hp)
+			}
+			row := &hd.Rows[len(hd.Rows)-1]
+			row.Lines = append(row.Lines, panels)
+		}
+		page.Dashboards = append(page.Dashboards, hd)
+	}
+	return page, nil
+}
+
+// htmlPanel ... returns panel p of the dashboard of index with its images of all time ranges, failed panels have
+// their errors instead of images
+func (rep *report) htmlPanel(index int, d *dashboard, p grafana.Panel) (htmlPanel, error) {
+	hp := htmlPanel{
+		Title:       panelTitle(p),
+		Description: p.Description,
+		Anchor:      fmt.Sprintf("panel-%d-%d", index, p.ID),
+		Width:       100,
+		Stacked:     rep.compare != nil && rep.compare.stacked,
+	}
+	if p.GridPos.W > 0 {
+		hp.Width = float64(p.GridPos.W) * 100 / grafana.GridColumnCount
+	}
+	if p.IsText() {
+		hp.Text = plainText(p.TextContent())
+		return hp, nil
+	}
+
+	for period := 0; period < rep.periods(); period++ {
+		var img htmlImage
+		if rep.compare != nil {
+			img.Label = rep.rangeLabels()[period]
+		}
+		if f, ok := rep.failure(layoutItem{panel: &p, section: index, period: period}); ok {
+			img.Error = f.Error
+			hp.Images = append(hp.Images, img)
+			continue
+		}
+
+		name := imgFileName(index, p, period)
+		data, err := rep.ws.read(name)
+		if err != nil {
+			return hp, errors.WithStack(err)
+		}
+		img.Src = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(data))
+
+		summaries := d.summaries
+		if period > 0 {
+			summaries = d.compareSummaries
+		}
+		var series []grafana.SeriesSummary
+		series, img.More = summaryRows(summaries[p.ID])
+		unit := p.Unit()
+		for _, s := range series {
+			row := []string{s.Name}
+			for _, v := range []float64{s.Min, s.Avg, s.Max, s.Last, s.P99} {
+				row = append(row, grafana.FormatValue(v, unit))
+			}
+			img.Summary = append(img.Summary, row)
+		}
+		hp.Images = append(hp.Images, img)
+	}
+	return hp, nil
+}
+
+// htmlTemplate is the html report, it has no external resources so that it can be mailed and opened offline
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"columns": func() []string { return summaryColumns },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { margin: 0; display: flex; align-items: flex-start; font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #222; }
+nav { position: sticky; top: 0; flex: 0 0 260px; height: 100vh; overflow-y: auto; box-sizing: border-box; padding: 16px; background: #f5f5f5; border-right: 1px solid #ddd; font-size: 14px; }
+nav input { width: 100%; box-sizing: border-box; margin-bottom: 8px; padding: 4px; }
+nav ul { list-style: none; margin: 0; padding-left: 12px; }
+nav > ul { padding-left: 0; }
+nav a { color: #1f60c4; text-decoration: none; line-height: 1.6; }
+main { flex: 1; min-width: 0; padding: 16px 24px; }
+header { border-bottom: 1px solid #ddd; margin-bottom: 16px; }
+h1 { font-size: 24px; margin: 0 0 8px; }
+h2 { font-size: 20px; margin: 24px 0 8px; }
+h3 { font-size: 16px; margin: 16px 0 8px; padding: 6px 8px; background: #eef2f7; }
+.meta { margin: 4px 0; color: #555; font-size: 14px; }
+.details { margin: 12px 0; padding: 8px 12px; border-left: 4px solid #c4162a; background: #fdf3f4; }
+.details h2 { margin: 0 0 4px; font-size: 16px; }
+.line { display: flex; flex-wrap: wrap; }
+.panel { box-sizing: border-box; padding: 8px; }
+.panel h4 { margin: 0 0 4px; font-size: 14px; }
+.panel .description { margin: 0 0 4px; color: #666; font-size: 12px; }
+.images { display: flex; gap: 8px; }
+.images.stacked { flex-direction: column; }
+.image { flex: 1; min-width: 0; }
+.image img { width: 100%; height: auto; }
+.label { color: #666; font-size: 12px; }
+.error { padding: 16px; border: 1px dashed #c4162a; color: #c4162a; font-size: 13px; }
+.text { border: 1px solid #ddd; padding: 8px; font-size: 13px; }
+.text p { margin: 0 0 6px; }
+table { border-collapse: collapse; width: 100%; font-size: 12px; }
+th, td { border-bottom: 1px solid #eee; padding: 2px 4px; text-align: right; }
+th:first-child, td:first-child { text-align: left; word-break: break-all; }
+@media (max-width: 800px) {
+  body { display: block; }
+  nav { position: static; height: auto; border-right: none; border-bottom: 1px solid #ddd; }
+  .panel { width: 100% !important; }
+  .images { flex-direction: column; }
+}
+</style>
+</head>
+<body>
+<nav>
+<input type="search" id="search" placeholder="Search panels">
+<ul>
+{{- range .Dashboards}}
+{{- if $.Bundle}}<li><a href="#{{.Anchor}}">{{.Title}}</a><ul>{{end}}
+{{- range .Rows}}
+<li>{{if .Title}}<a href="#{{.Anchor}}">{{.Title}}</a>{{end}}<ul>
+{{- range .Lines}}{{range .}}<li class="entry"><a href="#{{.Anchor}}">{{.Title}}</a></li>{{end}}{{end}}
+</ul></li>
+{{- end}}
+{{- if $.Bundle}}</ul></li>{{end}}
+{{- end}}
+{{- if .Failures}}<li><a href="#failures">Failed panels</a></li>{{end}}
+</ul>
+</nav>
+<main>
+<header>
+<h1>{{.Title}}</h1>
+{{- range .Ranges}}
+<p class="meta">{{.}}</p>
+{{- end}}
+{{- if not .Bundle}}{{range .Dashboards}}{{range .Variables}}
+<p class="meta">{{.}}</p>
+{{- end}}{{range .Filters}}
+<p class="meta">{{.}}</p>
+{{- end}}{{end}}{{end}}
+{{- with .Details}}
+<div class="details">
+<h2>{{.Title}}</h2>
+{{- range .Lines}}
+<p class="meta">{{.}}</p>
+{{- end}}
+</div>
+{{- end}}
+</header>
+{{- range .Dashboards}}
+<section id="{{.Anchor}}">
+{{- if $.Bundle}}
+<h2>{{.Title}}</h2>
+{{- range .Variables}}
+<p class="meta">{{.}}</p>
+{{- end}}
+{{- range .Filters}}
+<p class="meta">{{.}}</p>
+{{- end}}
+{{- end}}
+{{- range .Rows}}
+<section id="{{.Anchor}}">
+{{- if .Title}}
+<h3>{{.Title}}</h3>
+{{- end}}
+{{- range .Lines}}
+<div class="line">
+{{- range .}}
+<div class="panel" id="{{.Anchor}}" style="width: {{printf "%.4f" .Width}}%">
+<h4>{{.Title}}</h4>
+{{- if .Description}}
+<p class="description">{{.Description}}</p>
+{{- end}}
+{{- if .Text}}
+<div class="text">{{range .Text}}<p>{{.}}</p>{{end}}</div>
+{{- else}}
+<div class="images{{if .Stacked}} stacked{{end}}">
+{{- range .Images}}
+<div class="image">
+{{- if .Label}}
+<div class="label">{{.Label}}</div>
+{{- end}}
+{{- if .Error}}
+<div class="error">{{.Error}}</div>
+{{- else}}
+<img src="{{.Src}}" alt="">
+{{- end}}
+{{- if .Summary}}
+<table>
+<tr>{{range columns}}<th>{{.}}</th>{{end}}</tr>
+{{- range .Summary}}
+<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{- end}}
+{{- if .More}}
+<tr><td colspan="6">{{.More}} more series</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</div>
+{{- end}}
+</div>
+{{- end}}
+</div>
+{{- end}}
+</div>
+{{- end}}
+</section>
+{{- end}}
+</section>
+{{- end}}
+{{- if .Failures}}
+<section id="failures">
+<h2>Failed panels</h2>
+<table>
+<tr><th>Dashboard</th><th>Panel</th><th>Title</th><th>Range</th><th>Error</th></tr>
+{{- range .Failures}}
+<tr><td>{{.Dashboard}}</td><td>{{.PanelID}}</td><td>{{.Title}}</td><td>{{.Range}}</td><td>{{.Error}}</td></tr>
+{{- end}}
+</table>
+</section>
+{{- end}}
+</main>
+<script>
+document.getElementById("search").addEventListener("input", function () {
+  var query = this.value.toLowerCase();
+  document.querySelectorAll(".panel").forEach(function (panel) {
+    var title = panel.querySelector("h4").textContent.toLowerCase();
+    panel.style.display = title.indexOf(query) >= 0 ? "" : "none";
+  });
+  document.querySelectorAll("nav .entry").forEach(function (entry) {
+    entry.style.display = entry.textContent.toLowerCase().indexOf(query) >= 0 ? "" : "none";
+  });
+});
+</script>
+</body>
+</html>
+`))
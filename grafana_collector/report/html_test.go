@@ -0,0 +1,111 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGenerateHTML(t *testing.T) {
+	Convey("When generating an html report", t, func() {
+		panels := []grafana.Panel{
+			{ID: 1, Type: "graph", Title: "QPS", Description: "queries <per> second", RowTitle: "Server",
+				Targets: []grafana.Target{{Expr: "qps"}}, GridPos: grafana.GridPos{W: 12, H: 8}},
+			{ID: 2, Type: "graph", Title: "Latency", RowTitle: "Server", GridPos: grafana.GridPos{X: 12, W: 12, H: 8}},
+			{ID: 3, Type: "text", Title: "Notes", Content: "# Runbook\nCheck **TiKV** first", RowTitle: "Storage",
+				GridPos: grafana.GridPos{Y: 8, W: 24, H: 4}},
+		}
+		g := &mockClient{
+			dash:   grafana.Dashboard{Title: "Dash", Panels: panels, Variables: url.Values{"var-instance": {"tikv-1", "tikv-2"}}},
+			failed: map[int]bool{2: true},
+		}
+		rep := newTestReport(g)
+		rep.SetFormat(HTML)
+		defer rep.Clean()
+
+		file, err := rep.Generate(context.Background())
+		So(err, ShouldBeNil)
+		defer file.Close()
+		b, err := ioutil.ReadAll(file)
+		So(err, ShouldBeNil)
+		html := string(b)
+
+		Convey("Panel images should be embedded in rows with a table of contents", func() {
+			So(html, ShouldStartWith, "<!DOCTYPE html>")
+			So(strings.Count(html, `src="data:image/png;base64,`), ShouldEqual, 1)
+			So(html, ShouldContainSubstring, `<h3>Server</h3>`)
+			So(html, ShouldContainSubstring, `<h3>Storage</h3>`)
+			So(html, ShouldContainSubstring, `<a href="#panel-0-1">QPS</a>`)
+			So(html, ShouldContainSubstring, `id="panel-0-1" style="width: 50.0000%"`)
+			So(html, ShouldContainSubstring, "position: sticky")
+		})
+
+		Convey("Titles, descriptions, text, summaries, variables and time range should be written", func() {
+			So(html, ShouldContainSubstring, "<h1>Dash</h1>")
+			So(html, ShouldContainSubstring, "queries &lt;per&gt; second")
+			So(html, ShouldContainSubstring, "<p>Check TiKV first</p>")
+			So(html, ShouldContainSubstring, "<td>tikv-1</td>")
+			So(html, ShouldContainSubstring, "2 more series")
+			So(html, ShouldContainSubstring, "instance: tikv-1, tikv-2")
+			So(html, ShouldContainSubstring, rep.time.FromFormatted()+" to "+rep.time.ToFormatted())
+		})
+
+		Convey("Failed panels should have their errors and be listed", func() {
+			So(html, ShouldContainSubstring, `<div class="error">`)
+			So(html, ShouldContainSubstring, `<a href="#failures">Failed panels</a>`)
+			So(html, ShouldContainSubstring, "rendering panel 2 timeout")
+		})
+	})
+
+	Convey("When generating an html comparison report", t, func() {
+		g := &mockClient{dash: grafana.Dashboard{Title: "Dash", Panels: []grafana.Panel{
+			{ID: 1, Type: "graph", Title: "QPS", GridPos: grafana.GridPos{W: 24, H: 8}},
+		}}}
+		rep := newTestReport(g)
+		rep.compare = &comparison{time: grafana.TimeRange{From: "now-7d-1h", To: "now-7d"}, stacked: true}
+		rep.SetFormat(HTML)
+		defer rep.Clean()
+		file, err := rep.Generate(context.Background())
+		So(err, ShouldBeNil)
+		defer file.Close()
+		b, err := ioutil.ReadAll(file)
+		So(err, ShouldBeNil)
+
+		Convey("Images of both time ranges should be labelled", func() {
+			So(strings.Count(string(b), `src="data:image/png;base64,`), ShouldEqual, 2)
+			So(string(b), ShouldContainSubstring, `<div class="images stacked">`)
+			So(string(b), ShouldContainSubstring, `<div class="label">B: `)
+		})
+	})
+}
+
+func TestParseFormat(t *testing.T) {
+	Convey("Formats should be parsed with pdf by default", t, func() {
+		f, err := ParseFormat("")
+		So(err, ShouldBeNil)
+		So(f, ShouldEqual, PDF)
+		f, err = ParseFormat("html")
+		So(err, ShouldBeNil)
+		So(f.ContentType(), ShouldStartWith, "text/html")
+		_, err = ParseFormat("docx")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,250 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"math"
+
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+)
+
+// layoutItem is a panel image, a row title, a dashboard heading or a time range label placed on a PDF page, in
+// points from the upper left corner
+type layoutItem struct {
+	panel   *grafana.Panel // nil for row titles, headings and labels
+	title   string
+	heading bool // the title of a dashboard in bundle report
+	label   bool // the label of a time range in comparison report
+	section int  // index of the dashboard of report
+	period  int  // index of the time range of panel image, 1 for the compared time range
+	x       float64
+	y       float64
+	w       float64
+	h       float64
+	// sliceFrom and sliceTo are the part of a tall panel image on the page, both are 0 for the whole image
+	sliceFrom float64
+	sliceTo   float64
+	// summary is printed in a table of tableHeight at the bottom of item, under the panel image
+	summary     []grafana.SeriesSummary
+	tableHeight float64
+}
+
+// layout places panels on PDF pages the same as they are on the Grafana dashboard grid
+type layout struct {
+	width       float64
+	height      float64
+	margin      float64
+	titleHeight float64
+	summaries   map[int][]grafana.SeriesSummary // series summaries of panels by panel ID
+	heading     string                          // title at the top of the first page, if it is not empty
+	// labels of the time ranges of comparison report, panels are placed for both ranges if they are not empty
+	labels           [2]string
+	stacked          bool
+	compareSummaries map[int][]grafana.SeriesSummary
+}
+
+// scale ... returns points per pixel, the dashboard grid is scaled to the page width
+func (l layout) scale() float64 {
+	return (l.width - 2*l.margin) / (grafana.GridColumnCount * grafana.GridColumnWidth)
+}
+
+// place ... returns the items on every page. Side-by-side panels are kept together in a line, and pages are
+// broken between lines. A line which is higher than a page is shrunk to fit, except that a tall table panel
+// is sliced across pages. In comparison reports, a line is placed for both time ranges
+func (l layout) place(panels []grafana.Panel) [][]layoutItem {
+	var (
+		pages    [][]layoutItem
+		page     []layoutItem
+		y        = l.margin
+		bottom   = l.height - l.margin
+		rowTitle string
+	)
+	newPage := func() {
+		pages = append(pages, page)
+		page = nil
+		y = l.margin
+	}
+
+	if l.heading != "" {
+		page = append(page, layoutItem{title: l.heading, heading: true, x: l.margin, y: y, h: l.titleHeight})
+		y += l.titleHeight
+	}
+
+	for _, line := range gridLines(panels) {
+		var titleHeight float64
+		title := line[0].RowTitle
+		if title != "" && title != rowTitle {
+			titleHeight = l.titleHeight
+		}
+		rowTitle = line[0].RowTitle
+
+		for _, part := range l.compareParts(line) {
+			items, lineHeight := part.items, part.height
+			var labelHeight float64
+			if len(part.labels) > 0 {
+				labelHeight = part.labels[0].h
+			}
+
+			sliced := part.sliceable && len(items) == 1 && items[0].panel.IsTable() &&
+				lineHeight > bottom-l.margin-titleHeight-labelHeight
+			minHeight := lineHeight
+			if sliced {
+				// a sliced table starts on the current page if a quarter of page is left
+				minHeight = (bottom - l.margin) / 4
+			}
+			if y+titleHeight+labelHeight+minHeight > bottom && len(page) > 0 {
+				newPage()
+			}
+
+			if titleHeight > 0 {
+				page = append(page, layoutItem{title: title, x: l.margin, y: y, h: titleHeight})
+				y += titleHeight
+				titleHeight = 0
+			}
+			for _, label := range part.labels {
+				label.y = y
+				page = append(page, label)
+			}
+			y += labelHeight
+
+			if sliced {
+				l.slice(items[0], &page, &y, newPage)
+				y += lineHeight - items[0].h
+				continue
+			}
+
+			if available := bottom - y; lineHeight > available {
+				shrink(items, lineHeight, available, l.margin)
+				lineHeight = available
+			}
+			for _, item := range items {
+				item.y += y
+				page = append(page, item)
+			}
+			y += lineHeight
+		}
+	}
+
+	if len(page) > 0 {
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+// slice ... places parts of a tall panel from y to the bottom of pages, until the whole panel is placed
+func (l layout) slice(item layoutItem, page *[]layoutItem, y *float64, newPage func()) {
+	bottom := l.height - l.margin
+	for from := 0.0; from < item.h; {
+		if bottom-*y < 1 {
+			newPage()
+		}
+		h := math.Min(item.h-from, bottom-*y)
+
+		part := item
+		part.y = *y
+		part.h = h
+		part.sliceFrom = from / item.h
+		part.sliceTo = (from + h) / item.h
+		*page = append(*page, part)
+
+		from += h
+		*y += h
+	}
+}
+
+// placeLine ... places panels of a line relative to the top of the line, and returns the height of the line
+func (l layout) placeLine(line []grafana.Panel) ([]layoutItem, float64) {
+	var (
+		s          = l.scale()
+		gutter     = grafana.GridCellVMargin * s
+		top        = line[0].GridPos.Y
+		lineHeight float64
+		items      = make([]layoutItem, 0, len(line))
+	)
+	for _, p := range line {
+		if p.GridPos.Y < top {
+			top = p.GridPos.Y
+		}
+	}
+
+	for i := range line {
+		pos := line[i].GridPos
+		width, height := line[i].PixelSize()
+		summary := l.summaries[line[i].ID]
+		table := summaryHeight(summary)
+		item := layoutItem{
+			panel:       &line[i],
+			x:           l.margin + float64(pos.X*grafana.GridColumnWidth)*s,
+			y:           float64((pos.Y-top)*(grafana.GridCellHeight+grafana.GridCellVMargin)) * s,
+			w:           float64(width)*s - gutter,
+			h:           float64(height)*s + table,
+			summary:     summary,
+			tableHeight: table,
+		}
+		if pos.W <= 0 {
+			item.x, item.y = l.margin, 0
+		}
+		if item.y+item.h+gutter > lineHeight {
+			lineHeight = item.y + item.h + gutter
+		}
+		items = append(items, item)
+	}
+	return items, lineHeight
+}
+
+// shrink ... scales items of a line of lineHeight to fit into available height, keeping them at the left margin.
+// Summary tables are not scaled, as their font size is fixed
+func shrink(items []layoutItem, lineHeight float64, available float64, margin float64) {
+	var table float64
+	for _, item := range items {
+		table = math.Max(table, item.tableHeight)
+	}
+	// a line of nothing but summary tables has no height to scale
+	f := 0.1
+	if lineHeight > table {
+		f = math.Max((available-table)/(lineHeight-table), 0.1)
+	}
+
+	for i := range items {
+		if items[i].panel == nil {
+			continue
+		}
+		items[i].x = margin + (items[i].x-margin)*f
+		items[i].y *= f
+		items[i].w *= f
+		items[i].h = (items[i].h-items[i].tableHeight)*f + items[i].tableHeight
+	}
+}
+
+// gridLines ... groups panels into lines of side-by-side panels. A panel starts a new line if it is below all
+// panels of the current line or in another row. Panels without grid position are lines of their own
+func gridLines(panels []grafana.Panel) [][]grafana.Panel {
+	var (
+		lines       [][]grafana.Panel
+		top, bottom int
+	)
+	for i, p := range panels {
+		pos := p.GridPos
+		if i == 0 || pos.W <= 0 || panels[i-1].GridPos.W <= 0 || p.RowTitle != panels[i-1].RowTitle ||
+			pos.Y < top || pos.Y >= bottom {
+			lines = append(lines, nil)
+			top, bottom = pos.Y, pos.Y+pos.H
+		}
+		lines[len(lines)-1] = append(lines[len(lines)-1], p)
+		if pos.Y+pos.H > bottom {
+			bottom = pos.Y + pos.H
+		}
+	}
+	return lines
+}
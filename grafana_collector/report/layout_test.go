@@ -0,0 +1,113 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"math"
+	"testing"
+
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGridLines(t *testing.T) {
+	Convey("When grouping panels into lines", t, func() {
+		panels := []grafana.Panel{
+			{ID: 1, GridPos: grafana.GridPos{X: 0, Y: 0, W: 12, H: 16}},
+			{ID: 2, GridPos: grafana.GridPos{X: 12, Y: 0, W: 12, H: 8}},
+			{ID: 3, GridPos: grafana.GridPos{X: 12, Y: 8, W: 12, H: 8}},
+			{ID: 4, GridPos: grafana.GridPos{X: 0, Y: 16, W: 24, H: 8}},
+			{ID: 5, RowTitle: "row", GridPos: grafana.GridPos{X: 0, Y: 17, W: 24, H: 8}},
+			{ID: 6, RowTitle: "row"},
+		}
+		lines := gridLines(panels)
+
+		Convey("Side-by-side panels should be kept together, and rows and panels without grid position should start new lines", func() {
+			So(lines, ShouldHaveLength, 4)
+			So(lines[0], ShouldHaveLength, 3)
+			So(lines[1][0].ID, ShouldEqual, 4)
+			So(lines[2][0].ID, ShouldEqual, 5)
+			So(lines[3][0].ID, ShouldEqual, 6)
+		})
+	})
+}
+
+func TestLayoutPlace(t *testing.T) {
+	Convey("When placing panels on pages", t, func() {
+		// 1200 points wide content makes 1 point per pixel
+		l := layout{width: 1240, height: 1000, margin: 20, titleHeight: 20}
+		halfWidth := grafana.GridPos{W: 12, H: 8}
+
+		Convey("Side-by-side panels should be scaled to the page width", func() {
+			left, right := halfWidth, halfWidth
+			right.X = 12
+			pages := l.place([]grafana.Panel{{ID: 1, GridPos: left}, {ID: 2, GridPos: right}})
+			So(pages, ShouldHaveLength, 1)
+			So(pages[0], ShouldHaveLength, 2)
+			So(pages[0][0].x, ShouldEqual, 20)
+			So(pages[0][1].x, ShouldEqual, 620)
+			So(pages[0][1].y, ShouldEqual, 20)
+			So(pages[0][1].w, ShouldEqual, 592)
+			So(pages[0][1].h, ShouldEqual, 296)
+		})
+
+		Convey("Row titles should be placed above the panels of rows", func() {
+			pages := l.place([]grafana.Panel{{ID: 1, RowTitle: "row", GridPos: halfWidth}})
+			So(pages[0][0].panel, ShouldBeNil)
+			So(pages[0][0].title, ShouldEqual, "row")
+			So(pages[0][1].y, ShouldEqual, 40)
+		})
+
+		Convey("Pages should be broken between lines", func() {
+			var panels []grafana.Panel
+			for i := 0; i < 4; i++ {
+				panels = append(panels, grafana.Panel{ID: i, GridPos: grafana.GridPos{Y: i * 8, W: 24, H: 8}})
+			}
+			pages := l.place(panels)
+			So(pages, ShouldHaveLength, 2)
+			So(pages[0], ShouldHaveLength, 3)
+			So(pages[1][0].y, ShouldEqual, 20)
+		})
+
+		Convey("A line higher than a page should be shrunk to fit", func() {
+			pages := l.place([]grafana.Panel{{ID: 1, GridPos: grafana.GridPos{W: 24, H: 40}}})
+			So(pages, ShouldHaveLength, 1)
+			So(pages[0][0].y+pages[0][0].h, ShouldBeLessThanOrEqualTo, 980)
+			So(pages[0][0].w, ShouldBeLessThan, 1200)
+		})
+
+		Convey("A line as high as its summary table should be shrunk without NaN coordinates", func() {
+			items := []layoutItem{{panel: &grafana.Panel{ID: 1}, x: 20, y: 20, w: 1200, h: 1000, tableHeight: 1000}}
+			shrink(items, 1000, 960, 20)
+			So(math.IsNaN(items[0].x), ShouldBeFalse)
+			So(math.IsNaN(items[0].y), ShouldBeFalse)
+			So(items[0].w, ShouldEqual, 120)
+			So(items[0].h, ShouldEqual, 1000)
+		})
+
+		Convey("A table higher than a page should be sliced across pages", func() {
+			pages := l.place([]grafana.Panel{
+				{ID: 1, GridPos: grafana.GridPos{W: 24, H: 10}},
+				{ID: 2, Type: "table", GridPos: grafana.GridPos{Y: 10, W: 24, H: 40}},
+			})
+			So(pages, ShouldHaveLength, 2)
+			So(pages[0][1].panel.ID, ShouldEqual, 2)
+			So(pages[0][1].sliceFrom, ShouldEqual, 0)
+			So(pages[0][1].y+pages[0][1].h, ShouldEqual, 980)
+			So(pages[1][0].sliceFrom, ShouldEqual, pages[0][1].sliceTo)
+			So(pages[1][0].y, ShouldEqual, 20)
+			So(pages[1][0].sliceTo, ShouldEqual, 1)
+		})
+	})
+}
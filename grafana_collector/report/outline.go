@@ -0,0 +1,160 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// catalogObjID is the object ID of the document catalog written by gopdf, and pagesObjID is the ID of its page tree
+const (
+	catalogObjID = 1
+	pagesObjID   = 2
+)
+
+var (
+	pageObjRegexp   = regexp.MustCompile(`(?m)^(\d+) 0 obj\n<<\n\s*/Type /Page\n`)
+	trailerRegexp   = regexp.MustCompile(`(?s)trailer\n<<\n(.*)>>\nstartxref\n(\d+)\n%%EOF\n$`)
+	trailerSizeLine = regexp.MustCompile(`/Size \d+\n`)
+	trailerSize     = regexp.MustCompile(`/Size (\d+)`)
+)
+
+// outlineItem is a bookmark of PDF outline, which links to a position on a page
+type outlineItem struct {
+	title    string
+	page     int     // index of page in PDF, the cover page is 0
+	y        float64 // from the top of page
+	row      bool    // the bookmark of a dashboard row, panels of the row are its children
+	heading  bool    // the bookmark of a dashboard in bundle report, rows of the dashboard are its children
+	children []outlineItem
+}
+
+// outlineWriter writes outline objects of an incremental update
+type outlineWriter struct {
+	buf        bytes.Buffer
+	base       int // length of the original PDF, offsets of new objects start from it
+	offsets    map[int]int
+	nextID     int
+	pageIDs    []int
+	pageHeight float64
+}
+
+// addOutline ... appends outline to PDF written by gopdf as an incremental update, as gopdf can't write outlines.
+// The catalog is replaced to reference the outline, and the outline is shown when PDF is opened
+func addOutline(pdf []byte, items []outlineItem, pageHeight float64) ([]byte, error) {
+	if len(items) == 0 {
+		return pdf, nil
+	}
+
+	trailer := trailerRegexp.FindSubmatch(pdf)
+	if trailer == nil {
+		return nil, errors.New("trailer of pdf is not found")
+	}
+	size, err := strconv.Atoi(string(trailerSize.FindSubmatch(trailer[1])[1]))
+	if err != nil {
+		return nil, errors.Errorf("parsing trailer size of pdf error: %v", err)
+	}
+
+	w := &outlineWriter{
+		base:       len(pdf),
+		offsets:    make(map[int]int),
+		nextID:     size,
+		pageHeight: pageHeight,
+	}
+	for _, m := range pageObjRegexp.FindAllSubmatch(pdf, -1) {
+		id, _ := strconv.Atoi(string(m[1]))
+		w.pageIDs = append(w.pageIDs, id)
+	}
+
+	rootID := w.alloc()
+	first, last, count, err := w.writeItems(items, rootID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	w.writeObj(rootID, fmt.Sprintf("/Type /Outlines\n/First %d 0 R\n/Last %d 0 R\n/Count %d\n", first, last, count))
+	w.writeObj(catalogObjID, fmt.Sprintf("/Type /Catalog\n/Pages %d 0 R\n/Outlines %d 0 R\n/PageMode /UseOutlines\n", pagesObjID, rootID))
+
+	xrefOffset := w.base + w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 1\n0000000000 65535 f \n%d 1\n%010d 00000 n \n%d %d\n", catalogObjID, w.offsets[catalogObjID], size, w.nextID-size)
+	for id := size; id < w.nextID; id++ {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", w.offsets[id])
+	}
+	// keep the other entries of the original trailer, e.g. /Info
+	entries := trailerSizeLine.ReplaceAll(trailer[1], nil)
+	fmt.Fprintf(&w.buf, "trailer\n<<\n/Size %d\n%s/Prev %s\n>>\nstartxref\n%d\n%%%%EOF\n", w.nextID, entries, trailer[2], xrefOffset)
+
+	return append(pdf, w.buf.Bytes()...), nil
+}
+
+func (w *outlineWriter) alloc() int {
+	id := w.nextID
+	w.nextID++
+	return id
+}
+
+// writeItems ... writes sibling items and their children, and returns IDs of the first and last sibling,
+// and the number of visible items
+func (w *outlineWriter) writeItems(items []outlineItem, parentID int) (first int, last int, count int, err error) {
+	ids := make([]int, len(items))
+	for i := range items {
+		ids[i] = w.alloc()
+	}
+
+	for i, item := range items {
+		if item.page < 0 || item.page >= len(w.pageIDs) {
+			return 0, 0, 0, errors.Errorf("page %d of bookmark %s is not found in pdf", item.page, item.title)
+		}
+
+		obj := fmt.Sprintf("/Title %s\n/Parent %d 0 R\n", pdfString(item.title), parentID)
+		if i > 0 {
+			obj += fmt.Sprintf("/Prev %d 0 R\n", ids[i-1])
+		}
+		if i < len(items)-1 {
+			obj += fmt.Sprintf("/Next %d 0 R\n", ids[i+1])
+		}
+		if len(item.children) > 0 {
+			childFirst, childLast, childCount, err := w.writeItems(item.children, ids[i])
+			if err != nil {
+				return 0, 0, 0, errors.WithStack(err)
+			}
+			obj += fmt.Sprintf("/First %d 0 R\n/Last %d 0 R\n/Count %d\n", childFirst, childLast, childCount)
+			count += childCount
+		}
+		obj += fmt.Sprintf("/Dest [%d 0 R /XYZ 0 %.2f null]\n", w.pageIDs[item.page], w.pageHeight-item.y)
+		w.writeObj(ids[i], obj)
+	}
+	return ids[0], ids[len(ids)-1], count + len(items), nil
+}
+
+func (w *outlineWriter) writeObj(id int, dict string) {
+	w.offsets[id] = w.base + w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n<<\n%s>>\nendobj\n\n", id, dict)
+}
+
+// pdfString ... encodes s as an UTF-16BE hexadecimal string, which can contain any character
+func pdfString(s string) string {
+	var buf bytes.Buffer
+	buf.WriteString("<FEFF")
+	for _, c := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&buf, "%04X", c)
+	}
+	buf.WriteString(">")
+	return buf.String()
+}
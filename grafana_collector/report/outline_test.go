@@ -0,0 +1,72 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/signintech/gopdf"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAddOutline(t *testing.T) {
+	Convey("When adding outline to a pdf written by gopdf", t, func() {
+		pdf := &gopdf.GoPdf{}
+		pdf.Start(gopdf.Config{PageSize: gopdf.Rect{W: 100, H: 200}})
+		pdf.AddPage()
+		pdf.AddPage()
+		var buf bytes.Buffer
+		So(pdf.Write(&buf), ShouldBeNil)
+		original := buf.Bytes()
+
+		items := []outlineItem{
+			{title: "Row 1", page: 1, y: 50, row: true, children: []outlineItem{{title: "Panel", page: 1, y: 80}}},
+			{title: "Row 2", page: 1, y: 150, row: true},
+		}
+		out, err := addOutline(original, items, 200)
+		So(err, ShouldBeNil)
+
+		Convey("The original pdf should be kept and updated incrementally", func() {
+			So(bytes.HasPrefix(out, original), ShouldBeTrue)
+			So(string(out), ShouldContainSubstring, "/Prev 436\n")
+			So(string(out), ShouldContainSubstring, "/Outlines 6 0 R\n/PageMode /UseOutlines")
+			So(string(out), ShouldContainSubstring, "/Type /Outlines\n/First 7 0 R\n/Last 8 0 R\n/Count 3\n")
+			So(string(out), ShouldContainSubstring, fmt.Sprintf("/Title %s\n/Parent 7 0 R\n", pdfString("Panel")))
+			So(string(out), ShouldContainSubstring, "/Dest [5 0 R /XYZ 0 50.00 null]")
+		})
+
+		Convey("The new xref entries should point to the objects", func() {
+			xref := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(out)
+			So(xref, ShouldNotBeNil)
+			offset, _ := strconv.Atoi(string(xref[1]))
+			So(string(out[offset:]), ShouldStartWith, "xref\n0 1\n")
+
+			entries := regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllSubmatch(out[offset:], -1)
+			So(entries, ShouldHaveLength, 5)
+			for i, id := range []int{1, 6, 7, 8, 9} {
+				offset, _ := strconv.Atoi(string(entries[i][1]))
+				So(string(out[offset:]), ShouldStartWith, fmt.Sprintf("%d 0 obj\n", id))
+			}
+		})
+
+		Convey("Bookmarks of missing pages should return error", func() {
+			_, err := addOutline(original, []outlineItem{{title: "Row", page: 2}}, 200)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Format is the output format of reports
+type Format string
+
+// output formats of reports
+const (
+	PDF  Format = "pdf"
+	HTML Format = "html" // a single html file with embedded panel images
+)
+
+// ParseFormat ... parses the format parameter of reports, PDF is the default format
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case "":
+		return PDF, nil
+	case PDF, HTML:
+		return f, nil
+	}
+	return "", errors.Errorf("format=%s should be pdf or html", s)
+}
+
+// ContentType ... returns the MIME type of files of the format
+func (f Format) ContentType() string {
+	if f == HTML {
+		return "text/html; charset=utf-8"
+	}
+	return "application/pdf"
+}
+
+// outputWriter writes the panels fetched by renderPNGsParallel to the output file of report in its workspace, and
+// returns the file
+type outputWriter interface {
+	write(rep *report) (io.ReadCloser, error)
+}
+
+// outputWriters are the writers of output formats
+var outputWriters = map[Format]outputWriter{
+	PDF:  pdfWriter{},
+	HTML: htmlWriter{},
+}
+
+// pdfWriter writes reports as pdf files with a cover page, a table of contents and an outline
+type pdfWriter struct{}
+
+func (pdfWriter) write(rep *report) (io.ReadCloser, error) {
+	file, err := rep.renderPDF()
+	return file, errors.Wrap(err, "rendering pdf")
+}
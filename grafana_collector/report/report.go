@@ -30,14 +30,23 @@
 package report
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
 	"io"
-	"os"
-	"path/filepath"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/ngaut/log"
-	"github.com/pborman/uuid"
 	"github.com/pingcap/tidb-inspect-tools/grafana_collector/config"
 	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
 	"github.com/pkg/errors"
@@ -52,23 +61,81 @@ var (
 )
 
 const (
-	imgDir    = "images"
-	reportPdf = "report.pdf"
+	imgDir     = "images"
+	reportPdf  = "report.pdf"
+	reportHTML = "report.html"
 )
 
 // Report groups functions related to genrating the report.
 // After reading and closing the pdf returned by Generate(),
-// call Clean() to delete the pdf file as well the temporary build files
+// call Clean() to delete the pdf file as well the temporary build files.
+// Generate stops rendering panels and fails when ctx is done.
+// Title and TimeRange are known after Generate returns.
+// The file is written in the format set by SetFormat, PDF by default.
+// Details set by SetDetails are printed on the cover page
 type Report interface {
-	Generate() (pdf io.ReadCloser, err error)
+	Generate(ctx context.Context) (file io.ReadCloser, err error)
 	Clean()
+	Progress() Progress
+	Failures() []Failure
+	Title() string
+	TimeRange() grafana.TimeRange
+	SetFormat(f Format)
+	Format() Format
+	SetDetails(d Details)
+}
+
+// Details ... is a titled list of lines printed on the cover page, e.g. the alert of an incident report
+type Details struct {
+	Title string
+	Lines []string
+}
+
+// Progress ... counts panels of a report by their rendering state
+type Progress struct {
+	Total   int `json:"total"`
+	Done    int `json:"done"`
+	Failed  int `json:"failed"`
+	Pending int `json:"pending"`
 }
 
 type report struct {
-	gClient  grafana.Client
-	time     grafana.TimeRange
-	dashName string
-	tmpDir   string
+	title      string // title of bundle report, the report of a single dashboard has the title of dashboard
+	dashboards []*dashboard
+	time       grafana.TimeRange
+	compare    *comparison // the second time range of comparison report, nil for other reports
+	ws         workspace   // the files of report, it is created when the number of panels is known
+	format     Format      // output format, PDF if it is empty
+	details    *Details    // extra lines of the cover page, nil if there are none
+
+	mu       sync.Mutex
+	progress Progress
+	failures []failedPanel
+}
+
+// dashboard is a dashboard of report, the dashboards of bundle report are requested by their own clients
+type dashboard struct {
+	client    grafana.Client
+	name      string
+	dash      grafana.Dashboard
+	summaries map[int][]grafana.SeriesSummary // series summaries of panels by panel ID
+	// series summaries of panels for the compared time range of comparison report
+	compareSummaries map[int][]grafana.SeriesSummary
+}
+
+// BundleDashboard is a dashboard of bundle report, its client has the selected variables of the dashboard
+type BundleDashboard struct {
+	Client   grafana.Client
+	DashName string
+}
+
+// renderTask is a panel to be rendered by Grafana
+type renderTask struct {
+	dashboard *dashboard
+	index     int // index of the dashboard in report
+	panel     grafana.Panel
+	period    int // index of the time range, 1 for the compared time range of comparison report
+	order     int // index of the task in report
 }
 
 // SetFontDir ... sets up ttf font directory
@@ -81,130 +148,244 @@ func New(g grafana.Client, dashName string, timeRange grafana.TimeRange) Report
 	return new(g, dashName, timeRange)
 }
 
+// NewBundle ... creates a Report of several dashboards in a single pdf file, they share the time range, the cover
+// page and the table of contents
+func NewBundle(title string, dashboards []BundleDashboard, timeRange grafana.TimeRange) Report {
+	rep := &report{title: title, time: timeRange}
+	for _, d := range dashboards {
+		rep.dashboards = append(rep.dashboards, &dashboard{client: d.Client, name: d.DashName})
+	}
+	return rep
+}
+
 func new(g grafana.Client, dashName string, timeRange grafana.TimeRange) *report {
-	tmpDir := filepath.Join("tmp", uuid.New())
-	return &report{g, timeRange, dashName, tmpDir}
+	return &report{dashboards: []*dashboard{{client: g, name: dashName}}, time: timeRange}
 }
 
-// Generate returns the report.pdf file. After reading this file it should be Closed()
-// After closing the file, call report.Clean() to delete the file
-func (rep *report) Generate() (pdf io.ReadCloser, err error) {
-	// prepare stage: fetch dashboard json and create image directory
-	dash, err := rep.gClient.GetDashboard(rep.dashName)
-	if err != nil {
-		return nil, errors.Errorf("fetching dashboard %s error: %v", rep.dashName, err)
+// isBundle ... checks if the report is a bundle of dashboards, each of which starts with a heading
+func (rep *report) isBundle() bool {
+	return rep.title != ""
+}
+
+// Generate returns the report file, report.pdf or report.html by its format. After reading this file it should be
+// Closed(). After closing the file, call report.Clean() to delete the file
+func (rep *report) Generate(ctx context.Context) (file io.ReadCloser, err error) {
+	writer, ok := outputWriters[rep.Format()]
+	if !ok {
+		return nil, errors.Errorf("unknown report format %s", rep.Format())
 	}
 
-	err = os.MkdirAll(rep.imgDirPath(), 0777)
-	if err != nil {
-		return nil, errors.Errorf("creating image directory %s error: %v", rep.imgDirPath(), err)
+	// prepare stage: fetch dashboard json and create workspace
+	var total int
+	for _, d := range rep.dashboards {
+		d.dash, err = d.client.GetDashboard(ctx, d.name)
+		if err != nil {
+			return nil, errors.Errorf("fetching dashboard %s error: %v", d.name, err)
+		}
+		total += len(renderedPanels(d.dash)) * rep.periods()
+	}
+	if len(rep.dashboards) == 0 {
+		return nil, errors.New("no dashboard in report")
+	}
+	if rep.ws == nil {
+		rep.ws, err = newWorkspace(total)
+		if err != nil {
+			return nil, errors.Wrapf(err, "report %s", rep.reportTitle())
+		}
+	}
+	// absolute times are printed in the time zone of the first dashboard unless tz is requested
+	rep.time = rep.time.WithDashboardTimezone(rep.dashboards[0].dash.Timezone)
+	if rep.compare != nil {
+		rep.compare.time = rep.compare.time.WithDashboardTimezone(rep.dashboards[0].dash.Timezone)
 	}
+	rep.mu.Lock()
+	rep.progress = Progress{Total: total, Pending: total}
+	rep.mu.Unlock()
 
 	// working stage：fetch panel images
-	err = rep.renderPNGsParallel(dash)
+	err = rep.renderPNGsParallel(ctx)
+	if ctx.Err() != nil {
+		return nil, errors.Errorf("report %s is cancelled: %v", rep.reportTitle(), ctx.Err())
+	}
 	if err != nil {
-		return nil, errors.Errorf("rendering PNGs in parallel for dash %+v error: %v. It is recommended to select time range within 6 hours on the Dashboard. Otherwise, the grafana timeout problem might occur.", dash, err)
+		return nil, errors.Errorf("rendering PNGs in parallel for report %s error: %v. It is recommended to select time range within 6 hours on the Dashboard. Otherwise, the grafana timeout problem might occur.", rep.reportTitle(), err)
 	}
 
-	// working stage：render panel images to pdf
-	pdf, err = rep.renderPDF(dash)
+	// working stage：write panel images to the output file
+	file, err = writer.write(rep)
 	if err != nil {
-		return nil, errors.Errorf("rendering pdf for dash %+v error: %v", dash, err)
+		return nil, errors.Wrapf(err, "writing %s for report %s", rep.Format(), rep.reportTitle())
 	}
-	return pdf, nil
+	return file, nil
 }
 
-// Clean deletes the temporary directory used during report generation
+// reportTitle ... returns the title of bundle, or the title of dashboard
+func (rep *report) reportTitle() string {
+	if rep.isBundle() || len(rep.dashboards) == 0 {
+		return rep.title
+	}
+	return rep.dashboards[0].dash.Title
+}
+
+// Title returns the title of bundle, or the title of dashboard once it is fetched
+func (rep *report) Title() string {
+	return rep.reportTitle()
+}
+
+// TimeRange returns the time range of report, absolute times are in the time zone of the dashboard once it is
+// fetched
+func (rep *report) TimeRange() grafana.TimeRange {
+	return rep.time
+}
+
+// SetFormat sets the output format of report, it must be called before Generate
+func (rep *report) SetFormat(f Format) {
+	rep.format = f
+}
+
+// Format returns the output format of report
+func (rep *report) Format() Format {
+	if rep.format == "" {
+		return PDF
+	}
+	return rep.format
+}
+
+// SetDetails sets the lines printed on the cover page after the dashboards, it must be called before Generate
+func (rep *report) SetDetails(d Details) {
+	rep.details = &d
+}
+
+// Clean deletes the files of report used during report generation
 func (rep *report) Clean() {
-	err := os.RemoveAll(rep.tmpDir)
-	if err != nil {
-		log.Errorf("cleaning up tmp dir %s error: %v", rep.tmpDir, err)
+	if rep.ws != nil {
+		rep.ws.clean()
 	}
 }
 
-func (rep *report) imgDirPath() string {
-	return filepath.Join(rep.tmpDir, imgDir)
+// Progress returns the rendering progress of panels, it is safe to call it while Generate is running
+func (rep *report) Progress() Progress {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	return rep.progress
 }
 
-func (rep *report) pdfPath() string {
-	return filepath.Join(rep.tmpDir, reportPdf)
+func (rep *report) panelRendered(t renderTask, err error) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	rep.progress.Pending--
+	if err != nil {
+		rep.progress.Failed++
+		rep.recordFailure(t, err)
+	} else {
+		rep.progress.Done++
+	}
 }
 
-func (rep *report) renderPNGsParallel(dash grafana.Dashboard) error {
-	//buffer all panels on a channel
-	panels := make(chan grafana.Panel, len(dash.Panels))
-	for _, p := range dash.Panels {
-		panels <- p
+// imgDirName ... returns the image directory of the dashboard of index, as panel IDs are unique only in a dashboard
+func imgDirName(index int) string {
+	return path.Join(imgDir, strconv.Itoa(index))
+}
+
+func (rep *report) renderPNGsParallel(ctx context.Context) error {
+	//buffer all panels of all dashboards on a channel
+	var rendered []renderTask
+	for i, d := range rep.dashboards {
+		for _, p := range renderedPanels(d.dash) {
+			for period := 0; period < rep.periods(); period++ {
+				rendered = append(rendered, renderTask{dashboard: d, index: i, panel: p, period: period, order: len(rendered)})
+			}
+		}
 	}
-	close(panels)
+	tasks := make(chan renderTask, len(rendered))
+	for _, t := range rendered {
+		tasks <- t
+	}
+	close(tasks)
 
 	//fetch images in parrallel form Grafana sever.
 	//limit concurrency using a worker pool to avoid overwhelming grafana
-	//for dashboards with many panels.
+	//for dashboards with many panels. Renders of all reports are limited
+	//by the render limiter of their clients too.
 	var (
 		wg      sync.WaitGroup
 		workers = 5
-		errs    = make(chan error, len(dash.Panels)) //routines can return errors on a channel
 	)
 
 	wg.Add(workers)
 	for i := 0; i < workers; i++ {
-		go func(panels <-chan grafana.Panel, errs chan<- error) {
+		go func(tasks <-chan renderTask) {
 			defer wg.Done()
-			for p := range panels {
-				err := rep.renderPNG(p)
+			for t := range tasks {
+				// the remaining panels are left pending when the report is cancelled
+				if ctx.Err() != nil {
+					return
+				}
+				err := rep.renderPNG(ctx, t)
+				if err == nil && hasSummary(t.panel) {
+					rep.fetchSummary(ctx, t.dashboard, t.panel, t.period)
+				}
+				// failed panels are drawn as placeholders, the report fails only if every panel failed
+				rep.panelRendered(t, err)
 				if err != nil {
-					log.Errorf("creating image for panel ID %d error: %v", p.ID, err)
-					errs <- err
+					log.Errorf("creating image for panel ID %d of dashboard %s error: %v", t.panel.ID, t.dashboard.name, err)
 				}
 			}
-		}(panels, errs)
+		}(tasks)
 	}
 	wg.Wait()
-	close(errs)
 
-	for err := range errs {
-		if err != nil {
-			return err
-		}
+	if failures := rep.Failures(); len(rendered) > 0 && len(failures) == len(rendered) {
+		return errors.Errorf("all %d panels failed, panel %d: %s", len(failures), failures[0].PanelID, failures[0].Error)
 	}
 	return nil
 }
 
-func (rep *report) imgFilePath(p grafana.Panel) string {
-	imgFileName := fmt.Sprintf("image%d.png", p.ID)
-	imgFilePath := filepath.Join(rep.imgDirPath(), imgFileName)
-	return imgFilePath
+// renderedPanels ... returns panels which are rendered to images by Grafana, text panels are written as text
+func renderedPanels(dash grafana.Dashboard) []grafana.Panel {
+	var panels []grafana.Panel
+	for _, p := range dash.Panels {
+		if !p.IsText() {
+			panels = append(panels, p)
+		}
+	}
+	return panels
 }
 
-func (rep *report) renderPNG(p grafana.Panel) error {
-	body, err := rep.gClient.GetPanelPng(p, rep.dashName, rep.time)
-	if err != nil {
-		return errors.Errorf("getting panel %+v error: %v", p, err)
+// imgFileName ... returns the image file of panel p for the time range of period in workspace
+func imgFileName(index int, p grafana.Panel, period int) string {
+	name := fmt.Sprintf("image%d.png", p.ID)
+	if period > 0 {
+		name = fmt.Sprintf("image%d-%d.png", p.ID, period)
 	}
-	defer body.Close()
+	return path.Join(imgDirName(index), name)
+}
 
-	imgPath := rep.imgFilePath(p)
-	file, err := os.Create(imgPath)
+func (rep *report) renderPNG(ctx context.Context, t renderTask) error {
+	body, err := t.dashboard.client.GetPanelPng(ctx, t.panel, t.dashboard.name, rep.periodTime(t.period))
 	if err != nil {
-		return errors.Errorf("creating image file %s error: %v", imgPath, err)
+		return errors.Wrapf(err, "render panel %d", t.panel.ID)
 	}
-	defer file.Close()
+	defer body.Close()
 
-	_, err = io.Copy(file, body)
+	img, err := ioutil.ReadAll(body)
 	if err != nil {
-		return errors.Errorf("copying body to file error: %v", err)
+		return errors.Errorf("reading image of panel %d error: %v", t.panel.ID, err)
 	}
-	return nil
+	return errors.WithStack(rep.ws.write(imgFileName(t.index, t.panel, t.period), img))
 }
 
 // NewPDF ... creates a new PDF and sets font
 func (rep *report) NewPDF() (*gopdf.GoPdf, error) {
+	width, height, err := cfg.PageSize()
+	if err != nil {
+		return nil, errors.Wrap(err, "page size")
+	}
 	pdf := &gopdf.GoPdf{}
-	pdf.Start(gopdf.Config{PageSize: gopdf.Rect{W: cfg.Rect["page"].Width, H: cfg.Rect["page"].Height}})
+	pdf.Start(gopdf.Config{PageSize: gopdf.Rect{W: width, H: height}})
 
 	ttfPath := FontDir + cfg.Font.Ttf
-	err := pdf.AddTTFFont(cfg.Font.Family, ttfPath)
+	err = pdf.AddTTFFont(cfg.Font.Family, ttfPath)
 	if err != nil {
 		log.Errorf("add ttf font error: %v", err)
 		return nil, errors.Wrap(err, "add ttf font")
@@ -219,63 +400,281 @@ func (rep *report) NewPDF() (*gopdf.GoPdf, error) {
 	return pdf, nil
 }
 
-// createHomePage ... add Home Page for PDF
-func (rep *report) createHomePage(pdf *gopdf.GoPdf, dash grafana.Dashboard) {
+// createHomePage ... add Home Page for PDF, the home page of bundle report lists its dashboards. Details of report
+// follow the dashboards, long lines are wrapped to the page width
+func (rep *report) createHomePage(pdf *gopdf.GoPdf) error {
 	pdf.AddPage()
 	pdf.SetX(cfg.Position.X)
-	pdf.Cell(nil, "Dashboard: "+dash.Title)
+	if rep.isBundle() {
+		pdf.Cell(nil, "Report: "+rep.title)
+	} else {
+		pdf.Cell(nil, "Dashboard: "+rep.reportTitle())
+	}
+	pdf.Br(cfg.Position.Br)
+	pdf.SetX(cfg.Position.X)
+	if rep.compare != nil {
+		// both time ranges are labelled as they are above the panels
+		for _, label := range rep.rangeLabels() {
+			pdf.Br(cfg.Position.Br)
+			pdf.SetX(cfg.Position.X)
+			pdf.Cell(nil, label)
+		}
+	} else {
+		pdf.Cell(nil, rep.time.FromFormatted()+" to "+rep.time.ToFormatted())
+	}
+
+	x := cfg.Position.X
+	for _, d := range rep.dashboards {
+		if rep.isBundle() {
+			pdf.Br(cfg.Position.Br)
+			pdf.SetX(cfg.Position.X)
+			pdf.Cell(nil, "Dashboard: "+d.dash.Title)
+			// variables are indented under their dashboard
+			x = cfg.Position.X + cfg.Position.Br
+		}
+
+		for _, line := range append(variableLines(d.dash.Variables), filterLines(d.dash.Filter)...) {
+			pdf.Br(cfg.Position.Br)
+			pdf.SetX(x)
+			pdf.Cell(nil, line)
+		}
+	}
+
+	if rep.details == nil {
+		return nil
+	}
+	width, _, err := cfg.PageSize()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	pdf.Br(cfg.Position.Br)
 	pdf.Br(cfg.Position.Br)
 	pdf.SetX(cfg.Position.X)
-	pdf.Cell(nil, rep.time.FromFormatted()+" to "+rep.time.ToFormatted())
+	pdf.Cell(nil, rep.details.Title)
+	x = cfg.Position.X + cfg.Position.Br
+	for _, line := range rep.details.Lines {
+		wrapped, err := wrapText(pdf.MeasureTextWidth, line, width-x-cfg.Position.X)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, l := range wrapped {
+			pdf.Br(cfg.Position.Br)
+			pdf.SetX(x)
+			pdf.Cell(nil, l)
+		}
+	}
+	return nil
+}
+
+// variableLines ... returns the lines of selected variables in the order of their names, which are printed on the
+// home page
+func variableLines(vars url.Values) []string {
+	names := make([]string, 0, len(vars))
+	for k := range vars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, k := range names {
+		lines = append(lines, fmt.Sprintf("%s: %s", strings.TrimPrefix(k, "var-"), strings.Join(vars[k], ", ")))
+	}
+	return lines
 }
 
-func (rep *report) renderPDF(dash grafana.Dashboard) (outputPDF *os.File, err error) {
+// filterLines ... returns the lines of the rows and panels selected by filter, which are printed on the home page
+func filterLines(f grafana.PanelFilter) []string {
+	var lines []string
+	for _, filter := range []struct {
+		name   string
+		values []string
+	}{
+		{"rows", f.Rows},
+		{"panels", f.Panels},
+		{"exclude rows", f.ExcludeRows},
+		{"exclude panels", f.ExcludePanels},
+	} {
+		if len(filter.values) > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %s", filter.name, strings.Join(filter.values, ", ")))
+		}
+	}
+	return lines
+}
+
+// sections ... returns the dashboards of report to be placed on pages, the dashboards of bundle have headings
+func (rep *report) sections() []section {
+	sections := make([]section, 0, len(rep.dashboards))
+	for _, d := range rep.dashboards {
+		s := section{panels: d.dash.Panels, summaries: d.summaries, compareSummaries: d.compareSummaries}
+		if rep.isBundle() {
+			s.title = d.dash.Title
+		}
+		sections = append(sections, s)
+	}
+	return sections
+}
+
+func (rep *report) renderPDF() (outputPDF io.ReadCloser, err error) {
 	log.Infof("PDF templates config: %+v\n", cfg)
 
 	pdf, err := rep.NewPDF()
 	if err != nil {
 		return nil, errors.Wrap(err, "new pdf file")
 	}
-	rep.createHomePage(pdf, dash)
-
-	// setting rectangle size for grafana panel type: Graph/Singlestat
-	rectGraph := &gopdf.Rect{W: cfg.Rect["graph"].Width, H: cfg.Rect["graph"].Height}
-	rectSinglestat := &gopdf.Rect{W: cfg.Rect["singlestat"].Width, H: cfg.Rect["singlestat"].Height}
-	rect := &gopdf.Rect{}
+	width, height, err := cfg.PageSize()
+	if err != nil {
+		return nil, errors.Wrap(err, "page size")
+	}
+	l := layout{width: width, height: height, margin: cfg.Page.Margin, titleHeight: 1.5 * cfg.Position.Br}
+	if rep.compare != nil {
+		l.labels, l.stacked = rep.rangeLabels(), rep.compare.stacked
+	}
+	doc := newDocument(l, rep.sections())
+	appendix, err := rep.planAppendix(pdf, &doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "plan appendix")
+	}
 
-	var count int
-	for _, p := range dash.Panels {
-		imgPath := rep.imgFilePath(p)
+	err = rep.createHomePage(pdf)
+	if err != nil {
+		return nil, errors.Wrap(err, "create home page")
+	}
+	err = rep.drawHeaderFooter(pdf, doc, rep.reportTitle(), 0)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = rep.createTOC(pdf, doc, rep.reportTitle())
+	if err != nil {
+		return nil, errors.Wrap(err, "create table of contents")
+	}
 
-		if p.IsSingleStat() {
-			rect = rectSinglestat
-		} else {
-			rect = rectGraph
+	for i, page := range doc.pages {
+		pdf.AddPage()
+		title := rep.dashboards[doc.pageSections[i]].dash.Title
+		err = rep.drawHeaderFooter(pdf, doc, title, doc.firstPanelPage()+i)
+		if err != nil {
+			return nil, errors.WithStack(err)
 		}
 
-		// Add two images on every page
-		if count%2 == 0 {
-			pdf.SetX(cfg.Position.X)
-			pdf.SetY(cfg.Position.TitleY1)
-			pdf.Cell(nil, fmt.Sprintf("Row: %s, Panel: %s", p.RowTitle, p.Title))
-			err = pdf.Image(imgPath, cfg.Position.X, cfg.Position.ImageY1, rect)
-		} else {
-			pdf.SetX(cfg.Position.X)
-			pdf.SetY(cfg.Position.TitleY2)
-			pdf.Cell(nil, fmt.Sprintf("Row: %s, Panel: %s", p.RowTitle, p.Title))
-			err = pdf.Image(imgPath, cfg.Position.X, cfg.Position.ImageY2, rect)
-			pdf.AddPage()
-		}
-		if err != nil {
-			log.Errorf("rendering image %s to PDF error: %v", imgPath, err)
-		} else {
-			log.Infof("rendering image to PDF: %s", imgPath)
+		for _, item := range page {
+			if item.label {
+				err = rep.drawRangeLabel(pdf, item)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+				continue
+			}
+			if item.panel == nil {
+				rep.drawSectionHeader(pdf, doc, doc.firstPanelPage()+i, item)
+				continue
+			}
+
+			if item.panel.IsText() {
+				err = rep.drawTextPanel(pdf, item)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+				continue
+			}
+			if f, ok := rep.failure(item); ok {
+				err = rep.drawPlaceholder(pdf, item, f)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+				continue
+			}
+
+			imgName := imgFileName(item.section, *item.panel, item.period)
+			if item.sliceTo > 0 {
+				imgName, err = rep.sliceImage(item)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+			}
+			err = rep.drawImage(pdf, imgName, item.x, item.y, &gopdf.Rect{W: item.w, H: item.h - item.tableHeight})
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			log.Infof("rendering image to PDF: %s", imgName)
+
+			err = rep.drawSummaryTable(pdf, item)
+			if err != nil {
+				return nil, errors.Wrapf(err, "draw summary table of panel %d", item.panel.ID)
+			}
 		}
-		count++
 	}
 
-	// WritePdf(pdfPath string) func in gopdf doesn't return error
-	pdf.WritePdf(rep.pdfPath())
-	outputPDF, err = os.Open(rep.pdfPath())
+	err = rep.createAppendix(pdf, doc, appendix)
+	if err != nil {
+		return nil, errors.Wrap(err, "create appendix")
+	}
+
+	err = rep.writePDF(pdf, doc)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	outputPDF, err = rep.ws.open(reportPdf)
 	return outputPDF, errors.Wrap(err, "open pdf file")
 }
+
+// drawImage ... draws the image of workspace to pdf in rect at (x, y)
+func (rep *report) drawImage(pdf *gopdf.GoPdf, name string, x, y float64, rect *gopdf.Rect) error {
+	data, err := rep.ws.read(name)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	img, err := gopdf.ImageHolderByBytes(data)
+	if err != nil {
+		return errors.Errorf("reading image %s error: %v", name, err)
+	}
+	err = pdf.ImageByHolder(img, x, y, rect)
+	if err != nil {
+		return errors.Errorf("rendering image %s to PDF error: %v", name, err)
+	}
+	return nil
+}
+
+// sliceImage ... crops the part of panel image on a page for a panel which is sliced across pages
+func (rep *report) sliceImage(item layoutItem) (string, error) {
+	imgName := imgFileName(item.section, *item.panel, item.period)
+	data, err := rep.ws.read(imgName)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", errors.Errorf("decoding image file %s error: %v", imgName, err)
+	}
+	bounds := img.Bounds()
+	top := bounds.Min.Y + int(math.Round(item.sliceFrom*float64(bounds.Dy())))
+	bottom := bounds.Min.Y + int(math.Round(item.sliceTo*float64(bounds.Dy())))
+	if bottom <= top {
+		bottom = top + 1
+	}
+	part := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bottom-top))
+	draw.Draw(part, part.Bounds(), img, image.Pt(bounds.Min.X, top), draw.Src)
+
+	sliceName := path.Join(imgDirName(item.section), fmt.Sprintf("image%d-%d-%d.png", item.panel.ID, item.period, top))
+	var buf bytes.Buffer
+	err = png.Encode(&buf, part)
+	if err != nil {
+		return "", errors.Errorf("encoding image file %s error: %v", sliceName, err)
+	}
+	return sliceName, errors.WithStack(rep.ws.write(sliceName, buf.Bytes()))
+}
+
+// writePDF ... writes the pdf file with the outline of document
+func (rep *report) writePDF(pdf *gopdf.GoPdf, doc document) error {
+	var buf bytes.Buffer
+	err := pdf.Write(&buf)
+	if err != nil {
+		return errors.Errorf("writing pdf error: %v", err)
+	}
+
+	data, err := addOutline(buf.Bytes(), doc.outline, doc.layout.height)
+	if err != nil {
+		return errors.Wrap(err, "add pdf outline")
+	}
+
+	return errors.WithStack(rep.ws.write(reportPdf, data))
+}
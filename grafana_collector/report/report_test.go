@@ -0,0 +1,259 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// mockClient returns a dashboard and a blank png for every panel, except the failed panels
+type mockClient struct {
+	dash   grafana.Dashboard
+	failed map[int]bool
+
+	mu         sync.Mutex
+	rendered   []int
+	renderedAt []string // from of the time ranges of rendered panels
+	summarized []int
+}
+
+func (m *mockClient) GetDashboard(ctx context.Context, dashName string) (grafana.Dashboard, error) {
+	return m.dash, nil
+}
+
+func (m *mockClient) GetPanelPng(ctx context.Context, p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+	m.mu.Lock()
+	m.rendered = append(m.rendered, p.ID)
+	m.renderedAt = append(m.renderedAt, t.From)
+	m.mu.Unlock()
+	if m.failed[p.ID] {
+		return nil, errors.Errorf("rendering panel %d timeout", p.ID)
+	}
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 12, 8)))
+	return ioutil.NopCloser(&buf), err
+}
+
+func (m *mockClient) GetPanelSummary(ctx context.Context, p grafana.Panel, t grafana.TimeRange) ([]grafana.SeriesSummary, error) {
+	m.mu.Lock()
+	m.summarized = append(m.summarized, p.ID)
+	m.mu.Unlock()
+
+	var summaries []grafana.SeriesSummary
+	for i := 0; i < 12; i++ {
+		summaries = append(summaries, grafana.SeriesSummary{Name: fmt.Sprintf("tikv-%d", i), Min: 1, Avg: 2, Max: 3, Last: 2, P99: 3})
+	}
+	return summaries, nil
+}
+
+func newTestReport(g grafana.Client) *report {
+	SetFontDir("../ttf/")
+	rep := new(g, "testDash", grafana.TimeRange{From: "now-1h", To: "now"})
+	rep.ws = newTestWorkspace()
+	return rep
+}
+
+func TestGenerate(t *testing.T) {
+	Convey("When generating a report", t, func() {
+		var panels []grafana.Panel
+		for i := 0; i < 6; i++ {
+			row := "Row A"
+			if i >= 3 {
+				row = "Row B"
+			}
+			panels = append(panels, grafana.Panel{ID: i + 1, Type: "graph", Title: "Panel", RowTitle: row, GridPos: grafana.GridPos{Y: i * 8, W: 24, H: 8}})
+		}
+		g := &mockClient{dash: grafana.Dashboard{Title: "Dash", Panels: panels}}
+		rep := newTestReport(g)
+		defer rep.Clean()
+
+		pdf, err := rep.Generate(context.Background())
+		So(err, ShouldBeNil)
+		defer pdf.Close()
+		b, err := ioutil.ReadAll(pdf)
+		So(err, ShouldBeNil)
+
+		Convey("Every panel should be rendered", func() {
+			So(g.rendered, ShouldHaveLength, 6)
+			So(rep.Progress(), ShouldResemble, Progress{Total: 6, Done: 6})
+		})
+
+		Convey("The pdf should have a cover page, table of contents, panel pages and outline", func() {
+			So(string(b), ShouldStartWith, "%PDF-")
+			So(bytes.Count(b, []byte("/Type /Page\n")), ShouldEqual, 1+1+2)
+			So(string(b), ShouldContainSubstring, "/Type /Outlines")
+			So(string(b), ShouldContainSubstring, pdfString("Row B"))
+			So(string(b), ShouldContainSubstring, "/Subtype /Link")
+		})
+	})
+}
+
+func TestGenerateDashboardTimezone(t *testing.T) {
+	Convey("When generating a report of a dashboard out of UTC", t, func() {
+		g := &mockClient{dash: grafana.Dashboard{Title: "Dash", Timezone: "Asia/Shanghai", Panels: []grafana.Panel{
+			{ID: 1, Type: "graph", Title: "QPS", GridPos: grafana.GridPos{W: 24, H: 8}},
+		}}}
+		timeRange, err := grafana.NewTimeRange("2020-01-01T08:00:00", "2020-01-01T09:00:00", "")
+		So(err, ShouldBeNil)
+		rep := newTestReport(g)
+		rep.time = timeRange
+		defer rep.Clean()
+
+		Convey("Times without time zone should be in the time zone of dashboard", func() {
+			pdf, err := rep.Generate(context.Background())
+			So(err, ShouldBeNil)
+			pdf.Close()
+			So(g.renderedAt, ShouldResemble, []string{"1577836800000"})
+			So(rep.TimeRange().FromFormatted(), ShouldEqual, "Wed Jan  1 08:00:00 CST 2020")
+		})
+	})
+}
+
+func TestGenerateDetails(t *testing.T) {
+	Convey("When generating a report with details", t, func() {
+		g := &mockClient{dash: grafana.Dashboard{Title: "Dash", Panels: []grafana.Panel{
+			{ID: 1, Type: "graph", Title: "QPS", GridPos: grafana.GridPos{W: 24, H: 8}},
+		}}}
+		details := Details{Title: "Alert: TiKV_server_is_down", Lines: []string{"Status: firing",
+			"Summary: " + strings.Repeat("a long annotation ", 20)}}
+
+		Convey("The details should be printed on the cover page of pdf without adding pages", func() {
+			generate := func(details *Details) []byte {
+				rep := newTestReport(g)
+				if details != nil {
+					rep.SetDetails(*details)
+				}
+				defer rep.Clean()
+				pdf, err := rep.Generate(context.Background())
+				So(err, ShouldBeNil)
+				defer pdf.Close()
+				b, err := ioutil.ReadAll(pdf)
+				So(err, ShouldBeNil)
+				return b
+			}
+			b := generate(&details)
+			So(string(b), ShouldStartWith, "%PDF-")
+			So(bytes.Count(b, []byte("/Type /Page\n")), ShouldEqual, bytes.Count(generate(nil), []byte("/Type /Page\n")))
+		})
+
+		Convey("The details should be in the header of html", func() {
+			rep := newTestReport(g)
+			rep.SetDetails(details)
+			rep.SetFormat(HTML)
+			defer rep.Clean()
+			file, err := rep.Generate(context.Background())
+			So(err, ShouldBeNil)
+			defer file.Close()
+			b, err := ioutil.ReadAll(file)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldContainSubstring, "<h2>Alert: TiKV_server_is_down</h2>")
+			So(string(b), ShouldContainSubstring, `<p class="meta">Status: firing</p>`)
+		})
+	})
+}
+
+func TestGenerateTextAndTablePanels(t *testing.T) {
+	Convey("When generating a report with text and tall table panels", t, func() {
+		panels := []grafana.Panel{
+			{ID: 1, Type: "text", Title: "Notes", Content: "# Runbook\nCheck **TiKV** first", GridPos: grafana.GridPos{W: 24, H: 4}},
+			{ID: 2, Type: "table", Title: "Slow queries", GridPos: grafana.GridPos{Y: 4, W: 24, H: 80}},
+		}
+		g := &mockClient{dash: grafana.Dashboard{Title: "Dash", Panels: panels}}
+		rep := newTestReport(g)
+		defer rep.Clean()
+
+		pdf, err := rep.Generate(context.Background())
+		So(err, ShouldBeNil)
+		pdf.Close()
+
+		Convey("Text panels should not be rendered by Grafana", func() {
+			So(g.rendered, ShouldResemble, []int{2})
+			So(rep.Progress().Total, ShouldEqual, 1)
+		})
+
+		Convey("The table should be sliced into images of its parts", func() {
+			slices, err := filepath.Glob(rep.ws.(*dirWorkspace).path(imgDirName(0) + "/image2-*.png"))
+			So(err, ShouldBeNil)
+			So(len(slices), ShouldBeGreaterThan, 1)
+		})
+	})
+}
+
+func TestGenerateBundle(t *testing.T) {
+	Convey("When generating a bundle report of dashboards", t, func() {
+		panels := []grafana.Panel{{ID: 1, Type: "graph", RowTitle: "Row", GridPos: grafana.GridPos{W: 24, H: 8}}}
+		overview := &mockClient{dash: grafana.Dashboard{Title: "Overview", Panels: panels}}
+		tikv := &mockClient{dash: grafana.Dashboard{Title: "TiKV", Panels: panels}}
+		SetFontDir("../ttf/")
+		rep := NewBundle("Weekly", []BundleDashboard{{overview, "overview"}, {tikv, "tikv"}}, grafana.TimeRange{From: "now-1h", To: "now"}).(*report)
+		rep.ws = newTestWorkspace()
+		defer rep.Clean()
+
+		pdf, err := rep.Generate(context.Background())
+		So(err, ShouldBeNil)
+		defer pdf.Close()
+		b, err := ioutil.ReadAll(pdf)
+		So(err, ShouldBeNil)
+
+		Convey("Panels of every dashboard should be rendered by its own client", func() {
+			So(overview.rendered, ShouldResemble, []int{1})
+			So(tikv.rendered, ShouldResemble, []int{1})
+			So(rep.Progress(), ShouldResemble, Progress{Total: 2, Done: 2})
+			for i := range rep.dashboards {
+				_, err := rep.ws.read(imgFileName(i, panels[0], 0))
+				So(err, ShouldBeNil)
+			}
+		})
+
+		Convey("The pdf should have one cover page and table of contents, and a page for every dashboard", func() {
+			So(bytes.Count(b, []byte("/Type /Page\n")), ShouldEqual, 1+1+2)
+			So(string(b), ShouldContainSubstring, pdfString("TiKV"))
+		})
+	})
+}
+
+func TestGenerateCancelled(t *testing.T) {
+	Convey("When the report request is cancelled", t, func() {
+		panels := []grafana.Panel{{ID: 1, Type: "graph", GridPos: grafana.GridPos{W: 24, H: 8}}}
+		g := &mockClient{dash: grafana.Dashboard{Title: "Dash", Panels: panels}}
+		rep := newTestReport(g)
+		defer rep.Clean()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := rep.Generate(ctx)
+
+		Convey("The report should fail without rendering panels", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "cancelled")
+			So(g.rendered, ShouldBeEmpty)
+			So(rep.Progress().Pending, ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,171 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/ngaut/log"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	"github.com/pkg/errors"
+	"github.com/signintech/gopdf"
+)
+
+// summaryFontSize is the font size of summary tables, and summaryRowHeight is the height of their rows
+const (
+	summaryFontSize  = 7
+	summaryRowHeight = summaryFontSize * 1.6
+)
+
+var summaryColumns = []string{"Series", "Min", "Avg", "Max", "Last", "P99"}
+
+// hasSummary ... checks if a summary table is printed under the panel, which is a graph of queries
+func hasSummary(p grafana.Panel) bool {
+	kind := p.Kind()
+	return cfg.Summary.Enable && len(p.Targets) > 0 && (kind == grafana.GraphPanel || kind == grafana.TimeseriesPanel)
+}
+
+// fetchSummary ... queries the statistics of panel series for the time range of period. The summary table is left
+// out if it fails, as the panel image is still in the report
+func (rep *report) fetchSummary(ctx context.Context, d *dashboard, p grafana.Panel, period int) {
+	summaries, err := d.client.GetPanelSummary(ctx, p, rep.periodTime(period))
+	if err != nil {
+		log.Errorf("getting summary of panel %d of dashboard %s error: %v", p.ID, d.name, err)
+		return
+	}
+
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	target := &d.summaries
+	if period > 0 {
+		target = &d.compareSummaries
+	}
+	if *target == nil {
+		*target = make(map[int][]grafana.SeriesSummary)
+	}
+	(*target)[p.ID] = summaries
+}
+
+// summaryRows ... returns the series printed in a summary table, and the number of series left out
+func summaryRows(series []grafana.SeriesSummary) ([]grafana.SeriesSummary, int) {
+	if max := cfg.Summary.MaxSeries; max > 0 && len(series) > max {
+		return series[:max], len(series) - max
+	}
+	return series, 0
+}
+
+// summaryHeight ... returns the height of the summary table under panel image, 0 if the panel has no summary
+func summaryHeight(summary []grafana.SeriesSummary) float64 {
+	series, more := summaryRows(summary)
+	if len(series) == 0 {
+		return 0
+	}
+	rows := 1 + len(series)
+	if more > 0 {
+		rows++
+	}
+	// a half row of space between the image and the table
+	return (float64(rows) + 0.5) * summaryRowHeight
+}
+
+// drawSummaryTable ... draws the summary table at the bottom of item, the series column takes the remaining width
+// of value columns
+func (rep *report) drawSummaryTable(pdf *gopdf.GoPdf, item layoutItem) error {
+	series, more := summaryRows(item.summary)
+	if len(series) == 0 {
+		return nil
+	}
+	err := pdf.SetFont(cfg.Font.Family, "", summaryFontSize)
+	if err != nil {
+		return errors.Wrap(err, "set summary font")
+	}
+
+	var (
+		y          = item.y + item.h - item.tableHeight + summaryRowHeight/2
+		valueWidth = math.Min(item.w*0.13, 60)
+		nameWidth  = item.w - float64(len(summaryColumns)-1)*valueWidth
+		padding    = float64(summaryFontSize) / 3
+		unit       = item.panel.Unit()
+	)
+	cell := func(text string, column int, y float64) error {
+		x, width := item.x, nameWidth
+		if column > 0 {
+			x, width = item.x+nameWidth+float64(column-1)*valueWidth, valueWidth
+		}
+		text, textWidth, err := fitText(pdf, text, width-2*padding)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		pdf.SetX(x + padding)
+		if column > 0 {
+			// values are aligned right
+			pdf.SetX(x + width - padding - textWidth)
+		}
+		pdf.SetY(y + (summaryRowHeight-summaryFontSize)/2)
+		pdf.Cell(nil, text)
+		return nil
+	}
+
+	pdf.SetFillColor(235, 235, 235)
+	pdf.RectFromUpperLeftWithStyle(item.x, y, item.w, summaryRowHeight, "F")
+	pdf.SetFillColor(0, 0, 0)
+	for i, name := range summaryColumns {
+		if err = cell(name, i, y); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	pdf.SetLineWidth(0.3)
+	pdf.SetStrokeColor(220, 220, 220)
+	for _, s := range series {
+		y += summaryRowHeight
+		values := []string{s.Name}
+		for _, v := range []float64{s.Min, s.Avg, s.Max, s.Last, s.P99} {
+			values = append(values, grafana.FormatValue(v, unit))
+		}
+		for i, value := range values {
+			if err = cell(value, i, y); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		pdf.Line(item.x, y+summaryRowHeight, item.x+item.w, y+summaryRowHeight)
+	}
+	pdf.SetStrokeColor(0, 0, 0)
+
+	if more > 0 {
+		y += summaryRowHeight
+		if err = cell(fmt.Sprintf("and %d more series", more), 0, y); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.Wrap(pdf.SetFont(cfg.Font.Family, "", cfg.Font.Size), "set font")
+}
+
+// fitText ... cuts off the end of text which is wider than width, and returns the text and its width
+func fitText(pdf *gopdf.GoPdf, text string, width float64) (string, float64, error) {
+	w, err := pdf.MeasureTextWidth(text)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "measure text width")
+	}
+	for runes := []rune(text); w > width && len(runes) > 0; {
+		runes = runes[:len(runes)-1]
+		text = string(runes) + "..."
+		if w, err = pdf.MeasureTextWidth(text); err != nil {
+			return "", 0, errors.Wrap(err, "measure text width")
+		}
+	}
+	return text, w, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSummaryLayout(t *testing.T) {
+	Convey("When placing panels with summaries", t, func() {
+		l := layout{width: 1240, height: 1000, margin: 20, titleHeight: 20, summaries: map[int][]grafana.SeriesSummary{
+			1: {{Name: "a"}, {Name: "b"}},
+		}}
+		panels := []grafana.Panel{
+			{ID: 1, GridPos: grafana.GridPos{W: 12, H: 8}},
+			{ID: 2, GridPos: grafana.GridPos{X: 12, W: 12, H: 8}},
+		}
+		pages := l.place(panels)
+		table := summaryHeight(l.summaries[1])
+
+		Convey("The height of summary table should be reserved under the panel image", func() {
+			So(table, ShouldAlmostEqual, 3.5*summaryRowHeight)
+			So(pages[0][0].tableHeight, ShouldEqual, table)
+			So(pages[0][0].h, ShouldEqual, 296+table)
+			So(pages[0][1].tableHeight, ShouldEqual, 0)
+		})
+
+		Convey("Summary tables should not be shrunk with the panel images", func() {
+			panels[0].GridPos.H = 40
+			pages := l.place(panels[:1])
+			So(pages[0][0].tableHeight, ShouldEqual, table)
+			So(pages[0][0].y+pages[0][0].h, ShouldBeLessThanOrEqualTo, 980)
+		})
+	})
+}
+
+func TestGenerateSummaries(t *testing.T) {
+	Convey("When generating a report with graphs of queries", t, func() {
+		targets := []grafana.Target{{RefID: "A", Expr: "up"}}
+		panels := []grafana.Panel{
+			{ID: 1, Type: "graph", Targets: targets, GridPos: grafana.GridPos{W: 24, H: 8}},
+			{ID: 2, Type: "singlestat", Targets: targets, GridPos: grafana.GridPos{Y: 8, W: 12, H: 4}},
+			{ID: 3, Type: "graph", GridPos: grafana.GridPos{X: 12, Y: 8, W: 12, H: 4}},
+		}
+		g := &mockClient{dash: grafana.Dashboard{Title: "Dash", Panels: panels}}
+		rep := newTestReport(g)
+		defer rep.Clean()
+
+		pdf, err := rep.Generate(context.Background())
+		So(err, ShouldBeNil)
+		pdf.Close()
+
+		Convey("Only graphs with targets should be summarized", func() {
+			So(g.summarized, ShouldResemble, []int{1})
+			So(rep.dashboards[0].summaries[1], ShouldHaveLength, 12)
+		})
+	})
+}
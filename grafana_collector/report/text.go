@@ -0,0 +1,157 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/signintech/gopdf"
+)
+
+// textFontSize is the font size of text panels
+const textFontSize = 10
+
+var (
+	htmlBreakRegexp        = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>|</li>|</h[1-6]>|</tr>`)
+	htmlTagRegexp          = regexp.MustCompile(`<[^>]*>`)
+	markdownHeadingRegexp  = regexp.MustCompile(`^\s*#{1,6}\s+`)
+	markdownListRegexp     = regexp.MustCompile(`^\s*[-*+]\s+`)
+	markdownLinkRegexp     = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+	markdownEmphasisRegexp = regexp.MustCompile("\\*\\*|__|~~|`")
+)
+
+// plainText ... converts the content of text panel to lines of plain text. Markdown is the default mode
+func plainText(content string, mode string) []string {
+	if mode == "html" {
+		content = htmlBreakRegexp.ReplaceAllString(content, "\n")
+	}
+
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if mode != "html" && mode != "text" {
+			line = markdownHeadingRegexp.ReplaceAllString(line, "")
+			line = markdownListRegexp.ReplaceAllString(line, "- ")
+			line = markdownLinkRegexp.ReplaceAllString(line, "$1")
+			line = markdownEmphasisRegexp.ReplaceAllString(line, "")
+		}
+		if mode != "text" {
+			line = html.UnescapeString(htmlTagRegexp.ReplaceAllString(line, ""))
+		}
+
+		line = strings.TrimRight(line, " \t\r")
+		// keep a single empty line between paragraphs
+		if line == "" && (len(lines) == 0 || lines[len(lines)-1] == "") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// wrapText ... breaks text into lines which are not wider than width. Words are broken only if they are wider than width
+func wrapText(measure func(string) (float64, error), text string, width float64) ([]string, error) {
+	var (
+		lines []string
+		line  string
+	)
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		w, err := measure(candidate)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if w <= width {
+			line = candidate
+			continue
+		}
+
+		if line != "" {
+			lines = append(lines, line)
+		}
+		line = ""
+		for _, c := range word {
+			w, err := measure(line + string(c))
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			if w > width && line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			line += string(c)
+		}
+	}
+	if line != "" || len(lines) == 0 {
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// drawTextPanel ... writes the content of text panel into its box as text, lines which don't fit are cut off
+func (rep *report) drawTextPanel(pdf *gopdf.GoPdf, item layoutItem) error {
+	pdf.SetLineWidth(0.5)
+	pdf.SetStrokeColor(200, 200, 200)
+	pdf.RectFromUpperLeftWithStyle(item.x, item.y, item.w, item.h, "D")
+	pdf.SetStrokeColor(0, 0, 0)
+
+	content, mode := item.panel.TextContent()
+	paragraphs := plainText(content, mode)
+	if item.panel.Title != "" {
+		paragraphs = append([]string{item.panel.Title, ""}, paragraphs...)
+	}
+	err := writeParagraphs(pdf, paragraphs, item.x, item.y, item.w, item.h)
+	return errors.Wrapf(err, "write text of panel %d", item.panel.ID)
+}
+
+// writeParagraphs ... writes paragraphs into the box of x, y, w and h in the font size of text panels, lines which
+// don't fit are cut off
+func writeParagraphs(pdf *gopdf.GoPdf, paragraphs []string, x, y, w, h float64) error {
+	err := pdf.SetFont(cfg.Font.Family, "", textFontSize)
+	if err != nil {
+		return errors.Wrap(err, "set text font")
+	}
+
+	var (
+		padding    = float64(textFontSize) / 2
+		lineHeight = float64(textFontSize) * 1.4
+		top        = y + padding
+	)
+	for _, paragraph := range paragraphs {
+		lines, err := wrapText(pdf.MeasureTextWidth, paragraph, w-2*padding)
+		if err != nil {
+			return errors.Wrap(err, "wrap text")
+		}
+		for _, line := range lines {
+			if top+lineHeight > y+h-padding {
+				return errors.Wrap(pdf.SetFont(cfg.Font.Family, "", cfg.Font.Size), "set font")
+			}
+			if line != "" {
+				pdf.SetX(x + padding)
+				pdf.SetY(top)
+				pdf.Cell(nil, line)
+			}
+			top += lineHeight
+		}
+	}
+	return errors.Wrap(pdf.SetFont(cfg.Font.Family, "", cfg.Font.Size), "set font")
+}
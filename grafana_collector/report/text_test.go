@@ -0,0 +1,67 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPlainText(t *testing.T) {
+	Convey("When converting the content of text panels to plain text", t, func() {
+		Convey("Markdown should be stripped", func() {
+			lines := plainText("# Title\n\n\n* **bold** item\n- see [docs](http://x) for `tikv_engine_size`", "markdown")
+			So(lines, ShouldResemble, []string{"Title", "", "- bold item", "- see docs for tikv_engine_size"})
+		})
+
+		Convey("HTML tags should be removed and entities should be unescaped", func() {
+			lines := plainText("<h1>Title</h1><p>a &amp; b<br/>c</p>", "html")
+			So(lines, ShouldResemble, []string{"Title", "a & b", "c"})
+		})
+
+		Convey("Text should be kept as it is", func() {
+			lines := plainText("<b>**a**</b>", "text")
+			So(lines, ShouldResemble, []string{"<b>**a**</b>"})
+		})
+	})
+}
+
+func TestWrapText(t *testing.T) {
+	Convey("When wrapping text", t, func() {
+		// every character is 1 point wide
+		measure := func(s string) (float64, error) {
+			return float64(utf8.RuneCountInString(s)), nil
+		}
+
+		Convey("Lines should be broken between words", func() {
+			lines, err := wrapText(measure, "the quick brown fox", 10)
+			So(err, ShouldBeNil)
+			So(lines, ShouldResemble, []string{"the quick", "brown fox"})
+		})
+
+		Convey("Words wider than a line should be broken", func() {
+			lines, err := wrapText(measure, "a tikv_engine_size", 8)
+			So(err, ShouldBeNil)
+			So(lines, ShouldResemble, []string{"a", "tikv_eng", "ine_size"})
+		})
+
+		Convey("Empty text should be an empty line", func() {
+			lines, err := wrapText(measure, "", 8)
+			So(err, ShouldBeNil)
+			So(lines, ShouldResemble, []string{""})
+		})
+	})
+}
@@ -0,0 +1,266 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	"github.com/pkg/errors"
+	"github.com/signintech/gopdf"
+)
+
+// headerFontSize is the font size of running headers and page footers
+const headerFontSize = 9
+
+// document is the plan of report pages: the cover page, table of contents pages, panel pages and the appendix of
+// failed panels
+type document struct {
+	layout        layout
+	pages         [][]layoutItem
+	pageSections  []int // index of the dashboard of every panel page
+	tocPages      int
+	appendixPages int // pages of the failed panels after panel pages
+	outline       []outlineItem
+}
+
+// section is a dashboard of report, the dashboards of bundle report have headings and start on new pages
+type section struct {
+	title            string
+	panels           []grafana.Panel
+	summaries        map[int][]grafana.SeriesSummary
+	compareSummaries map[int][]grafana.SeriesSummary // series summaries of the compared time range
+}
+
+// newDocument ... places panels of sections on pages and plans the table of contents and outline, so that page
+// numbers are known before any page is drawn
+func newDocument(l layout, sections []section) document {
+	doc := document{layout: l}
+	for i, s := range sections {
+		l.heading = s.title
+		l.summaries = s.summaries
+		l.compareSummaries = s.compareSummaries
+		for _, page := range l.place(s.panels) {
+			for j := range page {
+				page[j].section = i
+			}
+			doc.pages = append(doc.pages, page)
+			doc.pageSections = append(doc.pageSections, i)
+		}
+	}
+
+	var entries int
+	for _, page := range doc.pages {
+		for _, item := range page {
+			if item.panel == nil && !item.label {
+				entries++
+			}
+		}
+	}
+	if entries > 0 {
+		doc.tocPages = int(math.Ceil(float64(entries) / float64(doc.tocEntriesPerPage())))
+	}
+	doc.outline = doc.buildOutline()
+	return doc
+}
+
+// firstPanelPage ... returns the index of the first panel page, after the cover page and table of contents
+func (d document) firstPanelPage() int {
+	return 1 + d.tocPages
+}
+
+func (d document) totalPages() int {
+	return d.firstPanelPage() + len(d.pages) + d.appendixPages
+}
+
+func (d document) tocEntriesPerPage() int {
+	l := d.layout
+	n := int((l.height - 2*l.margin) / l.titleHeight)
+	// the first line is the heading
+	if n < 2 {
+		return 1
+	}
+	return n - 1
+}
+
+// buildOutline ... returns a bookmark for every row with the bookmarks of its panels as children. Panels which
+// are not in a titled row are top level bookmarks. In bundle reports, rows and panels are under the bookmark of
+// their dashboard
+func (d document) buildOutline() []outlineItem {
+	var (
+		items   []outlineItem
+		heading = -1 // index of the bookmark of current dashboard
+	)
+	siblings := func() *[]outlineItem {
+		if heading >= 0 {
+			return &items[heading].children
+		}
+		return &items
+	}
+
+	for i, page := range d.pages {
+		for _, item := range page {
+			// panels of the compared time range are bookmarked with the ones of the first range
+			if item.sliceFrom > 0 || item.label || item.period > 0 {
+				continue
+			}
+			bookmark := outlineItem{page: d.firstPanelPage() + i, y: item.y}
+			switch {
+			case item.heading:
+				bookmark.title = item.title
+				bookmark.heading = true
+				items = append(items, bookmark)
+				heading = len(items) - 1
+			case item.panel == nil:
+				bookmark.title = item.title
+				bookmark.row = true
+				*siblings() = append(*siblings(), bookmark)
+			default:
+				bookmark.title = panelTitle(*item.panel)
+				rows := siblings()
+				if n := len(*rows); n > 0 && (*rows)[n-1].row && (*rows)[n-1].title == item.panel.RowTitle {
+					(*rows)[n-1].children = append((*rows)[n-1].children, bookmark)
+				} else {
+					*rows = append(*rows, bookmark)
+				}
+			}
+		}
+	}
+	return items
+}
+
+// tocEntries ... returns the bookmarks of dashboard headings and rows, which are entries of the table of contents
+func (d document) tocEntries() []outlineItem {
+	var entries []outlineItem
+	for _, item := range d.outline {
+		if item.row {
+			entries = append(entries, item)
+		}
+		if !item.heading {
+			continue
+		}
+		entries = append(entries, item)
+		for _, child := range item.children {
+			if child.row {
+				entries = append(entries, child)
+			}
+		}
+	}
+	return entries
+}
+
+func panelTitle(p grafana.Panel) string {
+	if p.Title == "" {
+		return fmt.Sprintf("Panel %d", p.ID)
+	}
+	return p.Title
+}
+
+func sectionAnchor(page int, y float64) string {
+	return fmt.Sprintf("section-%d-%.0f", page, y)
+}
+
+// createTOC ... adds table of contents pages, every entry links to the section header of a row or the heading of
+// a dashboard. Rows are indented under their dashboards in bundle reports
+func (rep *report) createTOC(pdf *gopdf.GoPdf, doc document, title string) error {
+	l := doc.layout
+	entries := doc.tocEntries()
+	perPage := doc.tocEntriesPerPage()
+	var indent float64
+	for _, entry := range entries {
+		if entry.heading {
+			indent = l.titleHeight
+		}
+	}
+	for i, entry := range entries {
+		if i%perPage == 0 {
+			pdf.AddPage()
+			err := rep.drawHeaderFooter(pdf, doc, title, 1+i/perPage)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			pdf.SetX(l.margin)
+			pdf.SetY(l.margin)
+			pdf.Cell(nil, "Contents")
+		}
+
+		y := l.margin + float64(i%perPage+1)*l.titleHeight
+		pageNumber := fmt.Sprintf("%d", entry.page+1)
+		width, err := pdf.MeasureTextWidth(pageNumber)
+		if err != nil {
+			return errors.Wrap(err, "measure text width")
+		}
+		pdf.SetX(l.margin + indent)
+		if entry.heading {
+			pdf.SetX(l.margin)
+		}
+		pdf.SetY(y)
+		pdf.Cell(nil, entry.title)
+		pdf.SetX(l.width - l.margin - width)
+		pdf.SetY(y)
+		pdf.Cell(nil, pageNumber)
+		pdf.AddInternalLink(sectionAnchor(entry.page, entry.y), l.margin, y, l.width-2*l.margin, l.titleHeight)
+	}
+	return nil
+}
+
+// drawSectionHeader ... draws the header band of a row or a dashboard heading, which is the destination of table of
+// contents links. Dashboard headings are darker than rows
+func (rep *report) drawSectionHeader(pdf *gopdf.GoPdf, doc document, page int, item layoutItem) {
+	l := doc.layout
+	gap := l.titleHeight / 6
+	pdf.SetFillColor(225, 225, 225)
+	if item.heading {
+		pdf.SetFillColor(190, 190, 190)
+	}
+	pdf.RectFromUpperLeftWithStyle(item.x, item.y, l.width-2*l.margin, item.h-gap, "F")
+	pdf.SetFillColor(0, 0, 0)
+
+	pdf.SetX(item.x + gap)
+	pdf.SetY(item.y + (item.h-gap-float64(cfg.Font.Size))/2)
+	pdf.SetAnchor(sectionAnchor(page, item.y))
+	pdf.Cell(nil, item.title)
+}
+
+// drawHeaderFooter ... draws the running header with dashboard title and time range, and the page number footer.
+// The cover page has only the footer
+func (rep *report) drawHeaderFooter(pdf *gopdf.GoPdf, doc document, title string, page int) error {
+	l := doc.layout
+	err := pdf.SetFont(cfg.Font.Family, "", headerFontSize)
+	if err != nil {
+		return errors.Wrap(err, "set header font")
+	}
+
+	if page > 0 {
+		y := (l.margin - headerFontSize) / 2
+		pdf.SetX(l.margin)
+		pdf.SetY(y)
+		pdf.Cell(nil, fmt.Sprintf("%s    %s to %s", title, rep.time.FromFormatted(), rep.time.ToFormatted()))
+		pdf.SetLineWidth(0.5)
+		pdf.Line(l.margin, y+headerFontSize+2, l.width-l.margin, y+headerFontSize+2)
+	}
+
+	footer := fmt.Sprintf("page %d of %d", page+1, doc.totalPages())
+	width, err := pdf.MeasureTextWidth(footer)
+	if err != nil {
+		return errors.Wrap(err, "measure text width")
+	}
+	pdf.SetX((l.width - width) / 2)
+	pdf.SetY(l.height - (l.margin+headerFontSize)/2)
+	pdf.Cell(nil, footer)
+
+	err = pdf.SetFont(cfg.Font.Family, "", cfg.Font.Size)
+	return errors.Wrap(err, "set font")
+}
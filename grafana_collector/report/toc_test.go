@@ -0,0 +1,86 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"testing"
+
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewDocument(t *testing.T) {
+	Convey("When planning the pages of a report", t, func() {
+		l := layout{width: 1240, height: 1000, margin: 20, titleHeight: 20}
+		panels := []grafana.Panel{
+			{ID: 1, Title: "Top", GridPos: grafana.GridPos{W: 24, H: 8}},
+			{ID: 2, RowTitle: "Row A", GridPos: grafana.GridPos{Y: 8, W: 24, H: 8}},
+			{ID: 3, Title: "Last", RowTitle: "Row B", GridPos: grafana.GridPos{Y: 16, W: 24, H: 8}},
+		}
+		doc := newDocument(l, []section{{panels: panels}})
+
+		Convey("Panel pages should follow the cover page and table of contents", func() {
+			So(doc.tocPages, ShouldEqual, 1)
+			So(doc.firstPanelPage(), ShouldEqual, 2)
+			So(doc.totalPages(), ShouldEqual, 3)
+		})
+
+		Convey("Panels should be bookmarked under their rows", func() {
+			So(doc.outline, ShouldHaveLength, 3)
+			So(doc.outline[0].title, ShouldEqual, "Top")
+			So(doc.outline[1].title, ShouldEqual, "Row A")
+			So(doc.outline[1].children[0].title, ShouldEqual, "Panel 2")
+			So(doc.outline[2].page, ShouldEqual, 2)
+			So(doc.outline[2].children[0].title, ShouldEqual, "Last")
+			So(doc.tocEntries(), ShouldHaveLength, 2)
+		})
+	})
+}
+
+func TestNewBundleDocument(t *testing.T) {
+	Convey("When planning the pages of a bundle report", t, func() {
+		l := layout{width: 1240, height: 1000, margin: 20, titleHeight: 20}
+		sections := []section{
+			{title: "Overview", panels: []grafana.Panel{
+				{ID: 1, Title: "Top", GridPos: grafana.GridPos{W: 24, H: 8}},
+				{ID: 2, RowTitle: "Row A", GridPos: grafana.GridPos{Y: 8, W: 24, H: 8}},
+			}},
+			{title: "TiKV", panels: []grafana.Panel{
+				{ID: 1, RowTitle: "Row A", GridPos: grafana.GridPos{W: 24, H: 8}},
+			}},
+		}
+		doc := newDocument(l, sections)
+
+		Convey("Every dashboard should start on a new page with its heading", func() {
+			So(doc.pages, ShouldHaveLength, 2)
+			So(doc.pageSections, ShouldResemble, []int{0, 1})
+			So(doc.pages[1][0].heading, ShouldBeTrue)
+			So(doc.pages[1][0].title, ShouldEqual, "TiKV")
+			So(doc.pages[1][2].section, ShouldEqual, 1)
+		})
+
+		Convey("Rows and panels should be bookmarked under their dashboards", func() {
+			So(doc.outline, ShouldHaveLength, 2)
+			So(doc.outline[0].children, ShouldHaveLength, 2)
+			So(doc.outline[0].children[1].children[0].title, ShouldEqual, "Panel 2")
+			So(doc.outline[1].title, ShouldEqual, "TiKV")
+			So(doc.outline[1].children[0].title, ShouldEqual, "Row A")
+			So(doc.outline[1].children[0].page, ShouldEqual, 3)
+
+			entries := doc.tocEntries()
+			So(entries, ShouldHaveLength, 4)
+			So(entries[2].heading, ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,231 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ngaut/log"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+// ErrWorkdirFull is the cause of errors of reports which would exceed the maximum size of work directory
+var ErrWorkdirFull = errors.New("work directory is full")
+
+// workdir is the work directory of reports, which is set up by SetWorkdir
+var workdir = &Workdir{dir: "tmp"}
+
+// Workdir is the directory where reports keep their panel images and pdf files while they are generated, every
+// report has its own directory named by an uuid in it. The total size of report files is limited to maxSize bytes
+type Workdir struct {
+	dir     string
+	maxSize int64 // 0 is unlimited
+
+	mu   sync.Mutex
+	used int64
+}
+
+// NewWorkdir ... creates the work directory dir and removes the report directories left in it, which are orphans
+// of killed processes as no report is in progress when the process starts
+func NewWorkdir(dir string, maxSize int64) (*Workdir, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, errors.Errorf("creating work directory %s error: %v", dir, err)
+	}
+	w := &Workdir{dir: dir, maxSize: maxSize}
+	removed, err := w.sweep()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if removed > 0 {
+		log.Infof("removed %d orphan report directories in work directory %s", removed, dir)
+	}
+	return w, nil
+}
+
+// SetWorkdir ... sets up the work directory of reports
+func SetWorkdir(w *Workdir) {
+	workdir = w
+}
+
+// Dir ... returns the path of work directory
+func (w *Workdir) Dir() string {
+	return w.dir
+}
+
+// Used ... returns the total size in bytes of the files of reports in progress
+func (w *Workdir) Used() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.used
+}
+
+// sweep ... removes the report directories in work directory, other files are left as they are
+func (w *Workdir) sweep() (int, error) {
+	entries, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return 0, errors.Errorf("reading work directory %s error: %v", w.dir, err)
+	}
+	var removed int
+	for _, e := range entries {
+		if !e.IsDir() || uuid.Parse(e.Name()) == nil {
+			continue
+		}
+		path := filepath.Join(w.dir, e.Name())
+		if err = os.RemoveAll(path); err != nil {
+			return removed, errors.Errorf("removing orphan report directory %s error: %v", path, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// reserve ... takes n bytes of work directory, it fails with ErrWorkdirFull if the maximum size would be exceeded
+func (w *Workdir) reserve(n int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.maxSize > 0 && w.used+n > w.maxSize {
+		return errors.Wrapf(ErrWorkdirFull, "%s has %d of %d bytes used, %d bytes more are needed", w.dir, w.used, w.maxSize, n)
+	}
+	w.used += n
+	return nil
+}
+
+// release ... gives n bytes back to work directory
+func (w *Workdir) release(n int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.used -= n
+}
+
+// workspace is where a report keeps its files while it is generated, files are named by slash separated paths
+type workspace interface {
+	write(name string, data []byte) error
+	read(name string) ([]byte, error)
+	open(name string) (io.ReadCloser, error)
+	clean()
+}
+
+// newWorkspace ... returns the workspace of a report of panels rendered images, small reports are kept in memory.
+// It fails with ErrWorkdirFull if the work directory is full
+func newWorkspace(panels int) (workspace, error) {
+	if panels <= cfg.Workdir.MemoryPanels {
+		return &memWorkspace{files: make(map[string][]byte)}, nil
+	}
+	workdir.mu.Lock()
+	defer workdir.mu.Unlock()
+	if workdir.maxSize > 0 && workdir.used >= workdir.maxSize {
+		return nil, errors.Wrapf(ErrWorkdirFull, "%s has %d of %d bytes used", workdir.dir, workdir.used, workdir.maxSize)
+	}
+	return &dirWorkspace{workdir: workdir, dir: filepath.Join(workdir.dir, uuid.New())}, nil
+}
+
+// dirWorkspace keeps files of a report in its own directory of work directory
+type dirWorkspace struct {
+	workdir *Workdir
+	dir     string
+
+	mu   sync.Mutex
+	used int64
+}
+
+func (ws *dirWorkspace) path(name string) string {
+	return filepath.Join(ws.dir, filepath.FromSlash(name))
+}
+
+func (ws *dirWorkspace) write(name string, data []byte) error {
+	if err := ws.workdir.reserve(int64(len(data))); err != nil {
+		return errors.WithStack(err)
+	}
+	ws.mu.Lock()
+	ws.used += int64(len(data))
+	ws.mu.Unlock()
+
+	path := ws.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return errors.Errorf("creating directory of %s error: %v", path, err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return errors.Errorf("writing file %s error: %v", path, err)
+	}
+	return nil
+}
+
+func (ws *dirWorkspace) read(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(ws.path(name))
+	if err != nil {
+		return nil, errors.Errorf("reading file %s error: %v", ws.path(name), err)
+	}
+	return data, nil
+}
+
+func (ws *dirWorkspace) open(name string) (io.ReadCloser, error) {
+	file, err := os.Open(ws.path(name))
+	if err != nil {
+		return nil, errors.Errorf("opening file %s error: %v", ws.path(name), err)
+	}
+	return file, nil
+}
+
+func (ws *dirWorkspace) clean() {
+	if err := os.RemoveAll(ws.dir); err != nil {
+		log.Errorf("cleaning up report dir %s error: %v", ws.dir, err)
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.workdir.release(ws.used)
+	ws.used = 0
+}
+
+// memWorkspace keeps files of a report in memory
+type memWorkspace struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (ws *memWorkspace) write(name string, data []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.files[name] = data
+	return nil
+}
+
+func (ws *memWorkspace) read(name string) ([]byte, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	data, ok := ws.files[name]
+	if !ok {
+		return nil, errors.Errorf("file %s is not found", name)
+	}
+	return data, nil
+}
+
+func (ws *memWorkspace) open(name string) (io.ReadCloser, error) {
+	data, err := ws.read(name)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (ws *memWorkspace) clean() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.files = make(map[string][]byte)
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pborman/uuid"
+	"github.com/pingcap/tidb-inspect-tools/grafana_collector/grafana"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newTestWorkspace returns a workspace in a temporary directory, which is removed by clean
+func newTestWorkspace() *dirWorkspace {
+	dir, err := ioutil.TempDir("", "report")
+	So(err, ShouldBeNil)
+	return &dirWorkspace{workdir: &Workdir{dir: dir}, dir: dir}
+}
+
+func TestWorkdir(t *testing.T) {
+	Convey("When the work directory is set up", t, func() {
+		dir, err := ioutil.TempDir("", "workdir")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		orphan := filepath.Join(dir, uuid.New())
+		So(os.MkdirAll(filepath.Join(orphan, imgDir), 0777), ShouldBeNil)
+		other := filepath.Join(dir, "keep")
+		So(os.MkdirAll(other, 0777), ShouldBeNil)
+
+		w, err := NewWorkdir(dir, 1024)
+		So(err, ShouldBeNil)
+		oldWorkdir, oldMemoryPanels := workdir, cfg.Workdir.MemoryPanels
+		SetWorkdir(w)
+		defer func() { workdir, cfg.Workdir.MemoryPanels = oldWorkdir, oldMemoryPanels }()
+
+		Convey("Report directories left by killed processes should be removed", func() {
+			_, err := os.Stat(orphan)
+			So(os.IsNotExist(err), ShouldBeTrue)
+			_, err = os.Stat(other)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Reports of few panels should be kept in memory", func() {
+			cfg.Workdir.MemoryPanels = 2
+			ws, err := newWorkspace(2)
+			So(err, ShouldBeNil)
+			So(ws, ShouldHaveSameTypeAs, &memWorkspace{})
+			So(ws.write("images/0/image1.png", []byte("png")), ShouldBeNil)
+			data, err := ws.read("images/0/image1.png")
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "png")
+			So(w.Used(), ShouldEqual, 0)
+		})
+
+		Convey("Files of a report should count until the report is cleaned", func() {
+			cfg.Workdir.MemoryPanels = 0
+			ws, err := newWorkspace(1)
+			So(err, ShouldBeNil)
+			So(ws.write("images/0/image1.png", make([]byte, 600)), ShouldBeNil)
+			So(w.Used(), ShouldEqual, 600)
+			reportDir := ws.(*dirWorkspace).dir
+			So(filepath.Dir(reportDir), ShouldEqual, dir)
+
+			Convey("Writing over the maximum size should fail", func() {
+				err := ws.write("images/0/image2.png", make([]byte, 600))
+				So(errors.Cause(err), ShouldEqual, ErrWorkdirFull)
+				So(err.Error(), ShouldContainSubstring, "600 of 1024 bytes used")
+			})
+
+			Convey("New reports should fail when the work directory is full", func() {
+				So(ws.write("images/0/image2.png", make([]byte, 424)), ShouldBeNil)
+				_, err := newWorkspace(1)
+				So(errors.Cause(err), ShouldEqual, ErrWorkdirFull)
+			})
+
+			Convey("Cleaning the report should remove its directory and release its size", func() {
+				ws.clean()
+				So(w.Used(), ShouldEqual, 0)
+				_, err := os.Stat(reportDir)
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+
+		Convey("A report should fail clearly when its files exceed the maximum size", func() {
+			cfg.Workdir.MemoryPanels = 0
+			SetFontDir("../ttf/")
+			So(w.reserve(1000), ShouldBeNil)
+			defer w.release(1000)
+			panels := []grafana.Panel{{ID: 1, Type: "graph", GridPos: grafana.GridPos{W: 24, H: 8}}}
+			rep := new(&mockClient{dash: grafana.Dashboard{Title: "Dash", Panels: panels}}, "testDash", grafana.TimeRange{From: "now-1h", To: "now"})
+			defer rep.Clean()
+			_, err := rep.Generate(context.Background())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, ErrWorkdirFull.Error())
+		})
+	})
+}
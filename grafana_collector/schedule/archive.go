@@ -0,0 +1,240 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is the cause of errors of archived reports which don't exist
+var ErrNotFound = errors.New("archived report is not found")
+
+// metaExt is the extension of the metadata files of archived reports
+const metaExt = ".json"
+
+// namePattern is the names of schedules and the IDs of archived reports, which are file names in archive
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Entry is the metadata of an archived report, Schedule is the name of its schedule or incident rule
+type Entry struct {
+	ID           string    `json:"id"`
+	Schedule     string    `json:"schedule"`
+	Dashboard    string    `json:"dashboard"`
+	From         string    `json:"from"`
+	To           string    `json:"to"`
+	Format       string    `json:"format"`
+	Created      time.Time `json:"created"`
+	Size         int64     `json:"size"`
+	FailedPanels int       `json:"failedPanels"`
+	Alert        string    `json:"alert,omitempty"` // alertname of incident report
+}
+
+// FileName ... returns the name of the report file of entry
+func (e Entry) FileName() string {
+	return e.ID + "." + e.Format
+}
+
+// ValidName ... checks if name can be the name of a schedule, which is used in file names of archive
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// Archive keeps the reports generated by schedules in a directory, every report has a metadata file of its entry
+type Archive struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewArchive ... creates the archive in dir
+func NewArchive(dir string) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, errors.Errorf("creating archive directory %s error: %v", dir, err)
+	}
+	return &Archive{dir: dir}, nil
+}
+
+// Save ... writes the report read from r to archive, the ID of entry is named by its schedule and created time. It
+// returns the saved entry with its ID and size
+func (a *Archive) Save(e Entry, r io.Reader) (Entry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !ValidName(e.Schedule) {
+		return e, errors.Errorf("invalid schedule name %q", e.Schedule)
+	}
+	e.ID = e.Schedule + "-" + e.Created.UTC().Format("20060102-150405")
+	// reports of the same schedule in the same second are told apart by a suffix
+	for i := 2; a.exists(e.ID); i++ {
+		e.ID = e.Schedule + "-" + e.Created.UTC().Format("20060102-150405") + "-" + strconv.Itoa(i)
+	}
+
+	// the report is written to a temporary file and renamed, so that the archive never lists a partial file
+	tmp, err := ioutil.TempFile(a.dir, ".tmp-")
+	if err != nil {
+		return e, errors.Errorf("creating file in archive %s error: %v", a.dir, err)
+	}
+	defer os.Remove(tmp.Name())
+	e.Size, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return e, errors.Errorf("writing archived report %s error: %v", e.ID, err)
+	}
+	if err = os.Rename(tmp.Name(), a.path(e.FileName())); err != nil {
+		return e, errors.Errorf("renaming archived report %s error: %v", e.ID, err)
+	}
+
+	meta, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return e, errors.WithStack(err)
+	}
+	if err = ioutil.WriteFile(a.path(e.ID+metaExt), meta, 0644); err != nil {
+		os.Remove(a.path(e.FileName()))
+		return e, errors.Errorf("writing metadata of archived report %s error: %v", e.ID, err)
+	}
+	return e, nil
+}
+
+// List ... returns the entries of archived reports, the latest first. Reports of other schedules are skipped if
+// schedule is not empty
+func (a *Archive) List(schedule string) ([]Entry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	files, err := ioutil.ReadDir(a.dir)
+	if err != nil {
+		return nil, errors.Errorf("reading archive directory %s error: %v", a.dir, err)
+	}
+	entries := make([]Entry, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), metaExt) {
+			continue
+		}
+		e, err := a.entry(strings.TrimSuffix(f.Name(), metaExt))
+		if err != nil {
+			log.Warnf("skip archived report %s: %v", f.Name(), err)
+			continue
+		}
+		if schedule == "" || e.Schedule == schedule {
+			entries = append(entries, e)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Created.After(entries[j].Created) })
+	return entries, nil
+}
+
+// Open ... opens the report file of id, it should be closed after reading
+func (a *Archive) Open(id string) (Entry, io.ReadCloser, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e, err := a.entry(id)
+	if err != nil {
+		return e, nil, errors.WithStack(err)
+	}
+	file, err := os.Open(a.path(e.FileName()))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return e, nil, errors.Wrapf(ErrNotFound, "report file of %s", id)
+		}
+		return e, nil, errors.Errorf("opening archived report %s error: %v", id, err)
+	}
+	return e, file, nil
+}
+
+// Delete ... removes the report of id and its metadata
+func (a *Archive) Delete(id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e, err := a.entry(id)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(a.remove(e))
+}
+
+// Prune ... removes the reports created before t, and returns the number of removed reports
+func (a *Archive) Prune(t time.Time) (int, error) {
+	entries, err := a.List("")
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var removed int
+	for _, e := range entries {
+		if !e.Created.Before(t) {
+			continue
+		}
+		if err = a.remove(e); err != nil {
+			return removed, errors.WithStack(err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// entry ... reads the metadata of archived report id
+func (a *Archive) entry(id string) (Entry, error) {
+	var e Entry
+	if !ValidName(id) {
+		return e, errors.Wrapf(ErrNotFound, "invalid id %q", id)
+	}
+	meta, err := ioutil.ReadFile(a.path(id + metaExt))
+	if os.IsNotExist(err) {
+		return e, errors.Wrapf(ErrNotFound, "id %s", id)
+	}
+	if err != nil {
+		return e, errors.Errorf("reading metadata of archived report %s error: %v", id, err)
+	}
+	if err = json.Unmarshal(meta, &e); err != nil {
+		return e, errors.Errorf("decoding metadata of archived report %s error: %v", id, err)
+	}
+	if e.ID != id || !ValidName(e.Format) {
+		return e, errors.Errorf("metadata of archived report %s is broken", id)
+	}
+	return e, nil
+}
+
+func (a *Archive) exists(id string) bool {
+	_, err := os.Stat(a.path(id + metaExt))
+	return err == nil
+}
+
+// remove ... removes the report file and metadata of e, the report file is removed first so that it is never left
+// without metadata
+func (a *Archive) remove(e Entry) error {
+	if err := os.Remove(a.path(e.FileName())); err != nil && !os.IsNotExist(err) {
+		return errors.Errorf("removing archived report %s error: %v", e.ID, err)
+	}
+	if err := os.Remove(a.path(e.ID + metaExt)); err != nil && !os.IsNotExist(err) {
+		return errors.Errorf("removing metadata of archived report %s error: %v", e.ID, err)
+	}
+	return nil
+}
+
+func (a *Archive) path(name string) string {
+	return filepath.Join(a.dir, name)
+}
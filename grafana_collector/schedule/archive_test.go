@@ -0,0 +1,99 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestArchive(t *testing.T) {
+	Convey("When reports are archived", t, func() {
+		dir, err := ioutil.TempDir("", "archive")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		a, err := NewArchive(dir)
+		So(err, ShouldBeNil)
+
+		created := time.Date(2018, 12, 4, 1, 0, 0, 0, time.UTC)
+		daily, err := a.Save(Entry{Schedule: "daily", Dashboard: "abc", Format: "pdf", Created: created}, strings.NewReader("%PDF-daily"))
+		So(err, ShouldBeNil)
+		weekly, err := a.Save(Entry{Schedule: "weekly", Dashboard: "abc", Format: "pdf", Created: created.Add(time.Hour)}, strings.NewReader("%PDF-weekly"))
+		So(err, ShouldBeNil)
+
+		Convey("Reports should be named by their schedules and created time", func() {
+			So(daily.ID, ShouldEqual, "daily-20181204-010000")
+			So(daily.Size, ShouldEqual, len("%PDF-daily"))
+			again, err := a.Save(Entry{Schedule: "daily", Format: "pdf", Created: created}, strings.NewReader("%PDF-again"))
+			So(err, ShouldBeNil)
+			So(again.ID, ShouldEqual, "daily-20181204-010000-2")
+		})
+
+		Convey("Reports should be listed with the latest first, and filtered by schedule", func() {
+			entries, err := a.List("")
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 2)
+			So(entries[0].ID, ShouldEqual, weekly.ID)
+			entries, err = a.List("daily")
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].Dashboard, ShouldEqual, "abc")
+		})
+
+		Convey("Reports should be opened by ID", func() {
+			e, file, err := a.Open(daily.ID)
+			So(err, ShouldBeNil)
+			defer file.Close()
+			b, err := ioutil.ReadAll(file)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "%PDF-daily")
+			So(e.Created.Equal(created), ShouldBeTrue)
+		})
+
+		Convey("Unknown and malformed IDs should not be found", func() {
+			_, _, err := a.Open("daily-19700101-000000")
+			So(errors.Cause(err), ShouldEqual, ErrNotFound)
+			_, _, err = a.Open("../daily")
+			So(errors.Cause(err), ShouldEqual, ErrNotFound)
+			So(errors.Cause(a.Delete("..")), ShouldEqual, ErrNotFound)
+			_, err = a.Save(Entry{Schedule: "../x", Format: "pdf"}, strings.NewReader(""))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Deleted reports should be removed with their metadata", func() {
+			So(a.Delete(daily.ID), ShouldBeNil)
+			_, _, err := a.Open(daily.ID)
+			So(errors.Cause(err), ShouldEqual, ErrNotFound)
+			files, err := ioutil.ReadDir(dir)
+			So(err, ShouldBeNil)
+			So(files, ShouldHaveLength, 2)
+		})
+
+		Convey("Reports older than retention should be pruned", func() {
+			removed, err := a.Prune(created.Add(30 * time.Minute))
+			So(err, ShouldBeNil)
+			So(removed, ShouldEqual, 1)
+			entries, err := a.List("")
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].ID, ShouldEqual, weekly.ID)
+		})
+	})
+}
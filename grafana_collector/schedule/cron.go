@@ -0,0 +1,167 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// macros are the shorthands of cron expressions
+var macros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// field is the allowed values of a cron field, bit i is set if value i is allowed
+type field uint64
+
+// bounds are the minimum and maximum values of cron fields
+var bounds = [5]struct{ min, max int }{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 7},  // day of week, both 0 and 7 are Sunday
+}
+
+// Cron is a parsed cron expression: minute, hour, day of month, month and day of week
+type Cron struct {
+	minute, hour, dom, month, dow field
+	// a day matches if either day of month or day of week matches when both are restricted, like cron does
+	domStar, dowStar bool
+}
+
+// ParseCron ... parses the 5 fields cron expression s, a field is *, a value, a range a-b, a step */n or a-b/n,
+// or a comma separated list of them. The macros @hourly, @daily, @weekly and @monthly are accepted too
+func ParseCron(s string) (Cron, error) {
+	expr := strings.TrimSpace(s)
+	if m, ok := macros[expr]; ok {
+		expr = m
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != len(bounds) {
+		return Cron{}, errors.Errorf("cron %q should have 5 fields: minute hour day-of-month month day-of-week", s)
+	}
+	var fields [5]field
+	for i, part := range parts {
+		f, err := parseField(part, bounds[i].min, bounds[i].max)
+		if err != nil {
+			return Cron{}, errors.Wrapf(err, "cron %q", s)
+		}
+		fields[i] = f
+	}
+	// 7 is Sunday too
+	if fields[4]&(1<<7) != 0 {
+		fields[4] |= 1
+	}
+	return Cron{
+		minute: fields[0], hour: fields[1], dom: fields[2], month: fields[3], dow: fields[4],
+		domStar: parts[2] == "*", dowStar: parts[4] == "*",
+	}, nil
+}
+
+// parseField ... parses a comma separated list of values, ranges and steps between min and max
+func parseField(s string, min, max int) (field, error) {
+	var f field
+	for _, item := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, errors.Errorf("invalid step in %s", item)
+			}
+			step, item = n, item[:i]
+		}
+		lo, hi := min, max
+		switch {
+		case item == "*":
+		case strings.Contains(item, "-"):
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.Errorf("invalid range %s", item)
+			}
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, errors.Errorf("invalid range %s", item)
+			}
+		default:
+			v, err := strconv.Atoi(item)
+			if err != nil {
+				return 0, errors.Errorf("invalid value %s", item)
+			}
+			lo, hi = v, v
+			if step > 1 {
+				// a/n means from a to max every n
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, errors.Errorf("%s is out of range %d-%d", item, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			f |= 1 << uint(v)
+		}
+	}
+	return f, nil
+}
+
+func (f field) has(v int) bool {
+	return f&(1<<uint(v)) != 0
+}
+
+// Match ... checks if the minute of t matches the cron expression in the time zone of t
+func (c Cron) Match(t time.Time) bool {
+	if !c.minute.has(t.Minute()) || !c.hour.has(t.Hour()) || !c.month.has(int(t.Month())) {
+		return false
+	}
+	return c.dayMatch(t)
+}
+
+// Next ... returns the first minute after t which matches the cron expression, in the time zone of t. It returns
+// the zero time if no minute matches within 5 years, e.g. for February 30
+func (c Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case !c.month.has(int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatch(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !c.hour.has(t.Hour()):
+			// hours are counted in the time zone, which may be offset by half an hour
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !c.minute.has(t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatch ... checks if the day of t matches the day of month and day of week
+func (c Cron) dayMatch(t time.Time) bool {
+	domMatch, dowMatch := c.dom.has(t.Day()), c.dow.has(int(t.Weekday()))
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
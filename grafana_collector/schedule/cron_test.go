@@ -0,0 +1,71 @@
+// Copyright 2018 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseCron(t *testing.T) {
+	Convey("When parsing cron expressions", t, func() {
+		loc, err := time.LoadLocation("Asia/Shanghai")
+		So(err, ShouldBeNil)
+		now := time.Date(2018, 12, 4, 16, 30, 20, 0, loc) // Tuesday
+
+		Convey("The next run of daily cron should be on the next day", func() {
+			c, err := ParseCron("0 1 * * *")
+			So(err, ShouldBeNil)
+			So(c.Next(now), ShouldResemble, time.Date(2018, 12, 5, 1, 0, 0, 0, loc))
+			So(c.Match(time.Date(2018, 12, 5, 1, 0, 0, 0, loc)), ShouldBeTrue)
+			So(c.Match(time.Date(2018, 12, 5, 1, 1, 0, 0, loc)), ShouldBeFalse)
+		})
+
+		Convey("Ranges, lists, steps and macros should be accepted", func() {
+			c, err := ParseCron("*/15 9-18 * * 1-5")
+			So(err, ShouldBeNil)
+			So(c.Next(now), ShouldResemble, time.Date(2018, 12, 4, 16, 45, 0, 0, loc))
+			c, err = ParseCron("@weekly")
+			So(err, ShouldBeNil)
+			So(c.Next(now), ShouldResemble, time.Date(2018, 12, 9, 0, 0, 0, 0, loc))
+			c, err = ParseCron("30 8 1,15 * *")
+			So(err, ShouldBeNil)
+			So(c.Next(now), ShouldResemble, time.Date(2018, 12, 15, 8, 30, 0, 0, loc))
+			c, err = ParseCron("0 0 * * 7")
+			So(err, ShouldBeNil)
+			So(c.Match(time.Date(2018, 12, 9, 0, 0, 0, 0, loc)), ShouldBeTrue)
+		})
+
+		Convey("Either day of month or day of week should match when both are restricted", func() {
+			c, err := ParseCron("0 0 1 * 1")
+			So(err, ShouldBeNil)
+			So(c.Next(now), ShouldResemble, time.Date(2018, 12, 10, 0, 0, 0, 0, loc))
+		})
+
+		Convey("Impossible days should never run", func() {
+			c, err := ParseCron("0 0 30 2 *")
+			So(err, ShouldBeNil)
+			So(c.Next(now).IsZero(), ShouldBeTrue)
+		})
+
+		Convey("Invalid expressions should fail", func() {
+			for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+				_, err := ParseCron(expr)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}